@@ -1,23 +1,262 @@
 package bitcoin
 
 import (
+	"fmt"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/bech32"
 )
 
-func H160ToP2SHAddress(h160 []byte, isTestnet bool) string {
-	prefix := byte(0x05)
-	if isTestnet {
-		prefix = 0xc4
+func H160ToP2SHAddress(h160 []byte, params *chaincfg.Params) string {
+	return base58.Checksum(append([]byte{params.ScriptHashAddrID}, h160...))
+}
+
+func H160ToP2PKHAddress(h160 []byte, params *chaincfg.Params) string {
+	return base58.Checksum(append([]byte{params.PubKeyHashAddrID}, h160...))
+}
+
+// Returns the pay-to-witness-public-key-hash address of h160 on the network
+// of params.
+func H160ToP2WPKHAddress(h160 []byte, params *chaincfg.Params) (string, error) {
+	if len(h160) != 20 {
+		return "", fmt.Errorf("public key hash must be 20 bytes")
+	}
+
+	return bech32.EncodeSegwitAddress(params.Bech32HRP, 0, h160)
+}
+
+// Returns the pay-to-witness-script-hash address of the SHA256 hash h256 of
+// a witness script on the network of params.
+func H256ToP2WSHAddress(h256 []byte, params *chaincfg.Params) (string, error) {
+	if len(h256) != 32 {
+		return "", fmt.Errorf("script hash must be 32 bytes")
+	}
+
+	return bech32.EncodeSegwitAddress(params.Bech32HRP, 0, h256)
+}
+
+// Returns the pay-to-taproot address of the x-only output key on the
+// network of params, see TaprootOutputKey.
+func OutputKeyToP2TRAddress(outputKey []byte, params *chaincfg.Params) (string, error) {
+	if len(outputKey) != 32 {
+		return "", fmt.Errorf("output key must be 32 bytes")
+	}
+
+	return bech32.EncodeSegwitAddress(params.Bech32HRP, 1, outputKey)
+}
+
+// Address is a destination of a payment, which is given as a string and
+// paid to with its scriptPubKey.
+type Address interface {
+	// Returns the encoded address
+	String() string
+	// Returns the script of an output that pays to the address
+	ScriptPubKey() *Script
+}
+
+// P2PKHAddress is a pay-to-public-key-hash address.
+type P2PKHAddress struct {
+	h160   []byte
+	params *chaincfg.Params
+}
+
+func NewP2PKHAddress(h160 []byte, params *chaincfg.Params) (*P2PKHAddress, error) {
+	if len(h160) != 20 {
+		return nil, fmt.Errorf("public key hash must be 20 bytes")
+	}
+
+	return &P2PKHAddress{h160, params}, nil
+}
+
+func (a *P2PKHAddress) String() string {
+	return H160ToP2PKHAddress(a.h160, a.params)
+}
+
+func (a *P2PKHAddress) ScriptPubKey() *Script {
+	script, _ := ToP2PKHScript(a.h160)
+	return script
+}
+
+// Returns the hash of the public key
+func (a *P2PKHAddress) Hash160() []byte {
+	return a.h160
+}
+
+// P2SHAddress is a pay-to-script-hash address.
+type P2SHAddress struct {
+	h160   []byte
+	params *chaincfg.Params
+}
+
+func NewP2SHAddress(h160 []byte, params *chaincfg.Params) (*P2SHAddress, error) {
+	if len(h160) != 20 {
+		return nil, fmt.Errorf("script hash must be 20 bytes")
 	}
 
-	return base58.Checksum(append([]byte{prefix}, h160...))
+	return &P2SHAddress{h160, params}, nil
 }
 
-func H160ToP2PKHAddress(h160 []byte, isTestnet bool) string {
-	prefix := byte(0x00)
-	if isTestnet {
-		prefix = 0x6f
+func (a *P2SHAddress) String() string {
+	return H160ToP2SHAddress(a.h160, a.params)
+}
+
+func (a *P2SHAddress) ScriptPubKey() *Script {
+	script, _ := ToP2SHScript(a.h160)
+	return script
+}
+
+// Returns the hash of the redeem script
+func (a *P2SHAddress) Hash160() []byte {
+	return a.h160
+}
+
+// P2WPKHAddress is a pay-to-witness-public-key-hash address.
+type P2WPKHAddress struct {
+	h160   []byte
+	params *chaincfg.Params
+}
+
+func NewP2WPKHAddress(h160 []byte, params *chaincfg.Params) (*P2WPKHAddress, error) {
+	if len(h160) != 20 {
+		return nil, fmt.Errorf("public key hash must be 20 bytes")
+	}
+
+	return &P2WPKHAddress{h160, params}, nil
+}
+
+func (a *P2WPKHAddress) String() string {
+	address, _ := H160ToP2WPKHAddress(a.h160, a.params)
+	return address
+}
+
+func (a *P2WPKHAddress) ScriptPubKey() *Script {
+	script, _ := ToP2WPKHScript(a.h160)
+	return script
+}
+
+// Returns the hash of the public key
+func (a *P2WPKHAddress) Hash160() []byte {
+	return a.h160
+}
+
+// P2WSHAddress is a pay-to-witness-script-hash address.
+type P2WSHAddress struct {
+	h256   []byte
+	params *chaincfg.Params
+}
+
+func NewP2WSHAddress(h256 []byte, params *chaincfg.Params) (*P2WSHAddress, error) {
+	if len(h256) != 32 {
+		return nil, fmt.Errorf("script hash must be 32 bytes")
+	}
+
+	return &P2WSHAddress{h256, params}, nil
+}
+
+func (a *P2WSHAddress) String() string {
+	address, _ := H256ToP2WSHAddress(a.h256, a.params)
+	return address
+}
+
+func (a *P2WSHAddress) ScriptPubKey() *Script {
+	script, _ := ToP2WSHScript(a.h256)
+	return script
+}
+
+// Returns the SHA256 hash of the witness script
+func (a *P2WSHAddress) Hash256() []byte {
+	return a.h256
+}
+
+// P2TRAddress is a pay-to-taproot address.
+type P2TRAddress struct {
+	outputKey []byte
+	params    *chaincfg.Params
+}
+
+func NewP2TRAddress(outputKey []byte, params *chaincfg.Params) (*P2TRAddress, error) {
+	if len(outputKey) != 32 {
+		return nil, fmt.Errorf("output key must be 32 bytes")
+	}
+
+	return &P2TRAddress{outputKey, params}, nil
+}
+
+func (a *P2TRAddress) String() string {
+	address, _ := OutputKeyToP2TRAddress(a.outputKey, a.params)
+	return address
+}
+
+func (a *P2TRAddress) ScriptPubKey() *Script {
+	script, _ := ToP2TRScript(a.outputKey)
+	return script
+}
+
+// Returns the x-only output key
+func (a *P2TRAddress) OutputKey() []byte {
+	return a.outputKey
+}
+
+// DecodeAddress parses a base58check or a bech32 address of the network of
+// params, which fails for an address of another network or of a witness
+// version that has no address type.
+func DecodeAddress(address string, params *chaincfg.Params) (Address, error) {
+	hrp := params.Bech32HRP
+	if strings.HasPrefix(strings.ToLower(address), hrp+"1") {
+		version, program, err := bech32.DecodeSegwitAddress(hrp, address)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case version == 0 && len(program) == 20:
+			return NewP2WPKHAddress(program, params)
+		case version == 0 && len(program) == 32:
+			return NewP2WSHAddress(program, params)
+		case version == 1 && len(program) == 32:
+			return NewP2TRAddress(program, params)
+		}
+
+		return nil, fmt.Errorf("unsupported witness version %d with a program of %d bytes", version, len(program))
+	}
+
+	prefix, payload, err := base58.DecodeCheck(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch prefix {
+	case params.PubKeyHashAddrID:
+		return NewP2PKHAddress(payload, params)
+	case params.ScriptHashAddrID:
+		return NewP2SHAddress(payload, params)
+	}
+
+	return nil, fmt.Errorf("unknown address version %#02x", prefix)
+}
+
+// AddressFromScriptPubKey returns the address of the network of params that
+// script pays to, which fails for scripts that have no address, such as
+// pay-to-public-key and bare multisig scripts.
+func AddressFromScriptPubKey(script *Script, params *chaincfg.Params) (Address, error) {
+	switch {
+	case script.IsP2PKHScriptPubKey():
+		return NewP2PKHAddress(script.instructions[2].Bytes(), params)
+	case script.IsP2SHScriptPubKey():
+		return NewP2SHAddress(script.instructions[1].Bytes(), params)
+	}
+
+	version, program, ok := script.WitnessProgram()
+	switch {
+	case ok && version == 0 && len(program) == 20:
+		return NewP2WPKHAddress(program, params)
+	case ok && version == 0 && len(program) == 32:
+		return NewP2WSHAddress(program, params)
+	case ok && version == 1 && len(program) == 32:
+		return NewP2TRAddress(program, params)
 	}
 
-	return base58.Checksum(append([]byte{prefix}, h160...))
+	return nil, fmt.Errorf("script has no address")
 }
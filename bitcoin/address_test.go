@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 )
 
 func TestH160PSHAddress(t *testing.T) {
@@ -13,14 +14,184 @@ func TestH160PSHAddress(t *testing.T) {
 	h160, _ := hex.DecodeString("74d691da1574e6b3c192ecfb52cc8984ee7b6c56")
 
 	// Mainnet
-	got := bitcoin.H160ToP2SHAddress(h160, false)
+	got := bitcoin.H160ToP2SHAddress(h160, &chaincfg.MainNetParams)
 	if got != expectedMainnet {
 		t.Errorf("got %v, expected %v", got, expectedMainnet)
 	}
 
 	// Testnet
-	got = bitcoin.H160ToP2SHAddress(h160, true)
+	got = bitcoin.H160ToP2SHAddress(h160, &chaincfg.TestNet3Params)
 	if got != expectedTestnet {
 		t.Errorf("got %v, expected %v", got, expectedTestnet)
 	}
 }
+
+func TestH160ToP2WPKHAddress(t *testing.T) {
+	h160, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+
+	tests := []struct {
+		params   *chaincfg.Params
+		expected string
+	}{
+		{&chaincfg.MainNetParams, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{&chaincfg.TestNet3Params, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"},
+		{&chaincfg.RegressionNetParams, "bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080"},
+	}
+
+	for _, test := range tests {
+		got, err := bitcoin.H160ToP2WPKHAddress(h160, test.params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != test.expected {
+			t.Errorf("got %v, expected %v", got, test.expected)
+		}
+	}
+
+	if _, err := bitcoin.H160ToP2WPKHAddress(h160[:19], &chaincfg.MainNetParams); err == nil {
+		t.Errorf("expected an error for a hash of 19 bytes")
+	}
+}
+
+func TestH256ToP2WSHAddress(t *testing.T) {
+	h256, _ := hex.DecodeString("1863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262")
+
+	tests := []struct {
+		params   *chaincfg.Params
+		expected string
+	}{
+		{&chaincfg.MainNetParams, "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3"},
+		{&chaincfg.TestNet3Params, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"},
+	}
+
+	for _, test := range tests {
+		got, err := bitcoin.H256ToP2WSHAddress(h256, test.params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != test.expected {
+			t.Errorf("got %v, expected %v", got, test.expected)
+		}
+	}
+}
+
+func TestDecodeAddress(t *testing.T) {
+	tests := []struct {
+		address      string
+		params       *chaincfg.Params
+		scriptPubKey string
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &chaincfg.MainNetParams, "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+		{"mnrVtF8DWjMu839VW3rBfgYaAfKk8983Xf", &chaincfg.TestNet3Params, "76a914507b27411ccf7f16f10297de6cef3f291623eddf88ac"},
+		{"3CLoMMyuoDQTPRD3XYZtCvgvkadrAdvdXh", &chaincfg.MainNetParams, "a91474d691da1574e6b3c192ecfb52cc8984ee7b6c5687"},
+		{"2N3u1R6uwQfuobCqbCgBkpsgBxvr1tZpe7B", &chaincfg.TestNet3Params, "a91474d691da1574e6b3c192ecfb52cc8984ee7b6c5687"},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", &chaincfg.MainNetParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", &chaincfg.TestNet3Params, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", &chaincfg.MainNetParams, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", &chaincfg.RegressionNetParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+	}
+
+	for _, test := range tests {
+		address, err := bitcoin.DecodeAddress(test.address, test.params)
+		if err != nil {
+			t.Errorf("DecodeAddress(%s): unexpected error: %v", test.address, err)
+			continue
+		}
+
+		raw, _ := address.ScriptPubKey().RawSerialize()
+		if hex.EncodeToString(raw) != test.scriptPubKey {
+			t.Errorf("DecodeAddress(%s): got scriptPubKey %x, expected %s", test.address, raw, test.scriptPubKey)
+		}
+
+		if address.String() != test.address {
+			t.Errorf("DecodeAddress(%s): got %s", test.address, address.String())
+		}
+	}
+}
+
+func TestAddressFromScriptPubKey(t *testing.T) {
+	tests := []struct {
+		scriptPubKey string
+		params       *chaincfg.Params
+		address      string
+	}{
+		{"76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac", &chaincfg.MainNetParams, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"},
+		{"a91474d691da1574e6b3c192ecfb52cc8984ee7b6c5687", &chaincfg.TestNet3Params, "2N3u1R6uwQfuobCqbCgBkpsgBxvr1tZpe7B"},
+		{"0014751e76e8199196d454941c45d1b3a323f1433bd6", &chaincfg.MainNetParams, "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"},
+		{"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262", &chaincfg.TestNet3Params, "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7"},
+		{"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", &chaincfg.MainNetParams, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"},
+	}
+
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.scriptPubKey)
+		script, err := bitcoin.ParseRawScript(raw)
+		if err != nil {
+			t.Fatalf("ParseRawScript(%s): unexpected error: %v", test.scriptPubKey, err)
+		}
+
+		address, err := bitcoin.AddressFromScriptPubKey(script, test.params)
+		if err != nil {
+			t.Errorf("AddressFromScriptPubKey(%s): unexpected error: %v", test.scriptPubKey, err)
+			continue
+		}
+		if address.String() != test.address {
+			t.Errorf("AddressFromScriptPubKey(%s): got %s, expected %s", test.scriptPubKey, address.String(), test.address)
+		}
+	}
+
+	// A pay-to-public-key script has no address
+	raw, _ := hex.DecodeString("2103a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bdac")
+	script, _ := bitcoin.ParseRawScript(raw)
+	if _, err := bitcoin.AddressFromScriptPubKey(script, &chaincfg.MainNetParams); err == nil {
+		t.Errorf("AddressFromScriptPubKey: expected an error for a pay-to-public-key script")
+	}
+}
+
+func TestDecodeAddressInvalid(t *testing.T) {
+	tests := []struct {
+		address string
+		params  *chaincfg.Params
+	}{
+		// Addresses of the other network
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &chaincfg.TestNet3Params},
+		{"2N3u1R6uwQfuobCqbCgBkpsgBxvr1tZpe7B", &chaincfg.MainNetParams},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", &chaincfg.TestNet3Params},
+		{"bcrt1qw508d6qejxtdg4y5r3zarvary0c5xw7kygt080", &chaincfg.TestNet3Params},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", &chaincfg.RegressionNetParams},
+		// Invalid checksums
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", &chaincfg.MainNetParams},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", &chaincfg.MainNetParams},
+		// A witness version without an address type
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", &chaincfg.MainNetParams},
+		// A private key in wallet import format
+		{"5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", &chaincfg.MainNetParams},
+	}
+
+	for _, test := range tests {
+		if _, err := bitcoin.DecodeAddress(test.address, test.params); err == nil {
+			t.Errorf("DecodeAddress(%s, %s): expected an error", test.address, test.params.Name)
+		}
+	}
+}
+
+func TestAddressTypes(t *testing.T) {
+	h160, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+
+	address, err := bitcoin.NewP2WPKHAddress(h160, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var _ bitcoin.Address = address
+	if address.String() != "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4" {
+		t.Errorf("got %s, expected bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", address.String())
+	}
+
+	if _, err := bitcoin.NewP2WSHAddress(h160, &chaincfg.MainNetParams); err == nil {
+		t.Errorf("expected an error for a script hash of 20 bytes")
+	}
+
+	if _, err := bitcoin.NewP2TRAddress(h160, &chaincfg.MainNetParams); err == nil {
+		t.Errorf("expected an error for an output key of 20 bytes")
+	}
+}
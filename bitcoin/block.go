@@ -6,7 +6,9 @@ import (
 	"io"
 	"math/big"
 	"slices"
+	"time"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/merkle"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
@@ -214,26 +216,43 @@ func TargetToBits(target *big.Int) []byte {
 	return bits
 }
 
-func CalculateNewBits(previousBits uint32, timeDifferential int64) []byte {
-	TWO_WEEKS_IN_SECONDS := int64(60 * 60 * 24 * 14)
+// Returns the bits of the next difficulty adjustment period on the network of
+// params, where the blocks of the previous period took timeDifferential
+// seconds.
+func CalculateNewBits(params *chaincfg.Params, previousBits uint32, timeDifferential int64) []byte {
+	if params.PoWNoRetargeting {
+		return binary.LittleEndian.AppendUint32(nil, previousBits)
+	}
+
+	targetTimespan := int64(params.TargetTimespan / time.Second)
 
-	// if the differential > 8 weeks, set to 8 weeks
-	if timeDifferential > (TWO_WEEKS_IN_SECONDS * 4) {
-		timeDifferential = TWO_WEEKS_IN_SECONDS * 4
+	// if the differential > 4 periods, set to 4 periods
+	if timeDifferential > (targetTimespan * 4) {
+		timeDifferential = targetTimespan * 4
 	}
 
-	// if the differential < 1/2 week, set to 1/2 week
-	if timeDifferential < (TWO_WEEKS_IN_SECONDS / 4) {
-		timeDifferential = TWO_WEEKS_IN_SECONDS / 4
+	// if the differential < 1/4 period, set to 1/4 period
+	if timeDifferential < (targetTimespan / 4) {
+		timeDifferential = targetTimespan / 4
 	}
 
 	target := bitsToTarget(previousBits)
 	newTarget := new(big.Int).Mul(target, big.NewInt(timeDifferential))
-	newTarget = new(big.Int).Div(newTarget, big.NewInt(TWO_WEEKS_IN_SECONDS))
+	newTarget = new(big.Int).Div(newTarget, big.NewInt(targetTimespan))
+
+	// The difficulty never goes below the lowest of the network
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		return binary.LittleEndian.AppendUint32(nil, params.PowLimitBits)
+	}
 
 	return TargetToBits(newTarget)
 }
 
+// Returns the first block of the network of params.
+func GenesisBlock(params *chaincfg.Params) (*Block, error) {
+	return ParseBlock(bytes.NewReader(params.GenesisHeader))
+}
+
 func (block *Block) ValidateMerkleRoot() bool {
 	hashes := make([][]byte, 0)
 
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 )
 
 func TestBlock(t *testing.T) {
@@ -269,9 +270,49 @@ func TestCalculateNewBits(t *testing.T) {
 
 	timeDifferential := lastBlock.Timestamp - firstBlock.Timestamp
 
-	newBits := bitcoin.CalculateNewBits(lastBlock.Bits, int64(timeDifferential))
+	newBits := bitcoin.CalculateNewBits(&chaincfg.MainNetParams, lastBlock.Bits, int64(timeDifferential))
 	bitsAsHex := hex.EncodeToString(newBits)
 	if bitsAsHex != expected {
 		t.Errorf("expected new bits to be %s, got %s", expected, bitsAsHex)
 	}
 }
+
+func TestCalculateNewBitsLimits(t *testing.T) {
+	// Eight weeks at the lowest difficulty would lower it below the limit
+	newBits := bitcoin.CalculateNewBits(&chaincfg.MainNetParams, 0x1d00ffff, 60*60*24*7*8)
+	if hex.EncodeToString(newBits) != "ffff001d" {
+		t.Errorf("expected new bits to be ffff001d, got %x", newBits)
+	}
+
+	// Regtest keeps the difficulty of the previous period
+	newBits = bitcoin.CalculateNewBits(&chaincfg.RegressionNetParams, 0x207fffff, 1)
+	if hex.EncodeToString(newBits) != "ffff7f20" {
+		t.Errorf("expected new bits to be ffff7f20, got %x", newBits)
+	}
+}
+
+func TestGenesisBlock(t *testing.T) {
+	for _, params := range chaincfg.Networks {
+		block, err := bitcoin.GenesisBlock(params)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", params.Name, err)
+		}
+
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", params.Name, err)
+		}
+
+		if hex.EncodeToString(hash) != params.GenesisHash {
+			t.Errorf("%s: expected hash %s, got %x", params.Name, params.GenesisHash, hash)
+		}
+
+		if block.Bits != params.PowLimitBits {
+			t.Errorf("%s: expected bits %x, got %x", params.Name, params.PowLimitBits, block.Bits)
+		}
+
+		if !block.CheckProofOfWork() {
+			t.Errorf("%s: expected a valid proof of work", params.Name)
+		}
+	}
+}
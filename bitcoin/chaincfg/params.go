@@ -0,0 +1,216 @@
+// Package chaincfg defines the parameters of the Bitcoin networks, which
+// tell the networks apart on the wire, in addresses and in the rules of
+// proof of work.
+package chaincfg
+
+import (
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+// Params are the parameters of a Bitcoin network.
+type Params struct {
+	// The name of the network as used by Bitcoin Core
+	Name string
+	// The magic bytes that start every message of the network
+	Net [4]byte
+	// The port that nodes of the network listen on
+	DefaultPort string
+
+	// The serialized header of the first block
+	GenesisHeader []byte
+	// The hash of the first block, in the byte order it is displayed in
+	GenesisHash string
+
+	// The highest target of proof of work, which is the lowest difficulty
+	PowLimit *big.Int
+	// PowLimit as the bits of a block header
+	PowLimitBits uint32
+	// The time that the blocks of a difficulty adjustment period should take
+	TargetTimespan time.Duration
+	// The time that a block should take
+	TargetTimePerBlock time.Duration
+	// Whether a block may have the lowest difficulty when no block has been
+	// found for MinDiffReductionTime
+	ReduceMinDifficulty  bool
+	MinDiffReductionTime time.Duration
+	// Whether the difficulty stays the same, which is only the case for
+	// regtest
+	PoWNoRetargeting bool
+	// Whether the rules of BIP 94 against the time warp attack apply
+	EnforceBIP94 bool
+
+	// The version byte of pay-to-public-key-hash addresses
+	PubKeyHashAddrID byte
+	// The version byte of pay-to-script-hash addresses
+	ScriptHashAddrID byte
+	// The version byte of private keys in wallet import format
+	PrivateKeyID byte
+	// The human readable part of segwit addresses, see BIP 173
+	Bech32HRP string
+	// The version bytes of extended private and public keys, see BIP 32
+	HDPrivateKeyID [4]byte
+	HDPublicKeyID  [4]byte
+	// The coin type of the paths of BIP 44
+	HDCoinType uint32
+}
+
+// The number of blocks of a difficulty adjustment period
+func (p *Params) DifficultyAdjustmentInterval() int64 {
+	return int64(p.TargetTimespan / p.TargetTimePerBlock)
+}
+
+// Returns the target 2^bits - 1
+func powLimit(bits uint) *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), bits)
+	return limit.Sub(limit, big.NewInt(1))
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// MainNetParams are the parameters of the main Bitcoin network.
+var MainNetParams = Params{
+	Name:        "main",
+	Net:         [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+	DefaultPort: "8333",
+
+	GenesisHeader: mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"),
+	GenesisHash:   "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+
+	PowLimit:           powLimit(224),
+	PowLimitBits:       0x1d00ffff,
+	TargetTimespan:     time.Hour * 24 * 14,
+	TargetTimePerBlock: time.Minute * 10,
+
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	PrivateKeyID:     0x80,
+	Bech32HRP:        "bc",
+	HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+	HDCoinType:       0,
+}
+
+// TestNet3Params are the parameters of the third test network.
+var TestNet3Params = Params{
+	Name:        "test",
+	Net:         [4]byte{0x0b, 0x11, 0x09, 0x07},
+	DefaultPort: "18333",
+
+	GenesisHeader: mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae18"),
+	GenesisHash:   "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+
+	PowLimit:             powLimit(224),
+	PowLimitBits:         0x1d00ffff,
+	TargetTimespan:       time.Hour * 24 * 14,
+	TargetTimePerBlock:   time.Minute * 10,
+	ReduceMinDifficulty:  true,
+	MinDiffReductionTime: time.Minute * 20,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// TestNet4Params are the parameters of the fourth test network, see BIP 94.
+var TestNet4Params = Params{
+	Name:        "testnet4",
+	Net:         [4]byte{0x1c, 0x16, 0x3f, 0x28},
+	DefaultPort: "48333",
+
+	GenesisHeader: mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000004e7b2b9128fe0291db0693af2ae418b767e657cd407e80cb1434221eaea7a07a046f3566ffff001dbb0c7817"),
+	GenesisHash:   "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043",
+
+	PowLimit:             powLimit(224),
+	PowLimitBits:         0x1d00ffff,
+	TargetTimespan:       time.Hour * 24 * 14,
+	TargetTimePerBlock:   time.Minute * 10,
+	ReduceMinDifficulty:  true,
+	MinDiffReductionTime: time.Minute * 20,
+	EnforceBIP94:         true,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// SigNetParams are the parameters of the default signet, where blocks are
+// signed instead of mined, see BIP 325.
+var SigNetParams = Params{
+	Name:        "signet",
+	Net:         [4]byte{0x0a, 0x03, 0xcf, 0x40},
+	DefaultPort: "38333",
+
+	GenesisHeader: mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a008f4d5fae77031e8ad22203"),
+	GenesisHash:   "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6",
+
+	PowLimit:           new(big.Int).SetBytes(mustDecodeHex("00000377ae000000000000000000000000000000000000000000000000000000")),
+	PowLimitBits:       0x1e0377ae,
+	TargetTimespan:     time.Hour * 24 * 14,
+	TargetTimePerBlock: time.Minute * 10,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "tb",
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// RegressionNetParams are the parameters of regtest, a local network for
+// testing where blocks are mined instantly.
+var RegressionNetParams = Params{
+	Name:        "regtest",
+	Net:         [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+	DefaultPort: "18444",
+
+	GenesisHeader: mustDecodeHex("0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff7f2002000000"),
+	GenesisHash:   "0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206",
+
+	PowLimit:             powLimit(255),
+	PowLimitBits:         0x207fffff,
+	TargetTimespan:       time.Hour * 24 * 14,
+	TargetTimePerBlock:   time.Minute * 10,
+	ReduceMinDifficulty:  true,
+	MinDiffReductionTime: time.Minute * 20,
+	PoWNoRetargeting:     true,
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+	Bech32HRP:        "bcrt",
+	HDPrivateKeyID:   [4]byte{0x04, 0x35, 0x83, 0x94},
+	HDPublicKeyID:    [4]byte{0x04, 0x35, 0x87, 0xcf},
+	HDCoinType:       1,
+}
+
+// Networks are the parameters of all of the known networks.
+var Networks = []*Params{&MainNetParams, &TestNet3Params, &TestNet4Params, &SigNetParams, &RegressionNetParams}
+
+// ParamsForNet returns the parameters of the network with the magic bytes.
+func ParamsForNet(net [4]byte) (*Params, bool) {
+	for _, params := range Networks {
+		if params.Net == net {
+			return params, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,28 @@
+package chaincfg_test
+
+import (
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+)
+
+func TestParamsForNet(t *testing.T) {
+	for _, params := range chaincfg.Networks {
+		got, exists := chaincfg.ParamsForNet(params.Net)
+		if !exists || got != params {
+			t.Errorf("ParamsForNet(%x): expected %s", params.Net, params.Name)
+		}
+	}
+
+	if _, exists := chaincfg.ParamsForNet([4]byte{0xde, 0xad, 0xbe, 0xef}); exists {
+		t.Errorf("ParamsForNet(deadbeef): expected no network")
+	}
+}
+
+func TestDifficultyAdjustmentInterval(t *testing.T) {
+	for _, params := range chaincfg.Networks {
+		if params.DifficultyAdjustmentInterval() != 2016 {
+			t.Errorf("%s: got %d, expected 2016", params.Name, params.DifficultyAdjustmentInterval())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The characters that can be used in a descriptor, in the order of their
+// values in the checksum, see BIP 380.
+const inputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+	"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+	"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+
+// The characters of the checksum, which are the ones of bech32.
+const checksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// The length of a checksum
+const checksumLength = 8
+
+var checksumGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// Checksum returns the 8 character checksum of a descriptor that has no
+// checksum, see BIP 380.
+func Checksum(descriptor string) (string, error) {
+	symbols, err := expandSymbols(descriptor)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := polymod(append(symbols, make([]uint64, checksumLength)...)) ^ 1
+
+	var sb strings.Builder
+	for i := 0; i < checksumLength; i++ {
+		sb.WriteByte(checksumCharset[(checksum>>(5*(checksumLength-1-i)))&31])
+	}
+
+	return sb.String(), nil
+}
+
+// Returns the symbols of the characters of a descriptor, where each character
+// is split into its position in a group of 32 characters and the group,
+// and the groups of three characters are combined
+func expandSymbols(descriptor string) ([]uint64, error) {
+	symbols := make([]uint64, 0, len(descriptor)+len(descriptor)/3+1)
+	groups := make([]uint64, 0, 3)
+	for _, c := range descriptor {
+		position := strings.IndexRune(inputCharset, c)
+		if position < 0 {
+			return nil, fmt.Errorf("invalid character %q in descriptor", c)
+		}
+
+		symbols = append(symbols, uint64(position&31))
+		groups = append(groups, uint64(position>>5))
+		if len(groups) == 3 {
+			symbols = append(symbols, groups[0]*9+groups[1]*3+groups[2])
+			groups = groups[:0]
+		}
+	}
+
+	switch len(groups) {
+	case 1:
+		symbols = append(symbols, groups[0])
+	case 2:
+		symbols = append(symbols, groups[0]*3+groups[1])
+	}
+
+	return symbols, nil
+}
+
+func polymod(symbols []uint64) uint64 {
+	checksum := uint64(1)
+	for _, symbol := range symbols {
+		top := checksum >> 35
+		checksum = (checksum&0x7ffffffff)<<5 ^ symbol
+		for i, generator := range checksumGenerator {
+			if (top>>i)&1 == 1 {
+				checksum ^= generator
+			}
+		}
+	}
+
+	return checksum
+}
@@ -0,0 +1,86 @@
+// Package descriptor implements the output script descriptors of BIPs 380 to
+// 386, which describe the scripts of a wallet as a string such as
+// wpkh([d34db33f/84h/0h/0h]xpub.../0/*), including the keys, where they were
+// derived from and how the scripts are built from them.
+package descriptor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+)
+
+// Descriptor is a parsed output script descriptor.
+type Descriptor struct {
+	expression expression
+	params     *chaincfg.Params
+}
+
+// Parse parses a descriptor of the network of params, whose checksum is
+// verified if it has one.
+func Parse(descriptor string, params *chaincfg.Params) (*Descriptor, error) {
+	descriptor, checksum, hasChecksum := strings.Cut(descriptor, "#")
+
+	expected, err := Checksum(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if hasChecksum && checksum != expected {
+		return nil, fmt.Errorf("invalid checksum %q, expected %q", checksum, expected)
+	}
+
+	expression, err := parseExpression(descriptor, topContext, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Descriptor{expression, params}, nil
+}
+
+// Returns the descriptor with its checksum
+func (d *Descriptor) String() string {
+	descriptor := d.expression.String()
+	checksum, _ := Checksum(descriptor)
+
+	return descriptor + "#" + checksum
+}
+
+// IsRange returns whether the descriptor has keys with a wildcard, such as
+// xpub.../0/*, so that it describes a different script at each index.
+func (d *Descriptor) IsRange() bool {
+	return d.expression.isRange()
+}
+
+// ScriptPubKeys returns the scripts that the descriptor describes at index,
+// which is ignored when the descriptor is not a range. There is a single
+// script, except for combo() which has up to four.
+func (d *Descriptor) ScriptPubKeys(index uint32) ([]*bitcoin.Script, error) {
+	return d.expression.scripts(index)
+}
+
+// Addresses returns the addresses of the scripts at index, see
+// ScriptPubKeys. Scripts without an address are left out, such as the
+// pay-to-public-key script of combo(), and it is an error when none of the
+// scripts have one.
+func (d *Descriptor) Addresses(index uint32) ([]bitcoin.Address, error) {
+	scripts, err := d.ScriptPubKeys(index)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]bitcoin.Address, 0, len(scripts))
+	for _, script := range scripts {
+		address, err := bitcoin.AddressFromScriptPubKey(script, d.params)
+		if err == nil {
+			addresses = append(addresses, address)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("descriptor %s has no address", d)
+	}
+
+	return addresses, nil
+}
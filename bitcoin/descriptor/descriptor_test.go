@@ -0,0 +1,199 @@
+package descriptor_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/descriptor"
+)
+
+type descriptorVectors struct {
+	Valid []struct {
+		Descriptor string `json:"descriptor"`
+		// The scripts at index 0, 1, ...
+		Scripts [][]string `json:"scripts"`
+	} `json:"valid"`
+	Invalid     []string `json:"invalid"`
+	ValidKeys   []string `json:"valid_keys"`
+	InvalidKeys []string `json:"invalid_keys"`
+}
+
+func readDescriptorVectors(t *testing.T) *descriptorVectors {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/descriptor_test_vectors.json")
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	var vectors descriptorVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	return &vectors
+}
+
+func TestScriptPubKeys(t *testing.T) {
+	for _, vector := range readDescriptorVectors(t).Valid {
+		d, err := descriptor.Parse(vector.Descriptor, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Errorf("Parse(%s): got error %v, expected nil", vector.Descriptor, err)
+			continue
+		}
+
+		if isRange := len(vector.Scripts) > 1; d.IsRange() != isRange {
+			t.Errorf("IsRange(%s): got %v, expected %v", vector.Descriptor, d.IsRange(), isRange)
+		}
+
+		for index, expected := range vector.Scripts {
+			scripts, err := d.ScriptPubKeys(uint32(index))
+			if err != nil {
+				t.Errorf("ScriptPubKeys(%s, %d): got error %v, expected nil", vector.Descriptor, index, err)
+				continue
+			}
+
+			actual := make([]string, 0, len(scripts))
+			for _, script := range scripts {
+				raw, err := script.RawSerialize()
+				if err != nil {
+					t.Fatalf("RawSerialize: %v", err)
+				}
+				actual = append(actual, hex.EncodeToString(raw))
+			}
+
+			if fmt.Sprint(actual) != fmt.Sprint(expected) {
+				t.Errorf("ScriptPubKeys(%s, %d): got %v, expected %v", vector.Descriptor, index, actual, expected)
+			}
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range readDescriptorVectors(t).Invalid {
+		if _, err := descriptor.Parse(s, &chaincfg.MainNetParams); err == nil {
+			t.Errorf("Parse(%s): expected an error", s)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	vectors := readDescriptorVectors(t)
+
+	for _, key := range vectors.ValidKeys {
+		if _, err := descriptor.Parse("pk("+key+")", &chaincfg.MainNetParams); err != nil {
+			t.Errorf("Parse(pk(%s)): got error %v, expected nil", key, err)
+		}
+	}
+
+	for _, key := range vectors.InvalidKeys {
+		if _, err := descriptor.Parse("pk("+key+")", &chaincfg.MainNetParams); err == nil {
+			t.Errorf("Parse(pk(%s)): expected an error", key)
+		}
+	}
+}
+
+func TestParseOtherNetwork(t *testing.T) {
+	s := "wpkh(L4rK1yDtCWekvXuE6oXD9jCYfFNV2cWRpVuPLBcCU2z8TrisoyY1)"
+	if _, err := descriptor.Parse(s, &chaincfg.TestNet3Params); err == nil {
+		t.Errorf("Parse(%s): expected an error for a mainnet key on testnet", s)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	checksum, err := descriptor.Checksum("raw(deadbeef)")
+	if err != nil {
+		t.Fatalf("Checksum: got error %v, expected nil", err)
+	}
+	if checksum != "89f8spxm" {
+		t.Errorf("Checksum: got %s, expected 89f8spxm", checksum)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []string{
+		"raw(deadbeef)",
+		"raw(deadbeef)#89f8spxm",
+		"sh(wpkh([ffffffff/13']xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH/1/2/*))",
+		"tr(a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd,{pk(669b8afcec803a0d323e9a17f3ea8e68e8abe5a278020a929adbec52421adbd0),pk(0260b2003c386519fc9eadf2b5cf124dd8eea4c4e68d5e154050a9346ea98ce600)})",
+	}
+
+	for _, test := range tests {
+		d, err := descriptor.Parse(test, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("Parse(%s): got error %v, expected nil", test, err)
+		}
+
+		body, _, _ := strings.Cut(test, "#")
+		checksum, err := descriptor.Checksum(body)
+		if err != nil {
+			t.Fatalf("Checksum(%s): got error %v, expected nil", body, err)
+		}
+
+		if expected := body + "#" + checksum; d.String() != expected {
+			t.Errorf("String(%s): got %s, expected %s", test, d.String(), expected)
+		}
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	// The receive addresses of the first account of BIP 84, whose extended
+	// public key is the zpub of the BIP with the version bytes of an xpub
+	d, err := descriptor.Parse("wpkh([73c5da0a/84h/0h/0h]xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V/0/*)", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Parse: got error %v, expected nil", err)
+	}
+
+	for index, expected := range []string{"bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"} {
+		addresses, err := d.Addresses(uint32(index))
+		if err != nil {
+			t.Fatalf("Addresses(%d): got error %v, expected nil", index, err)
+		}
+		if len(addresses) != 1 || addresses[0].String() != expected {
+			t.Errorf("Addresses(%d): got %v, expected [%s]", index, addresses, expected)
+		}
+	}
+}
+
+func TestAddressesCombo(t *testing.T) {
+	d, err := descriptor.Parse("combo(03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Parse: got error %v, expected nil", err)
+	}
+
+	// The pay-to-public-key script has no address
+	addresses, err := d.Addresses(0)
+	if err != nil {
+		t.Fatalf("Addresses: got error %v, expected nil", err)
+	}
+	if len(addresses) != 3 {
+		t.Errorf("Addresses: got %d addresses, expected 3", len(addresses))
+	}
+}
+
+func TestAddressesWithoutAddress(t *testing.T) {
+	d, err := descriptor.Parse("pk(03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Parse: got error %v, expected nil", err)
+	}
+
+	if _, err := d.Addresses(0); err == nil {
+		t.Errorf("Addresses: expected an error")
+	}
+}
+
+func TestScriptPubKeysHardenedFromPublicKey(t *testing.T) {
+	d, err := descriptor.Parse("pk(xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL/3h/*)", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("Parse: got error %v, expected nil", err)
+	}
+
+	if _, err := d.ScriptPubKeys(0); err == nil {
+		t.Errorf("ScriptPubKeys: expected an error")
+	}
+}
@@ -0,0 +1,646 @@
+package descriptor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Where a script expression is, which limits the expressions and keys that
+// can be used in it
+type context int
+
+const (
+	topContext context = iota
+	// In sh(), where the script is a redeem script
+	shContext
+	// In wsh(), where the script is a witness script
+	wshContext
+	// In the script tree of tr(), where the script is a tapscript
+	trContext
+)
+
+const (
+	// The most keys of a multisig script outside of wsh()
+	maxMultisigKeys = 16
+	// The most keys of a multisig witness script, which is the limit of
+	// OP_CHECKMULTISIG
+	maxWitnessMultisigKeys = 20
+)
+
+// A script expression, see BIP 380
+type expression interface {
+	// Returns the scripts at index, of which only combo() has more than one
+	scripts(index uint32) ([]*bitcoin.Script, error)
+	// Returns whether the scripts are different at each index
+	isRange() bool
+	String() string
+}
+
+// pk(KEY), see BIP 381
+type pkExpression struct{ key *key }
+
+// pkh(KEY), see BIP 381
+type pkhExpression struct{ key *key }
+
+// wpkh(KEY), see BIP 382
+type wpkhExpression struct{ key *key }
+
+// combo(KEY), see BIP 384
+type comboExpression struct{ key *key }
+
+// sh(SCRIPT), see BIP 381
+type shExpression struct{ script expression }
+
+// wsh(SCRIPT), see BIP 382
+type wshExpression struct{ script expression }
+
+// multi(NUM,KEY,...,KEY) and sortedmulti(NUM,KEY,...,KEY), see BIP 383
+type multiExpression struct {
+	threshold int
+	keys      []*key
+	isSorted  bool
+}
+
+// tr(KEY) and tr(KEY,TREE), see BIP 386
+type trExpression struct {
+	internalKey *key
+	tree        *tapTree
+}
+
+// A node of the script tree of tr(), which is either a leaf with a script or
+// a branch {left,right}
+type tapTree struct {
+	leaf        expression
+	left, right *tapTree
+}
+
+// addr(ADDR), see BIP 385
+type addrExpression struct{ address bitcoin.Address }
+
+// raw(HEX), see BIP 385
+type rawExpression struct{ script *bitcoin.Script }
+
+// Parses a script expression in ctx
+func parseExpression(s string, ctx context, params *chaincfg.Params) (expression, error) {
+	name, args, err := splitFunction(s)
+	if err != nil {
+		return nil, err
+	}
+
+	isAllowed := map[string]bool{
+		"pk":          true,
+		"pkh":         ctx != trContext,
+		"wpkh":        ctx == topContext || ctx == shContext,
+		"combo":       ctx == topContext,
+		"sh":          ctx == topContext,
+		"wsh":         ctx == topContext || ctx == shContext,
+		"multi":       ctx != trContext,
+		"sortedmulti": ctx != trContext,
+		"tr":          ctx == topContext,
+		"addr":        ctx == topContext,
+		"raw":         ctx == topContext,
+	}
+	allowed, isKnown := isAllowed[name]
+	if !isKnown {
+		return nil, fmt.Errorf("unknown script expression %s()", name)
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s() can not be used in %s", name, ctx)
+	}
+
+	switch name {
+	case "pk", "pkh", "wpkh", "combo":
+		k, err := parseKey(args, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "pk":
+			return &pkExpression{k}, nil
+		case "pkh":
+			return &pkhExpression{k}, nil
+		case "combo":
+			return &comboExpression{k}, nil
+		}
+
+		if !k.isCompressed {
+			return nil, fmt.Errorf("uncompressed key %s in wpkh()", k)
+		}
+		return &wpkhExpression{k}, nil
+	case "sh", "wsh":
+		innerCtx := shContext
+		if name == "wsh" {
+			innerCtx = wshContext
+		}
+
+		script, err := parseExpression(args, innerCtx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "wsh" {
+			return &wshExpression{script}, nil
+		}
+		return &shExpression{script}, nil
+	case "multi", "sortedmulti":
+		return parseMulti(args, name == "sortedmulti", ctx, params)
+	case "tr":
+		return parseTr(args, params)
+	case "addr":
+		address, err := bitcoin.DecodeAddress(args, params)
+		if err != nil {
+			return nil, err
+		}
+
+		return &addrExpression{address}, nil
+	}
+
+	raw, err := hex.DecodeString(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex script %s", args)
+	}
+	script, err := bitcoin.ParseRawScript(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawExpression{script}, nil
+}
+
+func parseMulti(args string, isSorted bool, ctx context, params *chaincfg.Params) (*multiExpression, error) {
+	parts := splitArgs(args)
+
+	threshold, err := strconv.Atoi(parts[0])
+	if err != nil || strings.Trim(parts[0], "0123456789") != "" {
+		return nil, fmt.Errorf("invalid multisig threshold %s", parts[0])
+	}
+
+	keys := make([]*key, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		k, err := parseKey(part, ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	maxKeys := maxMultisigKeys
+	if ctx == wshContext {
+		maxKeys = maxWitnessMultisigKeys
+	}
+	if len(keys) == 0 || len(keys) > maxKeys {
+		return nil, fmt.Errorf("multisig has %d keys, which must be between 1 and %d", len(keys), maxKeys)
+	}
+	if threshold < 1 || threshold > len(keys) {
+		return nil, fmt.Errorf("multisig threshold %d is not between 1 and %d", threshold, len(keys))
+	}
+
+	m := &multiExpression{threshold, keys, isSorted}
+	if ctx == shContext && m.length() > op.MaxScriptElementSize {
+		return nil, fmt.Errorf("redeem script of %d bytes is larger than %d bytes", m.length(), op.MaxScriptElementSize)
+	}
+
+	return m, nil
+}
+
+func parseTr(args string, params *chaincfg.Params) (*trExpression, error) {
+	parts := splitArgs(args)
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("tr() takes a key and a script tree")
+	}
+
+	internalKey, err := parseKey(parts[0], trContext, params)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &trExpression{internalKey: internalKey}
+	if len(parts) == 2 {
+		tr.tree, err = parseTapTree(parts[1], 0, params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tr, nil
+}
+
+// Parses a script tree {left,right} or a leaf script at depth in the tree
+func parseTapTree(s string, depth int, params *chaincfg.Params) (*tapTree, error) {
+	if depth > bitcoin.TaprootControlMaxNodeCount {
+		return nil, fmt.Errorf("script tree is deeper than %d", bitcoin.TaprootControlMaxNodeCount)
+	}
+
+	if !strings.HasPrefix(s, "{") {
+		leaf, err := parseExpression(s, trContext, params)
+		if err != nil {
+			return nil, err
+		}
+
+		return &tapTree{leaf: leaf}, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("script tree %s has no end", s)
+	}
+	parts := splitArgs(s[1 : len(s)-1])
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("branch of a script tree must have two children")
+	}
+
+	left, err := parseTapTree(parts[0], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseTapTree(parts[1], depth+1, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tapTree{left: left, right: right}, nil
+}
+
+// Splits name(args) into the name and the arguments
+func splitFunction(s string) (string, string, error) {
+	start := strings.Index(s, "(")
+	if start < 0 || !strings.HasSuffix(s, ")") {
+		return "", "", fmt.Errorf("expected a script expression, got %s", s)
+	}
+
+	return s[:start], s[start+1 : len(s)-1], nil
+}
+
+// Splits the arguments of an expression at the commas that are not inside of
+// parentheses or braces
+func splitArgs(args string) []string {
+	parts := make([]string, 0)
+	depth := 0
+	start := 0
+	for i, c := range args {
+		switch c {
+		case '(', '{':
+			depth++
+		case ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, args[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, args[start:])
+}
+
+func (ctx context) String() string {
+	switch ctx {
+	case shContext:
+		return "sh()"
+	case wshContext:
+		return "wsh()"
+	case trContext:
+		return "tr()"
+	}
+
+	return "the top level"
+}
+
+func (e *pkExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	publicKey, err := e.key.bytesAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{toP2PKScript(publicKey)}, nil
+}
+
+func (e *pkExpression) isRange() bool {
+	return e.key.isRange()
+}
+
+func (e *pkExpression) String() string {
+	return fmt.Sprintf("pk(%s)", e.key)
+}
+
+func (e *pkhExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	publicKey, err := e.key.bytesAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := bitcoin.ToP2PKHScript(hash.Hash160(publicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{script}, nil
+}
+
+func (e *pkhExpression) isRange() bool {
+	return e.key.isRange()
+}
+
+func (e *pkhExpression) String() string {
+	return fmt.Sprintf("pkh(%s)", e.key)
+}
+
+func (e *wpkhExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	publicKey, err := e.key.bytesAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := bitcoin.ToP2WPKHScript(hash.Hash160(publicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{script}, nil
+}
+
+func (e *wpkhExpression) isRange() bool {
+	return e.key.isRange()
+}
+
+func (e *wpkhExpression) String() string {
+	return fmt.Sprintf("wpkh(%s)", e.key)
+}
+
+// The scripts of combo() are pk() and pkh(), followed by wpkh() and
+// sh(wpkh()) when the key is compressed
+func (e *comboExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	expressions := []expression{&pkExpression{e.key}, &pkhExpression{e.key}}
+	if e.key.isCompressed {
+		wpkh := &wpkhExpression{e.key}
+		expressions = append(expressions, wpkh, &shExpression{wpkh})
+	}
+
+	scripts := make([]*bitcoin.Script, 0, len(expressions))
+	for _, expression := range expressions {
+		expressionScripts, err := expression.scripts(index)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, expressionScripts...)
+	}
+
+	return scripts, nil
+}
+
+func (e *comboExpression) isRange() bool {
+	return e.key.isRange()
+}
+
+func (e *comboExpression) String() string {
+	return fmt.Sprintf("combo(%s)", e.key)
+}
+
+func (e *shExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	redeemScript, err := singleScript(e.script, index)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := bitcoin.ToP2SHScript(hash.Hash160(redeemScript))
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{script}, nil
+}
+
+func (e *shExpression) isRange() bool {
+	return e.script.isRange()
+}
+
+func (e *shExpression) String() string {
+	return fmt.Sprintf("sh(%s)", e.script)
+}
+
+func (e *wshExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	witnessScript, err := singleScript(e.script, index)
+	if err != nil {
+		return nil, err
+	}
+
+	witnessScriptHash := sha256.Sum256(witnessScript)
+	script, err := bitcoin.ToP2WSHScript(witnessScriptHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{script}, nil
+}
+
+func (e *wshExpression) isRange() bool {
+	return e.script.isRange()
+}
+
+func (e *wshExpression) String() string {
+	return fmt.Sprintf("wsh(%s)", e.script)
+}
+
+// The script OP_m <public key>... OP_n OP_CHECKMULTISIG, where the keys of
+// sortedmulti() are sorted, see BIP 67
+func (e *multiExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	publicKeys := make([][]byte, 0, len(e.keys))
+	for _, k := range e.keys {
+		publicKey, err := k.bytesAt(index)
+		if err != nil {
+			return nil, err
+		}
+		publicKeys = append(publicKeys, publicKey)
+	}
+
+	if e.isSorted {
+		slices.SortFunc(publicKeys, bytes.Compare)
+	}
+
+	instructions := []op.Instruction{pushNumber(e.threshold)}
+	for _, publicKey := range publicKeys {
+		instructions = append(instructions, *op.NewPushData(byte(len(publicKey)), publicKey))
+	}
+	instructions = append(instructions, pushNumber(len(publicKeys)), *op.NewOpCode(0xae)) // OP_CHECKMULTISIG
+
+	return []*bitcoin.Script{bitcoin.NewScript(instructions)}, nil
+}
+
+// Returns the length of the script, which is the same at all indexes
+func (e *multiExpression) length() int {
+	// The threshold, the number of keys and OP_CHECKMULTISIG
+	length := 3
+	for _, k := range e.keys {
+		length += 1 + k.length()
+	}
+
+	return length
+}
+
+func (e *multiExpression) isRange() bool {
+	return slices.ContainsFunc(e.keys, (*key).isRange)
+}
+
+func (e *multiExpression) String() string {
+	name := "multi"
+	if e.isSorted {
+		name = "sortedmulti"
+	}
+
+	keys := make([]string, 0, len(e.keys))
+	for _, k := range e.keys {
+		keys = append(keys, k.String())
+	}
+
+	return fmt.Sprintf("%s(%d,%s)", name, e.threshold, strings.Join(keys, ","))
+}
+
+func (e *trExpression) scripts(index uint32) ([]*bitcoin.Script, error) {
+	internalKey, err := e.internalKey.bytesAt(index)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ecc.ParseXOnly(internalKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var merkleRoot []byte
+	if e.tree != nil {
+		merkleRoot, err = e.tree.hash(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	outputKey, err := bitcoin.TaprootOutputKey(publicKey, merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := bitcoin.ToP2TRScript(outputKey.XOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	return []*bitcoin.Script{script}, nil
+}
+
+func (e *trExpression) isRange() bool {
+	return e.internalKey.isRange() || (e.tree != nil && e.tree.isRange())
+}
+
+func (e *trExpression) String() string {
+	if e.tree == nil {
+		return fmt.Sprintf("tr(%s)", e.internalKey)
+	}
+
+	return fmt.Sprintf("tr(%s,%s)", e.internalKey, e.tree)
+}
+
+// Returns the hash of the node at index, which is the merkle root of the tree
+// when the node is the root, see BIP 341
+func (t *tapTree) hash(index uint32) ([]byte, error) {
+	if t.leaf != nil {
+		script, err := singleScript(t.leaf, index)
+		if err != nil {
+			return nil, err
+		}
+
+		return bitcoin.TapLeafHash(bitcoin.TapscriptLeafVersion, script), nil
+	}
+
+	left, err := t.left.hash(index)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.right.hash(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return bitcoin.TapBranchHash(left, right), nil
+}
+
+func (t *tapTree) isRange() bool {
+	if t.leaf != nil {
+		return t.leaf.isRange()
+	}
+
+	return t.left.isRange() || t.right.isRange()
+}
+
+func (t *tapTree) String() string {
+	if t.leaf != nil {
+		return t.leaf.String()
+	}
+
+	return fmt.Sprintf("{%s,%s}", t.left, t.right)
+}
+
+func (e *addrExpression) scripts(uint32) ([]*bitcoin.Script, error) {
+	return []*bitcoin.Script{e.address.ScriptPubKey()}, nil
+}
+
+func (e *addrExpression) isRange() bool {
+	return false
+}
+
+func (e *addrExpression) String() string {
+	return fmt.Sprintf("addr(%s)", e.address)
+}
+
+func (e *rawExpression) scripts(uint32) ([]*bitcoin.Script, error) {
+	return []*bitcoin.Script{e.script}, nil
+}
+
+func (e *rawExpression) isRange() bool {
+	return false
+}
+
+func (e *rawExpression) String() string {
+	raw, _ := e.script.RawSerialize()
+
+	return fmt.Sprintf("raw(%x)", raw)
+}
+
+// Returns the serialization of the script of an expression that is nested in
+// another one, which has a single script
+func singleScript(e expression, index uint32) ([]byte, error) {
+	scripts, err := e.scripts(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return scripts[0].RawSerialize()
+}
+
+// Returns the script <public key> OP_CHECKSIG
+func toP2PKScript(publicKey []byte) *bitcoin.Script {
+	return bitcoin.NewScript([]op.Instruction{
+		*op.NewPushData(byte(len(publicKey)), publicKey),
+		*op.NewOpCode(0xac), // OP_CHECKSIG
+	})
+}
+
+// Returns the instruction that pushes a number of keys of a multisig script,
+// which is OP_1 to OP_16 or the number itself
+func pushNumber(n int) op.Instruction {
+	if n <= 16 {
+		return *op.NewOpCode(byte(0x50 + n))
+	}
+
+	return *op.NewPushData(1, []byte{byte(n)})
+}
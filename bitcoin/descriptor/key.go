@@ -0,0 +1,262 @@
+package descriptor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+)
+
+// The child derived at the end of the path of an extended key
+type wildcard int
+
+const (
+	// The path has no wildcard, so the key is the same at every index
+	noWildcard wildcard = iota
+	// /* derives the unhardened child of the index
+	unhardenedWildcard
+	// /*' or /*h derives the hardened child of the index
+	hardenedWildcard
+)
+
+// The fingerprint of the master key and the path that a key was
+// derived at, written as [fingerprint/path] before the key.
+type keyOrigin struct {
+	masterFingerprint []byte
+	path              []uint32
+}
+
+// A key expression, which is a public key in hex, a private key in WIF or an
+// extended key with a path, see BIP 380
+type key struct {
+	origin *keyOrigin
+	// The key of a hex public key or a WIF private key
+	publicKey    *ecc.S256Point
+	isCompressed bool
+	// Whether the key is used as an x-only key in tr(), see BIP 386
+	isXOnly bool
+	// The key of an extended key, from which the child at path is derived
+	extendedKey *hdkey.ExtendedKey
+	path        []uint32
+	wildcard    wildcard
+	// The expression as written
+	text string
+}
+
+// Parses a key expression in ctx, where uncompressed keys are only allowed
+// outside of wsh() and tr(), and x-only keys only in tr()
+func parseKey(s string, ctx context, params *chaincfg.Params) (*key, error) {
+	k := &key{text: s, isXOnly: ctx == trContext}
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("key origin %s has no end", s)
+		}
+
+		origin, err := parseKeyOrigin(s[1:end])
+		if err != nil {
+			return nil, err
+		}
+		k.origin = origin
+		s = s[end+1:]
+	}
+
+	parts := strings.Split(s, "/")
+	if err := k.parseKey(parts[0], ctx, params); err != nil {
+		return nil, err
+	}
+
+	if len(parts) > 1 && k.extendedKey == nil {
+		return nil, fmt.Errorf("key %s has a derivation path, but is not an extended key", parts[0])
+	}
+
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			switch part {
+			case "*":
+				k.wildcard = unhardenedWildcard
+				continue
+			case "*'", "*h":
+				k.wildcard = hardenedWildcard
+				continue
+			}
+		}
+
+		index, err := parseIndex(part)
+		if err != nil {
+			return nil, err
+		}
+		k.path = append(k.path, index)
+	}
+
+	return k, nil
+}
+
+// Parses the key of a key expression without its origin and path
+func (k *key) parseKey(s string, ctx context, params *chaincfg.Params) error {
+	if data, err := hex.DecodeString(s); err == nil {
+		switch {
+		case len(data) == 32 && ctx == trContext:
+			publicKey, err := ecc.ParseXOnly(data)
+			if err != nil {
+				return err
+			}
+			k.publicKey, k.isCompressed = publicKey, true
+		case len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03),
+			len(data) == 65 && data[0] == 0x04:
+			publicKey, err := ecc.Parse(data)
+			if err != nil {
+				return err
+			}
+			k.publicKey, k.isCompressed = publicKey, len(data) == 33
+		default:
+			return fmt.Errorf("invalid public key %s", s)
+		}
+
+		return k.checkCompressed(ctx)
+	}
+
+	if privateKey, isCompressed, network, err := ecc.ParseWIF(s); err == nil {
+		if network.PrivateKeyID != params.PrivateKeyID {
+			return fmt.Errorf("private key %s is not of network %s", s, params.Name)
+		}
+		k.publicKey, k.isCompressed = privateKey.PublicKey(), isCompressed
+
+		return k.checkCompressed(ctx)
+	}
+
+	extendedKey, err := hdkey.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid key %s", s)
+	}
+	if extendedKey.Params().HDPublicKeyID != params.HDPublicKeyID {
+		return fmt.Errorf("extended key %s is not of network %s", s, params.Name)
+	}
+	k.extendedKey = extendedKey
+	k.isCompressed = true
+
+	return nil
+}
+
+// Uncompressed keys can not be used in segwit scripts
+func (k *key) checkCompressed(ctx context) error {
+	if !k.isCompressed && (ctx == wshContext || ctx == trContext) {
+		return fmt.Errorf("uncompressed key %s in a segwit script", k.text)
+	}
+
+	return nil
+}
+
+// Parses the fingerprint/path of a key origin
+func parseKeyOrigin(s string) (*keyOrigin, error) {
+	parts := strings.Split(s, "/")
+
+	fingerprint, err := hex.DecodeString(parts[0])
+	if err != nil || len(fingerprint) != 4 {
+		return nil, fmt.Errorf("invalid fingerprint %s in key origin", parts[0])
+	}
+
+	path := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		index, err := parseIndex(part)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, index)
+	}
+
+	return &keyOrigin{fingerprint, path}, nil
+}
+
+// Parses an element of a derivation path, which is hardened when it ends in
+// ' or h
+func parseIndex(s string) (uint32, error) {
+	offset := uint32(0)
+	if trimmed, found := strings.CutSuffix(s, "'"); found {
+		s, offset = trimmed, hdkey.HardenedKeyStart
+	} else if trimmed, found := strings.CutSuffix(s, "h"); found {
+		s, offset = trimmed, hdkey.HardenedKeyStart
+	}
+
+	// ParseUint would allow a sign
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid path element %q", s)
+	}
+
+	index, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || index >= uint64(hdkey.HardenedKeyStart) {
+		return 0, fmt.Errorf("path element %q is out of range", s)
+	}
+
+	return uint32(index) + offset, nil
+}
+
+// Returns whether the key is different at each index
+func (k *key) isRange() bool {
+	return k.wildcard != noWildcard
+}
+
+// Returns the public key at index
+func (k *key) publicKeyAt(index uint32) (*ecc.S256Point, error) {
+	if k.extendedKey == nil {
+		return k.publicKey, nil
+	}
+
+	extendedKey := k.extendedKey
+	path := k.path
+	switch k.wildcard {
+	case unhardenedWildcard:
+		path = append(path[:len(path):len(path)], index)
+	case hardenedWildcard:
+		path = append(path[:len(path):len(path)], index+hdkey.HardenedKeyStart)
+	}
+
+	for _, childIndex := range path {
+		child, err := extendedKey.Child(childIndex)
+		if err != nil {
+			return nil, err
+		}
+		extendedKey = child
+	}
+
+	return extendedKey.PublicKey(), nil
+}
+
+// Returns the serialization of the public key at index as it is pushed in
+// scripts, which is x-only in tr()
+func (k *key) bytesAt(index uint32) ([]byte, error) {
+	publicKey, err := k.publicKeyAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case k.isXOnly:
+		return publicKey.XOnly(), nil
+	case k.isCompressed:
+		return publicKey.SECCompressed(), nil
+	}
+
+	return publicKey.SEC(), nil
+}
+
+// Returns the length of the serialization of the key in scripts
+func (k *key) length() int {
+	switch {
+	case k.isXOnly:
+		return 32
+	case k.isCompressed:
+		return 33
+	}
+
+	return 65
+}
+
+func (k *key) String() string {
+	return k.text
+}
@@ -0,0 +1,320 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
+)
+
+// A signed message proves the ownership of an address by signing a message
+// with its key. Pay-to-public-key-hash addresses use the legacy format of
+// Bitcoin Core's signmessage, a base64 compact signature of the message,
+// which the public key is recovered from. The other addresses use the
+// generic format of BIP 322, where the signature spends an output to the
+// address in a virtual transaction that is verified by the script
+// interpreter.
+
+// The prefix of the messages of the legacy format, which keeps the hash of a
+// message from being the hash of a transaction
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// The prefixes of the base64 encoding of the variants of BIP 322 signatures
+const (
+	// The witness stack of the to_sign transaction
+	simpleSignaturePrefix = "smp"
+	// The whole to_sign transaction
+	fullSignaturePrefix = "ful"
+	// A PSBT of the to_sign transaction with additional inputs
+	proofOfFundsSignaturePrefix = "pof"
+)
+
+// The script rules of the verification of BIP 322 signatures
+const messageVerifyFlags = op.StandardVerifyFlags
+
+// MessageHash returns the hash that a message is signed with in the legacy
+// format, which is the hash256 of the magic prefix and the message, each
+// prefixed with its length.
+func MessageHash(message string) []byte {
+	data := varString(messageMagic)
+	data = append(data, varString(message)...)
+
+	return hash.Hash256(data)
+}
+
+// Returns the string prefixed with its length as a varint
+func varString(s string) []byte {
+	length, err := varint.Encode(uint64(len(s)))
+	if err != nil {
+		return nil
+	}
+
+	return append(length, s...)
+}
+
+// BIP322MessageHash returns the tagged hash of a message, which the to_spend
+// transaction of BIP 322 commits to.
+func BIP322MessageHash(message string) []byte {
+	return hash.TaggedHash("BIP0322-signed-message", []byte(message))
+}
+
+// SignMessage signs message with the private key of address on the network
+// of params. A pay-to-public-key-hash address gets a signature of the legacy
+// format, native segwit and taproot addresses a simple BIP 322 signature and
+// a pay-to-script-hash nested pay-to-witness-public-key-hash address a full
+// BIP 322 signature. Taproot addresses must be of the internal key
+// privateKey without a script tree.
+func SignMessage(privateKey *ecc.PrivateKey, address string, message string, params *chaincfg.Params) (string, error) {
+	decoded, err := DecodeAddress(address, params)
+	if err != nil {
+		return "", err
+	}
+
+	if p2pkh, ok := decoded.(*P2PKHAddress); ok {
+		return signLegacyMessage(privateKey, p2pkh, message)
+	}
+
+	toSpend, err := newToSpendTx(decoded.ScriptPubKey(), message, params)
+	if err != nil {
+		return "", err
+	}
+	toSign := newToSignTx(toSpend, nil, params)
+	toSign.PrevOutFetcher = PrevOutMap{toSign.Inputs[0].String(): toSpend.Outputs[0]}
+
+	hashType := SigHashAll
+	if decoded.ScriptPubKey().IsP2TRScriptPubKey() {
+		hashType = SigHashDefault
+	}
+
+	valid, err := toSign.SignInput(0, privateKey, hashType)
+	if err != nil {
+		return "", err
+	}
+	if !valid {
+		return "", fmt.Errorf("private key does not match the address %s", address)
+	}
+
+	if _, ok := decoded.(*P2SHAddress); ok {
+		return fullSignaturePrefix + base64.StdEncoding.EncodeToString(toSign.Serialize()), nil
+	}
+
+	return simpleSignaturePrefix + base64.StdEncoding.EncodeToString(SerializeWitness(toSign.Inputs[0].Witness)), nil
+}
+
+// Returns the base64 compact signature of the message hash, where the
+// compression of the public key is the one of the address
+func signLegacyMessage(privateKey *ecc.PrivateKey, address *P2PKHAddress, message string) (string, error) {
+	var isCompressed bool
+	switch {
+	case bytes.Equal(privateKey.PublicKey().Hash160(true), address.Hash160()):
+		isCompressed = true
+	case bytes.Equal(privateKey.PublicKey().Hash160(false), address.Hash160()):
+		isCompressed = false
+	default:
+		return "", fmt.Errorf("private key does not match the address %s", address)
+	}
+
+	z := new(big.Int).SetBytes(MessageHash(message))
+	signature, err := privateKey.SignCompact(z, isCompressed)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature.Serialize()), nil
+}
+
+// VerifyMessage returns whether signature is a signature of message by the
+// owner of address on the network of params. A pay-to-public-key-hash
+// address accepts signatures of the legacy format, and every address accepts
+// simple and full BIP 322 signatures, where a signature without a prefix is
+// simple. The lock time and the sequence that a full signature may set are
+// not checked against the chain. Addresses and signatures that can not be
+// decoded are errors, while a signature that does not verify, for whatever
+// reason, is false.
+func VerifyMessage(address string, message string, signature string, params *chaincfg.Params) (bool, error) {
+	decoded, err := DecodeAddress(address, params)
+	if err != nil {
+		return false, err
+	}
+
+	if p2pkh, ok := decoded.(*P2PKHAddress); ok {
+		raw, err := base64.StdEncoding.DecodeString(signature)
+		if err == nil && len(raw) == ecc.CompactSignatureLength {
+			return verifyLegacyMessage(p2pkh, message, raw)
+		}
+	}
+
+	challenge := decoded.ScriptPubKey()
+	toSpend, err := newToSpendTx(challenge, message, params)
+	if err != nil {
+		return false, err
+	}
+
+	var toSign *Tx
+	switch {
+	case strings.HasPrefix(signature, proofOfFundsSignaturePrefix):
+		return false, fmt.Errorf("proof of funds signatures are not supported")
+	case strings.HasPrefix(signature, fullSignaturePrefix):
+		toSign, err = parseFullSignature(strings.TrimPrefix(signature, fullSignaturePrefix), params)
+		if err != nil {
+			return false, err
+		}
+
+		if !isToSignTx(toSign, toSpend) {
+			return false, nil
+		}
+	default:
+		witness, err := parseSimpleSignature(strings.TrimPrefix(signature, simpleSignaturePrefix))
+		if err != nil {
+			return false, err
+		}
+
+		toSign = newToSignTx(toSpend, witness, params)
+	}
+
+	// Witness versions above 1 and other transaction versions are reserved
+	// for upgrades, so such signatures can not be verified
+	if version, _, ok := challenge.WitnessProgram(); ok && version > 1 {
+		return false, nil
+	}
+	if toSign.Version != 0 && toSign.Version != 2 {
+		return false, nil
+	}
+
+	toSign.PrevOutFetcher = PrevOutMap{toSign.Inputs[0].String(): toSpend.Outputs[0]}
+	// The spent output is known, so verification fails only because of the
+	// signature
+	valid, err := toSign.VerifyInput(0, messageVerifyFlags)
+	if err != nil {
+		return false, nil
+	}
+
+	return valid, nil
+}
+
+// Returns whether the compact signature of the message hash recovers the
+// public key of the address
+func verifyLegacyMessage(address *P2PKHAddress, message string, raw []byte) (bool, error) {
+	signature, err := ecc.ParseCompact(raw)
+	if err != nil {
+		return false, nil
+	}
+
+	z := new(big.Int).SetBytes(MessageHash(message))
+	publicKey, err := ecc.RecoverPublicKey(z, signature)
+	if err != nil {
+		return false, nil
+	}
+
+	return bytes.Equal(publicKey.Hash160(signature.IsCompressed), address.Hash160()), nil
+}
+
+// Returns the witness stack of a simple BIP 322 signature
+func parseSimpleSignature(signature string) ([][]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 signature: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("signature is empty")
+	}
+
+	reader := bytes.NewReader(raw)
+	witness, err := ParseWitness(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid witness stack: %w", err)
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("invalid witness stack: %d bytes left", reader.Len())
+	}
+
+	return witness, nil
+}
+
+// Returns the to_sign transaction of a full BIP 322 signature
+func parseFullSignature(signature string, params *chaincfg.Params) (*Tx, error) {
+	raw, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 signature: %w", err)
+	}
+
+	reader := bytes.NewReader(raw)
+	tx, err := Parse(reader, params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to_sign transaction: %w", err)
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("invalid to_sign transaction: bytes left")
+	}
+
+	return tx, nil
+}
+
+// Returns the virtual transaction of BIP 322 whose only output is spent by
+// the signature. It pays nothing to challenge, the scriptPubKey of the
+// address, from an input that commits to the message.
+func newToSpendTx(challenge *Script, message string, params *chaincfg.Params) (*Tx, error) {
+	messageHash, err := op.NewInstruction(BIP322MessageHash(message))
+	if err != nil {
+		return nil, err
+	}
+
+	scriptSig := NewScript([]op.Instruction{
+		*op.NewOpCode(0x00), // OP_0
+		*messageHash,
+	})
+	input := NewTxInput(make([]byte, 32), big.NewInt(0xffffffff), scriptSig, big.NewInt(0))
+	output := &TxOutput{Amount: 0, ScriptPubKey: *challenge}
+
+	return NewTx(0, []*TxInput{input}, []*TxOutput{output}, 0, params), nil
+}
+
+// Returns the virtual transaction of BIP 322 that spends the output of
+// toSpend with witness and pays nothing to OP_RETURN
+func newToSignTx(toSpend *Tx, witness [][]byte, params *chaincfg.Params) *Tx {
+	input := NewTxInput(toSpend.hash(), big.NewInt(0), NewScript([]op.Instruction{}), big.NewInt(0))
+	input.Witness = witness
+
+	return NewTx(0, []*TxInput{input}, []*TxOutput{newOpReturnOutput()}, 0, params)
+}
+
+// Returns an output of zero that can not be spent
+func newOpReturnOutput() *TxOutput {
+	return &TxOutput{
+		Amount:       0,
+		ScriptPubKey: *NewScript([]op.Instruction{*op.NewOpCode(0x6a)}), // OP_RETURN
+	}
+}
+
+// Returns whether the transaction of a full signature has the fields of a
+// to_sign transaction of toSpend. The version, the lock time and the
+// sequence may be set for time locks.
+func isToSignTx(tx *Tx, toSpend *Tx) bool {
+	if len(tx.Inputs) != 1 || len(tx.Outputs) != 1 {
+		return false
+	}
+
+	input := tx.Inputs[0]
+	if !bytes.Equal(input.PrevTx, toSpend.hash()) || input.PrevIndex.Sign() != 0 {
+		return false
+	}
+
+	expected, err := newOpReturnOutput().ScriptPubKey.RawSerialize()
+	if err != nil {
+		return false
+	}
+	script, err := tx.Outputs[0].ScriptPubKey.RawSerialize()
+	if err != nil {
+		return false
+	}
+
+	return tx.Outputs[0].Amount == 0 && bytes.Equal(script, expected)
+}
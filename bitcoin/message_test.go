@@ -0,0 +1,280 @@
+package bitcoin_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+type bip322Signature struct {
+	Message       string   `json:"message"`
+	PrivateKeys   []string `json:"private_keys"`
+	Address       string   `json:"address"`
+	Type          string   `json:"type"`
+	Signatures    []string `json:"bip322_signatures"`
+	Description   string   `json:"description"`
+	Signature     string   `json:"signature"`
+	ErrorContains string   `json:"error_substr"`
+}
+
+type bip322Vectors struct {
+	TxHashes []struct {
+		Message     string `json:"message"`
+		MessageHash string `json:"message_hash"`
+	} `json:"tx_hashes"`
+	Simple       []bip322Signature `json:"simple"`
+	Full         []bip322Signature `json:"full"`
+	ProofOfFunds []bip322Signature `json:"proof_of_funds"`
+	Error        []bip322Signature `json:"error"`
+}
+
+func readBIP322Vectors(t *testing.T, name string) *bip322Vectors {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	var vectors bip322Vectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	return &vectors
+}
+
+func TestLegacySignMessage(t *testing.T) {
+	// The signature of the signmessage test of Bitcoin Core
+	privateKey, _, params, err := ecc.ParseWIF("cUeKHd5orzT3mz8P9pxyREHfsWtVfgsfDjiZZBcjUBAaGk1BTj7N")
+	if err != nil {
+		t.Fatalf("ParseWIF: got error %v, expected nil", err)
+	}
+	address := "mpLQjfK79b7CCV4VMJWEWAj5Mpx8Up5zxB"
+	message := "This is just a test message"
+
+	signature, err := bitcoin.SignMessage(privateKey, address, message, params)
+	if err != nil {
+		t.Fatalf("SignMessage: got error %v, expected nil", err)
+	}
+
+	expected := "INbVnW4e6PeRmsv2Qgu8NuopvrVjkcxob+sX8OcZG0SALhWybUjzMLPdAsXI46YZGb0KQTRii+wWIQzRpG/U+S0="
+	if signature != expected {
+		t.Errorf("SignMessage: got %s, expected %s", signature, expected)
+	}
+
+	valid, err := bitcoin.VerifyMessage(address, message, signature, params)
+	if err != nil || !valid {
+		t.Errorf("VerifyMessage: got %v, %v, expected true", valid, err)
+	}
+
+	valid, err = bitcoin.VerifyMessage(address, "Another message", signature, params)
+	if err != nil || valid {
+		t.Errorf("VerifyMessage: got %v, %v for another message, expected false", valid, err)
+	}
+
+	valid, err = bitcoin.VerifyMessage("mfcSEPR8EkJrpX91YkTJ9iscdAzppJrG9j", message, signature, params)
+	if err != nil || valid {
+		t.Errorf("VerifyMessage: got %v, %v for another address, expected false", valid, err)
+	}
+}
+
+func TestLegacySignMessageUncompressed(t *testing.T) {
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(12345))
+	address := privateKey.PublicKey().Address(false, &chaincfg.MainNetParams)
+
+	signature, err := bitcoin.SignMessage(privateKey, address, "Hello World", &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("SignMessage: got error %v, expected nil", err)
+	}
+
+	valid, err := bitcoin.VerifyMessage(address, "Hello World", signature, &chaincfg.MainNetParams)
+	if err != nil || !valid {
+		t.Errorf("VerifyMessage: got %v, %v, expected true", valid, err)
+	}
+
+	// The signature is for the uncompressed public key only
+	compressed := privateKey.PublicKey().Address(true, &chaincfg.MainNetParams)
+	valid, err = bitcoin.VerifyMessage(compressed, "Hello World", signature, &chaincfg.MainNetParams)
+	if err != nil || valid {
+		t.Errorf("VerifyMessage: got %v, %v for the compressed address, expected false", valid, err)
+	}
+
+	other, _ := ecc.NewPrivateKey(big.NewInt(54321))
+	if _, err := bitcoin.SignMessage(other, address, "Hello World", &chaincfg.MainNetParams); err == nil {
+		t.Errorf("SignMessage: expected an error for the key of another address")
+	}
+}
+
+func TestBIP322MessageHash(t *testing.T) {
+	for _, vector := range readBIP322Vectors(t, "bip322_basic_test_vectors.json").TxHashes {
+		messageHash := hex.EncodeToString(bitcoin.BIP322MessageHash(vector.Message))
+		if messageHash != vector.MessageHash {
+			t.Errorf("BIP322MessageHash(%q): got %s, expected %s", vector.Message, messageHash, vector.MessageHash)
+		}
+	}
+}
+
+func TestBIP322VerifyMessage(t *testing.T) {
+	for _, name := range []string{"bip322_basic_test_vectors.json", "bip322_generated_test_vectors.json"} {
+		vectors := readBIP322Vectors(t, name)
+
+		for _, vector := range append(vectors.Simple, vectors.Full...) {
+			for _, signature := range vector.Signatures {
+				valid, err := bitcoin.VerifyMessage(vector.Address, vector.Message, signature, &chaincfg.MainNetParams)
+				if err != nil || !valid {
+					t.Errorf("VerifyMessage(%s, %s): got %v, %v, expected true", vector.Type, vector.Address, valid, err)
+				}
+			}
+		}
+
+		for _, vector := range vectors.Error {
+			valid, err := bitcoin.VerifyMessage(vector.Address, vector.Message, vector.Signature, &chaincfg.MainNetParams)
+			if err == nil && valid {
+				t.Errorf("VerifyMessage: got true for %s", vector.Description)
+			}
+		}
+
+		for _, vector := range vectors.ProofOfFunds {
+			if _, err := bitcoin.VerifyMessage(vector.Address, vector.Message, vector.Signatures[0], &chaincfg.MainNetParams); err == nil {
+				t.Errorf("VerifyMessage: expected an error for the proof of funds of %s", vector.Type)
+			}
+		}
+	}
+}
+
+func TestBIP322VerifyMessageOversizedTx(t *testing.T) {
+	// A full signature whose to_sign transaction claims 0x7fffffff inputs,
+	// and a simple signature whose witness claims 0x7fffffff elements
+	tests := []struct {
+		prefix string
+		raw    string
+	}{
+		{"ful", "01000000feffffff7f"},
+		{"", "feffffff7f"},
+	}
+
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.raw)
+		signature := test.prefix + base64.StdEncoding.EncodeToString(raw)
+
+		valid, err := bitcoin.VerifyMessage("bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l", "x", signature, &chaincfg.MainNetParams)
+		if err == nil || valid {
+			t.Errorf("VerifyMessage(%s): got %v, %v, expected an error", signature, valid, err)
+		}
+	}
+}
+
+func TestBIP322SignMessage(t *testing.T) {
+	vectors := readBIP322Vectors(t, "bip322_basic_test_vectors.json")
+	privateKey, _, _, err := ecc.ParseWIF("L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k")
+	if err != nil {
+		t.Fatalf("ParseWIF: got error %v, expected nil", err)
+	}
+
+	// The signatures of ECDSA are deterministic, so the signature of the
+	// P2WPKH vectors is one of the expected ones
+	for _, vector := range vectors.Simple {
+		if vector.Type != "p2wpkh" {
+			continue
+		}
+
+		signature, err := bitcoin.SignMessage(privateKey, vector.Address, vector.Message, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("SignMessage: got error %v, expected nil", err)
+		}
+
+		if !strings.HasPrefix(signature, "smp") {
+			t.Errorf("SignMessage: got %s, expected a simple signature", signature)
+		}
+
+		found := false
+		for _, expected := range vector.Signatures {
+			found = found || signature == expected
+		}
+		if !found {
+			t.Errorf("SignMessage(%q): got %s, expected one of %v", vector.Message, signature, vector.Signatures)
+		}
+	}
+
+	addresses := map[string]func() (string, error){
+		"p2wpkh": func() (string, error) {
+			return bitcoin.H160ToP2WPKHAddress(privateKey.PublicKey().Hash160(true), &chaincfg.MainNetParams)
+		},
+		"p2sh-p2wpkh": func() (string, error) {
+			redeemScript, _ := bitcoin.ToP2WPKHScript(privateKey.PublicKey().Hash160(true))
+			raw, _ := redeemScript.RawSerialize()
+			return bitcoin.H160ToP2SHAddress(hash.Hash160(raw), &chaincfg.MainNetParams), nil
+		},
+		"p2tr": func() (string, error) {
+			outputKey, err := bitcoin.TaprootOutputKey(privateKey.PublicKey(), nil)
+			if err != nil {
+				return "", err
+			}
+			return bitcoin.OutputKeyToP2TRAddress(outputKey.XOnly(), &chaincfg.MainNetParams)
+		},
+	}
+
+	for name, newAddress := range addresses {
+		t.Run(name, func(t *testing.T) {
+			address, err := newAddress()
+			if err != nil {
+				t.Fatalf("address: got error %v, expected nil", err)
+			}
+
+			signature, err := bitcoin.SignMessage(privateKey, address, "Hello World", &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("SignMessage: got error %v, expected nil", err)
+			}
+
+			valid, err := bitcoin.VerifyMessage(address, "Hello World", signature, &chaincfg.MainNetParams)
+			if err != nil || !valid {
+				t.Errorf("VerifyMessage: got %v, %v, expected true", valid, err)
+			}
+
+			valid, err = bitcoin.VerifyMessage(address, "Another message", signature, &chaincfg.MainNetParams)
+			if err != nil || valid {
+				t.Errorf("VerifyMessage: got %v, %v for another message, expected false", valid, err)
+			}
+		})
+	}
+}
+
+func TestVerifyMessageInvalidSignatures(t *testing.T) {
+	params := &chaincfg.MainNetParams
+
+	// A witness script that ends in the middle of a push
+	witnessScript := []byte{0x4c}
+	p2wsh, _ := bitcoin.H256ToP2WSHAddress(hash.HashSHA256(witnessScript), params)
+	p2sh := bitcoin.H160ToP2SHAddress(hash.Hash160([]byte{0x51}), params)
+	p2pkh := bitcoin.H160ToP2PKHAddress(make([]byte, 20), params)
+
+	// A legacy signature with an invalid header byte
+	compact := make([]byte, 65)
+
+	tests := []struct {
+		name      string
+		address   string
+		signature string
+	}{
+		{"unparsable witness script", p2wsh, "smp" + base64.StdEncoding.EncodeToString(bitcoin.SerializeWitness([][]byte{witnessScript}))},
+		{"pay-to-script-hash without a redeem script", p2sh, "smp" + base64.StdEncoding.EncodeToString(bitcoin.SerializeWitness(nil))},
+		{"legacy signature with an invalid header", p2pkh, base64.StdEncoding.EncodeToString(compact)},
+	}
+
+	for _, test := range tests {
+		valid, err := bitcoin.VerifyMessage(test.address, "x", test.signature, params)
+		if err != nil || valid {
+			t.Errorf("VerifyMessage(%s): got %v, %v, expected false", test.name, valid, err)
+		}
+	}
+}
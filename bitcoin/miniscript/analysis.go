@@ -0,0 +1,331 @@
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// The maximum number of non-push op codes that are executed in a P2WSH
+// script
+const maxOpsPerScript = 201
+
+// The maximum number of witness elements of a standard P2WSH input
+const maxStandardP2WSHStackItems = 100
+
+// The maximum number of elements on the stack of Tapscript
+const maxStackSize = 1000
+
+// A maximum that is not valid when there is no way of reaching it, such as
+// the dissatisfaction of an expression that can not be dissatisfied
+type maxInt struct {
+	valid bool
+	value int
+}
+
+func valid(value int) maxInt {
+	return maxInt{true, value}
+}
+
+// Returns the sum of the maximums, which is only valid when both are
+func (a maxInt) add(b maxInt) maxInt {
+	if !a.valid || !b.valid {
+		return maxInt{}
+	}
+
+	return valid(a.value + b.value)
+}
+
+// Returns the largest of the maximums that are valid
+func (a maxInt) or(b maxInt) maxInt {
+	if !a.valid {
+		return b
+	}
+	if !b.valid || a.value >= b.value {
+		return a
+	}
+
+	return b
+}
+
+// The maximum of something for the satisfaction and the dissatisfaction of
+// an expression
+type satDsat struct {
+	sat, dsat maxInt
+}
+
+// The number of non-push op codes of the script, together with the maximum
+// number of op codes executed by the keys of OP_CHECKMULTISIG when the
+// expression is satisfied and dissatisfied
+type opsCount struct {
+	count     int
+	sat, dsat maxInt
+}
+
+// Returns the op codes of the script of the expression
+func (n *Node) computeOps() opsCount {
+	var x, y, z opsCount
+	if len(n.subs) > 0 {
+		x = n.subs[0].ops
+	}
+	if len(n.subs) > 1 {
+		y = n.subs[1].ops
+	}
+	if len(n.subs) > 2 {
+		z = n.subs[2].ops
+	}
+
+	none := maxInt{}
+	switch n.fragment {
+	case fragmentJust1:
+		return opsCount{0, valid(0), none}
+	case fragmentJust0:
+		return opsCount{0, none, valid(0)}
+	case fragmentPkK:
+		return opsCount{0, valid(0), valid(0)}
+	case fragmentPkH:
+		return opsCount{3, valid(0), valid(0)}
+	case fragmentOlder, fragmentAfter:
+		return opsCount{1, valid(0), none}
+	case fragmentSHA256, fragmentHash256, fragmentRIPEMD160, fragmentHash160:
+		return opsCount{4, valid(0), none}
+	case fragmentAndV:
+		return opsCount{x.count + y.count, x.sat.add(y.sat), none}
+	case fragmentAndB:
+		return opsCount{1 + x.count + y.count, x.sat.add(y.sat), x.dsat.add(y.dsat)}
+	case fragmentOrB:
+		return opsCount{1 + x.count + y.count, x.sat.add(y.dsat).or(y.sat.add(x.dsat)), x.dsat.add(y.dsat)}
+	case fragmentOrD:
+		return opsCount{3 + x.count + y.count, x.sat.or(y.sat.add(x.dsat)), y.dsat.add(x.dsat)}
+	case fragmentOrC:
+		return opsCount{2 + x.count + y.count, x.sat.or(y.sat.add(x.dsat)), none}
+	case fragmentOrI:
+		return opsCount{3 + x.count + y.count, x.sat.or(y.sat), x.dsat.or(y.dsat)}
+	case fragmentAndOr:
+		return opsCount{3 + x.count + y.count + z.count, y.sat.add(x.sat).or(z.sat.add(x.dsat)), z.dsat.add(x.dsat)}
+	case fragmentMulti:
+		return opsCount{1, valid(len(n.keys)), valid(len(n.keys))}
+	case fragmentMultiA:
+		return opsCount{len(n.keys) + 1, valid(0), valid(0)}
+	case fragmentWrapS, fragmentWrapC, fragmentWrapN:
+		return opsCount{1 + x.count, x.sat, x.dsat}
+	case fragmentWrapA:
+		return opsCount{2 + x.count, x.sat, x.dsat}
+	case fragmentWrapD:
+		return opsCount{3 + x.count, x.sat, valid(0)}
+	case fragmentWrapJ:
+		return opsCount{4 + x.count, x.sat, valid(0)}
+	case fragmentWrapV:
+		count := x.count
+		if n.subs[0].typ.Has(ExpensiveVerify) {
+			count++
+		}
+		return opsCount{count, x.sat, none}
+	}
+
+	// thresh() has an OP_ADD for each expression but the first, and an
+	// OP_EQUAL
+	count := 0
+	subs := make([]satDsat, len(n.subs))
+	for i, sub := range n.subs {
+		count += sub.ops.count + 1
+		subs[i] = satDsat{sub.ops.sat, sub.ops.dsat}
+	}
+	sats := threshSats(subs)
+
+	return opsCount{count, sats[n.k], sats[0]}
+}
+
+// Returns for each number of satisfied expressions of thresh() the maximum
+// when the remaining expressions are dissatisfied
+func threshSats(subs []satDsat) []maxInt {
+	sats := []maxInt{valid(0)}
+	for _, sub := range subs {
+		next := []maxInt{sats[0].add(sub.dsat)}
+		for j := 1; j < len(sats); j++ {
+			next = append(next, sats[j].add(sub.dsat).or(sats[j-1].add(sub.sat)))
+		}
+		sats = append(next, sats[len(sats)-1].add(sub.sat))
+	}
+
+	return sats
+}
+
+// The sizes of the elements of a witness, which are counted as one each for
+// the number of elements, or in bytes with their length prefix for the size
+// of the witness
+type elementSizes struct {
+	sig, pubKey, preimage, zero, one int
+}
+
+var stackElements = elementSizes{sig: 1, pubKey: 1, preimage: 1, zero: 1, one: 1}
+
+// Returns the sizes in bytes of the elements of a witness of ctx, where a
+// signature has a sighash type in P2WSH, and a Schnorr signature may have
+// one in Tapscript
+func witnessBytes(ctx Context) elementSizes {
+	if ctx == Tapscript {
+		return elementSizes{sig: 1 + 65, pubKey: 1 + 32, preimage: 1 + 32, zero: 1, one: 2}
+	}
+
+	return elementSizes{sig: 1 + 72, pubKey: 1 + 33, preimage: 1 + 32, zero: 1, one: 2}
+}
+
+// Returns the maximum size of the witness that satisfies and dissatisfies
+// the expression, where the elements have sizes
+func (n *Node) computeWitness(sizes elementSizes) satDsat {
+	var x, y, z satDsat
+	if len(n.subs) > 0 {
+		x = n.subs[0].witness(sizes)
+	}
+	if len(n.subs) > 1 {
+		y = n.subs[1].witness(sizes)
+	}
+	if len(n.subs) > 2 {
+		z = n.subs[2].witness(sizes)
+	}
+
+	none := maxInt{}
+	switch n.fragment {
+	case fragmentJust0:
+		return satDsat{none, valid(0)}
+	case fragmentJust1, fragmentOlder, fragmentAfter:
+		return satDsat{valid(0), none}
+	case fragmentPkK:
+		return satDsat{valid(sizes.sig), valid(sizes.zero)}
+	case fragmentPkH:
+		return satDsat{valid(sizes.sig + sizes.pubKey), valid(sizes.zero + sizes.pubKey)}
+	case fragmentSHA256, fragmentHash256, fragmentRIPEMD160, fragmentHash160:
+		return satDsat{valid(sizes.preimage), none}
+	case fragmentAndOr:
+		return satDsat{x.sat.add(y.sat).or(x.dsat.add(z.sat)), x.dsat.add(z.dsat)}
+	case fragmentAndV:
+		return satDsat{x.sat.add(y.sat), none}
+	case fragmentAndB:
+		return satDsat{x.sat.add(y.sat), x.dsat.add(y.dsat)}
+	case fragmentOrB:
+		return satDsat{x.dsat.add(y.sat).or(x.sat.add(y.dsat)), x.dsat.add(y.dsat)}
+	case fragmentOrC:
+		return satDsat{x.sat.or(x.dsat.add(y.sat)), none}
+	case fragmentOrD:
+		return satDsat{x.sat.or(x.dsat.add(y.sat)), x.dsat.add(y.dsat)}
+	case fragmentOrI:
+		one, zero := valid(sizes.one), valid(sizes.zero)
+		return satDsat{x.sat.add(one).or(y.sat.add(zero)), x.dsat.add(one).or(y.dsat.add(zero))}
+	case fragmentMulti:
+		k := int(n.k)
+		return satDsat{valid(k*sizes.sig + sizes.zero), valid((k + 1) * sizes.zero)}
+	case fragmentMultiA:
+		k, count := int(n.k), len(n.keys)
+		return satDsat{valid(k*sizes.sig + (count-k)*sizes.zero), valid(count * sizes.zero)}
+	case fragmentWrapA, fragmentWrapS, fragmentWrapC, fragmentWrapN:
+		return x
+	case fragmentWrapD:
+		return satDsat{x.sat.add(valid(sizes.one)), valid(sizes.zero)}
+	case fragmentWrapV:
+		return satDsat{x.sat, none}
+	case fragmentWrapJ:
+		return satDsat{x.sat, valid(sizes.zero)}
+	}
+
+	subs := make([]satDsat, len(n.subs))
+	for i, sub := range n.subs {
+		subs[i] = sub.witness(sizes)
+	}
+	sats := threshSats(subs)
+
+	return satDsat{sats[n.k], sats[0]}
+}
+
+// Returns the maximums of the witness that have already been computed for
+// sizes
+func (n *Node) witness(sizes elementSizes) satDsat {
+	if sizes == stackElements {
+		return n.stackSize
+	}
+
+	return n.witnessSize
+}
+
+// MaxWitnessSize returns the maximum size in bytes of the witness elements
+// that satisfy the miniscript, without the script itself and, in
+// Tapscript, the control block.
+func (n *Node) MaxWitnessSize() (int, error) {
+	if !n.witnessSize.sat.valid {
+		return 0, fmt.Errorf("miniscript %s can not be satisfied", n)
+	}
+
+	return n.witnessSize.sat.value, nil
+}
+
+// CheckSane returns an error unless the miniscript is sane, which means
+// that it can always be satisfied without being malleable, that every
+// satisfaction needs a signature, that it does not mix time locks that can
+// not be satisfied together, that its satisfactions are within the limits
+// of the script and that it does not reuse keys.
+func (n *Node) CheckSane() error {
+	if err := n.checkTopLevel(); err != nil {
+		return err
+	}
+	if !n.typ.Has(NonMalleable) {
+		return fmt.Errorf("miniscript %s has satisfactions that are malleable", n)
+	}
+	if !n.typ.Has(Signed) {
+		return fmt.Errorf("miniscript %s has satisfactions without a signature", n)
+	}
+	if !n.typ.Has(NoTimelockMix) {
+		return fmt.Errorf("miniscript %s mixes time locks based on height and time", n)
+	}
+
+	if n.ctx == P2WSH {
+		if !n.ops.sat.valid {
+			return fmt.Errorf("miniscript %s can not be satisfied", n)
+		}
+		if ops := n.ops.count + n.ops.sat.value; ops > maxOpsPerScript {
+			return fmt.Errorf("miniscript %s executes %d op codes, which is more than %d", n, ops, maxOpsPerScript)
+		}
+	}
+
+	maxElements := maxStandardP2WSHStackItems
+	if n.ctx == Tapscript {
+		maxElements = maxStackSize
+	}
+	if !n.stackSize.sat.valid {
+		return fmt.Errorf("miniscript %s can not be satisfied", n)
+	}
+	if elements := n.stackSize.sat.value; elements > maxElements {
+		return fmt.Errorf("miniscript %s has a witness of %d elements, which is more than %d", n, elements, maxElements)
+	}
+
+	// The keys of pk_h() may only be known by their hashes
+	seen := make(map[string]bool)
+	for _, h := range n.keyHashes() {
+		key := hex.EncodeToString(h)
+		if seen[key] {
+			return fmt.Errorf("miniscript %s has the key with hash %s more than once", n, key)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// Returns the hashes of all the keys of the miniscript
+func (n *Node) keyHashes() [][]byte {
+	hashes := make([][]byte, 0)
+	switch n.fragment {
+	case fragmentPkH:
+		hashes = append(hashes, n.data)
+	case fragmentPkK, fragmentMulti, fragmentMultiA:
+		for _, key := range n.keys {
+			hashes = append(hashes, hash.Hash160(key))
+		}
+	}
+
+	for _, sub := range n.subs {
+		hashes = append(hashes, sub.keyHashes()...)
+	}
+
+	return hashes
+}
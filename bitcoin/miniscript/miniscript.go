@@ -0,0 +1,616 @@
+// Package miniscript implements Miniscript for P2WSH and Tapscript, see
+// BIP 379. A miniscript such as and_v(v:pk(key),older(144)) is a structured
+// way of writing a script, which can be type checked, analysed for its
+// resource usage and satisfied without knowing anything about the script
+// beyond the miniscript itself.
+package miniscript
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Context is the kind of script that a miniscript is used as, which decides
+// how keys are serialized and which fragments are allowed.
+type Context int
+
+const (
+	// The witness script of a pay-to-witness-script-hash output, with
+	// compressed keys
+	P2WSH Context = iota
+	// A leaf script of a taproot output, with x-only keys, see BIP 342
+	Tapscript
+)
+
+func (c Context) String() string {
+	if c == Tapscript {
+		return "tapscript"
+	}
+
+	return "p2wsh"
+}
+
+// The fragments of BIP 379, where the syntactic sugar such as pk() and t:
+// is written with the fragments that it stands for
+type fragment int
+
+const (
+	fragmentJust0 fragment = iota
+	fragmentJust1
+	fragmentPkK
+	fragmentPkH
+	fragmentOlder
+	fragmentAfter
+	fragmentSHA256
+	fragmentHash256
+	fragmentRIPEMD160
+	fragmentHash160
+	fragmentAndOr
+	fragmentAndV
+	fragmentAndB
+	fragmentOrB
+	fragmentOrC
+	fragmentOrD
+	fragmentOrI
+	fragmentThresh
+	fragmentMulti
+	fragmentMultiA
+	fragmentWrapA
+	fragmentWrapS
+	fragmentWrapC
+	fragmentWrapD
+	fragmentWrapV
+	fragmentWrapJ
+	fragmentWrapN
+)
+
+// The maximum number of keys of multi(), which is the limit of
+// OP_CHECKMULTISIG
+const maxMultiKeys = 20
+
+// The maximum number of keys of multi_a(), which is the limit of the number
+// of stack elements
+const maxMultiAKeys = 999
+
+// Node is a miniscript expression, whose type and resource usage are
+// computed when it is built.
+type Node struct {
+	fragment fragment
+	// The threshold of thresh(), multi() and multi_a(), or the lock time of
+	// older() and after()
+	k uint32
+	// The keys as they are pushed in the script. The key of pk_h() is nil
+	// when only its hash is known, which is the case when it is decoded from
+	// a script.
+	keys [][]byte
+	// The hash of a hash lock, or the hash of the key of pk_h()
+	data []byte
+	subs []*Node
+	ctx  Context
+
+	typ         Type
+	scriptSize  int
+	ops         opsCount
+	stackSize   satDsat
+	witnessSize satDsat
+}
+
+// Returns the node of a fragment, with its type and resource usage
+func newNode(ctx Context, f fragment, subs []*Node, keys [][]byte, data []byte, k uint32) *Node {
+	n := &Node{fragment: f, k: k, keys: keys, data: data, subs: subs, ctx: ctx}
+
+	var x, y, z Type
+	subTypes := make([]Type, len(subs))
+	for i, sub := range subs {
+		subTypes[i] = sub.typ
+	}
+	if len(subs) > 0 {
+		x = subs[0].typ
+	}
+	if len(subs) > 1 {
+		y = subs[1].typ
+	}
+	if len(subs) > 2 {
+		z = subs[2].typ
+	}
+
+	n.typ = computeType(f, x, y, z, subTypes, k, ctx)
+	n.scriptSize = n.computeScriptSize()
+	n.ops = n.computeOps()
+	n.stackSize = n.computeWitness(stackElements)
+	n.witnessSize = n.computeWitness(witnessBytes(ctx))
+
+	return n
+}
+
+// Parse parses a miniscript of ctx, which must be valid as a whole script:
+// its type must be B and its script not too large.
+func Parse(s string, ctx Context) (*Node, error) {
+	n, err := parse(s, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.checkTopLevel(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// Returns an error unless the miniscript can be used as a whole script
+func (n *Node) checkTopLevel() error {
+	if !n.typ.Has(TypeB) {
+		return fmt.Errorf("miniscript %s has type %s, expected B", n, n.typ)
+	}
+	if n.scriptSize > maxScriptSize(n.ctx) {
+		return fmt.Errorf("miniscript %s has a script of %d bytes, which is more than %d", n, n.scriptSize, maxScriptSize(n.ctx))
+	}
+
+	return nil
+}
+
+// Parses an expression with its wrappers
+func parse(s string, ctx Context) (*Node, error) {
+	name := s
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		name = s[:i]
+	}
+
+	wrappers, _, found := strings.Cut(name, ":")
+	if !found {
+		return parseFragment(s, ctx)
+	}
+	if wrappers == "" {
+		return nil, fmt.Errorf("miniscript %s has no wrappers before ':'", s)
+	}
+
+	n, err := parse(s[len(wrappers)+1:], ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The wrapper closest to the expression is applied first
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		switch wrappers[i] {
+		case 'a':
+			n = newNode(ctx, fragmentWrapA, []*Node{n}, nil, nil, 0)
+		case 's':
+			n = newNode(ctx, fragmentWrapS, []*Node{n}, nil, nil, 0)
+		case 'c':
+			n = newNode(ctx, fragmentWrapC, []*Node{n}, nil, nil, 0)
+		case 'd':
+			n = newNode(ctx, fragmentWrapD, []*Node{n}, nil, nil, 0)
+		case 'v':
+			n = newNode(ctx, fragmentWrapV, []*Node{n}, nil, nil, 0)
+		case 'j':
+			n = newNode(ctx, fragmentWrapJ, []*Node{n}, nil, nil, 0)
+		case 'n':
+			n = newNode(ctx, fragmentWrapN, []*Node{n}, nil, nil, 0)
+		case 't':
+			n = newNode(ctx, fragmentAndV, []*Node{n, newNode(ctx, fragmentJust1, nil, nil, nil, 0)}, nil, nil, 0)
+		case 'l':
+			n = newNode(ctx, fragmentOrI, []*Node{newNode(ctx, fragmentJust0, nil, nil, nil, 0), n}, nil, nil, 0)
+		case 'u':
+			n = newNode(ctx, fragmentOrI, []*Node{n, newNode(ctx, fragmentJust0, nil, nil, nil, 0)}, nil, nil, 0)
+		default:
+			return nil, fmt.Errorf("unknown wrapper %q in miniscript %s", wrappers[i], s)
+		}
+
+		if n.typ == 0 {
+			return nil, fmt.Errorf("wrapper %c: can not be applied in miniscript %s", wrappers[i], s)
+		}
+	}
+
+	return n, nil
+}
+
+// Parses an expression without wrappers
+func parseFragment(s string, ctx Context) (*Node, error) {
+	switch s {
+	case "0":
+		return newNode(ctx, fragmentJust0, nil, nil, nil, 0), nil
+	case "1":
+		return newNode(ctx, fragmentJust1, nil, nil, nil, 0), nil
+	}
+
+	name, args, err := splitFunction(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var n *Node
+	switch name {
+	case "pk_k", "pk":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 argument, got %d", name, len(args))
+		}
+		key, err := parseKey(args[0], ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		n = newNode(ctx, fragmentPkK, nil, [][]byte{key}, nil, 0)
+		if name == "pk" {
+			n = newNode(ctx, fragmentWrapC, []*Node{n}, nil, nil, 0)
+		}
+	case "pk_h", "pkh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 argument, got %d", name, len(args))
+		}
+		n, err = parsePkH(args[0], ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "pkh" {
+			n = newNode(ctx, fragmentWrapC, []*Node{n}, nil, nil, 0)
+		}
+	case "older", "after":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 argument, got %d", name, len(args))
+		}
+		lockTime, err := parseNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if lockTime < 1 || lockTime >= 0x80000000 {
+			return nil, fmt.Errorf("lock time %d of %s() is out of range", lockTime, name)
+		}
+
+		f := fragmentOlder
+		if name == "after" {
+			f = fragmentAfter
+		}
+		n = newNode(ctx, f, nil, nil, nil, lockTime)
+	case "sha256", "hash256", "ripemd160", "hash160":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() takes 1 argument, got %d", name, len(args))
+		}
+		f, size := hashFragment(name)
+		h, err := hex.DecodeString(args[0])
+		if err != nil || len(h) != size {
+			return nil, fmt.Errorf("invalid hash %s of %s(), expected %d bytes in hex", args[0], name, size)
+		}
+
+		n = newNode(ctx, f, nil, nil, h, 0)
+	case "andor", "and_v", "and_b", "and_n", "or_b", "or_c", "or_d", "or_i":
+		expected := 2
+		if name == "andor" {
+			expected = 3
+		}
+		if len(args) != expected {
+			return nil, fmt.Errorf("%s() takes %d arguments, got %d", name, expected, len(args))
+		}
+
+		subs := make([]*Node, len(args))
+		for i, arg := range args {
+			subs[i], err = parse(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch name {
+		case "andor":
+			n = newNode(ctx, fragmentAndOr, subs, nil, nil, 0)
+		case "and_n":
+			n = newNode(ctx, fragmentAndOr, append(subs, newNode(ctx, fragmentJust0, nil, nil, nil, 0)), nil, nil, 0)
+		default:
+			n = newNode(ctx, binaryFragments[name], subs, nil, nil, 0)
+		}
+	case "thresh":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("thresh() takes a threshold and at least 1 expression, got %d arguments", len(args))
+		}
+		k, err := parseNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if k < 1 || int(k) > len(args)-1 {
+			return nil, fmt.Errorf("threshold %d of thresh() with %d expressions is out of range", k, len(args)-1)
+		}
+
+		subs := make([]*Node, len(args)-1)
+		for i, arg := range args[1:] {
+			subs[i], err = parse(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		n = newNode(ctx, fragmentThresh, subs, nil, nil, k)
+	case "multi", "multi_a":
+		n, err = parseMulti(name, args, ctx)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown fragment %s in miniscript %s", name, s)
+	}
+
+	if n.typ == 0 {
+		return nil, fmt.Errorf("miniscript %s is not valid, as its subexpressions do not have the types that %s() requires", s, name)
+	}
+
+	return n, nil
+}
+
+// The fragments with two subexpressions that are not syntactic sugar
+var binaryFragments = map[string]fragment{
+	"and_v": fragmentAndV,
+	"and_b": fragmentAndB,
+	"or_b":  fragmentOrB,
+	"or_c":  fragmentOrC,
+	"or_d":  fragmentOrD,
+	"or_i":  fragmentOrI,
+}
+
+// Returns the fragment of a hash lock and the size of its hash
+func hashFragment(name string) (fragment, int) {
+	switch name {
+	case "sha256":
+		return fragmentSHA256, 32
+	case "hash256":
+		return fragmentHash256, 32
+	case "ripemd160":
+		return fragmentRIPEMD160, 20
+	}
+
+	return fragmentHash160, 20
+}
+
+// Parses the argument of pk_h(), which is a key, or the hash of a key as
+// it is printed when the key is not known
+func parsePkH(s string, ctx Context) (*Node, error) {
+	if h, err := hex.DecodeString(s); err == nil && len(h) == 20 {
+		return newNode(ctx, fragmentPkH, nil, [][]byte{nil}, h, 0), nil
+	}
+
+	key, err := parseKey(s, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNode(ctx, fragmentPkH, nil, [][]byte{key}, hash.Hash160(key), 0), nil
+}
+
+// Parses multi(), which is only allowed in P2WSH, and multi_a(), which is
+// only allowed in Tapscript
+func parseMulti(name string, args []string, ctx Context) (*Node, error) {
+	f, maxKeys := fragmentMulti, maxMultiKeys
+	if name == "multi_a" {
+		f, maxKeys = fragmentMultiA, maxMultiAKeys
+	}
+	if (f == fragmentMultiA) != (ctx == Tapscript) {
+		return nil, fmt.Errorf("%s() can not be used in %s", name, ctx)
+	}
+
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s() takes a threshold and at least 1 key, got %d arguments", name, len(args))
+	}
+	if len(args)-1 > maxKeys {
+		return nil, fmt.Errorf("%s() has %d keys, which is more than %d", name, len(args)-1, maxKeys)
+	}
+
+	k, err := parseNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if k < 1 || int(k) > len(args)-1 {
+		return nil, fmt.Errorf("threshold %d of %s() with %d keys is out of range", k, name, len(args)-1)
+	}
+
+	keys := make([][]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i], err = parseKey(arg, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newNode(ctx, f, nil, keys, nil, k), nil
+}
+
+// Parses a public key in hex, which is compressed in P2WSH and x-only in
+// Tapscript
+func parseKey(s string, ctx Context) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s", s)
+	}
+
+	if ctx == Tapscript {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s is not an x-only key, as required in %s", s, ctx)
+		}
+		_, err = ecc.ParseXOnly(key)
+	} else {
+		if len(key) != 33 {
+			return nil, fmt.Errorf("key %s is not a compressed key, as required in %s", s, ctx)
+		}
+		_, err = ecc.Parse(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", s, err)
+	}
+
+	return key, nil
+}
+
+// Parses a decimal number, without a sign
+func parseNumber(s string) (uint32, error) {
+	if s == "" || strings.Trim(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	number, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("number %s is out of range", s)
+	}
+
+	return uint32(number), nil
+}
+
+// Splits name(arg,arg,...) into the name and the arguments, where the
+// arguments may contain parentheses themselves
+func splitFunction(s string) (string, []string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("invalid miniscript %s", s)
+	}
+
+	args := make([]string, 0)
+	depth, start := 0, open+1
+	for i := open + 1; i < len(s)-1; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return "", nil, fmt.Errorf("unbalanced parentheses in miniscript %s", s)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return "", nil, fmt.Errorf("unbalanced parentheses in miniscript %s", s)
+	}
+
+	return s[:open], append(args, s[start:len(s)-1]), nil
+}
+
+// Type returns the type of the miniscript, with its properties.
+func (n *Node) Type() Type {
+	return n.typ
+}
+
+// Context returns the kind of script the miniscript is used as.
+func (n *Node) Context() Context {
+	return n.ctx
+}
+
+// Returns the miniscript, with the syntactic sugar of BIP 379 where it
+// applies, such as pk(key) for c:pk_k(key)
+func (n *Node) String() string {
+	return n.string(false)
+}
+
+// Returns the miniscript, where wrapped tells whether it is written after
+// the wrappers of its parent, so that a ':' is needed unless it is a
+// wrapper itself
+func (n *Node) string(wrapped bool) string {
+	switch {
+	case n.fragment == fragmentWrapA:
+		return "a" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapS:
+		return "s" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapC && n.subs[0].fragment == fragmentPkK:
+		return prefix(wrapped) + "pk(" + n.subs[0].keyString(0) + ")"
+	case n.fragment == fragmentWrapC && n.subs[0].fragment == fragmentPkH:
+		return prefix(wrapped) + "pkh(" + n.subs[0].keyString(0) + ")"
+	case n.fragment == fragmentWrapC:
+		return "c" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapD:
+		return "d" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapV:
+		return "v" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapJ:
+		return "j" + n.subs[0].string(true)
+	case n.fragment == fragmentWrapN:
+		return "n" + n.subs[0].string(true)
+	case n.fragment == fragmentAndV && n.subs[1].fragment == fragmentJust1:
+		return "t" + n.subs[0].string(true)
+	case n.fragment == fragmentOrI && n.subs[0].fragment == fragmentJust0:
+		return "l" + n.subs[1].string(true)
+	case n.fragment == fragmentOrI && n.subs[1].fragment == fragmentJust0:
+		return "u" + n.subs[0].string(true)
+	}
+
+	s := prefix(wrapped)
+	switch n.fragment {
+	case fragmentJust0:
+		return s + "0"
+	case fragmentJust1:
+		return s + "1"
+	case fragmentPkK:
+		return s + "pk_k(" + n.keyString(0) + ")"
+	case fragmentPkH:
+		return s + "pk_h(" + n.keyString(0) + ")"
+	case fragmentOlder:
+		return s + fmt.Sprintf("older(%d)", n.k)
+	case fragmentAfter:
+		return s + fmt.Sprintf("after(%d)", n.k)
+	case fragmentSHA256:
+		return s + fmt.Sprintf("sha256(%x)", n.data)
+	case fragmentHash256:
+		return s + fmt.Sprintf("hash256(%x)", n.data)
+	case fragmentRIPEMD160:
+		return s + fmt.Sprintf("ripemd160(%x)", n.data)
+	case fragmentHash160:
+		return s + fmt.Sprintf("hash160(%x)", n.data)
+	case fragmentAndOr:
+		if n.subs[2].fragment == fragmentJust0 {
+			return s + "and_n(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+		}
+		return s + "andor(" + n.subs[0].String() + "," + n.subs[1].String() + "," + n.subs[2].String() + ")"
+	case fragmentAndV:
+		return s + "and_v(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentAndB:
+		return s + "and_b(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentOrB:
+		return s + "or_b(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentOrC:
+		return s + "or_c(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentOrD:
+		return s + "or_d(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentOrI:
+		return s + "or_i(" + n.subs[0].String() + "," + n.subs[1].String() + ")"
+	case fragmentThresh:
+		args := []string{strconv.FormatUint(uint64(n.k), 10)}
+		for _, sub := range n.subs {
+			args = append(args, sub.String())
+		}
+		return s + "thresh(" + strings.Join(args, ",") + ")"
+	}
+
+	name := "multi"
+	if n.fragment == fragmentMultiA {
+		name = "multi_a"
+	}
+	args := []string{strconv.FormatUint(uint64(n.k), 10)}
+	for i := range n.keys {
+		args = append(args, n.keyString(i))
+	}
+
+	return s + name + "(" + strings.Join(args, ",") + ")"
+}
+
+// Returns the ':' that separates wrappers from the expression
+func prefix(wrapped bool) string {
+	if wrapped {
+		return ":"
+	}
+
+	return ""
+}
+
+// Returns the key at index in hex, or the hash of the key of pk_h() when the
+// key is not known
+func (n *Node) keyString(index int) string {
+	if n.keys[index] == nil {
+		return hex.EncodeToString(n.data)
+	}
+
+	return hex.EncodeToString(n.keys[index])
+}
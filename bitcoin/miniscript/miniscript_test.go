@@ -0,0 +1,269 @@
+package miniscript_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/miniscript"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Replaces @K1, @X1 and @P1 by the compressed key, the x-only key and the
+// hash of the key of the private key 1, and so on for 2 and 3, and @H by the
+// SHA256 of the preimage
+var keys = func() *strings.Replacer {
+	pairs := make([]string, 0)
+	for i, name := range []string{"1", "2", "3"} {
+		privateKey, _ := ecc.NewPrivateKey(big.NewInt(int64(i + 1)))
+		pairs = append(pairs,
+			"@K"+name, hex.EncodeToString(privateKey.SECCompressed()),
+			"@X"+name, hex.EncodeToString(privateKey.XOnly()),
+			"@P"+name, hex.EncodeToString(hash.Hash160(privateKey.SECCompressed())),
+		)
+	}
+
+	return strings.NewReplacer(append(pairs, "@H", hex.EncodeToString(hash.HashSHA256(preimage)))...)
+}()
+
+var preimage = bytes.Repeat([]byte{0x01}, 32)
+
+func parseAsm(t *testing.T, asm string) *bitcoin.Script {
+	t.Helper()
+
+	script, err := bitcoin.ParseScriptAsm(keys.Replace(asm))
+	if err != nil {
+		t.Fatalf("ParseScriptAsm(%s): %v", asm, err)
+	}
+
+	return script
+}
+
+func rawScript(t *testing.T, script *bitcoin.Script) []byte {
+	t.Helper()
+
+	raw, err := script.RawSerialize()
+	if err != nil {
+		t.Fatalf("RawSerialize: %v", err)
+	}
+
+	return raw
+}
+
+var scriptTests = []struct {
+	miniscript string
+	ctx        miniscript.Context
+	asm        string
+	typ        string
+}{
+	{"pk(@K1)", miniscript.P2WSH, "0x21 0x@K1 CHECKSIG", "Bonduesmk"},
+	{"pkh(@K1)", miniscript.P2WSH, "DUP HASH160 0x14 0x@P1 EQUALVERIFY CHECKSIG", "Bnduesmk"},
+	{"and_v(v:pk(@K1),older(144))", miniscript.P2WSH, "0x21 0x@K1 CHECKSIGVERIFY 144 CHECKSEQUENCEVERIFY", "Bonfsmxhk"},
+	{"or_d(pk(@K1),and_v(v:pk(@K2),older(1000)))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG IFDUP NOTIF 0x21 0x@K2 CHECKSIGVERIFY 1000 CHECKSEQUENCEVERIFY ENDIF", "Bfsmxhk"},
+	{"thresh(2,pk(@K1),s:pk(@K2),sln:older(12960))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG SWAP 0x21 0x@K2 CHECKSIG ADD SWAP IF 0 ELSE 12960 CHECKSEQUENCEVERIFY 0NOTEQUAL ENDIF ADD 2 EQUAL", "Bdusmhk"},
+	{"andor(pk(@K1),older(1008),pk(@K2))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG NOTIF 0x21 0x@K2 CHECKSIG ELSE 1008 CHECKSEQUENCEVERIFY ENDIF", "Bdesmxhk"},
+	{"multi(2,@K1,@K2,@K3)", miniscript.P2WSH, "2 0x21 0x@K1 0x21 0x@K2 0x21 0x@K3 3 CHECKMULTISIG", "Bnduesmk"},
+	{"and_b(pk(@K1),a:sha256(@H))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG TOALTSTACK SIZE 32 EQUALVERIFY SHA256 0x20 0x@H EQUAL FROMALTSTACK BOOLAND", "Bndusmxk"},
+	{"or_b(pk(@K1),s:pk(@K2))", miniscript.P2WSH, "0x21 0x@K1 CHECKSIG SWAP 0x21 0x@K2 CHECKSIG BOOLOR", "Bduesmxk"},
+	{"t:or_c(pk(@K1),v:pk(@K2))", miniscript.P2WSH, "0x21 0x@K1 CHECKSIG NOTIF 0x21 0x@K2 CHECKSIGVERIFY ENDIF 1", "Bufsmxk"},
+	{"or_i(pk(@K1),pk(@K2))", miniscript.P2WSH, "IF 0x21 0x@K1 CHECKSIG ELSE 0x21 0x@K2 CHECKSIG ENDIF", "Bdusmxk"},
+	{"andor(pk(@K1),pk(@K2),j:pkh(@K3))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG NOTIF SIZE 0NOTEQUAL IF DUP HASH160 0x14 0x@P3 EQUALVERIFY CHECKSIG ENDIF ELSE 0x21 0x@K2 CHECKSIG ENDIF", "Bdusmxk"},
+	{"or_d(pk(@K1),dv:after(500000))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG IFDUP NOTIF DUP IF 500000 CHECKLOCKTIMEVERIFY VERIFY ENDIF ENDIF", "Bdemxjk"},
+	{"and_n(pk(@K1),sha256(@H))", miniscript.P2WSH,
+		"0x21 0x@K1 CHECKSIG NOTIF 0 ELSE SIZE 32 EQUALVERIFY SHA256 0x20 0x@H EQUAL ENDIF", "Bduesmxk"},
+	{"and_v(v:multi(1,@K1,@K2),pk(@K3))", miniscript.P2WSH, "1 0x21 0x@K1 0x21 0x@K2 2 CHECKMULTISIGVERIFY 0x21 0x@K3 CHECKSIG", "Bnufsmk"},
+	{"multi_a(2,@X1,@X2,@X3)", miniscript.Tapscript, "0x20 0x@X1 CHECKSIG 0x20 0x@X2 CHECKSIGADD 0x20 0x@X3 CHECKSIGADD 2 NUMEQUAL", "Bduesmk"},
+	{"and_v(v:pk(@X1),pk(@X2))", miniscript.Tapscript, "0x20 0x@X1 CHECKSIGVERIFY 0x20 0x@X2 CHECKSIG", "Bnufsmk"},
+}
+
+func TestScript(t *testing.T) {
+	for _, test := range scriptTests {
+		s := keys.Replace(test.miniscript)
+
+		n, err := miniscript.Parse(s, test.ctx)
+		if err != nil {
+			t.Errorf("Parse(%s): got error %v, expected nil", test.miniscript, err)
+			continue
+		}
+
+		if n.String() != s {
+			t.Errorf("String(%s): got %s, expected %s", test.miniscript, n.String(), s)
+		}
+		if n.Type().String() != test.typ {
+			t.Errorf("Type(%s): got %s, expected %s", test.miniscript, n.Type(), test.typ)
+		}
+
+		expected := rawScript(t, parseAsm(t, test.asm))
+		if actual := rawScript(t, n.Script()); !bytes.Equal(actual, expected) {
+			t.Errorf("Script(%s): got %x, expected %x", test.miniscript, actual, expected)
+		}
+		if n.ScriptSize() != len(expected) {
+			t.Errorf("ScriptSize(%s): got %d, expected %d", test.miniscript, n.ScriptSize(), len(expected))
+		}
+	}
+}
+
+func TestFromScript(t *testing.T) {
+	for _, test := range scriptTests {
+		script := parseAsm(t, test.asm)
+
+		n, err := miniscript.FromScript(script, test.ctx)
+		if err != nil {
+			t.Errorf("FromScript(%s): got error %v, expected nil", test.asm, err)
+			continue
+		}
+
+		// The keys of pk_h() are only known by their hashes
+		expected := strings.NewReplacer("pkh(@K1)", "pkh(@P1)", "pkh(@K3)", "pkh(@P3)").Replace(test.miniscript)
+		if n.String() != keys.Replace(expected) {
+			t.Errorf("FromScript(%s): got %s, expected %s", test.asm, n, expected)
+		}
+		if !bytes.Equal(rawScript(t, n.Script()), rawScript(t, script)) {
+			t.Errorf("FromScript(%s): got a miniscript with the script %x", test.asm, rawScript(t, n.Script()))
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		ctx        miniscript.Context
+	}{
+		{"pk(@K1)", miniscript.Tapscript},
+		{"pk(@X1)", miniscript.P2WSH},
+		{"multi_a(1,@K1)", miniscript.P2WSH},
+		{"multi(1,@X1)", miniscript.Tapscript},
+		{"multi(0,@K1)", miniscript.P2WSH},
+		{"multi(2,@K1)", miniscript.P2WSH},
+		{"older(0)", miniscript.P2WSH},
+		{"after(2147483648)", miniscript.P2WSH},
+		{"sha256(00)", miniscript.P2WSH},
+		{"and_v(pk(@K1),pk(@K2))", miniscript.P2WSH},
+		{"v:pk(@K1)", miniscript.P2WSH},
+		{"pk_k(@K1)", miniscript.P2WSH},
+		{"thresh(3,pk(@K1),s:pk(@K2))", miniscript.P2WSH},
+		{"thresh(1,pk(@K1),pk(@K2))", miniscript.P2WSH},
+		{"or_b(pk(@K1))", miniscript.P2WSH},
+		{"pk(@K1", miniscript.P2WSH},
+		{"x:pk(@K1)", miniscript.P2WSH},
+		{":pk(@K1)", miniscript.P2WSH},
+		{"unknown(@K1)", miniscript.P2WSH},
+	}
+
+	for _, test := range tests {
+		if _, err := miniscript.Parse(keys.Replace(test.miniscript), test.ctx); err == nil {
+			t.Errorf("Parse(%s, %s): expected an error", test.miniscript, test.ctx)
+		}
+	}
+}
+
+func TestFromScriptInvalid(t *testing.T) {
+	tests := []struct {
+		asm string
+		ctx miniscript.Context
+	}{
+		// The type is V
+		{"0x21 0x@K1 CHECKSIGVERIFY", miniscript.P2WSH},
+		// OP_CHECKSIGVERIFY must be used instead
+		{"0x21 0x@K1 CHECKSIG VERIFY 1", miniscript.P2WSH},
+		// A push that is not minimal
+		{"0x4c21 0x@K1 CHECKSIG", miniscript.P2WSH},
+		{"0x20 0x@X1 CHECKSIG", miniscript.P2WSH},
+		{"1 0x20 0x@X1 1 CHECKMULTISIG", miniscript.Tapscript},
+		{"1 2 ADD", miniscript.P2WSH},
+		{"0x21 0x@K1 CHECKSIG 0x21 0x@K2 CHECKSIG", miniscript.P2WSH},
+	}
+
+	for _, test := range tests {
+		if _, err := miniscript.FromScript(parseAsm(t, test.asm), test.ctx); err == nil {
+			t.Errorf("FromScript(%s, %s): expected an error", test.asm, test.ctx)
+		}
+	}
+}
+
+func TestMaxWitnessSize(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		ctx        miniscript.Context
+		expected   int
+	}{
+		{"pk(@K1)", miniscript.P2WSH, 73},
+		{"pk(@X1)", miniscript.Tapscript, 66},
+		{"pkh(@K1)", miniscript.P2WSH, 73 + 34},
+		{"multi(2,@K1,@K2,@K3)", miniscript.P2WSH, 1 + 2*73},
+		{"multi_a(2,@X1,@X2,@X3)", miniscript.Tapscript, 2*66 + 1},
+		{"or_d(pk(@K1),and_v(v:pk(@K2),older(1000)))", miniscript.P2WSH, 1 + 73},
+		{"or_i(pk(@K1),pk(@K2))", miniscript.P2WSH, 73 + 2},
+		{"and_v(v:pk(@K1),sha256(@H))", miniscript.P2WSH, 33 + 73},
+	}
+
+	for _, test := range tests {
+		n, err := miniscript.Parse(keys.Replace(test.miniscript), test.ctx)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.miniscript, err)
+		}
+
+		size, err := n.MaxWitnessSize()
+		if err != nil || size != test.expected {
+			t.Errorf("MaxWitnessSize(%s): got %d, %v, expected %d, nil", test.miniscript, size, err, test.expected)
+		}
+	}
+}
+
+func TestCheckSane(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		sane       bool
+	}{
+		{"and_v(v:pk(@K1),older(144))", true},
+		{"or_d(pk(@K1),and_v(v:pk(@K2),older(1000)))", true},
+		{"thresh(2,pk(@K1),s:pk(@K2),sln:older(12960))", true},
+		{"multi(2,@K1,@K2,@K3)", true},
+		// No signature is needed
+		{"older(144)", false},
+		// Satisfying both is malleable, as the preimage can be replaced
+		{"or_b(pk(@K1),a:sha256(@H))", false},
+		// The key is used twice
+		{"and_v(v:pk(@K1),pk(@K1))", false},
+		{"and_v(v:pk(@K1),pkh(@K1))", false},
+		// A height and a time can not be satisfied together
+		{"and_v(v:pk(@K1),and_v(v:older(144),older(4194305)))", false},
+	}
+
+	for _, test := range tests {
+		n, err := miniscript.Parse(keys.Replace(test.miniscript), miniscript.P2WSH)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.miniscript, err)
+		}
+
+		if err := n.CheckSane(); (err == nil) != test.sane {
+			t.Errorf("CheckSane(%s): got %v, expected sane to be %v", test.miniscript, err, test.sane)
+		}
+	}
+}
+
+func TestCheckSaneOpsLimit(t *testing.T) {
+	// Each v:older() has an OP_CHECKSEQUENCEVERIFY and an OP_VERIFY
+	s := "pk(@K1)"
+	for i := 0; i < 101; i++ {
+		s = "and_v(v:older(1)," + s + ")"
+	}
+
+	n, err := miniscript.Parse(keys.Replace(s), miniscript.P2WSH)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := n.CheckSane(); err == nil || !strings.Contains(err.Error(), "op codes") {
+		t.Errorf("CheckSane: got %v, expected an error for 203 op codes", err)
+	}
+}
@@ -0,0 +1,366 @@
+package miniscript
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Satisfier holds what is available to satisfy a miniscript.
+type Satisfier struct {
+	// The signatures, with their sighash type, by the key in hex as it is
+	// pushed in the script. The keys of pk_h() that are only known by their
+	// hashes are looked up among these keys.
+	Signatures map[string][]byte
+	// The preimages of the hash locks, which are 32 bytes each
+	Preimages [][]byte
+	// The nSequence of the input, for older()
+	Sequence uint32
+	// The nLockTime of the transaction, for after()
+	LockTime uint32
+}
+
+// The flag of nSequence that disables its relative time lock, see BIP 68
+const sequenceLockTimeDisableFlag = 1 << 31
+
+// The bits of nSequence that are the relative time lock and its type
+const sequenceLockTimeMask = sequenceLockTimeTypeFlag | 0x0000ffff
+
+// A witness stack that satisfies or dissatisfies an expression, from the
+// bottom to the top, with what is needed to choose between alternatives
+// without making the witness malleable
+type solution struct {
+	available bool
+	// Whether a signature is part of the stack
+	hasSig bool
+	// Whether a third party can change the stack into another valid one
+	malleable bool
+	// Whether the stack is not the canonical one, which only a third party
+	// that changes the witness would use
+	nonCanon bool
+	// The size of the stack in bytes, with the length prefixes
+	size  int
+	stack [][]byte
+}
+
+var (
+	unavailable = solution{}
+	empty       = solution{available: true}
+	zero        = push([]byte{})
+	one         = push([]byte{1})
+	// A dissatisfaction of a hash lock is any preimage that does not match,
+	// so it can be changed by anyone
+	zero32 = push(make([]byte, 32)).setMalleable(true)
+)
+
+// Returns the solution that pushes data
+func push(data []byte) solution {
+	return solution{available: true, size: len(data) + 1, stack: [][]byte{data}}
+}
+
+func (s solution) withSig() solution {
+	s.hasSig = true
+	return s
+}
+
+func (s solution) setMalleable(malleable bool) solution {
+	s.malleable = s.malleable || malleable
+	return s
+}
+
+func (s solution) setNonCanon() solution {
+	s.nonCanon = true
+	return s
+}
+
+func (s solution) setAvailable(available bool) solution {
+	s.available = available
+	return s
+}
+
+// Returns the solutions one after the other, where the stacks of the later
+// solutions are on top of the earlier ones
+func join(solutions ...solution) solution {
+	result := solution{available: true, stack: make([][]byte, 0)}
+	for _, s := range solutions {
+		result.available = result.available && s.available
+		result.hasSig = result.hasSig || s.hasSig
+		result.malleable = result.malleable || s.malleable
+		result.nonCanon = result.nonCanon || s.nonCanon
+		result.size += s.size
+		result.stack = append(result.stack, s.stack...)
+	}
+
+	return result
+}
+
+// Returns the best of the alternatives, which is the one that can not be
+// used by a third party to make the witness malleable
+func choose(solutions ...solution) solution {
+	result := solutions[0]
+	for _, s := range solutions[1:] {
+		result = choose2(result, s)
+	}
+
+	return result
+}
+
+func choose2(a, b solution) solution {
+	if !a.available {
+		return b
+	}
+	if !b.available {
+		return a
+	}
+
+	// A third party can always use an alternative without a signature, so
+	// it must be the one that is chosen
+	if !a.hasSig && b.hasSig {
+		return a
+	}
+	if !b.hasSig && a.hasSig {
+		return b
+	}
+	if !a.hasSig && !b.hasSig {
+		// A third party can choose either of them
+		a.malleable, b.malleable = true, true
+	} else {
+		if b.malleable && !a.malleable {
+			return a
+		}
+		if a.malleable && !b.malleable {
+			return b
+		}
+	}
+
+	if a.size <= b.size {
+		return a
+	}
+
+	return b
+}
+
+// Satisfy returns the smallest witness stack that satisfies the miniscript
+// without being malleable, from the bottom to the top. The script, and in
+// Tapscript the control block, still has to be added to the witness.
+func (n *Node) Satisfy(s *Satisfier) ([][]byte, error) {
+	_, sat := n.solve(s)
+	if !sat.available {
+		return nil, fmt.Errorf("miniscript %s can not be satisfied with the available signatures, preimages and time locks", n)
+	}
+	if sat.malleable {
+		return nil, fmt.Errorf("miniscript %s can only be satisfied with a malleable witness", n)
+	}
+	if !sat.hasSig {
+		return nil, fmt.Errorf("miniscript %s can only be satisfied without a signature, which makes the witness malleable", n)
+	}
+
+	return sat.stack, nil
+}
+
+// Returns the best dissatisfaction and satisfaction of the expression
+func (n *Node) solve(s *Satisfier) (solution, solution) {
+	dsats := make([]solution, len(n.subs))
+	sats := make([]solution, len(n.subs))
+	for i, sub := range n.subs {
+		dsats[i], sats[i] = sub.solve(s)
+	}
+
+	switch n.fragment {
+	case fragmentJust0:
+		return empty, unavailable
+	case fragmentJust1:
+		return unavailable, empty
+	case fragmentPkK:
+		return zero, s.sign(n.keys[0])
+	case fragmentPkH:
+		key := s.keyOf(n)
+		if key == nil {
+			return unavailable, unavailable
+		}
+		return join(zero, push(key)), join(s.sign(key), push(key))
+	case fragmentOlder:
+		return unavailable, empty.setAvailable(s.checkOlder(n.k))
+	case fragmentAfter:
+		return unavailable, empty.setAvailable(s.checkAfter(n.k))
+	case fragmentSHA256, fragmentHash256, fragmentRIPEMD160, fragmentHash160:
+		return zero32, s.preimage(n.fragment, n.data)
+	case fragmentAndV:
+		return join(dsats[1], sats[0]).setNonCanon(), join(sats[1], sats[0])
+	case fragmentAndB:
+		return choose(
+			join(dsats[1], dsats[0]),
+			join(sats[1], dsats[0]).setMalleable(true).setNonCanon(),
+			join(dsats[1], sats[0]).setMalleable(true).setNonCanon(),
+		), join(sats[1], sats[0])
+	case fragmentOrB:
+		return join(dsats[1], dsats[0]), choose(
+			join(dsats[1], sats[0]),
+			join(sats[1], dsats[0]),
+			join(sats[1], sats[0]).setMalleable(true).setNonCanon(),
+		)
+	case fragmentOrC:
+		return unavailable, choose(sats[0], join(sats[1], dsats[0]))
+	case fragmentOrD:
+		return join(dsats[1], dsats[0]), choose(sats[0], join(sats[1], dsats[0]))
+	case fragmentOrI:
+		return choose(join(dsats[0], one), join(dsats[1], zero)), choose(join(sats[0], one), join(sats[1], zero))
+	case fragmentAndOr:
+		return choose(join(dsats[1], sats[0]).setNonCanon(), join(dsats[2], dsats[0])),
+			choose(join(sats[1], sats[0]), join(sats[2], dsats[0]))
+	case fragmentWrapA, fragmentWrapS, fragmentWrapC, fragmentWrapN:
+		return dsats[0], sats[0]
+	case fragmentWrapD:
+		return zero, join(sats[0], one)
+	case fragmentWrapJ:
+		// Any dissatisfaction of the expression that does not need a
+		// signature can be used by a third party instead of the zero
+		return zero.setMalleable(dsats[0].available && !dsats[0].hasSig), sats[0]
+	case fragmentWrapV:
+		return unavailable, sats[0]
+	case fragmentMulti:
+		return n.solveMulti(s)
+	case fragmentMultiA:
+		return n.solveMultiA(s)
+	}
+
+	return n.solveThresh(dsats, sats)
+}
+
+// Returns the solutions of multi(), which takes the signatures in the order
+// of the keys after a zero for the extra element that OP_CHECKMULTISIG pops
+func (n *Node) solveMulti(s *Satisfier) (solution, solution) {
+	// sats[j] is the best solution with j signatures of the keys so far
+	sats := []solution{zero}
+	for _, key := range n.keys {
+		sig := s.sign(key)
+		next := []solution{sats[0]}
+		for j := 1; j < len(sats); j++ {
+			next = append(next, choose(sats[j], join(sats[j-1], sig)))
+		}
+		sats = append(next, join(sats[len(sats)-1], sig))
+	}
+
+	dsat := zero
+	for i := uint32(0); i < n.k; i++ {
+		dsat = join(dsat, zero)
+	}
+
+	return dsat, sats[n.k]
+}
+
+// Returns the solutions of multi_a(), which takes a signature or a zero for
+// each key, where the one of the first key is on top
+func (n *Node) solveMultiA(s *Satisfier) (solution, solution) {
+	sats := []solution{empty}
+	for i := range n.keys {
+		sig := s.sign(n.keys[len(n.keys)-1-i])
+		next := []solution{join(sats[0], zero)}
+		for j := 1; j < len(sats); j++ {
+			next = append(next, choose(join(sats[j], zero), join(sats[j-1], sig)))
+		}
+		sats = append(next, join(sats[len(sats)-1], sig))
+	}
+
+	return sats[0], sats[n.k]
+}
+
+// Returns the solutions of thresh(), where exactly k of the expressions
+// must be satisfied
+func (n *Node) solveThresh(dsats, sats []solution) (solution, solution) {
+	// solutions[j] is the best solution that satisfies j of the last
+	// expressions so far
+	solutions := []solution{empty}
+	for i := len(n.subs) - 1; i >= 0; i-- {
+		next := []solution{join(solutions[0], dsats[i])}
+		for j := 1; j < len(solutions); j++ {
+			next = append(next, choose(join(solutions[j], dsats[i]), join(solutions[j-1], sats[i])))
+		}
+		solutions = append(next, join(solutions[len(solutions)-1], sats[i]))
+	}
+
+	// Satisfying any other number of expressions than k dissatisfies it,
+	// but only satisfying none of them is canonical
+	dsat := unavailable
+	for j, solution := range solutions {
+		if j == int(n.k) {
+			continue
+		}
+		if j != 0 {
+			solution = solution.setMalleable(true).setNonCanon()
+		}
+		dsat = choose(dsat, solution)
+	}
+
+	return dsat, solutions[n.k]
+}
+
+// Returns the solution with the signature of key
+func (s *Satisfier) sign(key []byte) solution {
+	sig, ok := s.Signatures[hex.EncodeToString(key)]
+	if !ok {
+		return unavailable
+	}
+
+	return push(sig).withSig()
+}
+
+// Returns the key of pk_h(), which is looked up by its hash among the keys
+// of the signatures when the miniscript only has the hash
+func (s *Satisfier) keyOf(n *Node) []byte {
+	if n.keys[0] != nil {
+		return n.keys[0]
+	}
+
+	for k := range s.Signatures {
+		key, err := hex.DecodeString(k)
+		if err == nil && bytes.Equal(hash.Hash160(key), n.data) {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// Returns the solution with the preimage of the hash of a hash lock
+func (s *Satisfier) preimage(f fragment, h []byte) solution {
+	hashFunc := map[fragment]func([]byte) []byte{
+		fragmentSHA256:    hash.HashSHA256,
+		fragmentHash256:   hash.Hash256,
+		fragmentRIPEMD160: hash.HashRIPEMD160,
+		fragmentHash160:   hash.Hash160,
+	}[f]
+
+	for _, preimage := range s.Preimages {
+		if len(preimage) == 32 && bytes.Equal(hashFunc(preimage), h) {
+			return push(preimage)
+		}
+	}
+
+	return unavailable
+}
+
+// Returns whether the relative time lock of older() has passed, see BIP 68
+// and BIP 112
+func (s *Satisfier) checkOlder(lockTime uint32) bool {
+	if s.Sequence&sequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+	if s.Sequence&sequenceLockTimeTypeFlag != lockTime&sequenceLockTimeTypeFlag {
+		return false
+	}
+
+	return lockTime&sequenceLockTimeMask <= s.Sequence&sequenceLockTimeMask
+}
+
+// Returns whether the absolute time lock of after() has passed, which must
+// be of the same kind as nLockTime, see BIP 65
+func (s *Satisfier) checkAfter(lockTime uint32) bool {
+	if (lockTime < lockTimeThreshold) != (s.LockTime < lockTimeThreshold) {
+		return false
+	}
+
+	return lockTime <= s.LockTime
+}
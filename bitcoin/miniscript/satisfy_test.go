@@ -0,0 +1,245 @@
+package miniscript_test
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/miniscript"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+const amount = 100_000
+
+// Returns the transaction that spends an output with the script pubkey,
+// with the sequence of its input and the lock time
+func newSpendingTx(t *testing.T, scriptPubKey *bitcoin.Script, sequence, lockTime uint32) *bitcoin.Tx {
+	t.Helper()
+
+	final := big.NewInt(0xffffffff)
+	coinbaseScriptSig, _ := bitcoin.ParseScriptAsm("0 0")
+	creditingTx := bitcoin.NewTx(1,
+		[]*bitcoin.TxInput{bitcoin.NewTxInput(make([]byte, 32), final, coinbaseScriptSig, final)},
+		[]*bitcoin.TxOutput{{Amount: amount, ScriptPubKey: *scriptPubKey}},
+		0, &chaincfg.MainNetParams)
+
+	txIn := bitcoin.NewTxInput(hash.Hash256(creditingTx.Serialize()), big.NewInt(0), bitcoin.NewScript([]op.Instruction{}), big.NewInt(int64(sequence)))
+	tx := bitcoin.NewTx(2,
+		[]*bitcoin.TxInput{txIn},
+		[]*bitcoin.TxOutput{{Amount: amount, ScriptPubKey: *bitcoin.NewScript([]op.Instruction{})}},
+		int32(lockTime), &chaincfg.MainNetParams)
+	tx.PrevOutFetcher = bitcoin.PrevOutMap{txIn.String(): creditingTx.Outputs[0]}
+
+	return tx
+}
+
+func privateKeys(t *testing.T, secrets ...int64) []*ecc.PrivateKey {
+	t.Helper()
+
+	privateKeys := make([]*ecc.PrivateKey, len(secrets))
+	for i, secret := range secrets {
+		privateKey, err := ecc.NewPrivateKey(big.NewInt(secret))
+		if err != nil {
+			t.Fatalf("NewPrivateKey: %v", err)
+		}
+		privateKeys[i] = privateKey
+	}
+
+	return privateKeys
+}
+
+func TestSatisfyP2WSH(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		signers    []int64
+		preimages  [][]byte
+		sequence   uint32
+		lockTime   uint32
+	}{
+		{"pk(@K1)", []int64{1}, nil, 0xffffffff, 0},
+		{"pkh(@K1)", []int64{1}, nil, 0xffffffff, 0},
+		{"and_v(v:pk(@K1),older(144))", []int64{1}, nil, 144, 0},
+		{"or_d(pk(@K1),and_v(v:pk(@K2),older(1000)))", []int64{1}, nil, 0xffffffff, 0},
+		{"or_d(pk(@K1),and_v(v:pk(@K2),older(1000)))", []int64{2}, nil, 1000, 0},
+		{"thresh(2,pk(@K1),s:pk(@K2),sln:older(12960))", []int64{2}, nil, 12960, 0},
+		{"thresh(2,pk(@K1),s:pk(@K2),sln:older(12960))", []int64{1, 2}, nil, 0xffffffff, 0},
+		{"andor(pk(@K1),older(1008),pk(@K2))", []int64{2}, nil, 0xffffffff, 0},
+		{"multi(2,@K1,@K2,@K3)", []int64{1, 3}, nil, 0xffffffff, 0},
+		{"and_v(v:pkh(@K1),sha256(@H))", []int64{1}, [][]byte{preimage}, 0xffffffff, 0},
+		{"andor(pk(@K1),pk(@K2),j:pkh(@K3))", []int64{3}, nil, 0xffffffff, 0},
+		{"or_i(and_v(v:pk(@K1),after(500000)),pk(@K2))", []int64{1}, nil, 0xfffffffe, 500000},
+	}
+
+	for _, test := range tests {
+		n, err := miniscript.Parse(keys.Replace(test.miniscript), miniscript.P2WSH)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.miniscript, err)
+		}
+
+		script := n.Script()
+		raw := rawScript(t, script)
+		scriptPubKey, err := bitcoin.ToP2WSHScript(hash.HashSHA256(raw))
+		if err != nil {
+			t.Fatalf("ToP2WSHScript: %v", err)
+		}
+
+		tx := newSpendingTx(t, scriptPubKey, test.sequence, test.lockTime)
+		sigHash, err := tx.SegwitSignatureHash(0, script, amount, bitcoin.SigHashAll)
+		if err != nil {
+			t.Fatalf("SegwitSignatureHash: %v", err)
+		}
+
+		satisfier := &miniscript.Satisfier{
+			Signatures: make(map[string][]byte),
+			Preimages:  test.preimages,
+			Sequence:   test.sequence,
+			LockTime:   test.lockTime,
+		}
+		for _, privateKey := range privateKeys(t, test.signers...) {
+			signature, err := privateKey.Sign(big.NewInt(0).SetBytes(sigHash))
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			satisfier.Signatures[hex.EncodeToString(privateKey.SECCompressed())] = append(signature.DER(), byte(bitcoin.SigHashAll))
+		}
+
+		stack, err := n.Satisfy(satisfier)
+		if err != nil {
+			t.Errorf("Satisfy(%s): got error %v, expected nil", test.miniscript, err)
+			continue
+		}
+
+		size := 0
+		for _, element := range stack {
+			size += 1 + len(element)
+		}
+		if maxSize, _ := n.MaxWitnessSize(); size > maxSize {
+			t.Errorf("Satisfy(%s): got a witness of %d bytes, expected at most %d", test.miniscript, size, maxSize)
+		}
+
+		tx.Inputs[0].Witness = append(stack, raw)
+		if valid, err := tx.VerifyInput(0, op.StandardVerifyFlags); err != nil || !valid {
+			t.Errorf("VerifyInput(%s): got %v, %v, expected true, nil", test.miniscript, valid, err)
+		}
+	}
+}
+
+func TestSatisfyTapscript(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		signers    []int64
+	}{
+		{"pk(@X1)", []int64{1}},
+		{"multi_a(2,@X1,@X2,@X3)", []int64{1, 3}},
+		{"multi_a(2,@X1,@X2,@X3)", []int64{2, 3}},
+		{"and_v(v:pk(@X1),pk(@X2))", []int64{1, 2}},
+		{"or_b(pk(@X1),s:pk(@X2))", []int64{2}},
+	}
+
+	internalKey := privateKeys(t, 1000)[0].PublicKey()
+
+	for _, test := range tests {
+		n, err := miniscript.Parse(keys.Replace(test.miniscript), miniscript.Tapscript)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.miniscript, err)
+		}
+
+		raw := rawScript(t, n.Script())
+		leafHash := bitcoin.TapLeafHash(bitcoin.TapscriptLeafVersion, raw)
+		outputKey, err := bitcoin.TaprootOutputKey(internalKey, leafHash)
+		if err != nil {
+			t.Fatalf("TaprootOutputKey: %v", err)
+		}
+		scriptPubKey, err := bitcoin.ToP2TRScript(outputKey.XOnly())
+		if err != nil {
+			t.Fatalf("ToP2TRScript: %v", err)
+		}
+
+		tx := newSpendingTx(t, scriptPubKey, 0xffffffff, 0)
+		sigHash, err := tx.TaprootSignatureHash(0, bitcoin.SigHashDefault, &op.TaprootExecutionData{
+			TapLeafHash:           leafHash,
+			CodeSeparatorPosition: 0xffffffff,
+		})
+		if err != nil {
+			t.Fatalf("TaprootSignatureHash: %v", err)
+		}
+
+		satisfier := &miniscript.Satisfier{Signatures: make(map[string][]byte)}
+		for _, privateKey := range privateKeys(t, test.signers...) {
+			signature, err := privateKey.SignSchnorr(sigHash, make([]byte, 32))
+			if err != nil {
+				t.Fatalf("SignSchnorr: %v", err)
+			}
+			satisfier.Signatures[hex.EncodeToString(privateKey.XOnly())] = signature.Serialize()
+		}
+
+		stack, err := n.Satisfy(satisfier)
+		if err != nil {
+			t.Errorf("Satisfy(%s): got error %v, expected nil", test.miniscript, err)
+			continue
+		}
+
+		controlBlock := &bitcoin.ControlBlock{
+			LeafVersion:     bitcoin.TapscriptLeafVersion,
+			OutputKeyYIsOdd: !outputKey.HasEvenY(),
+			InternalKey:     internalKey,
+		}
+		tx.Inputs[0].Witness = append(stack, raw, controlBlock.Serialize())
+		if valid, err := tx.VerifyInput(0, op.StandardVerifyFlags); err != nil || !valid {
+			t.Errorf("VerifyInput(%s): got %v, %v, expected true, nil", test.miniscript, valid, err)
+		}
+	}
+}
+
+func TestSatisfyFromScript(t *testing.T) {
+	// The key of pkh() is found among the keys of the signatures
+	n, err := miniscript.FromScript(parseAsm(t, "DUP HASH160 0x14 0x@P1 EQUALVERIFY CHECKSIG"), miniscript.P2WSH)
+	if err != nil {
+		t.Fatalf("FromScript: %v", err)
+	}
+
+	key := privateKeys(t, 1)[0].SECCompressed()
+	stack, err := n.Satisfy(&miniscript.Satisfier{Signatures: map[string][]byte{hex.EncodeToString(key): {0x30, 0x01}}})
+	if err != nil {
+		t.Fatalf("Satisfy: %v", err)
+	}
+	if len(stack) != 2 || hex.EncodeToString(stack[1]) != hex.EncodeToString(key) {
+		t.Errorf("Satisfy: got %x, expected the signature and the key", stack)
+	}
+}
+
+func TestSatisfyUnavailable(t *testing.T) {
+	tests := []struct {
+		miniscript string
+		satisfier  *miniscript.Satisfier
+	}{
+		// The relative time lock has not passed
+		{"and_v(v:pk(@K1),older(144))", &miniscript.Satisfier{Sequence: 143}},
+		// The relative time lock is based on time
+		{"and_v(v:pk(@K1),older(4194305))", &miniscript.Satisfier{Sequence: 144}},
+		{"and_v(v:pk(@K1),older(144))", &miniscript.Satisfier{Sequence: 0xffffffff}},
+		// The absolute time lock is a height, where the lock time is a time
+		{"and_v(v:pk(@K1),after(144))", &miniscript.Satisfier{LockTime: 500000000}},
+		{"and_v(v:pk(@K1),sha256(@H))", &miniscript.Satisfier{Preimages: [][]byte{make([]byte, 32)}}},
+		{"multi(2,@K1,@K2,@K3)", &miniscript.Satisfier{}},
+		// Satisfying without a signature is malleable
+		{"or_d(pk(@K1),older(144))", &miniscript.Satisfier{Sequence: 144}},
+	}
+
+	for _, test := range tests {
+		n, err := miniscript.Parse(keys.Replace(test.miniscript), miniscript.P2WSH)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", test.miniscript, err)
+		}
+
+		key := privateKeys(t, 1)[0].SECCompressed()
+		test.satisfier.Signatures = map[string][]byte{hex.EncodeToString(key): {0x30, 0x01}}
+		if _, err := n.Satisfy(test.satisfier); err == nil {
+			t.Errorf("Satisfy(%s): expected an error", test.miniscript)
+		}
+	}
+}
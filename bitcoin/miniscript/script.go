@@ -0,0 +1,817 @@
+package miniscript
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+// The op codes of the scripts of miniscript
+const (
+	op0                   = 0x00
+	op1                   = 0x51
+	op16                  = 0x60
+	opIf                  = 0x63
+	opNotIf               = 0x64
+	opElse                = 0x67
+	opEndIf               = 0x68
+	opVerify              = 0x69
+	opToAltStack          = 0x6b
+	opFromAltStack        = 0x6c
+	opIfDup               = 0x73
+	opDup                 = 0x76
+	opSwap                = 0x7c
+	opSize                = 0x82
+	opEqual               = 0x87
+	opEqualVerify         = 0x88
+	op0NotEqual           = 0x92
+	opAdd                 = 0x93
+	opBoolAnd             = 0x9a
+	opBoolOr              = 0x9b
+	opNumEqual            = 0x9c
+	opNumEqualVerify      = 0x9d
+	opRIPEMD160           = 0xa6
+	opSHA256              = 0xa8
+	opHash160             = 0xa9
+	opHash256             = 0xaa
+	opCheckSig            = 0xac
+	opCheckSigVerify      = 0xad
+	opCheckMultiSig       = 0xae
+	opCheckMultiSigVerify = 0xaf
+	opCheckLockTimeVerify = 0xb1
+	opCheckSequenceVerify = 0xb2
+	opCheckSigAdd         = 0xba
+)
+
+// The op codes that have a VERIFY version, which is the op code plus one
+var verifyOpCodes = map[int]bool{
+	opEqual:         true,
+	opNumEqual:      true,
+	opCheckSig:      true,
+	opCheckMultiSig: true,
+}
+
+// Script returns the script of the miniscript.
+func (n *Node) Script() *bitcoin.Script {
+	return bitcoin.NewScript(n.instructions())
+}
+
+func (n *Node) instructions() []op.Instruction {
+	var sub []op.Instruction
+	if len(n.subs) > 0 {
+		sub = n.subs[0].instructions()
+	}
+
+	switch n.fragment {
+	case fragmentJust0:
+		return opCodes(op0)
+	case fragmentJust1:
+		return opCodes(op1)
+	case fragmentPkK:
+		return []op.Instruction{pushData(n.keys[0])}
+	case fragmentPkH:
+		return concat(opCodes(opDup, opHash160), []op.Instruction{pushData(n.data)}, opCodes(opEqualVerify))
+	case fragmentOlder:
+		return []op.Instruction{pushNumber(int64(n.k)), *op.NewOpCode(opCheckSequenceVerify)}
+	case fragmentAfter:
+		return []op.Instruction{pushNumber(int64(n.k)), *op.NewOpCode(opCheckLockTimeVerify)}
+	case fragmentSHA256, fragmentHash256, fragmentRIPEMD160, fragmentHash160:
+		hashOpCode := map[fragment]byte{
+			fragmentSHA256:    opSHA256,
+			fragmentHash256:   opHash256,
+			fragmentRIPEMD160: opRIPEMD160,
+			fragmentHash160:   opHash160,
+		}[n.fragment]
+		return concat(
+			[]op.Instruction{*op.NewOpCode(opSize), pushNumber(32)},
+			opCodes(opEqualVerify, hashOpCode),
+			[]op.Instruction{pushData(n.data), *op.NewOpCode(opEqual)},
+		)
+	case fragmentAndOr:
+		return concat(sub, opCodes(opNotIf), n.subs[2].instructions(), opCodes(opElse), n.subs[1].instructions(), opCodes(opEndIf))
+	case fragmentAndV:
+		return concat(sub, n.subs[1].instructions())
+	case fragmentAndB:
+		return concat(sub, n.subs[1].instructions(), opCodes(opBoolAnd))
+	case fragmentOrB:
+		return concat(sub, n.subs[1].instructions(), opCodes(opBoolOr))
+	case fragmentOrC:
+		return concat(sub, opCodes(opNotIf), n.subs[1].instructions(), opCodes(opEndIf))
+	case fragmentOrD:
+		return concat(sub, opCodes(opIfDup, opNotIf), n.subs[1].instructions(), opCodes(opEndIf))
+	case fragmentOrI:
+		return concat(opCodes(opIf), sub, opCodes(opElse), n.subs[1].instructions(), opCodes(opEndIf))
+	case fragmentThresh:
+		instructions := sub
+		for _, s := range n.subs[1:] {
+			instructions = concat(instructions, s.instructions(), opCodes(opAdd))
+		}
+		return concat(instructions, []op.Instruction{pushNumber(int64(n.k)), *op.NewOpCode(opEqual)})
+	case fragmentMulti:
+		instructions := []op.Instruction{pushNumber(int64(n.k))}
+		for _, key := range n.keys {
+			instructions = append(instructions, pushData(key))
+		}
+		return append(instructions, pushNumber(int64(len(n.keys))), *op.NewOpCode(opCheckMultiSig))
+	case fragmentMultiA:
+		instructions := []op.Instruction{pushData(n.keys[0]), *op.NewOpCode(opCheckSig)}
+		for _, key := range n.keys[1:] {
+			instructions = append(instructions, pushData(key), *op.NewOpCode(opCheckSigAdd))
+		}
+		return append(instructions, pushNumber(int64(n.k)), *op.NewOpCode(opNumEqual))
+	case fragmentWrapA:
+		return concat(opCodes(opToAltStack), sub, opCodes(opFromAltStack))
+	case fragmentWrapS:
+		return concat(opCodes(opSwap), sub)
+	case fragmentWrapC:
+		return concat(sub, opCodes(opCheckSig))
+	case fragmentWrapD:
+		return concat(opCodes(opDup, opIf), sub, opCodes(opEndIf))
+	case fragmentWrapV:
+		// The last op code is replaced by its VERIFY version when it has one
+		if n.subs[0].typ.Has(ExpensiveVerify) {
+			return concat(sub, opCodes(opVerify))
+		}
+		last := sub[len(sub)-1].OpCode()
+		return concat(sub[:len(sub)-1], opCodes(byte(last+1)))
+	case fragmentWrapJ:
+		return concat(opCodes(opSize, op0NotEqual, opIf), sub, opCodes(opEndIf))
+	case fragmentWrapN:
+		return concat(sub, opCodes(op0NotEqual))
+	}
+
+	return nil
+}
+
+func opCodes(opCodes ...byte) []op.Instruction {
+	instructions := make([]op.Instruction, len(opCodes))
+	for i, opCode := range opCodes {
+		instructions[i] = *op.NewOpCode(opCode)
+	}
+
+	return instructions
+}
+
+func concat(parts ...[]op.Instruction) []op.Instruction {
+	instructions := make([]op.Instruction, 0)
+	for _, part := range parts {
+		instructions = append(instructions, part...)
+	}
+
+	return instructions
+}
+
+// Returns the instruction that pushes data of at most 75 bytes
+func pushData(data []byte) op.Instruction {
+	return *op.NewPushData(byte(len(data)), data)
+}
+
+// Returns the instruction that pushes a number, which is OP_0 or OP_1 to
+// OP_16 when possible
+func pushNumber(number int64) op.Instruction {
+	switch {
+	case number == 0:
+		return *op.NewOpCode(op0)
+	case number >= 1 && number <= 16:
+		return *op.NewOpCode(byte(op1 - 1 + number))
+	}
+
+	data := op.EncodeNum(number)
+	return *op.NewPushData(byte(len(data)), data)
+}
+
+// Returns the size in bytes of the instruction that pushes a number
+func numberSize(number int64) int {
+	if number >= 0 && number <= 16 {
+		return 1
+	}
+
+	return 1 + len(op.EncodeNum(number))
+}
+
+// Returns the size of the script, computed from the sizes of the scripts of
+// the subexpressions
+func (n *Node) computeScriptSize() int {
+	size := 0
+	for _, sub := range n.subs {
+		size += sub.scriptSize
+	}
+
+	keySize := 33
+	if n.ctx == Tapscript {
+		keySize = 32
+	}
+
+	switch n.fragment {
+	case fragmentJust0, fragmentJust1:
+		return 1
+	case fragmentPkK:
+		return 1 + keySize
+	case fragmentPkH:
+		return 3 + 21
+	case fragmentOlder, fragmentAfter:
+		return 1 + numberSize(int64(n.k))
+	case fragmentSHA256, fragmentHash256:
+		return 4 + 2 + 33
+	case fragmentRIPEMD160, fragmentHash160:
+		return 4 + 2 + 21
+	case fragmentMulti:
+		return 1 + numberSize(int64(len(n.keys))) + numberSize(int64(n.k)) + (1+keySize)*len(n.keys)
+	case fragmentMultiA:
+		return (1+keySize+1)*len(n.keys) + numberSize(int64(n.k)) + 1
+	case fragmentAndV:
+		return size
+	case fragmentWrapV:
+		if n.subs[0].typ.Has(ExpensiveVerify) {
+			return size + 1
+		}
+		return size
+	case fragmentWrapS, fragmentWrapC, fragmentWrapN, fragmentAndB, fragmentOrB:
+		return size + 1
+	case fragmentWrapA, fragmentOrC:
+		return size + 2
+	case fragmentWrapD, fragmentOrD, fragmentOrI, fragmentAndOr:
+		return size + 3
+	case fragmentWrapJ:
+		return size + 4
+	case fragmentThresh:
+		return size + len(n.subs) - 1 + numberSize(int64(n.k)) + 1
+	}
+
+	return size
+}
+
+// ScriptSize returns the size of the script in bytes.
+func (n *Node) ScriptSize() int {
+	return n.scriptSize
+}
+
+// The maximum size of a script: P2WSH scripts larger than 3600 bytes are
+// not standard, and Tapscript scripts are only limited by the size of a
+// block
+func maxScriptSize(ctx Context) int {
+	if ctx == Tapscript {
+		return 4000000
+	}
+
+	return 3600
+}
+
+// FromScript decodes a script of ctx into the miniscript it was encoded
+// from, which must be valid as a whole script. The keys of pk_h() are not
+// in the script, so only their hashes are known.
+func FromScript(script *bitcoin.Script, ctx Context) (*Node, error) {
+	tokens, err := decompose(script)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{tokens: tokens, ctx: ctx}
+	n, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.checkTopLevel(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// An op code of a script that is decoded, where pushed data, including the
+// number of OP_1 to OP_16, is in data
+type token struct {
+	opCode int
+	data   []byte
+}
+
+// Returns the op codes of the script in reverse, as the script is decoded
+// from its end. The op codes that end in VERIFY are split in two, so that
+// the v: wrapper is always a separate OP_VERIFY.
+func decompose(script *bitcoin.Script) ([]token, error) {
+	instructions := script.Instructions()
+	tokens := make([]token, 0, len(instructions))
+	for i, instruction := range instructions {
+		if !instruction.IsOpCode() {
+			if !instruction.IsMinimalPush() {
+				return nil, fmt.Errorf("script has a push of %x that is not minimal", instruction.Bytes())
+			}
+			tokens = append(tokens, token{int(instruction.PushOpCode()), instruction.Bytes()})
+			continue
+		}
+
+		opCode := instruction.OpCode()
+		switch {
+		case opCode >= op1 && opCode <= op16:
+			tokens = append(tokens, token{opCode, []byte{byte(opCode - op1 + 1)}})
+		case verifyOpCodes[opCode-1]:
+			tokens = append(tokens, token{opCode - 1, nil}, token{opVerify, nil})
+		case verifyOpCodes[opCode] && i+1 < len(instructions) && instructions[i+1].OpCode() == opVerify:
+			return nil, fmt.Errorf("script has an OP_VERIFY after an op code that has a VERIFY version")
+		default:
+			tokens = append(tokens, token{opCode, nil})
+		}
+	}
+
+	for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		tokens[i], tokens[j] = tokens[j], tokens[i]
+	}
+
+	return tokens, nil
+}
+
+// Returns the number pushed by the token, which must be minimally encoded
+// and fit in 4 bytes
+func (t token) number() (int64, bool) {
+	if t.opCode == op0 {
+		return 0, true
+	}
+	if len(t.data) == 0 || len(t.data) > 4 {
+		return 0, false
+	}
+
+	number := op.DecodeNum(t.data)
+	if !bytes.Equal(op.EncodeNum(number), t.data) {
+		return 0, false
+	}
+
+	return number, true
+}
+
+// What the decoder expects next in the reversed script
+type decodeState int
+
+const (
+	// A single expression of type B, V or K, which is not and_v()
+	decodeSingleBKV decodeState = iota
+	// An expression of type B, V or K, which may be and_v()
+	decodeBKV
+	// An expression of type W
+	decodeW
+	// The expression before an expression of type B, V or K, which makes
+	// them and_v(), unless the script or the branch ends there
+	decodeMaybeAndV
+	// The OP_SWAP of s:
+	decodeSwap
+	// The OP_TOALTSTACK of a:
+	decodeAlt
+	// The last decoded expressions are combined, when the state is popped
+	decodeAndV
+	decodeAndB
+	decodeOrB
+	decodeOrC
+	decodeOrD
+	decodeAndOr
+	decodeWrapC
+	decodeWrapD
+	decodeWrapV
+	decodeWrapJ
+	decodeWrapN
+	// The expressions of thresh(), which are W expressions after an OP_ADD
+	// and a B expression at the start
+	decodeThreshW
+	// The expressions of thresh() are decoded
+	decodeThreshE
+	// The start of the branch that ends with OP_ENDIF
+	decodeEndIf
+	// The start of or_c() or or_d()
+	decodeEndIfNotIf
+	// The start of or_i() or andor()
+	decodeEndIfElse
+)
+
+type decodeTask struct {
+	state decodeState
+	// The number of expressions and the threshold of thresh()
+	n, k int
+}
+
+// Decodes a reversed script, by keeping the states that are expected next
+// on a stack, together with the expressions that are decoded
+type decoder struct {
+	tokens      []token
+	position    int
+	ctx         Context
+	tasks       []decodeTask
+	constructed []*Node
+}
+
+// Returns the token at offset from the current position, or nil when the
+// script ends before it
+func (d *decoder) peek(offset int) *token {
+	if d.position+offset >= len(d.tokens) {
+		return nil
+	}
+
+	return &d.tokens[d.position+offset]
+}
+
+// Returns whether the token at offset is the op code
+func (d *decoder) is(offset int, opCode int) bool {
+	t := d.peek(offset)
+	return t != nil && t.opCode == opCode
+}
+
+func (d *decoder) push(states ...decodeState) {
+	for _, state := range states {
+		d.tasks = append(d.tasks, decodeTask{state: state})
+	}
+}
+
+// Adds the node of a fragment, which must have a valid type
+func (d *decoder) build(f fragment, subs []*Node, keys [][]byte, data []byte, k uint32) error {
+	n := newNode(d.ctx, f, subs, keys, data, k)
+	if n.typ == 0 {
+		return fmt.Errorf("script decodes to miniscript %s, which is not valid", n)
+	}
+	d.constructed = append(d.constructed, n)
+
+	return nil
+}
+
+// Replaces the last decoded expressions by the fragment of them, where the
+// last one decoded is the first in the script
+func (d *decoder) combine(f fragment, count int) error {
+	if len(d.constructed) < count {
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	subs := make([]*Node, count)
+	for i := range subs {
+		subs[i] = d.constructed[len(d.constructed)-1-i]
+	}
+	d.constructed = d.constructed[:len(d.constructed)-count]
+
+	return d.build(f, subs, nil, nil, 0)
+}
+
+func (d *decoder) decode() (*Node, error) {
+	d.push(decodeBKV)
+
+	for len(d.tasks) > 0 {
+		task := d.tasks[len(d.tasks)-1]
+		d.tasks = d.tasks[:len(d.tasks)-1]
+
+		var err error
+		switch task.state {
+		case decodeSingleBKV:
+			err = d.decodeSingle()
+		case decodeBKV:
+			d.push(decodeMaybeAndV, decodeSingleBKV)
+		case decodeW:
+			if d.is(0, opFromAltStack) {
+				d.position++
+				d.push(decodeAlt)
+			} else {
+				d.push(decodeSwap)
+			}
+			d.push(decodeBKV)
+		case decodeMaybeAndV:
+			// An expression that comes before in the script makes and_v(),
+			// unless the branch or the W expression starts there
+			if t := d.peek(0); t != nil && t.opCode != opIf && t.opCode != opElse && t.opCode != opNotIf && t.opCode != opToAltStack && t.opCode != opSwap {
+				d.push(decodeAndV, decodeBKV)
+			}
+		case decodeSwap, decodeAlt:
+			expected, f := opSwap, fragmentWrapS
+			if task.state == decodeAlt {
+				expected, f = opToAltStack, fragmentWrapA
+			}
+			if !d.is(0, expected) {
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+			d.position++
+			err = d.combine(f, 1)
+		case decodeAndV:
+			err = d.combine(fragmentAndV, 2)
+		case decodeAndB:
+			err = d.combine(fragmentAndB, 2)
+		case decodeOrB:
+			err = d.combine(fragmentOrB, 2)
+		case decodeOrC:
+			err = d.combine(fragmentOrC, 2)
+		case decodeOrD:
+			err = d.combine(fragmentOrD, 2)
+		case decodeAndOr:
+			// andor(X,Y,Z) is [X] NOTIF [Z] ELSE [Y] ENDIF, so Y was decoded
+			// first
+			if len(d.constructed) < 3 {
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+			last := len(d.constructed) - 1
+			x, z, y := d.constructed[last], d.constructed[last-1], d.constructed[last-2]
+			d.constructed = d.constructed[:last-2]
+			err = d.build(fragmentAndOr, []*Node{x, y, z}, nil, nil, 0)
+		case decodeWrapC:
+			err = d.combine(fragmentWrapC, 1)
+		case decodeWrapD:
+			err = d.combine(fragmentWrapD, 1)
+		case decodeWrapV:
+			err = d.combine(fragmentWrapV, 1)
+		case decodeWrapJ:
+			err = d.combine(fragmentWrapJ, 1)
+		case decodeWrapN:
+			err = d.combine(fragmentWrapN, 1)
+		case decodeThreshW:
+			if d.peek(0) == nil {
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+			if d.is(0, opAdd) {
+				d.position++
+				d.tasks = append(d.tasks, decodeTask{decodeThreshW, task.n + 1, task.k})
+				d.push(decodeW)
+			} else {
+				// The first expression of thresh() is d, so it can not be
+				// and_v()
+				d.tasks = append(d.tasks, decodeTask{decodeThreshE, task.n + 1, task.k})
+				d.push(decodeSingleBKV)
+			}
+		case decodeThreshE:
+			if task.k < 1 || task.k > task.n || len(d.constructed) < task.n {
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+			subs := make([]*Node, task.n)
+			for i := range subs {
+				subs[i] = d.constructed[len(d.constructed)-1-i]
+			}
+			d.constructed = d.constructed[:len(d.constructed)-task.n]
+			err = d.build(fragmentThresh, subs, nil, nil, uint32(task.k))
+		case decodeEndIf:
+			err = d.decodeEndIf()
+		case decodeEndIfNotIf:
+			if d.peek(0) == nil {
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+			if d.is(0, opIfDup) {
+				d.position++
+				d.push(decodeOrD)
+			} else {
+				d.push(decodeOrC)
+			}
+			// X of or_c() and or_d() is d, so it can not be and_v()
+			d.push(decodeSingleBKV)
+		case decodeEndIfElse:
+			switch {
+			case d.is(0, opIf):
+				d.position++
+				err = d.combine(fragmentOrI, 2)
+			case d.is(0, opNotIf):
+				d.position++
+				// X of andor() is d, so it can not be and_v()
+				d.push(decodeAndOr, decodeSingleBKV)
+			default:
+				return nil, fmt.Errorf("script is not a miniscript")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.position != len(d.tokens) || len(d.constructed) != 1 {
+		return nil, fmt.Errorf("script is not a miniscript")
+	}
+
+	return d.constructed[0], nil
+}
+
+// Decodes an expression that is not and_v(), from its last op code
+func (d *decoder) decodeSingle() error {
+	t := d.peek(0)
+	if t == nil {
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	switch {
+	case t.opCode == op0:
+		d.position++
+		return d.build(fragmentJust0, nil, nil, nil, 0)
+	case t.opCode == op1:
+		d.position++
+		return d.build(fragmentJust1, nil, nil, nil, 0)
+	case len(t.data) == 32 || len(t.data) == 33:
+		key, err := d.key(t.data)
+		if err != nil {
+			return err
+		}
+		d.position++
+		return d.build(fragmentPkK, nil, [][]byte{key}, nil, 0)
+	case d.is(0, opVerify) && d.is(1, opEqual) && d.is(3, opHash160) && d.is(4, opDup) && len(d.peek(2).data) == 20:
+		h := d.peek(2).data
+		d.position += 5
+		return d.build(fragmentPkH, nil, [][]byte{nil}, h, 0)
+	case (t.opCode == opCheckSequenceVerify || t.opCode == opCheckLockTimeVerify) && d.peek(1) != nil:
+		lockTime, ok := d.peek(1).number()
+		if !ok {
+			break
+		}
+		if lockTime < 1 || lockTime >= 0x80000000 {
+			return fmt.Errorf("lock time %d is out of range", lockTime)
+		}
+		d.position += 2
+
+		f := fragmentOlder
+		if t.opCode == opCheckLockTimeVerify {
+			f = fragmentAfter
+		}
+		return d.build(f, nil, nil, nil, uint32(lockTime))
+	case d.isHashLock():
+		h := d.peek(1).data
+		f := map[int]fragment{
+			opSHA256:    fragmentSHA256,
+			opHash256:   fragmentHash256,
+			opRIPEMD160: fragmentRIPEMD160,
+			opHash160:   fragmentHash160,
+		}[d.peek(2).opCode]
+		d.position += 7
+		return d.build(f, nil, nil, h, 0)
+	case t.opCode == opCheckMultiSig:
+		return d.decodeMulti()
+	case t.opCode == opNumEqual && d.ctx == Tapscript:
+		return d.decodeMultiA()
+	}
+
+	switch t.opCode {
+	// The wrappers commute with and_v(), as c:and_v(X,Y) has the same
+	// script as and_v(X,c:Y), so the expression they wrap is not and_v()
+	case opCheckSig:
+		d.position++
+		d.push(decodeWrapC, decodeSingleBKV)
+	case opVerify:
+		d.position++
+		d.push(decodeWrapV, decodeSingleBKV)
+	case op0NotEqual:
+		d.position++
+		d.push(decodeWrapN, decodeSingleBKV)
+	case opEqual:
+		k, ok := int64(0), false
+		if next := d.peek(1); next != nil {
+			k, ok = next.number()
+		}
+		if !ok || k < 1 {
+			return fmt.Errorf("script is not a miniscript")
+		}
+		d.position += 2
+		d.tasks = append(d.tasks, decodeTask{decodeThreshW, 0, int(k)})
+	case opEndIf:
+		d.position++
+		d.push(decodeEndIf, decodeBKV)
+	// In the same way and_b() and or_b() are decoded without and_v(), as
+	// or_b(and_v(X,Y),Z) has the same script as and_v(X,or_b(Y,Z)), of
+	// which only the latter is valid
+	case opBoolAnd:
+		d.position++
+		d.push(decodeAndB, decodeSingleBKV, decodeW)
+	case opBoolOr:
+		d.position++
+		d.push(decodeOrB, decodeSingleBKV, decodeW)
+	default:
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	return nil
+}
+
+// Returns whether the last op codes are those of a hash lock, which is
+// SIZE <32> EQUALVERIFY <hash op code> <hash> EQUAL
+func (d *decoder) isHashLock() bool {
+	if !d.is(0, opEqual) || !d.is(3, opVerify) || !d.is(4, opEqual) || !d.is(6, opSize) {
+		return false
+	}
+	if size, ok := d.peek(5).number(); !ok || size != 32 {
+		return false
+	}
+
+	switch d.peek(2).opCode {
+	case opSHA256, opHash256:
+		return len(d.peek(1).data) == 32
+	case opRIPEMD160, opHash160:
+		return len(d.peek(1).data) == 20
+	}
+
+	return false
+}
+
+// Decodes <k> <key_1> ... <key_n> <n> CHECKMULTISIG
+func (d *decoder) decodeMulti() error {
+	if d.ctx == Tapscript {
+		return fmt.Errorf("script has an OP_CHECKMULTISIG, which is disabled in tapscript")
+	}
+
+	count, ok := int64(0), false
+	if t := d.peek(1); t != nil {
+		count, ok = t.number()
+	}
+	if !ok || count < 1 || count > maxMultiKeys || d.peek(2+int(count)) == nil {
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	keys := make([][]byte, count)
+	for i := range keys {
+		key, err := d.key(d.peek(2 + i).data)
+		if err != nil {
+			return err
+		}
+		// The keys are in reverse
+		keys[len(keys)-1-i] = key
+	}
+
+	k, ok := d.peek(2 + int(count)).number()
+	if !ok || k < 1 || k > count {
+		return fmt.Errorf("script is not a miniscript")
+	}
+	d.position += 3 + int(count)
+
+	return d.build(fragmentMulti, nil, keys, nil, uint32(k))
+}
+
+// Decodes <key_1> CHECKSIG <key_2> CHECKSIGADD ... <k> NUMEQUAL
+func (d *decoder) decodeMultiA() error {
+	k, ok := int64(0), false
+	if t := d.peek(1); t != nil {
+		k, ok = t.number()
+	}
+	if !ok || k < 1 || k > maxMultiAKeys {
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	keys := make([][]byte, 0)
+	for offset := 2; ; offset += 2 {
+		if !d.is(offset, opCheckSig) && !d.is(offset, opCheckSigAdd) {
+			return fmt.Errorf("script is not a miniscript")
+		}
+		t := d.peek(offset + 1)
+		if t == nil || len(t.data) != 32 {
+			return fmt.Errorf("script is not a miniscript")
+		}
+		key, err := d.key(t.data)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		if len(keys) > maxMultiAKeys {
+			return fmt.Errorf("script is not a miniscript")
+		}
+
+		// The first key is checked with OP_CHECKSIG
+		if d.is(offset, opCheckSig) {
+			break
+		}
+	}
+	if len(keys) < int(k) {
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	d.position += 2 + 2*len(keys)
+
+	return d.build(fragmentMultiA, nil, keys, nil, uint32(k))
+}
+
+// Decodes the start of a branch that ends with OP_ENDIF, which is j:, d:,
+// andor(), or_c(), or_d() or or_i()
+func (d *decoder) decodeEndIf() error {
+	switch {
+	case d.is(0, opElse):
+		d.position++
+		d.push(decodeEndIfElse, decodeBKV)
+	case d.is(0, opIf) && d.is(1, opDup):
+		d.position += 2
+		d.push(decodeWrapD)
+	case d.is(0, opIf) && d.is(1, op0NotEqual) && d.is(2, opSize):
+		d.position += 3
+		d.push(decodeWrapJ)
+	case d.is(0, opNotIf):
+		d.position++
+		d.push(decodeEndIfNotIf)
+	default:
+		return fmt.Errorf("script is not a miniscript")
+	}
+
+	return nil
+}
+
+// Returns the key pushed in the script, which must be a compressed key in
+// P2WSH and an x-only key in Tapscript
+func (d *decoder) key(data []byte) ([]byte, error) {
+	var err error
+	switch {
+	case d.ctx == Tapscript && len(data) == 32:
+		_, err = ecc.ParseXOnly(data)
+	case d.ctx == P2WSH && len(data) == 33:
+		_, err = ecc.Parse(data)
+	default:
+		return nil, fmt.Errorf("script pushes %x, which is not a key of %s", data, d.ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("script pushes %x, which is not a valid key: %w", data, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,307 @@
+package miniscript
+
+import "strings"
+
+// Type is the type of a miniscript expression, which is one of the basic
+// types B, V, K and W together with the properties of the expression, see
+// BIP 379.
+type Type uint32
+
+const (
+	// B pushes a nonzero value when satisfied and an exact 0 when
+	// dissatisfied
+	TypeB Type = 1 << iota
+	// V continues without pushing anything when satisfied, and can not be
+	// dissatisfied
+	TypeV
+	// K pushes a public key that a signature is still needed for
+	TypeK
+	// W is like B, but takes its inputs from below the top of the stack
+	TypeW
+	// z always consumes exactly 0 stack elements
+	ZeroArg
+	// o always consumes exactly 1 stack element
+	OneArg
+	// n does not need the top stack element to be zero to be satisfied
+	NonZero
+	// d can be dissatisfied unconditionally
+	Dissatisfiable
+	// u puts an exact 1 on the stack when satisfied
+	Unit
+	// e has a unique unconditional dissatisfaction, and all other
+	// dissatisfactions need a signature
+	Expressive
+	// f needs a signature to be dissatisfied
+	Forced
+	// s needs a signature to be satisfied
+	Signed
+	// m has a non-malleable satisfaction
+	NonMalleable
+	// x ends with an op code that has no VERIFY version, so the v: wrapper
+	// adds an OP_VERIFY
+	ExpensiveVerify
+	// g has a relative time lock based on time
+	RelativeTime
+	// h has a relative time lock based on height
+	RelativeHeight
+	// i has an absolute time lock based on time
+	AbsoluteTime
+	// j has an absolute time lock based on height
+	AbsoluteHeight
+	// k does not mix time locks based on height and time that can not be
+	// satisfied together
+	NoTimelockMix
+)
+
+// The letters of the types and properties, in the order of the constants
+const typeLetters = "BVKWzonduefsmxghijk"
+
+// Returns the type with the types and properties of letters
+func types(letters string) Type {
+	t := Type(0)
+	for _, letter := range letters {
+		t |= 1 << strings.IndexRune(typeLetters, letter)
+	}
+
+	return t
+}
+
+// Has returns whether the type has all of the types and properties of
+// flags.
+func (t Type) Has(flags Type) bool {
+	return t&flags == flags
+}
+
+// Returns the type letter and the letters of the properties, such as
+// "Bondusemk".
+func (t Type) String() string {
+	var sb strings.Builder
+	for i, letter := range typeLetters {
+		if t&(1<<i) != 0 {
+			sb.WriteRune(letter)
+		}
+	}
+
+	return sb.String()
+}
+
+// Returns t when condition holds, and no type otherwise
+func when(condition bool, t Type) Type {
+	if condition {
+		return t
+	}
+
+	return 0
+}
+
+// Returns whether x and y have time locks of the same kind, where one is
+// based on height and the other on time, so that both can not be satisfied
+func mixesTimelocks(x, y Type) bool {
+	return x.Has(RelativeTime) && y.Has(RelativeHeight) ||
+		x.Has(RelativeHeight) && y.Has(RelativeTime) ||
+		x.Has(AbsoluteTime) && y.Has(AbsoluteHeight) ||
+		x.Has(AbsoluteHeight) && y.Has(AbsoluteTime)
+}
+
+// The flag of nSequence that makes a relative time lock based on time,
+// see BIP 68
+const sequenceLockTimeTypeFlag = 1 << 22
+
+// The lock times from which nLockTime is a time instead of a height
+const lockTimeThreshold = 500000000
+
+// Returns the type of a fragment from the types of its subexpressions x,
+// y and z, or of all of them for thresh(). It has no basic type when the
+// subexpressions do not meet the requirements of the fragment, see the
+// tables of BIP 379.
+func computeType(f fragment, x, y, z Type, subTypes []Type, k uint32, ctx Context) Type {
+	var t Type
+	switch f {
+	case fragmentJust0:
+		t = types("Bzudemsxk")
+	case fragmentJust1:
+		t = types("Bzufmxk")
+	case fragmentPkK:
+		t = types("Konudemsxk")
+	case fragmentPkH:
+		t = types("Knudemsxk")
+	case fragmentOlder:
+		t = when(k&sequenceLockTimeTypeFlag != 0, RelativeTime) |
+			when(k&sequenceLockTimeTypeFlag == 0, RelativeHeight) |
+			types("Bzfmxk")
+	case fragmentAfter:
+		t = when(k >= lockTimeThreshold, AbsoluteTime) |
+			when(k < lockTimeThreshold, AbsoluteHeight) |
+			types("Bzfmxk")
+	case fragmentSHA256, fragmentRIPEMD160, fragmentHash256, fragmentHash160:
+		t = types("Bonudmk")
+	case fragmentWrapA:
+		t = when(x.Has(TypeB), TypeW) |
+			x&types("ghijk") |
+			x&types("udfems") |
+			ExpensiveVerify
+	case fragmentWrapS:
+		t = when(x.Has(types("Bo")), TypeW) |
+			x&types("ghijk") |
+			x&types("udfemsx")
+	case fragmentWrapC:
+		t = when(x.Has(TypeK), TypeB) |
+			x&types("ghijk") |
+			x&types("ondfem") |
+			types("us")
+	case fragmentWrapD:
+		t = when(x.Has(types("Vz")), TypeB) |
+			when(x.Has(ZeroArg), OneArg) |
+			when(x.Has(Forced), Expressive) |
+			x&types("ghijk") |
+			x&types("ms") |
+			// MINIMALIF is only a policy rule in P2WSH, so a 2 could be
+			// passed to OP_IF there
+			when(ctx == Tapscript, Unit) |
+			types("ndx")
+	case fragmentWrapV:
+		t = when(x.Has(TypeB), TypeV) |
+			x&types("ghijk") |
+			x&types("zonms") |
+			types("fx")
+	case fragmentWrapJ:
+		t = when(x.Has(types("Bn")), TypeB) |
+			when(x.Has(Forced), Expressive) |
+			x&types("ghijk") |
+			x&types("oums") |
+			types("ndx")
+	case fragmentWrapN:
+		t = x&types("ghijk") |
+			x&types("Bzondfems") |
+			types("ux")
+	case fragmentAndV:
+		t = when(x.Has(TypeV), y&types("KVB")) |
+			x&NonZero | when(x.Has(ZeroArg), y&NonZero) |
+			when((x|y).Has(ZeroArg), (x|y)&OneArg) |
+			x&y&types("dmz") |
+			(x|y)&Signed |
+			when(y.Has(Forced) || x.Has(Signed), Forced) |
+			y&types("ux") |
+			(x|y)&types("ghij") |
+			when((x&y).Has(NoTimelockMix) && !mixesTimelocks(x, y), NoTimelockMix)
+	case fragmentAndB:
+		t = when(y.Has(TypeW), x&TypeB) |
+			when((x|y).Has(ZeroArg), (x|y)&OneArg) |
+			x&NonZero | when(x.Has(ZeroArg), y&NonZero) |
+			when((x&y).Has(Signed), x&y&Expressive) |
+			x&y&types("dzm") |
+			when((x&y).Has(Forced) || x.Has(types("sf")) || y.Has(types("sf")), Forced) |
+			(x|y)&Signed |
+			types("ux") |
+			(x|y)&types("ghij") |
+			when((x&y).Has(NoTimelockMix) && !mixesTimelocks(x, y), NoTimelockMix)
+	case fragmentOrB:
+		t = when(x.Has(types("Bd")) && y.Has(types("Wd")), TypeB) |
+			when((x|y).Has(ZeroArg), (x|y)&OneArg) |
+			when((x|y).Has(Signed) && (x&y).Has(Expressive), x&y&NonMalleable) |
+			x&y&types("zse") |
+			types("dux") |
+			(x|y)&types("ghij") |
+			x&y&NoTimelockMix
+	case fragmentOrD:
+		t = when(x.Has(types("Bdu")), y&TypeB) |
+			when(y.Has(ZeroArg), x&OneArg) |
+			when(x.Has(Expressive) && (x|y).Has(Signed), x&y&NonMalleable) |
+			x&y&types("zes") |
+			y&types("ufd") |
+			ExpensiveVerify |
+			(x|y)&types("ghij") |
+			x&y&NoTimelockMix
+	case fragmentOrC:
+		t = when(x.Has(types("Bdu")), y&TypeV) |
+			when(y.Has(ZeroArg), x&OneArg) |
+			when(x.Has(Expressive) && (x|y).Has(Signed), x&y&NonMalleable) |
+			x&y&types("zs") |
+			types("fx") |
+			(x|y)&types("ghij") |
+			x&y&NoTimelockMix
+	case fragmentOrI:
+		t = x&y&types("VBKufs") |
+			when((x&y).Has(ZeroArg), OneArg) |
+			when(x.Has(Expressive) && y.Has(Forced) || y.Has(Expressive) && x.Has(Forced), Expressive) |
+			when((x|y).Has(Signed), x&y&NonMalleable) |
+			(x|y)&Dissatisfiable |
+			ExpensiveVerify |
+			(x|y)&types("ghij") |
+			x&y&NoTimelockMix
+	case fragmentAndOr:
+		t = when(x.Has(types("Bdu")), y&z&types("BKV")) |
+			x&y&z&ZeroArg |
+			when((x|(y&z)).Has(ZeroArg), (x|(y&z))&OneArg) |
+			y&z&Unit |
+			when(x.Has(Signed) || y.Has(Forced), z&Forced) |
+			z&Dissatisfiable |
+			when(x.Has(Signed) || y.Has(Forced), z&Expressive) |
+			when(x.Has(Expressive) && (x|y|z).Has(Signed), x&y&z&NonMalleable) |
+			z&(x|y)&Signed |
+			ExpensiveVerify |
+			(x|y|z)&types("ghij") |
+			when((x&y&z).Has(NoTimelockMix) && !mixesTimelocks(x, y), NoTimelockMix)
+	case fragmentMulti:
+		t = types("Bnudemsk")
+	case fragmentMultiA:
+		t = types("Budemsk")
+	case fragmentThresh:
+		t = threshType(subTypes, k)
+	}
+
+	return sanitize(t)
+}
+
+// Returns the type of thresh() with the types of its subexpressions
+func threshType(subTypes []Type, k uint32) Type {
+	allExpressive, allNonMalleable := true, true
+	args, signed := 0, 0
+	timelocks := NoTimelockMix
+	for i, t := range subTypes {
+		required := types("Wdu")
+		if i == 0 {
+			required = types("Bdu")
+		}
+		if !t.Has(required) {
+			return 0
+		}
+
+		allExpressive = allExpressive && t.Has(Expressive)
+		allNonMalleable = allNonMalleable && t.Has(NonMalleable)
+		if t.Has(Signed) {
+			signed++
+		}
+		switch {
+		case t.Has(ZeroArg):
+		case t.Has(OneArg):
+			args++
+		default:
+			args += 2
+		}
+
+		// Only one of the time locks is needed when k is 1, so they can be
+		// mixed then
+		timelocks = (timelocks|t)&types("ghij") |
+			when((timelocks&t).Has(NoTimelockMix) && (k <= 1 || !mixesTimelocks(timelocks, t)), NoTimelockMix)
+	}
+
+	n := len(subTypes)
+	return types("Bdu") |
+		when(args == 0, ZeroArg) |
+		when(args == 1, OneArg) |
+		when(allExpressive && signed == n, Expressive) |
+		when(allExpressive && allNonMalleable && signed >= n-int(k), NonMalleable) |
+		when(signed >= n-int(k)+1, Signed) |
+		timelocks
+}
+
+// Returns no type when t has no basic type, which is how the type of an
+// invalid expression ends up
+func sanitize(t Type) Type {
+	if t&(TypeB|TypeV|TypeK|TypeW) == 0 {
+		return 0
+	}
+
+	return t
+}
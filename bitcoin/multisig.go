@@ -0,0 +1,281 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Returns the multisig script OP_m <public key>... OP_n OP_CHECKMULTISIG,
+// where m of the n public keys must sign in the order of the keys.
+func ToMultisigScript(required int, publicKeys [][]byte) (*Script, error) {
+	if len(publicKeys) < 1 || len(publicKeys) > op.MaxPubKeysPerMultisig {
+		return nil, fmt.Errorf("multisig has %d public keys, which must be between 1 and %d", len(publicKeys), op.MaxPubKeysPerMultisig)
+	}
+	if required < 1 || required > len(publicKeys) {
+		return nil, fmt.Errorf("multisig requires %d signatures, which must be between 1 and %d", required, len(publicKeys))
+	}
+
+	instructions := []op.Instruction{pushSmallNumber(required)}
+	for _, publicKey := range publicKeys {
+		if _, err := ecc.Parse(publicKey); err != nil {
+			return nil, fmt.Errorf("invalid public key %x: %w", publicKey, err)
+		}
+		instructions = append(instructions, *op.NewPushData(byte(len(publicKey)), publicKey))
+	}
+	instructions = append(instructions, pushSmallNumber(len(publicKeys)), *op.NewOpCode(0xae)) // OP_CHECKMULTISIG
+
+	return NewScript(instructions), nil
+}
+
+// Returns the multisig script of the compressed public keys sorted in
+// lexicographic order, so that all cosigners get the same script whatever
+// the order they exchanged their keys in, see BIP 67.
+func ToSortedMultisigScript(required int, publicKeys [][]byte) (*Script, error) {
+	for _, publicKey := range publicKeys {
+		if len(publicKey) != 33 {
+			return nil, fmt.Errorf("public key %x is not compressed, as BIP 67 requires", publicKey)
+		}
+	}
+
+	sorted := slices.Clone(publicKeys)
+	slices.SortFunc(sorted, bytes.Compare)
+
+	return ToMultisigScript(required, sorted)
+}
+
+// Returns the pay-to-script-hash script pubkey of the multisig script, which
+// must fit in the 520 bytes that the push of a redeem script is limited to.
+// Scripts of 16 or more compressed keys only fit in a
+// pay-to-witness-script-hash output.
+func ToP2SHMultisigScript(multisig *Script) (*Script, error) {
+	raw, err := multisig.RawSerialize()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRedeemScriptSize(raw); err != nil {
+		return nil, err
+	}
+
+	return ToP2SHScript(hash.Hash160(raw))
+}
+
+// Returns an error when the raw redeem script can not be pushed
+func checkRedeemScriptSize(raw []byte) error {
+	if len(raw) > op.MaxScriptElementSize {
+		return fmt.Errorf("redeem script of %d bytes is larger than %d bytes", len(raw), op.MaxScriptElementSize)
+	}
+
+	return nil
+}
+
+// Returns the instruction that pushes a number of a multisig script, which
+// is OP_1 to OP_16 or the number itself
+func pushSmallNumber(n int) op.Instruction {
+	if n <= 16 {
+		return *op.NewOpCode(byte(0x50 + n)) // OP_1 to OP_16
+	}
+
+	return *op.NewPushData(1, op.EncodeNum(int64(n)))
+}
+
+// Returns the number pushed by OP_1 to OP_16 or by a push of a single byte,
+// or 0 for other instructions
+func smallNumber(instruction op.Instruction) int {
+	opCode := instruction.OpCode()
+	switch {
+	case opCode >= 0x51 && opCode <= 0x60: // OP_1 to OP_16
+		return opCode - 0x50
+	case !instruction.IsOpCode() && instruction.Length() == 1 && instruction.IsMinimalPush():
+		return int(op.DecodeNum(instruction.Bytes()))
+	}
+
+	return 0
+}
+
+// Returns the number of required signatures and the public keys of a
+// multisig script, OP_m <public key>... OP_n OP_CHECKMULTISIG.
+func (script *Script) Multisig() (int, [][]byte, bool) {
+	instructions := script.instructions
+	if len(instructions) < 4 || instructions[len(instructions)-1].OpCode() != 0xae { // OP_CHECKMULTISIG
+		return 0, nil, false
+	}
+
+	required := smallNumber(instructions[0])
+	count := smallNumber(instructions[len(instructions)-2])
+	keys := make([][]byte, 0, count)
+	for _, instruction := range instructions[1 : len(instructions)-2] {
+		if instruction.IsOpCode() || (instruction.Length() != 33 && instruction.Length() != 65) {
+			return 0, nil, false
+		}
+		keys = append(keys, instruction.Bytes())
+	}
+
+	if required < 1 || required > count || count != len(keys) || count > op.MaxPubKeysPerMultisig {
+		return 0, nil, false
+	}
+
+	return required, keys, true
+}
+
+// How the output spent by a multisig input commits to the multisig script
+type multisigSpend int
+
+const (
+	// The script pubkey is the multisig script
+	bareMultisig multisigSpend = iota
+	// The multisig script is the redeem script
+	p2shMultisig
+	// The multisig script is the witness script
+	p2wshMultisig
+	// The multisig script is the witness script of a pay-to-witness-script-hash
+	// redeem script
+	p2shP2WSHMultisig
+)
+
+// Returns how the input at inputIndex spends the multisig script, together
+// with the amount of the spent output
+func (tx *Tx) multisigSpend(inputIndex int, script *Script) (multisigSpend, uint64, error) {
+	if _, _, ok := script.Multisig(); !ok {
+		return 0, 0, fmt.Errorf("script is not a multisig script")
+	}
+
+	prevOut, err := tx.prevOut(tx.Inputs[inputIndex])
+	if err != nil {
+		return 0, 0, err
+	}
+	scriptPubKey := &prevOut.ScriptPubKey
+
+	raw, err := script.RawSerialize()
+	if err != nil {
+		return 0, 0, err
+	}
+	rawScriptPubKey, err := scriptPubKey.RawSerialize()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rawP2WSH, err := rawP2WSHScript(raw)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch {
+	case bytes.Equal(rawScriptPubKey, raw):
+		return bareMultisig, prevOut.Amount, nil
+	case scriptPubKey.IsP2SHScriptPubKey() && bytes.Equal(scriptPubKey.instructions[1].Bytes(), hash.Hash160(raw)):
+		if err := checkRedeemScriptSize(raw); err != nil {
+			return 0, 0, err
+		}
+		return p2shMultisig, prevOut.Amount, nil
+	case bytes.Equal(rawScriptPubKey, rawP2WSH):
+		return p2wshMultisig, prevOut.Amount, nil
+	case scriptPubKey.IsP2SHScriptPubKey() && bytes.Equal(scriptPubKey.instructions[1].Bytes(), hash.Hash160(rawP2WSH)):
+		return p2shP2WSHMultisig, prevOut.Amount, nil
+	}
+
+	return 0, 0, fmt.Errorf("input %d does not spend the multisig script", inputIndex)
+}
+
+// SignMultisigInput returns the signature of privateKey for the input at
+// inputIndex with hashType, which is one cosigner's part of spending the
+// multisig script. The spent output may be the multisig script itself, or
+// pay to it as a pay-to-script-hash, pay-to-witness-script-hash or nested
+// pay-to-witness-script-hash output. The public key of privateKey, compressed
+// or uncompressed, must be one of the keys of the script.
+func (tx *Tx) SignMultisigInput(inputIndex int, privateKey *ecc.PrivateKey, script *Script, hashType SigHashType) ([]byte, error) {
+	spend, amount, err := tx.multisigSpend(inputIndex, script)
+	if err != nil {
+		return nil, err
+	}
+
+	_, publicKeys, _ := script.Multisig()
+	if !slices.ContainsFunc(publicKeys, func(publicKey []byte) bool {
+		return bytes.Equal(publicKey, privateKey.SECCompressed()) || bytes.Equal(publicKey, privateKey.SECUncompressed())
+	}) {
+		return nil, fmt.Errorf("private key is not one of the keys of the multisig script")
+	}
+
+	var z []byte
+	if spend == p2wshMultisig || spend == p2shP2WSHMultisig {
+		z, err = tx.SegwitSignatureHash(inputIndex, script, amount, hashType)
+	} else {
+		z, err = tx.SignatureHash(inputIndex, script, hashType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sign(privateKey, z, hashType)
+}
+
+// FinalizeMultisigInput sets the scriptSig and witness of the input at
+// inputIndex that spends the multisig script, from the signatures of the
+// cosigners by their public keys in hex. The signatures follow the dummy
+// OP_0 that OP_CHECKMULTISIG pops, in the order of the keys of the script,
+// and only as many as are required are used. Returns whether the input
+// verifies.
+func (tx *Tx) FinalizeMultisigInput(inputIndex int, script *Script, signatures map[string][]byte) (bool, error) {
+	spend, _, err := tx.multisigSpend(inputIndex, script)
+	if err != nil {
+		return false, err
+	}
+
+	required, publicKeys, _ := script.Multisig()
+	stack := [][]byte{{}}
+	for _, publicKey := range publicKeys {
+		if signature, ok := signatures[hex.EncodeToString(publicKey)]; ok && len(stack) <= required {
+			stack = append(stack, signature)
+		}
+	}
+	if len(stack)-1 < required {
+		return false, fmt.Errorf("input %d has %d of the %d required signatures", inputIndex, len(stack)-1, required)
+	}
+
+	raw, err := script.RawSerialize()
+	if err != nil {
+		return false, err
+	}
+
+	var scriptSig, witness [][]byte
+	switch spend {
+	case bareMultisig:
+		scriptSig = stack
+	case p2shMultisig:
+		scriptSig = append(stack, raw)
+	case p2wshMultisig:
+		witness = append(stack, raw)
+	case p2shP2WSHMultisig:
+		rawP2WSH, err := rawP2WSHScript(raw)
+		if err != nil {
+			return false, err
+		}
+		scriptSig = [][]byte{rawP2WSH}
+		witness = append(stack, raw)
+	}
+
+	txIn := tx.Inputs[inputIndex]
+	txIn.ScriptSig, err = ToPushOnlyScript(scriptSig)
+	if err != nil {
+		return false, err
+	}
+	txIn.Witness = witness
+
+	return tx.VerifyInput(inputIndex, op.StandardVerifyFlags)
+}
+
+// Returns the pay-to-witness-script-hash script of the raw witness script,
+// which is the redeem script of a nested pay-to-witness-script-hash output
+func rawP2WSHScript(raw []byte) ([]byte, error) {
+	p2wsh, err := ToP2WSHScript(hash.HashSHA256(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return p2wsh.RawSerialize()
+}
@@ -0,0 +1,265 @@
+package bitcoin_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+func TestToSortedMultisigScript(t *testing.T) {
+	// The test vectors of BIP 67, which require two signatures
+	tests := []struct {
+		keys    []string
+		script  string
+		address string
+	}{
+		{
+			[]string{
+				"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+				"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+			},
+			"522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae",
+			"39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z",
+		},
+		{
+			[]string{
+				"02632b12f4ac5b1d1b72b2a3b508c19172de44f6f46bcee50ba33f3f9291e47ed0",
+				"027735a29bae7780a9755fae7a1c4374c656ac6a69ea9f3697fda61bb99a4f3e77",
+				"02e2cc6bd5f45edd43bebe7cb9b675f0ce9ed3efe613b177588290ad188d11b404",
+			},
+			"522102632b12f4ac5b1d1b72b2a3b508c19172de44f6f46bcee50ba33f3f9291e47ed021027735a29bae7780a9755fae7a1c4374c656ac6a69ea9f3697fda61bb99a4f3e772102e2cc6bd5f45edd43bebe7cb9b675f0ce9ed3efe613b177588290ad188d11b40453ae",
+			"3CKHTjBKxCARLzwABMu9yD85kvtm7WnMfH",
+		},
+		{
+			[]string{
+				"030000000000000000000000000000000000004141414141414141414141414141",
+				"020000000000000000000000000000000000004141414141414141414141414141",
+				"020000000000000000000000000000000000004141414141414141414141414140",
+				"030000000000000000000000000000000000004141414141414141414141414140",
+			},
+			"522102000000000000000000000000000000000000414141414141414141414141414021020000000000000000000000000000000000004141414141414141414141414141210300000000000000000000000000000000000041414141414141414141414141402103000000000000000000000000000000000000414141414141414141414141414154ae",
+			"32V85igBri9zcfBRVupVvwK18NFtS37FuD",
+		},
+		{
+			[]string{
+				"022df8750480ad5b26950b25c7ba79d3e37d75f640f8e5d9bcd5b150a0f85014da",
+				"03e3818b65bcc73a7d64064106a859cc1a5a728c4345ff0b641209fba0d90de6e9",
+				"021f2f6e1e50cb6a953935c3601284925decd3fd21bc445712576873fb8c6ebc18",
+			},
+			"5221021f2f6e1e50cb6a953935c3601284925decd3fd21bc445712576873fb8c6ebc1821022df8750480ad5b26950b25c7ba79d3e37d75f640f8e5d9bcd5b150a0f85014da2103e3818b65bcc73a7d64064106a859cc1a5a728c4345ff0b641209fba0d90de6e953ae",
+			"3Q4sF6tv9wsdqu2NtARzNCpQgwifm2rAba",
+		},
+	}
+
+	for _, test := range tests {
+		keys := make([][]byte, len(test.keys))
+		for i, key := range test.keys {
+			keys[i], _ = hex.DecodeString(key)
+		}
+
+		script, err := bitcoin.ToSortedMultisigScript(2, keys)
+		if err != nil {
+			t.Fatalf("ToSortedMultisigScript: %v", err)
+		}
+
+		raw, _ := script.RawSerialize()
+		if hex.EncodeToString(raw) != test.script {
+			t.Errorf("ToSortedMultisigScript: got %x, expected %s", raw, test.script)
+		}
+		if address := bitcoin.H160ToP2SHAddress(hash.Hash160(raw), &chaincfg.MainNetParams); address != test.address {
+			t.Errorf("ToSortedMultisigScript: got address %s, expected %s", address, test.address)
+		}
+
+		// The keys are not sorted in place
+		if hex.EncodeToString(keys[0]) != test.keys[0] {
+			t.Errorf("ToSortedMultisigScript: the keys were sorted in place")
+		}
+	}
+}
+
+func TestToMultisigScriptInvalid(t *testing.T) {
+	key := privateKey(1).SECCompressed()
+	uncompressed := privateKey(1).SECUncompressed()
+
+	tests := []struct {
+		required int
+		keys     [][]byte
+	}{
+		{0, [][]byte{key}},
+		{2, [][]byte{key}},
+		{1, [][]byte{}},
+		{1, [][]byte{key[:32]}},
+		{1, make([][]byte, 21)},
+	}
+
+	for _, test := range tests {
+		if _, err := bitcoin.ToMultisigScript(test.required, test.keys); err == nil {
+			t.Errorf("ToMultisigScript(%d, %x): expected an error", test.required, test.keys)
+		}
+	}
+
+	if _, err := bitcoin.ToSortedMultisigScript(1, [][]byte{key, uncompressed}); err == nil {
+		t.Errorf("ToSortedMultisigScript: expected an error for an uncompressed key")
+	}
+}
+
+func TestToP2SHMultisigScript(t *testing.T) {
+	publicKeys := make([][]byte, 16)
+	for i := range publicKeys {
+		publicKeys[i] = privateKey(int64(1001 + i)).SECCompressed()
+	}
+
+	// 15 compressed keys are the most that fit in a redeem script
+	script, _ := bitcoin.ToMultisigScript(1, publicKeys[:15])
+	raw, _ := script.RawSerialize()
+	p2sh, err := bitcoin.ToP2SHMultisigScript(script)
+	if err != nil {
+		t.Fatalf("ToP2SHMultisigScript: %v", err)
+	}
+	expected, _ := bitcoin.ToP2SHScript(hash.Hash160(raw))
+	rawP2SH, _ := p2sh.RawSerialize()
+	if rawExpected, _ := expected.RawSerialize(); !bytes.Equal(rawP2SH, rawExpected) {
+		t.Errorf("ToP2SHMultisigScript: expected the pay-to-script-hash of the script")
+	}
+
+	script, _ = bitcoin.ToMultisigScript(1, publicKeys)
+	if _, err := bitcoin.ToP2SHMultisigScript(script); err == nil {
+		t.Errorf("ToP2SHMultisigScript: expected an error for a script of 16 keys")
+	}
+
+	// Spending the pay-to-script-hash of a script that is too large fails
+	// before it is signed
+	raw, _ = script.RawSerialize()
+	p2sh, _ = bitcoin.ToP2SHScript(hash.Hash160(raw))
+	tx := newSpendingTx(t, bitcoin.NewScript([]op.Instruction{}), p2sh, nil, 100_000)
+	if _, err := tx.SignMultisigInput(0, privateKey(1001), script, bitcoin.SigHashAll); err == nil {
+		t.Errorf("SignMultisigInput: expected an error for a redeem script of %d bytes", len(raw))
+	}
+}
+
+func TestMultisig(t *testing.T) {
+	keys := make([][]byte, 20)
+	for i := range keys {
+		keys[i] = privateKey(int64(i + 1)).SECCompressed()
+	}
+
+	for _, n := range []int{1, 3, 16, 17, 20} {
+		script, err := bitcoin.ToMultisigScript(n, keys[:n])
+		if err != nil {
+			t.Fatalf("ToMultisigScript: %v", err)
+		}
+
+		required, publicKeys, ok := script.Multisig()
+		if !ok || required != n || len(publicKeys) != n {
+			t.Errorf("Multisig: got %d, %d keys, %v, expected %d, %d keys, true", required, len(publicKeys), ok, n, n)
+		}
+	}
+
+	for _, asm := range []string{"1 0x21 0x" + hex.EncodeToString(keys[0]) + " 2 CHECKMULTISIG", "CHECKSIG 1 2 CHECKMULTISIG"} {
+		script, err := bitcoin.ParseScriptAsm(asm)
+		if err != nil {
+			t.Fatalf("ParseScriptAsm: %v", err)
+		}
+		if _, _, ok := script.Multisig(); ok {
+			t.Errorf("Multisig(%s): expected it not to be a multisig script", asm)
+		}
+	}
+}
+
+func privateKey(secret int64) *ecc.PrivateKey {
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(secret))
+	return privateKey
+}
+
+func TestSignMultisigInput(t *testing.T) {
+	cosigners := []*ecc.PrivateKey{privateKey(1001), privateKey(1002), privateKey(1003)}
+	publicKeys := make([][]byte, len(cosigners))
+	for i, cosigner := range cosigners {
+		publicKeys[i] = cosigner.SECCompressed()
+	}
+
+	script, err := bitcoin.ToSortedMultisigScript(2, publicKeys)
+	if err != nil {
+		t.Fatalf("ToSortedMultisigScript: %v", err)
+	}
+	raw, _ := script.RawSerialize()
+
+	p2sh, _ := bitcoin.ToP2SHScript(hash.Hash160(raw))
+	p2wsh, _ := bitcoin.ToP2WSHScript(hash.HashSHA256(raw))
+	rawP2WSH, _ := p2wsh.RawSerialize()
+	p2shP2WSH, _ := bitcoin.ToP2SHScript(hash.Hash160(rawP2WSH))
+
+	tests := []struct {
+		name         string
+		scriptPubKey *bitcoin.Script
+	}{
+		{"bare", script},
+		{"pay-to-script-hash", p2sh},
+		{"pay-to-witness-script-hash", p2wsh},
+		{"nested pay-to-witness-script-hash", p2shP2WSH},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := newSpendingTx(t, bitcoin.NewScript([]op.Instruction{}), test.scriptPubKey, nil, 100_000)
+
+			// The cosigners sign independently, in any order
+			signatures := make(map[string][]byte)
+			for _, cosigner := range []*ecc.PrivateKey{cosigners[2], cosigners[0]} {
+				signature, err := tx.SignMultisigInput(0, cosigner, script, bitcoin.SigHashAll)
+				if err != nil {
+					t.Fatalf("SignMultisigInput: %v", err)
+				}
+				signatures[hex.EncodeToString(cosigner.SECCompressed())] = signature
+			}
+
+			valid, err := tx.FinalizeMultisigInput(0, script, signatures)
+			if err != nil || !valid {
+				t.Fatalf("FinalizeMultisigInput: got %v, %v, expected true, nil", valid, err)
+			}
+
+			// The dummy element is empty
+			stack := tx.Inputs[0].Witness
+			if test.scriptPubKey == script || test.scriptPubKey == p2sh {
+				if raw, _ := tx.Inputs[0].ScriptSig.RawSerialize(); len(raw) == 0 || raw[0] != 0x00 {
+					t.Errorf("FinalizeMultisigInput: got the scriptSig %x, expected it to start with OP_0", raw)
+				}
+			} else if len(stack) != 4 || len(stack[0]) != 0 {
+				t.Errorf("FinalizeMultisigInput: got the witness %x, expected 4 elements starting with an empty one", stack)
+			}
+		})
+	}
+}
+
+func TestFinalizeMultisigInputMissingSignature(t *testing.T) {
+	publicKeys := [][]byte{privateKey(1001).SECCompressed(), privateKey(1002).SECCompressed()}
+	script, _ := bitcoin.ToMultisigScript(2, publicKeys)
+	raw, _ := script.RawSerialize()
+	p2wsh, _ := bitcoin.ToP2WSHScript(hash.HashSHA256(raw))
+
+	tx := newSpendingTx(t, bitcoin.NewScript([]op.Instruction{}), p2wsh, nil, 100_000)
+	signature, err := tx.SignMultisigInput(0, privateKey(1001), script, bitcoin.SigHashAll)
+	if err != nil {
+		t.Fatalf("SignMultisigInput: %v", err)
+	}
+
+	if _, err := tx.FinalizeMultisigInput(0, script, map[string][]byte{hex.EncodeToString(publicKeys[0]): signature}); err == nil {
+		t.Errorf("FinalizeMultisigInput: expected an error for 1 of 2 signatures")
+	}
+
+	if _, err := tx.SignMultisigInput(0, privateKey(1003), script, bitcoin.SigHashAll); err == nil {
+		t.Errorf("SignMultisigInput: expected an error for a key that is not in the script")
+	}
+
+	other, _ := bitcoin.ToMultisigScript(1, publicKeys)
+	if _, err := tx.SignMultisigInput(0, privateKey(1001), other, bitcoin.SigHashAll); err == nil {
+		t.Errorf("SignMultisigInput: expected an error for a script that is not spent by the input")
+	}
+}
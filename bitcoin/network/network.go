@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 )
 
@@ -14,10 +15,11 @@ type NetworkMagic [4]byte
 
 // Known network magic values
 var (
-	Mainnet  NetworkMagic = [4]byte{0xf9, 0xbe, 0xb4, 0xd9}
-	Testnet3 NetworkMagic = [4]byte{0x0b, 0x11, 0x09, 0x07}
-	Regtest  NetworkMagic = [4]byte{0xfa, 0xbf, 0xb5, 0xda}
-	Signet   NetworkMagic = [4]byte{0x0a, 0x03, 0xcf, 0x40}
+	Mainnet  NetworkMagic = chaincfg.MainNetParams.Net
+	Testnet3 NetworkMagic = chaincfg.TestNet3Params.Net
+	Testnet4 NetworkMagic = chaincfg.TestNet4Params.Net
+	Regtest  NetworkMagic = chaincfg.RegressionNetParams.Net
+	Signet   NetworkMagic = chaincfg.SigNetParams.Net
 	Namecoin NetworkMagic = [4]byte{0xf9, 0xbe, 0xb4, 0xfe}
 )
 
@@ -32,13 +34,8 @@ type NetworkEnvelope struct {
 	payload []byte
 }
 
-func NewNetworkEnvelope(command []byte, payload []byte, isTestnet bool) *NetworkEnvelope {
-	var magic []byte
-	if isTestnet {
-		magic = Testnet3[:]
-	} else {
-		magic = Mainnet[:]
-	}
+func NewNetworkEnvelope(command []byte, payload []byte, params *chaincfg.Params) *NetworkEnvelope {
+	magic := params.Net
 
 	trimmedCommand, err := trimCommand(bytes.NewReader(command))
 	if err != nil {
@@ -46,7 +43,7 @@ func NewNetworkEnvelope(command []byte, payload []byte, isTestnet bool) *Network
 	}
 
 	return &NetworkEnvelope{
-		magic:   magic,
+		magic:   magic[:],
 		command: trimmedCommand,
 		payload: payload,
 	}
@@ -94,7 +91,7 @@ func ParseNetworkEnvelope(data io.Reader) (*NetworkEnvelope, error) {
 		return nil, err
 	}
 
-	if !isMainnet(magic) && !isTestnet(magic) {
+	if _, exists := chaincfg.ParamsForNet([4]byte(magic)); !exists {
 		return nil, fmt.Errorf("invalid magic: %x", magic)
 	}
 
@@ -138,7 +135,7 @@ func ParseNetworkEnvelope(data io.Reader) (*NetworkEnvelope, error) {
 	}
 
 	return &NetworkEnvelope{
-		magic:   magic,
+		magic:   magic[:],
 		command: command,
 		payload: payload,
 	}, nil
@@ -163,11 +160,3 @@ func (ne *NetworkEnvelope) Serialize() []byte {
 
 	return result
 }
-
-func isMainnet(magic []byte) bool {
-	return bytes.Equal(magic, Mainnet[:])
-}
-
-func isTestnet(magic []byte) bool {
-	return bytes.Equal(magic, Testnet3[:])
-}
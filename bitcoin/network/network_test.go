@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/network"
 )
 
@@ -12,7 +13,7 @@ func TestString(t *testing.T) {
 		command := []byte{0x76, 0x65, 0x72, 0x61, 0x63, 0x6b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 		payload := []byte{}
 
-		ne := network.NewNetworkEnvelope(command, payload, false)
+		ne := network.NewNetworkEnvelope(command, payload, &chaincfg.MainNetParams)
 		expected := "verack: "
 		actual := ne.String()
 
@@ -25,7 +26,7 @@ func TestString(t *testing.T) {
 		command := []byte("hello, world")
 		payload := []byte{0xDE, 0xAD, 0xBE, 0xEF}
 
-		ne := network.NewNetworkEnvelope(command, payload, false)
+		ne := network.NewNetworkEnvelope(command, payload, &chaincfg.MainNetParams)
 		expected := "hello, world: deadbeef"
 		actual := ne.String()
 
@@ -164,4 +165,25 @@ func TestSerializeNetworkEnvelope(t *testing.T) {
 			t.Errorf("expected %x but got %x", message, serialized)
 		}
 	})
+
+	t.Run("magic of the network", func(t *testing.T) {
+		command := []byte("verack")
+
+		for _, params := range chaincfg.Networks {
+			ne := network.NewNetworkEnvelope(command, []byte{}, params)
+			serialized := ne.Serialize()
+			if !bytes.Equal(serialized[:4], params.Net[:]) {
+				t.Errorf("%s: expected magic %x but got %x", params.Name, params.Net, serialized[:4])
+			}
+
+			parsed, err := network.ParseNetworkEnvelope(bytes.NewReader(serialized))
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", params.Name, err)
+				continue
+			}
+			if !bytes.Equal(parsed.Serialize(), serialized) {
+				t.Errorf("%s: expected %x but got %x", params.Name, serialized, parsed.Serialize())
+			}
+		}
+	})
 }
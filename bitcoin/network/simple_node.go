@@ -6,17 +6,18 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/network/message"
 )
 
 type SimpleNode struct {
 	address   net.Addr
-	isTestnet bool
+	params    *chaincfg.Params
 	isLogging bool
 }
 
-func NewSimpleNode(address net.Addr, isTestnet bool, isLogging bool) *SimpleNode {
-	return &SimpleNode{address, isTestnet, isLogging}
+func NewSimpleNode(address net.Addr, params *chaincfg.Params, isLogging bool) *SimpleNode {
+	return &SimpleNode{address, params, isLogging}
 }
 
 // Send a message to the connected node
@@ -26,7 +27,7 @@ func (n *SimpleNode) Send(msg message.Message) error {
 		return err
 	}
 
-	envelope := NewNetworkEnvelope(msg.Command(), serializedMessage, n.isTestnet)
+	envelope := NewNetworkEnvelope(msg.Command(), serializedMessage, n.params)
 
 	if n.isLogging {
 		fmt.Println("Sending:", envelope)
@@ -6,6 +6,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/network"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/network/message"
 )
@@ -38,7 +39,7 @@ func TestSimpleNode(t *testing.T) {
 	}()
 
 	t.Run("Send", func(t *testing.T) {
-		simpleNode := network.NewSimpleNode(nodeAddress, false, false)
+		simpleNode := network.NewSimpleNode(nodeAddress, &chaincfg.MainNetParams, false)
 		versionMessage := message.NewVersionMessage()
 
 		err := simpleNode.Send(versionMessage)
@@ -0,0 +1,192 @@
+package op
+
+// ErrorCode identifies the rule that made the evaluation of a script fail.
+type ErrorCode int
+
+const (
+	// A failure that is not covered by any of the other codes
+	ErrUnknown ErrorCode = iota
+	// The script finished with false on top of the stack
+	ErrEvalFalse
+	// OP_RETURN was executed
+	ErrOpReturn
+
+	// The script is larger than 10,000 bytes
+	ErrScriptSize
+	// A pushed element is larger than 520 bytes
+	ErrPushSize
+	// The script has more than 201 non-push op codes
+	ErrOpCount
+	// The stack and alt stack hold more than 1,000 elements together
+	ErrStackSize
+	// The signature count of OP_CHECKMULTISIG is out of range
+	ErrSigCount
+	// The public key count of OP_CHECKMULTISIG is out of range
+	ErrPubKeyCount
+
+	// OP_VERIFY failed
+	ErrVerify
+	// OP_EQUALVERIFY failed
+	ErrEqualVerify
+	// OP_CHECKMULTISIGVERIFY failed
+	ErrCheckMultiSigVerify
+	// OP_CHECKSIGVERIFY failed
+	ErrCheckSigVerify
+	// OP_NUMEQUALVERIFY failed
+	ErrNumEqualVerify
+
+	// An op code that is not defined was executed
+	ErrBadOpCode
+	// The script contains a disabled op code
+	ErrDisabledOpCode
+	// An op code needed more elements than the stack holds
+	ErrInvalidStackOperation
+	// OP_FROMALTSTACK was executed on an empty alt stack
+	ErrInvalidAltStackOperation
+	// An OP_ELSE or OP_ENDIF without OP_IF, or an OP_IF without OP_ENDIF
+	ErrUnbalancedConditional
+	// A number is larger than the op code accepts
+	ErrNumberOverflow
+	// A number is not minimally encoded, see VerifyMinimalData
+	ErrMinimalNumber
+
+	// The lock time of OP_CHECKLOCKTIMEVERIFY or OP_CHECKSEQUENCEVERIFY is negative
+	ErrNegativeLockTime
+	// The lock time of OP_CHECKLOCKTIMEVERIFY or OP_CHECKSEQUENCEVERIFY is not satisfied
+	ErrUnsatisfiedLockTime
+
+	// The hash type of a signature is not defined, see VerifyStrictEnc
+	ErrSigHashType
+	// A signature is not strictly DER encoded, see VerifyDERSig
+	ErrSigDER
+	// A push is not minimally encoded, see VerifyMinimalData
+	ErrMinimalData
+	// The scriptSig contains other op codes than pushes, see VerifySigPushOnly
+	ErrSigPushOnly
+	// The S value of a signature is too high, see VerifyLowS
+	ErrSigHighS
+	// The dummy element of OP_CHECKMULTISIG is not empty, see VerifyNullDummy
+	ErrSigNullDummy
+	// A public key is neither compressed nor uncompressed, see VerifyStrictEnc
+	ErrPubKeyType
+	// More than one element is left on the stack, see VerifyCleanStack
+	ErrCleanStack
+	// A failing signature check had a non-empty signature, see VerifyNullFail
+	ErrNullFail
+	// An upgradable NOP was executed, see VerifyDiscourageUpgradableNops
+	ErrDiscourageUpgradableNops
+	// The argument of OP_IF or OP_NOTIF in a version 0 witness script is not
+	// minimal, see VerifyMinimalIf
+	ErrMinimalIf
+	// A public key of a version 0 witness script is not compressed, see
+	// VerifyWitnessPubKeyType
+	ErrWitnessPubKeyType
+	// A witness program of a version reserved for soft forks was spent, see
+	// VerifyDiscourageUpgradableWitnessProgram
+	ErrDiscourageUpgradableWitnessProgram
+
+	// A version 0 witness program is neither 20 nor 32 bytes
+	ErrWitnessProgramWrongLength
+	// The witness of a pay-to-witness-script-hash output is empty
+	ErrWitnessProgramWitnessEmpty
+	// The witness does not match the witness program
+	ErrWitnessProgramMismatch
+	// The scriptSig of a native witness program is not empty
+	ErrWitnessMalleated
+	// The scriptSig of a nested witness program is not a single push of it
+	ErrWitnessMalleatedP2SH
+	// An input that does not spend a witness program has a witness
+	ErrWitnessUnexpected
+
+	// A Schnorr signature is neither 64 nor 65 bytes
+	ErrSchnorrSigSize
+	// The hash type of a Schnorr signature is not defined
+	ErrSchnorrSigHashType
+	// A Schnorr signature that is not empty failed
+	ErrSchnorrSig
+	// The control block of a script path spend has an invalid size
+	ErrTaprootWrongControlSize
+	// The signatures of a tapscript use more than its validation weight
+	ErrTapscriptValidationWeight
+	// OP_CHECKMULTISIG or OP_CHECKMULTISIGVERIFY was executed in a tapscript
+	ErrTapscriptCheckMultiSig
+	// The argument of OP_IF or OP_NOTIF in a tapscript is not minimal
+	ErrTapscriptMinimalIf
+)
+
+// The names of the error codes, as used by Bitcoin Core.
+var errorCodeNames = map[ErrorCode]string{
+	ErrUnknown:                    "UNKNOWN_ERROR",
+	ErrEvalFalse:                  "EVAL_FALSE",
+	ErrOpReturn:                   "OP_RETURN",
+	ErrScriptSize:                 "SCRIPT_SIZE",
+	ErrPushSize:                   "PUSH_SIZE",
+	ErrOpCount:                    "OP_COUNT",
+	ErrStackSize:                  "STACK_SIZE",
+	ErrSigCount:                   "SIG_COUNT",
+	ErrPubKeyCount:                "PUBKEY_COUNT",
+	ErrVerify:                     "VERIFY",
+	ErrEqualVerify:                "EQUALVERIFY",
+	ErrCheckMultiSigVerify:        "CHECKMULTISIGVERIFY",
+	ErrCheckSigVerify:             "CHECKSIGVERIFY",
+	ErrNumEqualVerify:             "NUMEQUALVERIFY",
+	ErrBadOpCode:                  "BAD_OPCODE",
+	ErrDisabledOpCode:             "DISABLED_OPCODE",
+	ErrInvalidStackOperation:      "INVALID_STACK_OPERATION",
+	ErrInvalidAltStackOperation:   "INVALID_ALTSTACK_OPERATION",
+	ErrUnbalancedConditional:      "UNBALANCED_CONDITIONAL",
+	ErrNumberOverflow:             "SCRIPTNUM_OVERFLOW",
+	ErrMinimalNumber:              "SCRIPTNUM_MINIMALDATA",
+	ErrNegativeLockTime:           "NEGATIVE_LOCKTIME",
+	ErrUnsatisfiedLockTime:        "UNSATISFIED_LOCKTIME",
+	ErrSigHashType:                "SIG_HASHTYPE",
+	ErrSigDER:                     "SIG_DER",
+	ErrMinimalData:                "MINIMALDATA",
+	ErrSigPushOnly:                "SIG_PUSHONLY",
+	ErrSigHighS:                   "SIG_HIGH_S",
+	ErrSigNullDummy:               "SIG_NULLDUMMY",
+	ErrPubKeyType:                 "PUBKEYTYPE",
+	ErrCleanStack:                 "CLEANSTACK",
+	ErrNullFail:                   "NULLFAIL",
+	ErrDiscourageUpgradableNops:   "DISCOURAGE_UPGRADABLE_NOPS",
+	ErrWitnessProgramWrongLength:  "WITNESS_PROGRAM_WRONG_LENGTH",
+	ErrWitnessProgramWitnessEmpty: "WITNESS_PROGRAM_WITNESS_EMPTY",
+	ErrWitnessProgramMismatch:     "WITNESS_PROGRAM_MISMATCH",
+	ErrWitnessMalleated:           "WITNESS_MALLEATED",
+	ErrWitnessMalleatedP2SH:       "WITNESS_MALLEATED_P2SH",
+	ErrWitnessUnexpected:          "WITNESS_UNEXPECTED",
+	ErrSchnorrSigSize:             "SCHNORR_SIG_SIZE",
+	ErrSchnorrSigHashType:         "SCHNORR_SIG_HASHTYPE",
+	ErrSchnorrSig:                 "SCHNORR_SIG",
+	ErrTaprootWrongControlSize:    "TAPROOT_WRONG_CONTROL_SIZE",
+	ErrTapscriptValidationWeight:  "TAPSCRIPT_VALIDATION_WEIGHT",
+	ErrTapscriptCheckMultiSig:     "TAPSCRIPT_CHECKMULTISIG",
+	ErrTapscriptMinimalIf:         "TAPSCRIPT_MINIMALIF",
+
+	ErrMinimalIf:                          "MINIMALIF",
+	ErrWitnessPubKeyType:                  "WITNESS_PUBKEYTYPE",
+	ErrDiscourageUpgradableWitnessProgram: "DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM",
+}
+
+func (c ErrorCode) String() string {
+	name, exists := errorCodeNames[c]
+	if !exists {
+		return "UNKNOWN_ERROR"
+	}
+
+	return name
+}
+
+// Error is returned by the op codes, telling which rule failed.
+type Error struct {
+	Code        ErrorCode
+	Description string
+}
+
+func (e *Error) Error() string {
+	return e.Description
+}
+
+func newError(code ErrorCode, description string) *Error {
+	return &Error{code, description}
+}
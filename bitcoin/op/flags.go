@@ -0,0 +1,92 @@
+package op
+
+// VerifyFlags selects the rules, on top of the basic script rules, that are
+// enforced when a script is evaluated.
+type VerifyFlags uint32
+
+// Only the basic script rules are enforced.
+const VerifyNone VerifyFlags = 0
+
+const (
+	// Evaluate the redeem script of pay-to-script-hash outputs, see BIP 16.
+	VerifyP2SH VerifyFlags = 1 << iota
+
+	// Signatures must have a defined hash type and public keys must be
+	// compressed or uncompressed SEC encodings.
+	VerifyStrictEnc
+
+	// Signatures must be strictly DER encoded, see BIP 66.
+	VerifyDERSig
+
+	// Signatures must have an S value of at most half the curve order,
+	// see BIP 146.
+	VerifyLowS
+
+	// The dummy element of OP_CHECKMULTISIG must be empty, see BIP 147.
+	VerifyNullDummy
+
+	// The scriptSig may only contain pushes.
+	VerifySigPushOnly
+
+	// Pushes and numbers must use the shortest possible encoding.
+	VerifyMinimalData
+
+	// Executing OP_NOP1 and OP_NOP4 to OP_NOP10 fails.
+	VerifyDiscourageUpgradableNops
+
+	// Exactly one element must be left on the stack after evaluation.
+	VerifyCleanStack
+
+	// Enforce OP_CHECKLOCKTIMEVERIFY instead of treating it as OP_NOP2,
+	// see BIP 65.
+	VerifyCheckLockTimeVerify
+
+	// Enforce OP_CHECKSEQUENCEVERIFY instead of treating it as OP_NOP3,
+	// see BIP 112.
+	VerifyCheckSequenceVerify
+
+	// A failing signature check must have an empty signature, see BIP 146.
+	VerifyNullFail
+
+	// Evaluate the witness of segwit outputs, see BIP 141. Requires
+	// VerifyP2SH.
+	VerifyWitness
+
+	// Evaluate the witness of segwit version 1 outputs with the taproot and
+	// tapscript rules, see BIP 341 and BIP 342. Requires VerifyWitness.
+	VerifyTaproot
+
+	// The argument of OP_IF and OP_NOTIF in a version 0 witness script must
+	// be empty or exactly 0x01.
+	VerifyMinimalIf
+
+	// The public keys of version 0 witness scripts must be compressed.
+	VerifyWitnessPubKeyType
+
+	// Spending witness programs of the versions reserved for soft forks
+	// fails.
+	VerifyDiscourageUpgradableWitnessProgram
+)
+
+// The flags of the soft forks that are enforced by consensus.
+const MandatoryVerifyFlags = VerifyP2SH |
+	VerifyDERSig |
+	VerifyNullDummy |
+	VerifyCheckLockTimeVerify |
+	VerifyCheckSequenceVerify |
+	VerifyWitness |
+	VerifyTaproot
+
+// The flags that a transaction must satisfy to be relayed by Bitcoin Core.
+const StandardVerifyFlags = MandatoryVerifyFlags |
+	VerifyStrictEnc |
+	VerifyLowS |
+	VerifyMinimalData |
+	VerifyDiscourageUpgradableNops |
+	VerifyCleanStack |
+	VerifyNullFail
+
+// Returns whether all of the given flags are set.
+func (f VerifyFlags) Has(flags VerifyFlags) bool {
+	return f&flags == flags
+}
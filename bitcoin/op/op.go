@@ -2,10 +2,7 @@ package op
 
 import (
 	"bytes"
-	"fmt"
-	"math/big"
 
-	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 )
 
@@ -231,58 +228,73 @@ func NOP(stack *Stack) (*Stack, error) {
 }
 
 // If the top stack value is not False, the statements are executed. The top stack value is removed.
-func IF(stack *Stack, instructions []Instruction) (*Stack, *[]Instruction, error) {
-	if stack.Size() < 1 {
-		return nil, nil, fmt.Errorf("stack too small")
-	}
-
-	founded := false
-	numberOfEndIfsNeeded := 1
-	trueItems := make([]Instruction, 0)
-	falseItems := make([]Instruction, 0)
-	current := make([]Instruction, 0)
-
-	for _, item := range instructions {
-		if bytes.Equal(item.instruction, []byte{0x63}) || bytes.Equal(item.instruction, []byte{0x64}) {
-			numberOfEndIfsNeeded++
-			current = append(current, item)
-		} else if numberOfEndIfsNeeded == 1 || numberOfEndIfsNeeded == 103 {
-			current = falseItems
-		} else if bytes.Equal(item.instruction, []byte{0x68}) {
-			if numberOfEndIfsNeeded == 1 {
-				founded = true
-				break
-			} else {
-				numberOfEndIfsNeeded--
-				current = append(current, item)
-			}
-		} else {
-			current = append(current, item)
+func IF(stack *Stack) (*Stack, error) {
+	return conditional(stack, false)
+}
+
+// If the top stack value is False, the statements are executed. The top stack value is removed.
+func NOTIF(stack *Stack) (*Stack, error) {
+	return conditional(stack, true)
+}
+
+func conditional(stack *Stack, isNotIf bool) (*Stack, error) {
+	execute := false
+
+	// Branches inside a branch that is not executed are never executed,
+	// and nothing is popped from the stack.
+	if stack.IsExecuting() {
+		if stack.Size() < 1 {
+			return nil, newError(ErrUnbalancedConditional, "stack too small")
 		}
-	}
 
-	if !founded {
-		return nil, nil, fmt.Errorf("missing OP_ENDIF")
+		instruction, err := stack.Pop()
+		if err != nil {
+			return nil, err
+		}
+
+		execute = instruction.IsTrue() != isNotIf
 	}
 
-	instruction, err := stack.Pop()
-	if err != nil {
-		return nil, nil, err
+	stack.conditions = append(stack.conditions, execute)
+
+	return stack, nil
+}
+
+// If the preceding OP_IF or OP_NOTIF or OP_ELSE was not executed then these statements are
+// and if the preceding OP_IF or OP_NOTIF or OP_ELSE was executed then these statements are not.
+func ELSE(stack *Stack) (*Stack, error) {
+	if stack.IsBalanced() {
+		return nil, newError(ErrUnbalancedConditional, "unbalanced conditional")
 	}
 
-	if bytes.Equal(instruction.instruction, []byte{0x00}) {
-		instructions = append(falseItems, instructions...)
-	} else {
-		instructions = append(trueItems, instructions...)
+	last := len(stack.conditions) - 1
+	stack.conditions[last] = !stack.conditions[last]
+
+	return stack, nil
+}
+
+// Ends an if/else block. All blocks must end, or the transaction is invalid. An OP_ENDIF
+// without OP_IF earlier is also invalid.
+func ENDIF(stack *Stack) (*Stack, error) {
+	if stack.IsBalanced() {
+		return nil, newError(ErrUnbalancedConditional, "unbalanced conditional")
 	}
 
-	return stack, &instructions, nil
+	stack.conditions = stack.conditions[:len(stack.conditions)-1]
+
+	return stack, nil
 }
 
 // Marks transaction as invalid if top stack value is not true. The top stack value is removed.
 func VERIFY(stack *Stack) (*Stack, error) {
+	return verify(stack, ErrVerify)
+}
+
+// Runs OP_VERIFY, failing with code so that the op codes ending with VERIFY
+// can be told apart.
+func verify(stack *Stack, code ErrorCode) (*Stack, error) {
 	if stack.Size() < 1 {
-		return nil, fmt.Errorf("transaction invalid") // Should stack be included in the return?
+		return nil, newError(ErrInvalidStackOperation, "transaction invalid") // Should stack be included in the return?
 	}
 
 	instruction, err := stack.Pop()
@@ -290,8 +302,8 @@ func VERIFY(stack *Stack) (*Stack, error) {
 		return nil, err
 	}
 
-	if bytes.Equal(instruction.instruction, []byte{0x00}) {
-		return nil, fmt.Errorf("transaction invalid") // Should stack be included in the return?
+	if !instruction.IsTrue() {
+		return nil, newError(code, "transaction invalid") // Should stack be included in the return?
 	}
 
 	return stack, nil
@@ -304,27 +316,29 @@ func VERIFY(stack *Stack) (*Stack, error) {
 // with OP_RETURN, that contains any sequence of push statements (or OP_RESERVED[1]) after the
 // OP_RETURN provided the total scriptPubKey length is at most 83 bytes.
 func RETURN(stack *Stack) (*Stack, error) {
-	return stack, fmt.Errorf("transaction invalid")
+	return stack, newError(ErrOpReturn, "transaction invalid")
 }
 
-// Duplicates the top two stack items.
-func OP2DUP(stack *Stack) (*Stack, error) {
-	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	element1, err := stack.Peek()
+// Puts the input onto the top of the alt stack. Removes it from the main stack.
+func TOALTSTACK(stack *Stack) (*Stack, error) {
+	instruction, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	element2, err := stack.PeekN(1)
+	stack.PushAlt(instruction)
+
+	return stack, nil
+}
+
+// Puts the input onto the top of the main stack. Removes it from the alt stack.
+func FROMALTSTACK(stack *Stack) (*Stack, error) {
+	instruction, err := stack.PopAlt()
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(element2)
-	stack.Push(element1)
+	stack.Push(instruction)
 
 	return stack, nil
 }
@@ -332,7 +346,7 @@ func OP2DUP(stack *Stack) (*Stack, error) {
 // Removes the top two stack items.
 func OP2DROP(stack *Stack) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
 	_, err := stack.Pop()
@@ -348,6 +362,100 @@ func OP2DROP(stack *Stack) (*Stack, error) {
 	return stack, nil
 }
 
+// Duplicates the top two stack items.
+func OP2DUP(stack *Stack) (*Stack, error) {
+	if stack.Size() < 2 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	element1, err := stack.Peek()
+	if err != nil {
+		return nil, err
+	}
+
+	element2, err := stack.PeekN(1)
+	if err != nil {
+		return nil, err
+	}
+
+	stack.Push(element2)
+	stack.Push(element1)
+
+	return stack, nil
+}
+
+// Duplicates the top three stack items.
+func OP3DUP(stack *Stack) (*Stack, error) {
+	if stack.Size() < 3 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	for i := 0; i < 3; i++ {
+		element, err := stack.PeekN(2)
+		if err != nil {
+			return nil, err
+		}
+
+		stack.Push(element)
+	}
+
+	return stack, nil
+}
+
+// Copies the pair of items two spaces back in the stack to the front.
+func OP2OVER(stack *Stack) (*Stack, error) {
+	if stack.Size() < 4 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	for i := 0; i < 2; i++ {
+		element, err := stack.PeekN(3)
+		if err != nil {
+			return nil, err
+		}
+
+		stack.Push(element)
+	}
+
+	return stack, nil
+}
+
+// The fifth and sixth items back are moved to the top of the stack.
+func OP2ROT(stack *Stack) (*Stack, error) {
+	if stack.Size() < 6 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	for i := 0; i < 2; i++ {
+		element, err := stack.RemoveN(5)
+		if err != nil {
+			return nil, err
+		}
+
+		stack.Push(element)
+	}
+
+	return stack, nil
+}
+
+// Swaps the top two pairs of items.
+func OP2SWAP(stack *Stack) (*Stack, error) {
+	if stack.Size() < 4 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	for i := 0; i < 2; i++ {
+		element, err := stack.RemoveN(3)
+		if err != nil {
+			return nil, err
+		}
+
+		stack.Push(element)
+	}
+
+	return stack, nil
+}
+
 // If the top stack value is not 0, duplicate it.
 func IFDUP(stack *Stack) (*Stack, error) {
 	instruction, err := stack.Peek()
@@ -355,7 +463,7 @@ func IFDUP(stack *Stack) (*Stack, error) {
 		return nil, err
 	}
 
-	if instruction.IsZero() {
+	if !instruction.IsTrue() {
 		return stack, nil
 	}
 
@@ -366,7 +474,7 @@ func IFDUP(stack *Stack) (*Stack, error) {
 
 // Puts the number of stack items onto the stack.
 func DEPTH(stack *Stack) (*Stack, error) {
-	size := []byte{byte(stack.Size())}
+	size := EncodeNum(int64(stack.Size()))
 	instruction, err := NewInstruction(size)
 	if err != nil {
 		return nil, err
@@ -399,231 +507,173 @@ func DUP(stack *Stack) (*Stack, error) {
 	return stack, nil
 }
 
-// The item n back in the stack is copied to the top.
-func PICK(stack *Stack) (*Stack, error) {
-	if stack.Size() < 1 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	data, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	index := new(big.Int).Add(new(big.Int).SetInt64(1), new(big.Int).SetBytes(data.instruction))
-	if index.Cmp(big.NewInt(int64(stack.Size()))) > 0 {
-		return nil, fmt.Errorf("stack too small")
+// Removes the second-to-top stack item.
+func NIP(stack *Stack) (*Stack, error) {
+	if stack.Size() < 2 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	element, err := stack.PeekN(int(index.Int64()) - 1)
+	_, err := stack.RemoveN(1)
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(element)
-
 	return stack, nil
 }
 
-// The top two items on the stack are swapped.
-func SWAP(stack *Stack) (*Stack, error) {
+// Copies the second-to-top stack item to the top.
+func OVER(stack *Stack) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	element1, err := stack.Pop()
-	if err != nil {
-		return nil, err
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	element2, err := stack.Pop()
+	element, err := stack.PeekN(1)
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(element1)
-	stack.Push(element2)
+	stack.Push(element)
 
 	return stack, nil
 }
 
-// Pushes the string length of the top element of the stack (without popping it).
-func SIZE(stack *Stack) (*Stack, error) {
+// The item n back in the stack is copied to the top.
+func PICK(stack *Stack) (*Stack, error) {
 	if stack.Size() < 1 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	instruction, err := stack.Peek()
+	n, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	size := len(instruction.instruction)
-	data, err := NewInstruction([]byte{byte(size)})
+	if n < 0 || n >= int64(stack.Size()) {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	element, err := stack.PeekN(int(n))
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(data)
+	stack.Push(element)
 
 	return stack, nil
 }
 
-// Returns 1 if the inputs are exactly equal, 0 otherwise.
-func EQUAL(stack *Stack) (*Stack, error) {
-	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	element1, err := stack.Pop()
-	if err != nil {
-		return nil, err
+// The item n back in the stack is moved to the top.
+func ROLL(stack *Stack) (*Stack, error) {
+	if stack.Size() < 1 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	element2, err := stack.Pop()
+	n, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	var data []byte
-	if bytes.Equal(element1.instruction, element2.instruction) {
-		data = []byte{0x01}
-	} else {
-		data = []byte{0x00}
+	if n < 0 || n >= int64(stack.Size()) {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	NewInstruction, err := NewInstruction(data)
+	element, err := stack.RemoveN(int(n))
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(NewInstruction)
+	stack.Push(element)
 
 	return stack, nil
 }
 
-// If the input is 0 or 1, it is flipped. Otherwise the output will be 0.
-func NOT(stack *Stack) (*Stack, error) {
-	instruction, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	value := new(big.Int).SetBytes(instruction.instruction)
-
-	var data []byte
-	if value.Cmp(big.NewInt(0)) == 0 {
-		data = []byte{0x01}
-	} else {
-		data = []byte{0x00}
+// The 3rd item down the stack is moved to the top.
+func ROT(stack *Stack) (*Stack, error) {
+	if stack.Size() < 3 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	NewInstruction, err := NewInstruction(data)
+	element, err := stack.RemoveN(2)
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(NewInstruction)
+	stack.Push(element)
 
 	return stack, nil
 }
 
-// a is added to b.
-func ADD(stack *Stack) (*Stack, error) {
+// The top two items on the stack are swapped.
+func SWAP(stack *Stack) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	b, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	a, err := stack.Pop()
+	element1, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	bInt := new(big.Int).SetBytes(b.instruction)
-	aInt := new(big.Int).SetBytes(a.instruction)
-
-	sum := new(big.Int).Add(aInt, bInt)
-	sumElement, err := NewInstruction(sum.Bytes())
+	element2, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(sumElement)
+	stack.Push(element1)
+	stack.Push(element2)
 
 	return stack, nil
 }
 
-// a is multiplied by b. disabled.
-func MUL(stack *Stack) (*Stack, error) {
+// The item at the top of the stack is copied and inserted before the second-to-top item.
+func TUCK(stack *Stack) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	b, err := stack.Pop()
-	if err != nil {
-		return nil, err
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	a, err := stack.Pop()
+	element1, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	bInt := new(big.Int).SetBytes(b.instruction)
-	aInt := new(big.Int).SetBytes(a.instruction)
-
-	product := new(big.Int).Mul(aInt, bInt)
-	productElement, err := NewInstruction(product.Bytes())
+	element2, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(productElement)
+	stack.Push(element1)
+	stack.Push(element2)
+	stack.Push(element1)
 
 	return stack, nil
 }
 
-// Returns 1 if a is greater than or equal to b, 0 otherwise.
-func GREATERTHANOREQUAL(stack *Stack) (*Stack, error) {
-	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
+// Pushes the string length of the top element of the stack (without popping it).
+func SIZE(stack *Stack) (*Stack, error) {
+	if stack.Size() < 1 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	element1, err := stack.Pop()
+	instruction, err := stack.Peek()
 	if err != nil {
 		return nil, err
 	}
-	element2, err := stack.Pop()
+
+	size := len(instruction.instruction)
+	data, err := NewInstruction(EncodeNum(int64(size)))
 	if err != nil {
 		return nil, err
 	}
 
-	if element2.Int64() >= element1.Int64() {
-		one, err := NewInstruction([]byte{0x01})
-		if err != nil {
-			return nil, err
-		}
-		stack.Push(one)
-	} else {
-		zero, err := NewInstruction([]byte{0x00})
-		if err != nil {
-			return nil, err
-		}
-		stack.Push(zero)
-	}
+	stack.Push(data)
 
 	return stack, nil
 }
 
-// Returns the smaller of a and b.
-func MIN(stack *Stack) (*Stack, error) {
+// Returns 1 if the inputs are exactly equal, 0 otherwise.
+func EQUAL(stack *Stack) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
 	element1, err := stack.Pop()
@@ -635,125 +685,186 @@ func MIN(stack *Stack) (*Stack, error) {
 	if err != nil {
 		return nil, err
 	}
-	if element1.Int64() < element2.Int64() {
-		stack.Push(element1)
-	} else {
-		stack.Push(element2)
-	}
+
+	pushBool(stack, bytes.Equal(element1.instruction, element2.instruction))
 
 	return stack, nil
 }
 
-// Returns the larger of a and b.
-func MAX(stack *Stack) (*Stack, error) {
-	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
-	}
-
-	element1, err := stack.Pop()
+// Same as OP_EQUAL, but runs OP_VERIFY afterward.
+func EQUALVERIFY(stack *Stack) (*Stack, error) {
+	stack, err := EQUAL(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	element2, err := stack.Pop()
+	return verify(stack, ErrEqualVerify)
+}
+
+// 1 is added to the input.
+func OP1ADD(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 { return a + 1 })
+}
+
+// 1 is subtracted from the input.
+func OP1SUB(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 { return a - 1 })
+}
+
+// The sign of the input is flipped.
+func NEGATE(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 { return -a })
+}
+
+// The input is made positive.
+func ABS(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 {
+		if a < 0 {
+			return -a
+		}
+		return a
+	})
+}
+
+// If the input is 0 or 1, it is flipped. Otherwise the output will be 0.
+func NOT(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 { return boolToNumber(a == 0) })
+}
+
+// Returns 0 if the input is 0. 1 otherwise.
+func OP0NOTEQUAL(stack *Stack) (*Stack, error) {
+	return unaryNumber(stack, func(a int64) int64 { return boolToNumber(a != 0) })
+}
+
+// a is added to b.
+func ADD(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return a + b })
+}
+
+// b is subtracted from a.
+func SUB(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return a - b })
+}
+
+// a is multiplied by b. disabled.
+func MUL(stack *Stack) (*Stack, error) {
+	return nil, newError(ErrDisabledOpCode, "disabled opcode")
+}
+
+// If both a and b are not 0, the output is 1. Otherwise 0.
+func BOOLAND(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a != 0 && b != 0) })
+}
+
+// If a or b is not 0, the output is 1. Otherwise 0.
+func BOOLOR(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a != 0 || b != 0) })
+}
+
+// Returns 1 if the numbers are equal, 0 otherwise.
+func NUMEQUAL(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a == b) })
+}
+
+// Same as OP_NUMEQUAL, but runs OP_VERIFY afterward.
+func NUMEQUALVERIFY(stack *Stack) (*Stack, error) {
+	stack, err := NUMEQUAL(stack)
 	if err != nil {
 		return nil, err
 	}
-	if element1.Int64() > element2.Int64() {
-		stack.Push(element1)
-	} else {
-		stack.Push(element2)
-	}
 
-	return stack, nil
+	return verify(stack, ErrNumEqualVerify)
+}
+
+// Returns 1 if the numbers are not equal, 0 otherwise.
+func NUMNOTEQUAL(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a != b) })
+}
+
+// Returns 1 if a is less than b, 0 otherwise.
+func LESSTHAN(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a < b) })
+}
+
+// Returns 1 if a is greater than b, 0 otherwise.
+func GREATERTHAN(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a > b) })
+}
+
+// Returns 1 if a is less than or equal to b, 0 otherwise.
+func LESSTHANOREQUAL(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a <= b) })
+}
+
+// Returns 1 if a is greater than or equal to b, 0 otherwise.
+func GREATERTHANOREQUAL(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return boolToNumber(a >= b) })
+}
+
+// Returns the smaller of a and b.
+func MIN(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return min(a, b) })
+}
+
+// Returns the larger of a and b.
+func MAX(stack *Stack) (*Stack, error) {
+	return binaryNumber(stack, func(a, b int64) int64 { return max(a, b) })
 }
 
 // Returns 1 if x is within the specified range (left-inclusive), 0 otherwise.
 func WITHIN(stack *Stack) (*Stack, error) {
 	if stack.Size() < 3 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	max, err := stack.Pop()
+	max, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	min, err := stack.Pop()
+	min, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	x, err := stack.Pop()
+	x, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	if x.Int64() >= min.Int64() && x.Int64() < max.Int64() {
-		one, err := NewInstruction([]byte{0x01})
-		if err != nil {
-			return nil, err
-		}
-		stack.Push(one)
-	} else {
-		zero, err := NewInstruction([]byte{0x00})
-		if err != nil {
-			return nil, err
-		}
-		stack.Push(zero)
-	}
+	pushBool(stack, x >= min && x < max)
 
 	return stack, nil
 }
 
+// The input is hashed using RIPEMD-160.
+func RIPEMD160(stack *Stack) (*Stack, error) {
+	return hashTop(stack, hash.HashRIPEMD160)
+}
+
 // The input is hashed using SHA-1.
 func SHA1(stack *Stack) (*Stack, error) {
-	instruction, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	hashed := hash.HashSHA1(instruction.instruction)
-	hashedElement, err := NewInstruction(hashed)
-	if err != nil {
-		return nil, err
-	}
-	stack.Push(hashedElement)
+	return hashTop(stack, hash.HashSHA1)
+}
 
-	return stack, nil
+// The input is hashed using SHA-256.
+func SHA256(stack *Stack) (*Stack, error) {
+	return hashTop(stack, hash.HashSHA256)
 }
 
 // The input is hashed twice: first with SHA-256 and then with RIPEMD-160.
 func HASH160(stack *Stack) (*Stack, error) {
-	instruction, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	hashed := hash.Hash160(instruction.instruction)
-	hashedElement, err := NewInstruction(hashed)
-	if err != nil {
-		return nil, err
-	}
-	stack.Push(hashedElement)
-
-	return stack, nil
+	return hashTop(stack, hash.Hash160)
 }
 
 // The input is hashed two times with SHA-256.
 func HASH256(stack *Stack) (*Stack, error) {
-	instruction, err := stack.Pop()
-	if err != nil {
-		return nil, err
-	}
-
-	hashed := hash.Hash256(instruction.instruction)
-	hashedElement, err := NewInstruction(hashed)
-	if err != nil {
-		return nil, err
-	}
-	stack.Push(hashedElement)
+	return hashTop(stack, hash.Hash256)
+}
 
+// All of the signature checking words will only match signatures to the data
+// after the most recently-executed OP_CODESEPARATOR.
+func CODESEPARATOR(stack *Stack) (*Stack, error) {
 	return stack, nil
 }
 
@@ -761,9 +872,11 @@ func HASH256(stack *Stack) (*Stack, error) {
 // most recently-executed OP_CODESEPARATOR to the end) are hashed.
 // The signature used by OP_CHECKSIG must be a valid signature for
 // this hash and public key. If it is, 1 is returned, 0 otherwise.
-func CHECKSIG(stack *Stack, z *big.Int) (*Stack, error) {
+// The hash is given by sigHash for the hash type in the last byte of
+// the signature.
+func CHECKSIG(stack *Stack, sigHash SigHashFunc) (*Stack, error) {
 	if stack.Size() < 2 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
 	secPubKey, err := stack.Pop()
@@ -776,36 +889,29 @@ func CHECKSIG(stack *Stack, z *big.Int) (*Stack, error) {
 		return nil, err
 	}
 
-	point, err := ecc.Parse(secPubKey.instruction)
+	signatures := [][]byte{derSignature.instruction}
+	valid, err := checkSignature(stack.flags, derSignature.instruction, secPubKey.instruction, sigHash, signatures)
 	if err != nil {
 		return nil, err
 	}
 
-	signature, err := ecc.ParseDER(derSignature.instruction[:len(derSignature.instruction)-1])
-	if err != nil {
-		return nil, err
+	if !valid && stack.flags.Has(VerifyNullFail) && derSignature.Length() > 0 {
+		return nil, newError(ErrNullFail, "signature must be empty when the check fails")
 	}
 
-	valid, err := point.Verify(z, signature)
-	if err != nil {
-		return nil, err
-	}
+	pushBool(stack, valid)
 
-	var data []byte
-	if valid {
-		data = []byte{0x01}
-	} else {
-		data = []byte{0x00}
-	}
+	return stack, nil
+}
 
-	NewInstruction, err := NewInstruction(data)
+// Same as OP_CHECKSIG, but OP_VERIFY is executed afterward.
+func CHECKSIGVERIFY(stack *Stack, sigHash SigHashFunc) (*Stack, error) {
+	stack, err := CHECKSIG(stack, sigHash)
 	if err != nil {
 		return nil, err
 	}
 
-	stack.Push(NewInstruction)
-
-	return stack, nil
+	return verify(stack, ErrCheckSigVerify)
 }
 
 // Compares the first signature against each public key until it
@@ -820,40 +926,51 @@ func CHECKSIG(stack *Stack, z *big.Int) (*Stack, error) {
 // corresponding public keys were placed in the scriptPubKey or
 // redeemScript. If all signatures are valid, 1 is returned, 0
 // otherwise. Due to a bug, one extra unused value is removed from
-// the stack.
-func CHECKMULTISIG(stack *Stack, z *big.Int) (*Stack, error) {
+// the stack, which must be an empty array (BIP 147).
+func CHECKMULTISIG(stack *Stack, sigHash SigHashFunc) (*Stack, error) {
 	if stack.Size() < 1 {
-		return nil, fmt.Errorf("stack too small")
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
 	}
 
-	n, err := stack.Pop()
+	n, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	if int64(stack.Size()) < (n.Int64() + 1) {
-		return nil, fmt.Errorf("stack too small")
+	if n < 0 || n > MaxPubKeysPerMultisig {
+		return nil, newError(ErrPubKeyCount, "pubkey count out of range")
 	}
 
-	secPubKey := make([]Instruction, n.Int64())
+	if int64(stack.Size()) < n+1 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
 
-	for i := 0; i < int(n.Int64()); i++ {
+	secPubKeys := make([]Instruction, n)
+	for i := range secPubKeys {
 		instruction, err := stack.Pop()
 		if err != nil {
 			return nil, err
 		}
 
-		secPubKey[i] = *instruction
+		secPubKeys[i] = *instruction
 	}
 
-	m, err := stack.Pop()
+	m, err := popNumber(stack)
 	if err != nil {
 		return nil, err
 	}
 
-	derSignatures := make([]Instruction, m.Int64())
+	if m < 0 || m > n {
+		return nil, newError(ErrSigCount, "signature count out of range")
+	}
+
+	// The signatures and the dummy element
+	if int64(stack.Size()) < m+1 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
 
-	for i := 0; i < int(m.Int64()); i++ {
+	derSignatures := make([]Instruction, m)
+	for i := range derSignatures {
 		instruction, err := stack.Pop()
 		if err != nil {
 			return nil, err
@@ -862,110 +979,493 @@ func CHECKMULTISIG(stack *Stack, z *big.Int) (*Stack, error) {
 		derSignatures[i] = *instruction
 	}
 
-	_, err = stack.Pop()
+	dummy, err := stack.Pop()
 	if err != nil {
 		return nil, err
 	}
 
-	points := make([]*ecc.S256Point, len(secPubKey))
-	for index, sec := range secPubKey {
-		point, err := ecc.Parse(sec.Bytes())
+	// All signatures are removed from the signed script before any is checked
+	signatures := make([][]byte, len(derSignatures))
+	for i, derSignature := range derSignatures {
+		signatures[i] = derSignature.instruction
+	}
+
+	// The keys and signatures are checked from the top of the stack, which
+	// is the last key and signature of the script, as the order of the
+	// checks decides which encoding errors are found.
+	sigIndex := 0
+	keyIndex := 0
+	for sigIndex < len(derSignatures) && len(derSignatures)-sigIndex <= len(secPubKeys)-keyIndex {
+		valid, err := checkSignature(stack.flags, derSignatures[sigIndex].instruction, secPubKeys[keyIndex].instruction, sigHash, signatures)
 		if err != nil {
 			return nil, err
 		}
 
-		points[index] = point
+		if valid {
+			sigIndex++
+		}
+		keyIndex++
 	}
 
-	sigs := make([]*ecc.Signature, len(derSignatures))
-	for index, der := range derSignatures {
-		signature, err := ecc.ParseDER(der.Bytes())
-		if err != nil {
-			return nil, err
+	success := sigIndex == len(derSignatures)
+
+	if !success && stack.flags.Has(VerifyNullFail) {
+		for _, derSignature := range derSignatures {
+			if derSignature.Length() > 0 {
+				return nil, newError(ErrNullFail, "signature must be empty when the check fails")
+			}
 		}
+	}
 
-		sigs[index] = signature
+	if stack.flags.Has(VerifyNullDummy) && dummy.Length() != 0 {
+		return nil, newError(ErrSigNullDummy, "dummy element is not empty")
 	}
 
-	for _, sig := range sigs {
-		if len(points) == 0 {
-			return nil, fmt.Errorf("signatures do not match public keys")
-		}
+	pushBool(stack, success)
 
-		for len(points) > 0 {
-			point := points[0]
-			points = points[1:]
+	return stack, nil
+}
 
-			ok, err := point.Verify(z, sig)
-			if err != nil {
-				return nil, err
-			}
+// Same as OP_CHECKMULTISIG, but OP_VERIFY is executed afterward.
+func CHECKMULTISIGVERIFY(stack *Stack, sigHash SigHashFunc) (*Stack, error) {
+	stack, err := CHECKMULTISIG(stack, sigHash)
+	if err != nil {
+		return nil, err
+	}
 
-			if ok {
-				break
-			}
-		}
+	return verify(stack, ErrCheckMultiSigVerify)
+}
+
+// The maximum number of public keys of OP_CHECKMULTISIG.
+const MaxPubKeysPerMultisig = 20
+
+// SigHashFunc returns the hash that a signature with hashType signs. The
+// signatures are removed from the signed script first, as the legacy
+// signature hash requires.
+type SigHashFunc func(hashType byte, signatures [][]byte) ([]byte, error)
+
+// SigVersion selects the algorithm of the signature hash.
+type SigVersion int
+
+const (
+	// The legacy signature hash, used outside of witness scripts
+	SigVersionBase SigVersion = iota
+	// The signature hash of version 0 witness scripts, see BIP 143
+	SigVersionWitnessV0
+	// The signature hash of taproot key path spends, see BIP 341
+	SigVersionTaproot
+	// The signature hash of tapscripts, see BIP 342
+	SigVersionTapscript
+)
+
+// TaprootExecutionData is what a taproot signature commits to besides the
+// transaction and the spent outputs.
+type TaprootExecutionData struct {
+	// The annex of the witness, or nil when there is none
+	Annex []byte
+	// The hash of the executed leaf, or nil for key path spends
+	TapLeafHash []byte
+	// The op code position of the last executed OP_CODESEPARATOR, or
+	// 0xffffffff when none has been executed
+	CodeSeparatorPosition uint32
+}
+
+// TxChecker gives the op codes that inspect the spending transaction access
+// to the input being verified.
+type TxChecker interface {
+	// Returns whether the transaction's nLockTime satisfies lockTime.
+	CheckLockTime(lockTime int64) bool
+	// Returns whether the input's nSequence satisfies sequence.
+	CheckSequence(sequence int64) bool
+	// Returns the hash signed by a signature with hashType, where scriptCode
+	// is the serialized script that is signed.
+	SignatureHash(scriptCode []byte, hashType byte, sigVersion SigVersion) ([]byte, error)
+	// Returns the hash signed by a taproot signature with hashType.
+	TaprootSignatureHash(hashType byte, execData *TaprootExecutionData) ([]byte, error)
+}
+
+// Marks transaction as invalid if the top stack item is greater than the
+// transaction's nLockTime field, otherwise script evaluation continues as
+// though an OP_NOP was executed. See BIP 65.
+func CHECKLOCKTIMEVERIFY(stack *Stack, checker TxChecker) (*Stack, error) {
+	element, err := stack.Peek()
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := NewInstruction([]byte{0x01})
+	// Lock times can be up to 5 bytes, as 4 bytes would overflow in 2038.
+	lockTime, err := element.number(5, stack.flags.Has(VerifyMinimalData))
 	if err != nil {
 		return nil, err
 	}
-	stack.Push(data)
+
+	if lockTime < 0 {
+		return nil, newError(ErrNegativeLockTime, "negative locktime")
+	}
+
+	if checker == nil || !checker.CheckLockTime(lockTime) {
+		return nil, newError(ErrUnsatisfiedLockTime, "unsatisfied locktime")
+	}
+
+	return stack, nil
+}
+
+// Marks transaction as invalid if the relative lock time of the input is not
+// equal to or longer than the value of the top stack item. See BIP 112.
+func CHECKSEQUENCEVERIFY(stack *Stack, checker TxChecker) (*Stack, error) {
+	element, err := stack.Peek()
+	if err != nil {
+		return nil, err
+	}
+
+	sequence, err := element.number(5, stack.flags.Has(VerifyMinimalData))
+	if err != nil {
+		return nil, err
+	}
+
+	if sequence < 0 {
+		return nil, newError(ErrNegativeLockTime, "negative locktime")
+	}
+
+	// With the disable flag set the op code behaves as a NOP.
+	if sequence&(1<<31) != 0 {
+		return stack, nil
+	}
+
+	if checker == nil || !checker.CheckSequence(sequence) {
+		return nil, newError(ErrUnsatisfiedLockTime, "unsatisfied locktime")
+	}
+
+	return stack, nil
+}
+
+// Pops the top element as a script number of at most 4 bytes.
+func popNumber(stack *Stack) (int64, error) {
+	element, err := stack.Pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return element.number(4, stack.flags.Has(VerifyMinimalData))
+}
+
+func pushNumber(stack *Stack, number int64) {
+	stack.Push(&Instruction{instruction: EncodeNum(number)})
+}
+
+func pushBool(stack *Stack, value bool) {
+	pushNumber(stack, boolToNumber(value))
+}
+
+func boolToNumber(value bool) int64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func unaryNumber(stack *Stack, operation func(int64) int64) (*Stack, error) {
+	a, err := popNumber(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	pushNumber(stack, operation(a))
 
 	return stack, nil
 }
 
+func binaryNumber(stack *Stack, operation func(int64, int64) int64) (*Stack, error) {
+	if stack.Size() < 2 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	b, err := popNumber(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := popNumber(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	pushNumber(stack, operation(a, b))
+
+	return stack, nil
+}
+
+func hashTop(stack *Stack, hasher func([]byte) []byte) (*Stack, error) {
+	instruction, err := stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := hasher(instruction.instruction)
+	hashedElement, err := NewInstruction(hashed)
+	if err != nil {
+		return nil, err
+	}
+	stack.Push(hashedElement)
+
+	return stack, nil
+}
+
+// Returns whether the op code is disabled. A script containing a disabled
+// op code is invalid, even if the op code is in a branch that is not executed.
+func IsDisabled(opCode int) bool {
+	switch opCode {
+	case 0x7e, 0x7f, 0x80, 0x81, // OP_CAT, OP_SUBSTR, OP_LEFT, OP_RIGHT
+		0x83, 0x84, 0x85, 0x86, // OP_INVERT, OP_AND, OP_OR, OP_XOR
+		0x8d, 0x8e, // OP_2MUL, OP_2DIV
+		0x95, 0x96, 0x97, 0x98, 0x99: // OP_MUL, OP_DIV, OP_MOD, OP_LSHIFT, OP_RSHIFT
+		return true
+	}
+
+	return false
+}
+
+// Returns whether the op code is one of the OP_SUCCESSx op codes of
+// tapscript, which make the script succeed without being executed, see
+// BIP 342.
+func IsSuccess(opCode int) bool {
+	switch {
+	case opCode == 80 || opCode == 98:
+		return true
+	case opCode >= 126 && opCode <= 129:
+		return true
+	case opCode >= 131 && opCode <= 134:
+		return true
+	case opCode == 137 || opCode == 138:
+		return true
+	case opCode == 141 || opCode == 142:
+		return true
+	case opCode >= 149 && opCode <= 153:
+		return true
+	case opCode >= 187 && opCode <= 254:
+		return true
+	}
+
+	return false
+}
+
+// Returns whether the op code is one of OP_IF, OP_NOTIF, OP_VERIF, OP_VERNOTIF,
+// OP_ELSE or OP_ENDIF, which are evaluated even in branches that are not executed.
+func IsConditional(opCode int) bool {
+	return opCode >= 0x63 && opCode <= 0x68
+}
+
 var OP_CODE_FUNCTIONS = map[int]func(*Stack) (*Stack, error){
-	0:  OP0,
-	79: OP1NEGATE,
-	81: OP1,
-	82: OP2,
-	83: OP3,
-	84: OP4,
-	85: OP5,
-	86: OP6,
-	87: OP7,
-	88: OP8,
-	89: OP9,
-	90: OP10,
-	91: OP11,
-	92: OP12,
-	93: OP13,
-	94: OP14,
-	95: OP15,
-	96: OP16,
-	97: NOP,
-	// 99:  IF,
+	0:   OP0,
+	79:  OP1NEGATE,
+	81:  OP1,
+	82:  OP2,
+	83:  OP3,
+	84:  OP4,
+	85:  OP5,
+	86:  OP6,
+	87:  OP7,
+	88:  OP8,
+	89:  OP9,
+	90:  OP10,
+	91:  OP11,
+	92:  OP12,
+	93:  OP13,
+	94:  OP14,
+	95:  OP15,
+	96:  OP16,
+	97:  NOP,
+	99:  IF,
+	100: NOTIF,
+	103: ELSE,
+	104: ENDIF,
 	105: VERIFY,
 	106: RETURN,
+	107: TOALTSTACK,
+	108: FROMALTSTACK,
 	109: OP2DROP,
 	110: OP2DUP,
+	111: OP3DUP,
+	112: OP2OVER,
+	113: OP2ROT,
+	114: OP2SWAP,
 	115: IFDUP,
 	116: DEPTH,
 	117: DROP,
 	118: DUP,
+	119: NIP,
+	120: OVER,
 	121: PICK,
-	123: SWAP,
+	122: ROLL,
+	123: ROT,
+	124: SWAP,
+	125: TUCK,
 	130: SIZE,
 	135: EQUAL,
+	136: EQUALVERIFY,
+	139: OP1ADD,
+	140: OP1SUB,
+	143: NEGATE,
+	144: ABS,
 	145: NOT,
+	146: OP0NOTEQUAL,
 	147: ADD,
-	149: MUL,
+	148: SUB,
+	154: BOOLAND,
+	155: BOOLOR,
+	156: NUMEQUAL,
+	157: NUMEQUALVERIFY,
+	158: NUMNOTEQUAL,
+	159: LESSTHAN,
+	160: GREATERTHAN,
+	161: LESSTHANOREQUAL,
+	162: GREATERTHANOREQUAL,
 	163: MIN,
 	164: MAX,
 	165: WITHIN,
+	166: RIPEMD160,
 	167: SHA1,
+	168: SHA256,
 	169: HASH160,
 	170: HASH256,
+	171: CODESEPARATOR,
 	// 172: CHECKSIG,
+	// 173: CHECKSIGVERIFY,
 	// 174: CHECKMULTISIG,
+	// 175: CHECKMULTISIGVERIFY,
+	176: NOP,
+	// 177: CHECKLOCKTIMEVERIFY,
+	// 178: CHECKSEQUENCEVERIFY,
+	179: NOP,
+	180: NOP,
+	181: NOP,
+	182: NOP,
+	183: NOP,
+	184: NOP,
+	185: NOP,
+}
+
+// The names of the op codes, as used by Bitcoin Core.
+var OP_CODE_NAMES = map[int]string{
+	0:   "OP_0",
+	76:  "OP_PUSHDATA1",
+	77:  "OP_PUSHDATA2",
+	78:  "OP_PUSHDATA4",
+	79:  "OP_1NEGATE",
+	80:  "OP_RESERVED",
+	81:  "OP_1",
+	82:  "OP_2",
+	83:  "OP_3",
+	84:  "OP_4",
+	85:  "OP_5",
+	86:  "OP_6",
+	87:  "OP_7",
+	88:  "OP_8",
+	89:  "OP_9",
+	90:  "OP_10",
+	91:  "OP_11",
+	92:  "OP_12",
+	93:  "OP_13",
+	94:  "OP_14",
+	95:  "OP_15",
+	96:  "OP_16",
+	97:  "OP_NOP",
+	98:  "OP_VER",
+	99:  "OP_IF",
+	100: "OP_NOTIF",
+	101: "OP_VERIF",
+	102: "OP_VERNOTIF",
+	103: "OP_ELSE",
+	104: "OP_ENDIF",
+	105: "OP_VERIFY",
+	106: "OP_RETURN",
+	107: "OP_TOALTSTACK",
+	108: "OP_FROMALTSTACK",
+	109: "OP_2DROP",
+	110: "OP_2DUP",
+	111: "OP_3DUP",
+	112: "OP_2OVER",
+	113: "OP_2ROT",
+	114: "OP_2SWAP",
+	115: "OP_IFDUP",
+	116: "OP_DEPTH",
+	117: "OP_DROP",
+	118: "OP_DUP",
+	119: "OP_NIP",
+	120: "OP_OVER",
+	121: "OP_PICK",
+	122: "OP_ROLL",
+	123: "OP_ROT",
+	124: "OP_SWAP",
+	125: "OP_TUCK",
+	126: "OP_CAT",
+	127: "OP_SUBSTR",
+	128: "OP_LEFT",
+	129: "OP_RIGHT",
+	130: "OP_SIZE",
+	131: "OP_INVERT",
+	132: "OP_AND",
+	133: "OP_OR",
+	134: "OP_XOR",
+	135: "OP_EQUAL",
+	136: "OP_EQUALVERIFY",
+	137: "OP_RESERVED1",
+	138: "OP_RESERVED2",
+	139: "OP_1ADD",
+	140: "OP_1SUB",
+	141: "OP_2MUL",
+	142: "OP_2DIV",
+	143: "OP_NEGATE",
+	144: "OP_ABS",
+	145: "OP_NOT",
+	146: "OP_0NOTEQUAL",
+	147: "OP_ADD",
+	148: "OP_SUB",
+	149: "OP_MUL",
+	150: "OP_DIV",
+	151: "OP_MOD",
+	152: "OP_LSHIFT",
+	153: "OP_RSHIFT",
+	154: "OP_BOOLAND",
+	155: "OP_BOOLOR",
+	156: "OP_NUMEQUAL",
+	157: "OP_NUMEQUALVERIFY",
+	158: "OP_NUMNOTEQUAL",
+	159: "OP_LESSTHAN",
+	160: "OP_GREATERTHAN",
+	161: "OP_LESSTHANOREQUAL",
+	162: "OP_GREATERTHANOREQUAL",
+	163: "OP_MIN",
+	164: "OP_MAX",
+	165: "OP_WITHIN",
+	166: "OP_RIPEMD160",
+	167: "OP_SHA1",
+	168: "OP_SHA256",
+	169: "OP_HASH160",
+	170: "OP_HASH256",
+	171: "OP_CODESEPARATOR",
+	172: "OP_CHECKSIG",
+	173: "OP_CHECKSIGVERIFY",
+	174: "OP_CHECKMULTISIG",
+	175: "OP_CHECKMULTISIGVERIFY",
+	176: "OP_NOP1",
+	177: "OP_CHECKLOCKTIMEVERIFY",
+	178: "OP_CHECKSEQUENCEVERIFY",
+	179: "OP_NOP4",
+	180: "OP_NOP5",
+	181: "OP_NOP6",
+	182: "OP_NOP7",
+	183: "OP_NOP8",
+	184: "OP_NOP9",
+	185: "OP_NOP10",
+	186: "OP_CHECKSIGADD",
 }
 
 var OP_CODE = struct {
 	EQUAL   Instruction
 	HASH160 Instruction
 }{
-	EQUAL:   Instruction{instruction: []byte{0x87}},
-	HASH160: Instruction{instruction: []byte{0xa9}},
+	EQUAL:   Instruction{instruction: []byte{0x87}, isOpCode: true},
+	HASH160: Instruction{instruction: []byte{0xa9}, isOpCode: true},
 }
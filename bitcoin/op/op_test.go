@@ -1,8 +1,11 @@
 package op_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
@@ -88,20 +91,130 @@ func TestNOP(t *testing.T) {
 func TestIF(t *testing.T) {
 	t.Run("Empty stack", func(t *testing.T) {
 		stack := op.NewStack()
-		_, _, err := op.IF(stack, []op.Instruction{})
+		_, err := op.IF(stack)
 		if err == nil || err.Error() != "stack too small" {
 			t.Errorf("expected 'stack too small', got %v", err)
 		}
 	})
 
-	t.Run("If missing OP_ENDIF", func(t *testing.T) {
+	t.Run("True value executes the branch", func(t *testing.T) {
 		instruction, _ := op.NewInstruction([]byte{0x01})
 		stack := op.NewStack()
 		stack.Push(instruction)
 
-		_, _, err := op.IF(stack, []op.Instruction{})
-		if err == nil || err.Error() != "missing OP_ENDIF" {
-			t.Errorf("expected 'missing OP_ENDIF', got %v", err)
+		stack, err := op.IF(stack)
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+
+		if stack.Size() != 0 {
+			t.Errorf("expected: %v, got: %v", 0, stack.Size())
+		}
+
+		if !stack.IsExecuting() {
+			t.Errorf("expected the branch to be executed")
+		}
+	})
+
+	t.Run("False value skips the branch", func(t *testing.T) {
+		instruction, _ := op.NewInstruction([]byte{})
+		stack := op.NewStack()
+		stack.Push(instruction)
+
+		stack, _ = op.IF(stack)
+
+		if stack.IsExecuting() {
+			t.Errorf("expected the branch to be skipped")
+		}
+	})
+
+	t.Run("Nothing is popped in a skipped branch", func(t *testing.T) {
+		zero, _ := op.NewInstruction([]byte{})
+		one, _ := op.NewInstruction([]byte{0x01})
+		stack := op.NewStack()
+		stack.Push(one)
+		stack.Push(zero)
+
+		stack, _ = op.IF(stack)
+		stack, err := op.IF(stack)
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+
+		if stack.Size() != 1 {
+			t.Errorf("expected: %v, got: %v", 1, stack.Size())
+		}
+	})
+}
+
+func TestNOTIF(t *testing.T) {
+	t.Run("Empty stack", func(t *testing.T) {
+		stack := op.NewStack()
+		_, err := op.NOTIF(stack)
+		if err == nil || err.Error() != "stack too small" {
+			t.Errorf("expected 'stack too small', got %v", err)
+		}
+	})
+
+	t.Run("False value executes the branch", func(t *testing.T) {
+		instruction, _ := op.NewInstruction([]byte{0x80})
+		stack := op.NewStack()
+		stack.Push(instruction)
+
+		stack, _ = op.NOTIF(stack)
+
+		if !stack.IsExecuting() {
+			t.Errorf("expected the branch to be executed")
+		}
+	})
+
+	t.Run("True value skips the branch", func(t *testing.T) {
+		instruction, _ := op.NewInstruction([]byte{0x02})
+		stack := op.NewStack()
+		stack.Push(instruction)
+
+		stack, _ = op.NOTIF(stack)
+
+		if stack.IsExecuting() {
+			t.Errorf("expected the branch to be skipped")
+		}
+	})
+}
+
+func TestELSE_ENDIF(t *testing.T) {
+	t.Run("ELSE without IF", func(t *testing.T) {
+		_, err := op.ELSE(op.NewStack())
+		if err == nil || err.Error() != "unbalanced conditional" {
+			t.Errorf("expected 'unbalanced conditional', got %v", err)
+		}
+	})
+
+	t.Run("ENDIF without IF", func(t *testing.T) {
+		_, err := op.ENDIF(op.NewStack())
+		if err == nil || err.Error() != "unbalanced conditional" {
+			t.Errorf("expected 'unbalanced conditional', got %v", err)
+		}
+	})
+
+	t.Run("ELSE flips the branch and ENDIF closes it", func(t *testing.T) {
+		instruction, _ := op.NewInstruction([]byte{})
+		stack := op.NewStack()
+		stack.Push(instruction)
+
+		stack, _ = op.IF(stack)
+		stack, _ = op.ELSE(stack)
+		if !stack.IsExecuting() {
+			t.Errorf("expected the else branch to be executed")
+		}
+
+		stack, _ = op.ELSE(stack)
+		if stack.IsExecuting() {
+			t.Errorf("expected a second else to flip the branch again")
+		}
+
+		stack, _ = op.ENDIF(stack)
+		if !stack.IsBalanced() || !stack.IsExecuting() {
+			t.Errorf("expected all branches to be closed")
 		}
 	})
 }
@@ -500,8 +613,8 @@ func TestEQUAL(t *testing.T) {
 		}
 
 		instruction, _ := stack.Pop()
-		if instruction.Hex() != "00" {
-			t.Errorf("expected: %v, got: %v", "00", instruction.Hex())
+		if instruction.Hex() != "" {
+			t.Errorf("expected: %v, got: %v", "", instruction.Hex())
 		}
 	})
 }
@@ -530,8 +643,8 @@ func TestNOT(t *testing.T) {
 		}
 
 		instruction, _ = stack.Pop()
-		if instruction.Hex() != "00" {
-			t.Errorf("expected: %v, got: %v", "00", instruction.Hex())
+		if instruction.Hex() != "" {
+			t.Errorf("expected: %v, got: %v", "", instruction.Hex())
 		}
 	})
 
@@ -589,33 +702,20 @@ func TestADD(t *testing.T) {
 }
 
 func TestMUL(t *testing.T) {
-	t.Run("Empty stack", func(t *testing.T) {
-		stack := op.NewStack()
-		_, err := op.MUL(stack)
-		if err == nil || err.Error() != "stack too small" {
-			t.Errorf("expected error, got nil")
-		}
-	})
-
-	t.Run("Multiply elements", func(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
 		element1, _ := op.NewInstruction([]byte{0x02})
 		element2, _ := op.NewInstruction([]byte{0x03})
 		stack := op.NewStack()
 		stack.Push(element1)
 		stack.Push(element2)
 
-		stack, err := op.MUL(stack)
-		if err != nil {
-			t.Errorf("expected nil, got %v", err)
-		}
-
-		if stack.Size() != 1 {
-			t.Errorf("expected: %v, got: %v", 1, stack.Size())
+		_, err := op.MUL(stack)
+		if err == nil || err.Error() != "disabled opcode" {
+			t.Errorf("expected 'disabled opcode', got %v", err)
 		}
 
-		instruction, _ := stack.Pop()
-		if instruction.Hex() != "06" {
-			t.Errorf("expected: %v, got: %v", "06", instruction.Hex())
+		if !op.IsDisabled(0x95) {
+			t.Errorf("expected OP_MUL to be disabled")
 		}
 	})
 }
@@ -668,8 +768,8 @@ func TestGREATERTHANOREQUAL(t *testing.T) {
 		}
 
 		instruction, _ := stack.Pop()
-		if instruction.Hex() != "00" {
-			t.Errorf("expected: %v, got: %v", "00", instruction.Hex())
+		if instruction.Hex() != "" {
+			t.Errorf("expected: %v, got: %v", "", instruction.Hex())
 		}
 	})
 }
@@ -790,8 +890,8 @@ func TestWITHIN(t *testing.T) {
 		}
 
 		instruction, _ := stack.Pop()
-		if instruction.Hex() != "00" {
-			t.Errorf("expected: %v, got: %v", "00", instruction.Hex())
+		if instruction.Hex() != "" {
+			t.Errorf("expected: %v, got: %v", "", instruction.Hex())
 		}
 	})
 }
@@ -883,6 +983,13 @@ func TestHASH256(t *testing.T) {
 	})
 }
 
+// Returns a SigHashFunc that always gives z, whatever the hash type is.
+func fixedSigHash(z *big.Int) op.SigHashFunc {
+	return func(hashType byte, signatures [][]byte) ([]byte, error) {
+		return z.FillBytes(make([]byte, 32)), nil
+	}
+}
+
 func TestCHECKSIG(t *testing.T) {
 	t.Run("Empty stack", func(t *testing.T) {
 		stack := op.NewStack()
@@ -904,7 +1011,7 @@ func TestCHECKSIG(t *testing.T) {
 		stack.Push(sig)
 		stack.Push(sec)
 
-		stack, err := op.CHECKSIG(stack, z)
+		stack, err := op.CHECKSIG(stack, fixedSigHash(z))
 		if err != nil {
 			t.Errorf("expected nil, got %v", err)
 		}
@@ -920,7 +1027,105 @@ func TestCHECKSIG(t *testing.T) {
 	})
 }
 
+func TestCHECKSIGHashType(t *testing.T) {
+	secBytes, _ := hex.DecodeString("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	sigBytes, _ := hex.DecodeString("300602010102010183")
+
+	var hashTypes []byte
+	var removed [][]byte
+	sigHash := func(hashType byte, signatures [][]byte) ([]byte, error) {
+		hashTypes = append(hashTypes, hashType)
+		removed = signatures
+		return make([]byte, 32), nil
+	}
+
+	t.Run("OP_CHECKSIG", func(t *testing.T) {
+		hashTypes = nil
+		if _, err := op.CHECKSIG(newStack(sigBytes, secBytes), sigHash); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if len(hashTypes) != 1 || hashTypes[0] != 0x83 {
+			t.Errorf("expected hash type 0x83, got %x", hashTypes)
+		}
+
+		if len(removed) != 1 || !bytes.Equal(removed[0], sigBytes) {
+			t.Errorf("expected the signature to be removed, got %x", removed)
+		}
+	})
+
+	t.Run("OP_CHECKMULTISIG", func(t *testing.T) {
+		hashTypes = nil
+		otherSigBytes, _ := hex.DecodeString("300602010202010201")
+
+		stack := newStack([]byte{}, sigBytes, otherSigBytes, []byte{0x02}, secBytes, secBytes, []byte{0x02})
+		if _, err := op.CHECKMULTISIG(stack, sigHash); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if len(hashTypes) == 0 || hashTypes[0] != 0x01 {
+			t.Errorf("expected hash type 0x01 first, got %x", hashTypes)
+		}
+
+		if len(removed) != 2 {
+			t.Errorf("expected both signatures to be removed, got %x", removed)
+		}
+	})
+
+	t.Run("Empty signature is not hashed", func(t *testing.T) {
+		hashTypes = nil
+		if _, err := op.CHECKSIG(newStack([]byte{}, secBytes), sigHash); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if len(hashTypes) != 0 {
+			t.Errorf("expected no signature hash, got %x", hashTypes)
+		}
+	})
+}
+
+func TestCHECKSIGInvalidEncoding(t *testing.T) {
+	z := big.NewInt(1)
+	secBytes, _ := hex.DecodeString("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+
+	testCases := []struct {
+		name      string
+		signature []byte
+		sec       []byte
+	}{
+		{"Empty signature", []byte{}, secBytes},
+		{"Malformed signature", []byte{0x30, 0x01, 0x01}, secBytes},
+		{"Malformed public key", []byte{0x30, 0x01, 0x01}, []byte{0x02, 0x01}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stack, err := op.CHECKSIG(newStack(tc.signature, tc.sec), fixedSigHash(z))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			instruction, _ := stack.Pop()
+			if instruction.Hex() != "" {
+				t.Errorf("expected: %v, got: %v", "", instruction.Hex())
+			}
+		})
+	}
+
+	t.Run("OP_CHECKSIGVERIFY", func(t *testing.T) {
+		_, err := op.CHECKSIGVERIFY(newStack([]byte{}, secBytes), fixedSigHash(z))
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	})
+}
+
 func TestCHECKMULTISIG(t *testing.T) {
+	z, _ := new(big.Int).SetString("7c076ff316692a3d7eb3c3bb0f8b1488cf72e1afcd929e29307032997a838a3d", 16)
+	secBytes, _ := hex.DecodeString("04887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34")
+	sigBytes, _ := hex.DecodeString("3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab601")
+	otherSecBytes, _ := hex.DecodeString("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+
 	t.Run("Empty stack", func(t *testing.T) {
 		stack := op.NewStack()
 		_, err := op.CHECKMULTISIG(stack, nil)
@@ -928,4 +1133,350 @@ func TestCHECKMULTISIG(t *testing.T) {
 			t.Errorf("expected error, got nil")
 		}
 	})
+
+	testCases := []struct {
+		name     string
+		stack    [][]byte
+		expected string
+	}{
+		{"1-of-2 with the first key", [][]byte{{}, sigBytes, {0x01}, secBytes, otherSecBytes, {0x02}}, "01"},
+		{"1-of-2 with the second key", [][]byte{{}, sigBytes, {0x01}, otherSecBytes, secBytes, {0x02}}, "01"},
+		{"2-of-2 with one signature twice", [][]byte{{}, sigBytes, sigBytes, {0x02}, secBytes, otherSecBytes, {0x02}}, ""},
+		{"0-of-0", [][]byte{{}, {}, {}}, "01"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stack, err := op.CHECKMULTISIG(newStack(tc.stack...), fixedSigHash(z))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			if stack.Size() != 1 {
+				t.Fatalf("expected: %v, got: %v", 1, stack.Size())
+			}
+
+			instruction, _ := stack.Pop()
+			if instruction.Hex() != tc.expected {
+				t.Errorf("expected: %v, got: %v", tc.expected, instruction.Hex())
+			}
+		})
+	}
+
+	t.Run("Dummy element is not empty", func(t *testing.T) {
+		stack := newStackWithFlags(op.VerifyNullDummy, []byte{0x00}, sigBytes, []byte{0x01}, secBytes, []byte{0x01})
+
+		_, err := op.CHECKMULTISIG(stack, fixedSigHash(z))
+		if err == nil || err.Error() != "dummy element is not empty" {
+			t.Errorf("expected 'dummy element is not empty', got %v", err)
+		}
+	})
+
+	t.Run("Dummy element is not checked without VerifyNullDummy", func(t *testing.T) {
+		stack := newStack([]byte{0x00}, sigBytes, []byte{0x01}, secBytes, []byte{0x01})
+
+		stack, err := op.CHECKMULTISIG(stack, fixedSigHash(z))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		instruction, _ := stack.Pop()
+		if instruction.Hex() != "01" {
+			t.Errorf("expected: %v, got: %v", "01", instruction.Hex())
+		}
+	})
+
+	t.Run("Too many public keys", func(t *testing.T) {
+		stack := newStack([]byte{}, []byte{}, []byte{21})
+
+		_, err := op.CHECKMULTISIG(stack, fixedSigHash(z))
+		if err == nil || err.Error() != "pubkey count out of range" {
+			t.Errorf("expected 'pubkey count out of range', got %v", err)
+		}
+	})
+
+	t.Run("OP_CHECKMULTISIGVERIFY", func(t *testing.T) {
+		stack := newStack([]byte{}, sigBytes, sigBytes, []byte{0x02}, secBytes, otherSecBytes, []byte{0x02})
+
+		_, err := op.CHECKMULTISIGVERIFY(stack, fixedSigHash(z))
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	})
+}
+
+// Pushes the elements onto a new stack, the last element ends up on top.
+func newStack(elements ...[]byte) *op.Stack {
+	return newStackWithFlags(op.VerifyNone, elements...)
+}
+
+// Same as newStack, but the op codes enforce the rules selected by flags.
+func newStackWithFlags(flags op.VerifyFlags, elements ...[]byte) *op.Stack {
+	stack := op.NewStackWithFlags(flags)
+	for _, element := range elements {
+		instruction, _ := op.NewInstruction(element)
+		stack.Push(instruction)
+	}
+
+	return stack
+}
+
+// Returns the elements of the stack as hex, bottom first.
+func stackToHex(stack *op.Stack) []string {
+	result := make([]string, stack.Size())
+	for i := range result {
+		element, _ := stack.PeekN(stack.Size() - 1 - i)
+		result[i] = element.Hex()
+	}
+
+	return result
+}
+
+func TestStackOperations(t *testing.T) {
+	a, b, c, d, e, f := []byte{0x0a}, []byte{0x0b}, []byte{0x0c}, []byte{0x0d}, []byte{0x0e}, []byte{0x0f}
+
+	testCases := []struct {
+		name      string
+		operation func(*op.Stack) (*op.Stack, error)
+		stack     [][]byte
+		expected  []string
+	}{
+		{"OP_3DUP", op.OP3DUP, [][]byte{a, b, c}, []string{"0a", "0b", "0c", "0a", "0b", "0c"}},
+		{"OP_2OVER", op.OP2OVER, [][]byte{a, b, c, d}, []string{"0a", "0b", "0c", "0d", "0a", "0b"}},
+		{"OP_2ROT", op.OP2ROT, [][]byte{a, b, c, d, e, f}, []string{"0c", "0d", "0e", "0f", "0a", "0b"}},
+		{"OP_2SWAP", op.OP2SWAP, [][]byte{a, b, c, d}, []string{"0c", "0d", "0a", "0b"}},
+		{"OP_NIP", op.NIP, [][]byte{a, b}, []string{"0b"}},
+		{"OP_OVER", op.OVER, [][]byte{a, b}, []string{"0a", "0b", "0a"}},
+		{"OP_ROLL", op.ROLL, [][]byte{a, b, c, {0x02}}, []string{"0b", "0c", "0a"}},
+		{"OP_ROT", op.ROT, [][]byte{a, b, c}, []string{"0b", "0c", "0a"}},
+		{"OP_TUCK", op.TUCK, [][]byte{a, b}, []string{"0b", "0a", "0b"}},
+		{"OP_DEPTH", op.DEPTH, [][]byte{}, []string{""}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stack, err := tc.operation(newStack(tc.stack...))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			got := stackToHex(stack)
+			if strings.Join(got, " ") != strings.Join(tc.expected, " ") {
+				t.Errorf("expected: %v, got: %v", tc.expected, got)
+			}
+		})
+
+		t.Run(tc.name+" stack too small", func(t *testing.T) {
+			if len(tc.stack) == 0 {
+				return
+			}
+
+			_, err := tc.operation(newStack(tc.stack[1:]...))
+			if err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestAltStack(t *testing.T) {
+	t.Run("FROMALTSTACK on empty alt stack", func(t *testing.T) {
+		_, err := op.FROMALTSTACK(op.NewStack())
+		if err == nil || err.Error() != "invalid alt stack" {
+			t.Errorf("expected 'invalid alt stack', got %v", err)
+		}
+	})
+
+	t.Run("Move an element to the alt stack and back", func(t *testing.T) {
+		stack := newStack([]byte{0x01}, []byte{0x02})
+
+		stack, err := op.TOALTSTACK(stack)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if stack.Size() != 1 || stack.AltSize() != 1 {
+			t.Errorf("expected: 1 and 1, got: %v and %v", stack.Size(), stack.AltSize())
+		}
+
+		stack, err = op.FROMALTSTACK(stack)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		top, _ := stack.Peek()
+		if top.Hex() != "02" || stack.AltSize() != 0 {
+			t.Errorf("expected: 02, got: %v", top.Hex())
+		}
+	})
+}
+
+func TestArithmetic(t *testing.T) {
+	testCases := []struct {
+		name      string
+		operation func(*op.Stack) (*op.Stack, error)
+		stack     [][]byte
+		expected  string
+	}{
+		{"OP_1ADD", op.OP1ADD, [][]byte{{0x7f}}, "8000"},
+		{"OP_1SUB", op.OP1SUB, [][]byte{{}}, "81"},
+		{"OP_NEGATE", op.NEGATE, [][]byte{{0x05}}, "85"},
+		{"OP_ABS", op.ABS, [][]byte{{0x85}}, "05"},
+		{"OP_NOT on negative zero", op.NOT, [][]byte{{0x80}}, "01"},
+		{"OP_0NOTEQUAL", op.OP0NOTEQUAL, [][]byte{{0x07}}, "01"},
+		{"OP_ADD with negative number", op.ADD, [][]byte{{0x82}, {0x05}}, "03"},
+		{"OP_SUB", op.SUB, [][]byte{{0x02}, {0x05}}, "83"},
+		{"OP_BOOLAND", op.BOOLAND, [][]byte{{0x01}, {}}, ""},
+		{"OP_BOOLOR", op.BOOLOR, [][]byte{{0x01}, {}}, "01"},
+		{"OP_NUMEQUAL", op.NUMEQUAL, [][]byte{{0x05}, {0x05, 0x00}}, "01"},
+		{"OP_NUMNOTEQUAL", op.NUMNOTEQUAL, [][]byte{{0x05}, {0x06}}, "01"},
+		{"OP_LESSTHAN", op.LESSTHAN, [][]byte{{0x81}, {0x01}}, "01"},
+		{"OP_GREATERTHAN", op.GREATERTHAN, [][]byte{{0x81}, {0x01}}, ""},
+		{"OP_LESSTHANOREQUAL", op.LESSTHANOREQUAL, [][]byte{{0x01}, {0x01}}, "01"},
+		{"OP_MIN with negative number", op.MIN, [][]byte{{0x81}, {0x01}}, "81"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stack, err := tc.operation(newStack(tc.stack...))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			if stack.Size() != 1 {
+				t.Errorf("expected: %v, got: %v", 1, stack.Size())
+			}
+
+			result, _ := stack.Pop()
+			if result.Hex() != tc.expected {
+				t.Errorf("expected: %v, got: %v", tc.expected, result.Hex())
+			}
+		})
+	}
+
+	t.Run("Numbers larger than 4 bytes overflow", func(t *testing.T) {
+		stack := newStack([]byte{0x01, 0x00, 0x00, 0x00, 0x00}, []byte{0x01})
+		_, err := op.ADD(stack)
+		if err == nil || err.Error() != "script number overflow" {
+			t.Errorf("expected 'script number overflow', got %v", err)
+		}
+	})
+}
+
+func TestVerifyOperations(t *testing.T) {
+	t.Run("EQUALVERIFY", func(t *testing.T) {
+		stack, err := op.EQUALVERIFY(newStack([]byte{0x01}, []byte{0x01}))
+		if err != nil || stack.Size() != 0 {
+			t.Errorf("expected an empty stack, got %v", err)
+		}
+
+		_, err = op.EQUALVERIFY(newStack([]byte{0x01}, []byte{0x02}))
+		if err == nil || err.Error() != "transaction invalid" {
+			t.Errorf("expected 'transaction invalid', got %v", err)
+		}
+	})
+
+	t.Run("NUMEQUALVERIFY", func(t *testing.T) {
+		stack, err := op.NUMEQUALVERIFY(newStack([]byte{0x01}, []byte{0x01, 0x00}))
+		if err != nil || stack.Size() != 0 {
+			t.Errorf("expected an empty stack, got %v", err)
+		}
+
+		_, err = op.NUMEQUALVERIFY(newStack([]byte{0x01}, []byte{0x02}))
+		if err == nil || err.Error() != "transaction invalid" {
+			t.Errorf("expected 'transaction invalid', got %v", err)
+		}
+	})
+}
+
+func TestHashOperations(t *testing.T) {
+	testCases := []struct {
+		name      string
+		operation func(*op.Stack) (*op.Stack, error)
+		expected  string
+	}{
+		{"OP_RIPEMD160", op.RIPEMD160, "98c615784ccb5fe5936fbc0cbe9dfdb408d92f0f"},
+		{"OP_SHA256", op.SHA256, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stack, err := tc.operation(newStack([]byte("hello world")))
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			hashed, _ := stack.Pop()
+			if hashed.Hex() != tc.expected {
+				t.Errorf("expected: %v, got: %v", tc.expected, hashed.Hex())
+			}
+		})
+	}
+}
+
+type lockTimeChecker struct {
+	lockTime, sequence int64
+}
+
+func (c *lockTimeChecker) CheckLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+func (c *lockTimeChecker) CheckSequence(sequence int64) bool {
+	return sequence <= c.sequence
+}
+
+func (c *lockTimeChecker) SignatureHash(scriptCode []byte, hashType byte, sigVersion op.SigVersion) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (c *lockTimeChecker) TaprootSignatureHash(hashType byte, execData *op.TaprootExecutionData) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestCHECKLOCKTIMEVERIFY(t *testing.T) {
+	checker := &lockTimeChecker{lockTime: 100, sequence: 10}
+
+	t.Run("Satisfied", func(t *testing.T) {
+		stack, err := op.CHECKLOCKTIMEVERIFY(newStack([]byte{0x64}), checker)
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+
+		if stack.Size() != 1 {
+			t.Errorf("expected: %v, got: %v", 1, stack.Size())
+		}
+	})
+
+	t.Run("Unsatisfied", func(t *testing.T) {
+		_, err := op.CHECKLOCKTIMEVERIFY(newStack([]byte{0x65}), checker)
+		if err == nil || err.Error() != "unsatisfied locktime" {
+			t.Errorf("expected 'unsatisfied locktime', got %v", err)
+		}
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		_, err := op.CHECKLOCKTIMEVERIFY(newStack([]byte{0x81}), checker)
+		if err == nil || err.Error() != "negative locktime" {
+			t.Errorf("expected 'negative locktime', got %v", err)
+		}
+	})
+}
+
+func TestCHECKSEQUENCEVERIFY(t *testing.T) {
+	checker := &lockTimeChecker{lockTime: 100, sequence: 10}
+
+	t.Run("Unsatisfied", func(t *testing.T) {
+		_, err := op.CHECKSEQUENCEVERIFY(newStack([]byte{0x0b}), checker)
+		if err == nil || err.Error() != "unsatisfied locktime" {
+			t.Errorf("expected 'unsatisfied locktime', got %v", err)
+		}
+	})
+
+	t.Run("Disable flag makes it a NOP", func(t *testing.T) {
+		_, err := op.CHECKSEQUENCEVERIFY(newStack([]byte{0x00, 0x00, 0x00, 0x80, 0x00}), checker)
+		if err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	})
 }
@@ -0,0 +1,286 @@
+package op
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+// Half the order of the curve, the highest S value allowed by VerifyLowS.
+var halfOrder = new(big.Int).Rsh(ecc.Secp256k1.N, 1)
+
+// Checks the encoding of the signature and public key before verifying the
+// signature, which has the hash type as its last byte, against the hash
+// given by sigHash. The signatures are removed from the signed script.
+func checkSignature(flags VerifyFlags, signature, secPubKey []byte, sigHash SigHashFunc, signatures [][]byte) (bool, error) {
+	if err := checkSignatureEncoding(signature, flags); err != nil {
+		return false, err
+	}
+
+	if err := checkPubKeyEncoding(secPubKey, flags); err != nil {
+		return false, err
+	}
+
+	if len(signature) == 0 {
+		return false, nil
+	}
+
+	z, err := sigHash(signature[len(signature)-1], signatures)
+	if err != nil {
+		return false, err
+	}
+
+	return verifySignature(signature, secPubKey, new(big.Int).SetBytes(z))
+}
+
+// Checks the encoding of a signature, with the hash type as its last byte,
+// against the rules selected by flags. An empty signature is always allowed,
+// as it is the way to make a signature check fail on purpose.
+func checkSignatureEncoding(signature []byte, flags VerifyFlags) error {
+	if len(signature) == 0 {
+		return nil
+	}
+
+	strictDER := flags&(VerifyDERSig|VerifyLowS|VerifyStrictEnc) != 0
+	if strictDER && !isValidSignatureEncoding(signature) {
+		return newError(ErrSigDER, "non-canonical DER signature")
+	}
+
+	if flags.Has(VerifyLowS) && !isLowS(signature) {
+		return newError(ErrSigHighS, "non-canonical S value in signature")
+	}
+
+	if flags.Has(VerifyStrictEnc) && !isDefinedHashType(signature) {
+		return newError(ErrSigHashType, "undefined hash type in signature")
+	}
+
+	return nil
+}
+
+// Checks the encoding of a public key against the rules selected by flags.
+func checkPubKeyEncoding(secPubKey []byte, flags VerifyFlags) error {
+	if flags.Has(VerifyStrictEnc) && !isCompressedOrUncompressed(secPubKey) {
+		return newError(ErrPubKeyType, "public key is neither compressed nor uncompressed")
+	}
+
+	if flags.Has(VerifyWitnessPubKeyType) && (len(secPubKey) != 33 || !isCompressedOrUncompressed(secPubKey)) {
+		return newError(ErrWitnessPubKeyType, "public key is not compressed")
+	}
+
+	return nil
+}
+
+// Returns whether the signature, with the hash type as its last byte, is
+// strictly DER encoded as described in BIP 66:
+//
+//	0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S] [sighash]
+func isValidSignatureEncoding(signature []byte) bool {
+	length := len(signature)
+
+	// The shortest signature has one byte values, the longest 33 byte values
+	if length < 9 || length > 73 {
+		return false
+	}
+
+	if signature[0] != 0x30 || int(signature[1]) != length-3 {
+		return false
+	}
+
+	rLength := int(signature[3])
+	if 5+rLength >= length {
+		return false
+	}
+
+	sLength := int(signature[5+rLength])
+	if rLength+sLength+7 != length {
+		return false
+	}
+
+	// R must be a positive integer without unnecessary leading zeros
+	if signature[2] != 0x02 || rLength == 0 || signature[4]&0x80 != 0 {
+		return false
+	}
+
+	if rLength > 1 && signature[4] == 0x00 && signature[5]&0x80 == 0 {
+		return false
+	}
+
+	// S must be a positive integer without unnecessary leading zeros
+	if signature[rLength+4] != 0x02 || sLength == 0 || signature[rLength+6]&0x80 != 0 {
+		return false
+	}
+
+	if sLength > 1 && signature[rLength+6] == 0x00 && signature[rLength+7]&0x80 == 0 {
+		return false
+	}
+
+	return true
+}
+
+// Returns whether the S value of a strictly DER encoded signature is at
+// most half the curve order.
+func isLowS(signature []byte) bool {
+	rLength := int(signature[3])
+	sLength := int(signature[5+rLength])
+	s := new(big.Int).SetBytes(signature[rLength+6 : rLength+6+sLength])
+
+	return s.Cmp(halfOrder) <= 0
+}
+
+// Returns whether the hash type of the signature is SIGHASH_ALL,
+// SIGHASH_NONE or SIGHASH_SINGLE, optionally with SIGHASH_ANYONECANPAY.
+func isDefinedHashType(signature []byte) bool {
+	hashType := signature[len(signature)-1] &^ 0x80
+
+	return hashType >= 0x01 && hashType <= 0x03
+}
+
+func isCompressedOrUncompressed(secPubKey []byte) bool {
+	switch len(secPubKey) {
+	case 33:
+		return secPubKey[0] == 0x02 || secPubKey[0] == 0x03
+	case 65:
+		return secPubKey[0] == 0x04
+	}
+
+	return false
+}
+
+// Verifies a signature, with the hash type as its last byte, against the
+// SEC encoded public key. Signatures and public keys that cannot be parsed
+// are not valid.
+func verifySignature(signature, secPubKey []byte, z *big.Int) (bool, error) {
+	if len(signature) == 0 {
+		return false, nil
+	}
+
+	point, err := parsePubKey(secPubKey)
+	if err != nil {
+		return false, nil
+	}
+
+	sig, ok := parseLaxDER(signature[:len(signature)-1])
+	if !ok {
+		return false, nil
+	}
+
+	return point.Verify(z, sig)
+}
+
+// Parses a SEC encoded public key, also accepting the hybrid encoding, which
+// is an uncompressed key where the prefix is 0x06 or 0x07 depending on
+// whether y is even or odd.
+func parsePubKey(secPubKey []byte) (*ecc.S256Point, error) {
+	if len(secPubKey) == 65 && (secPubKey[0] == 0x06 || secPubKey[0] == 0x07) {
+		isOdd := secPubKey[64]&1 == 1
+		if isOdd != (secPubKey[0] == 0x07) {
+			return nil, fmt.Errorf("invalid hybrid public key")
+		}
+
+		uncompressed := append([]byte{0x04}, secPubKey[1:]...)
+
+		return ecc.Parse(uncompressed)
+	}
+
+	return ecc.Parse(secPubKey)
+}
+
+// Parses a DER signature the way OpenSSL did before BIP 66, which accepts
+// lengths with extra bytes, padded integers and trailing garbage. Integers
+// that do not fit in 32 bytes give a signature that never verifies.
+func parseLaxDER(der []byte) (*ecc.Signature, bool) {
+	position := 0
+
+	// Reads a tag followed by a length, where the length may be encoded in
+	// several bytes.
+	readLength := func() (int, bool) {
+		if position == len(der) {
+			return 0, false
+		}
+		length := int(der[position])
+		position++
+
+		if length&0x80 == 0 {
+			return length, true
+		}
+
+		lengthSize := length - 0x80
+		if lengthSize > len(der)-position {
+			return 0, false
+		}
+
+		for lengthSize > 0 && der[position] == 0 {
+			position++
+			lengthSize--
+		}
+
+		if lengthSize >= 8 {
+			return 0, false
+		}
+
+		length = 0
+		for ; lengthSize > 0; lengthSize-- {
+			length = length<<8 + int(der[position])
+			position++
+		}
+
+		return length, true
+	}
+
+	readInteger := func() ([]byte, bool) {
+		if position == len(der) || der[position] != 0x02 {
+			return nil, false
+		}
+		position++
+
+		length, ok := readLength()
+		if !ok || length > len(der)-position {
+			return nil, false
+		}
+
+		integer := der[position : position+length]
+		position += length
+
+		// Leading zeros are ignored
+		for len(integer) > 0 && integer[0] == 0 {
+			integer = integer[1:]
+		}
+
+		return integer, true
+	}
+
+	if len(der) == 0 || der[0] != 0x30 {
+		return nil, false
+	}
+	position++
+
+	// The length of the sequence is ignored, only the size of it is checked.
+	if position == len(der) {
+		return nil, false
+	}
+	sequenceLength := int(der[position])
+	position++
+	if sequenceLength&0x80 != 0 {
+		if sequenceLength-0x80 > len(der)-position {
+			return nil, false
+		}
+		position += sequenceLength - 0x80
+	}
+
+	r, ok := readInteger()
+	if !ok {
+		return nil, false
+	}
+
+	s, ok := readInteger()
+	if !ok {
+		return nil, false
+	}
+
+	if len(r) > 32 || len(s) > 32 {
+		return ecc.NewSignature(big.NewInt(0), big.NewInt(0)), true
+	}
+
+	return ecc.NewSignature(new(big.Int).SetBytes(r), new(big.Int).SetBytes(s)), true
+}
@@ -0,0 +1,128 @@
+package op_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+func TestCHECKSIGFlags(t *testing.T) {
+	z, _ := new(big.Int).SetString("7c076ff316692a3d7eb3c3bb0f8b1488cf72e1afcd929e29307032997a838a3d", 16)
+	secBytes, _ := hex.DecodeString("04887387e452b8eacc4acfde10d9aaf7f6d9a0f975aabb10d006e4da568744d06c61de6d95231cd89026e286df3b6ae4a894a3378e393e93a0f45b666329a0ae34")
+	// The S value of this signature is higher than half the curve order
+	highSBytes, _ := hex.DecodeString("3045022000eff69ef2b1bd93a66ed5219add4fb51e11a840f404876325a1e8ffe0529a2c022100c7207fee197d27c618aea621406f6bf5ef6fca38681d82b2f06fddbdce6feab601")
+
+	highS, _ := ecc.ParseDER(highSBytes[:len(highSBytes)-1])
+	lowS := ecc.NewSignature(highS.R, new(big.Int).Sub(ecc.Secp256k1.N, highS.S))
+	lowSBytes := append(lowS.DER(), 0x01)
+
+	undefinedHashType := append(append([]byte{}, lowSBytes[:len(lowSBytes)-1]...), 0x05)
+	negativeR, _ := hex.DecodeString("300602018102010101")
+	hybridKey := append([]byte{0x06}, secBytes[1:]...)
+
+	testCases := []struct {
+		name      string
+		flags     op.VerifyFlags
+		signature []byte
+		sec       []byte
+		z         *big.Int
+		expected  op.ErrorCode
+	}{
+		{"High S value", op.VerifyLowS, highSBytes, secBytes, z, op.ErrSigHighS},
+		{"Undefined hash type", op.VerifyStrictEnc, undefinedHashType, secBytes, z, op.ErrSigHashType},
+		{"Negative R value", op.VerifyDERSig, negativeR, secBytes, z, op.ErrSigDER},
+		{"Hybrid public key", op.VerifyStrictEnc, lowSBytes, hybridKey, z, op.ErrPubKeyType},
+		{"Failing signature that is not empty", op.VerifyNullFail, lowSBytes, secBytes, big.NewInt(1), op.ErrNullFail},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := op.CHECKSIG(newStackWithFlags(tc.flags, tc.signature, tc.sec), fixedSigHash(tc.z))
+
+			var opErr *op.Error
+			if !errors.As(err, &opErr) || opErr.Code != tc.expected {
+				t.Errorf("expected %s, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	t.Run("Low S value", func(t *testing.T) {
+		stack, err := op.CHECKSIG(newStackWithFlags(op.StandardVerifyFlags, lowSBytes, secBytes), fixedSigHash(z))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		instruction, _ := stack.Pop()
+		if instruction.Hex() != "01" {
+			t.Errorf("expected: %v, got: %v", "01", instruction.Hex())
+		}
+	})
+
+	t.Run("Empty signature with VerifyNullFail", func(t *testing.T) {
+		stack, err := op.CHECKSIG(newStackWithFlags(op.StandardVerifyFlags, []byte{}, secBytes), fixedSigHash(z))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		instruction, _ := stack.Pop()
+		if instruction.Hex() != "" {
+			t.Errorf("expected: %v, got: %v", "", instruction.Hex())
+		}
+	})
+
+	t.Run("Failing OP_CHECKMULTISIG with VerifyNullFail", func(t *testing.T) {
+		stack := newStackWithFlags(op.VerifyNullFail, []byte{}, lowSBytes, []byte{0x01}, secBytes, []byte{0x01})
+
+		_, err := op.CHECKMULTISIG(stack, fixedSigHash(big.NewInt(1)))
+
+		var opErr *op.Error
+		if !errors.As(err, &opErr) || opErr.Code != op.ErrNullFail {
+			t.Errorf("expected %s, got %v", op.ErrNullFail, err)
+		}
+	})
+}
+
+func TestMinimalData(t *testing.T) {
+	t.Run("Numbers must be minimally encoded", func(t *testing.T) {
+		_, err := op.ADD(newStackWithFlags(op.VerifyMinimalData, []byte{0x01, 0x00}, []byte{0x01}))
+
+		var opErr *op.Error
+		if !errors.As(err, &opErr) || opErr.Code != op.ErrMinimalNumber {
+			t.Errorf("expected %s, got %v", op.ErrMinimalNumber, err)
+		}
+	})
+
+	t.Run("Numbers are not checked without VerifyMinimalData", func(t *testing.T) {
+		stack, err := op.ADD(newStack([]byte{0x01, 0x00}, []byte{0x01}))
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		instruction, _ := stack.Pop()
+		if instruction.Hex() != "02" {
+			t.Errorf("expected: %v, got: %v", "02", instruction.Hex())
+		}
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	testCases := []struct {
+		code     op.ErrorCode
+		expected string
+	}{
+		{op.ErrEvalFalse, "EVAL_FALSE"},
+		{op.ErrInvalidStackOperation, "INVALID_STACK_OPERATION"},
+		{op.ErrSigNullDummy, "SIG_NULLDUMMY"},
+		{op.ErrorCode(-1), "UNKNOWN_ERROR"},
+	}
+
+	for _, tc := range testCases {
+		if tc.code.String() != tc.expected {
+			t.Errorf("expected: %s, got: %s", tc.expected, tc.code.String())
+		}
+	}
+}
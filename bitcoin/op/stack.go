@@ -8,16 +8,35 @@ import (
 // Instruction represents a single instruction or a value in a script.
 type Instruction struct {
 	instruction []byte
+	isOpCode    bool
+	// The op code that pushed the data, or 0 when it is not known
+	pushOpCode byte
 }
 
+// The maximum size in bytes of an element on the stack.
+const MaxScriptElementSize = 520
+
 func NewInstruction(instruction []byte) (*Instruction, error) {
 	instructionSize := len(instruction)
 
-	if instructionSize > 520 {
-		return nil, fmt.Errorf("instruction too large")
+	if instructionSize > MaxScriptElementSize {
+		return nil, newError(ErrPushSize, "instruction too large")
 	}
 
-	return &Instruction{instruction}, nil
+	return &Instruction{instruction, false, 0}, nil
+}
+
+// NewPushData returns the data element pushed by pushOpCode, which is either
+// the length of the data or one of OP_PUSHDATA1, OP_PUSHDATA2 and
+// OP_PUSHDATA4. The size is not limited here, as that is enforced when the
+// script is evaluated.
+func NewPushData(pushOpCode byte, data []byte) *Instruction {
+	return &Instruction{data, false, pushOpCode}
+}
+
+// NewOpCode returns the instruction for a single op code.
+func NewOpCode(opCode byte) *Instruction {
+	return &Instruction{[]byte{opCode}, true, 0}
 }
 
 func (i *Instruction) Hex() string {
@@ -25,6 +44,10 @@ func (i *Instruction) Hex() string {
 }
 
 func (e *Instruction) Equals(other *Instruction) bool {
+	if e.isOpCode != other.isOpCode {
+		return false
+	}
+
 	if len(e.instruction) != len(other.instruction) {
 		return false
 	}
@@ -48,12 +71,80 @@ func (i *Instruction) IsZero() bool {
 	return true
 }
 
+// Returns the boolean value of the element, where an empty array, zero and
+// negative zero are false and everything else is true.
+func (i *Instruction) IsTrue() bool {
+	for index, b := range i.instruction {
+		if b != 0 {
+			// Negative zero is still false
+			if index == len(i.instruction)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
 func (i *Instruction) IsOpCode() bool {
-	if len(i.instruction) != 1 {
+	return i.isOpCode
+}
+
+// Returns the op code of the instruction, or -1 if it is a data element.
+func (i *Instruction) OpCode() int {
+	if !i.isOpCode {
+		return -1
+	}
+
+	return int(i.instruction[0])
+}
+
+// Returns the op code used to push a data element, which is the length of
+// the data or one of OP_PUSHDATA1, OP_PUSHDATA2 and OP_PUSHDATA4.
+func (i *Instruction) PushOpCode() byte {
+	if i.pushOpCode != 0 {
+		return i.pushOpCode
+	}
+
+	length := len(i.instruction)
+	switch {
+	case length < 76:
+		return byte(length)
+	case length <= 0xff:
+		return 76
+	case length <= 0xffff:
+		return 77
+	default:
+		return 78
+	}
+}
+
+// Returns whether the data element is pushed with the smallest possible
+// op code, as required by VerifyMinimalData.
+func (i *Instruction) IsMinimalPush() bool {
+	length := len(i.instruction)
+	pushOpCode := i.PushOpCode()
+
+	switch {
+	case length == 0:
+		// Should have been OP_0
+		return false
+	case length == 1 && i.instruction[0] >= 1 && i.instruction[0] <= 16:
+		// Should have been OP_1 to OP_16
 		return false
+	case length == 1 && i.instruction[0] == 0x81:
+		// Should have been OP_1NEGATE
+		return false
+	case length < 76:
+		return int(pushOpCode) == length
+	case length <= 0xff:
+		return pushOpCode == 76
+	case length <= 0xffff:
+		return pushOpCode == 77
 	}
 
-	return i.instruction[0] >= 0x01 && i.instruction[0] <= 0x4b
+	return true
 }
 
 func (i *Instruction) Length() int {
@@ -64,17 +155,128 @@ func (i *Instruction) Bytes() []byte {
 	return i.instruction
 }
 
+// Returns the element decoded as a script number.
 func (i *Instruction) Int64() int64 {
-	n := big.NewInt(0).SetBytes(i.instruction)
-	return n.Int64()
+	return DecodeNum(i.instruction)
+}
+
+// Returns the element decoded as a script number, failing when it is
+// longer than maxSize bytes, or when it is not minimally encoded and
+// requireMinimal is set.
+func (i *Instruction) number(maxSize int, requireMinimal bool) (int64, error) {
+	if len(i.instruction) > maxSize {
+		return 0, newError(ErrNumberOverflow, "script number overflow")
+	}
+
+	if requireMinimal && !isMinimalNumber(i.instruction) {
+		return 0, newError(ErrMinimalNumber, "non-minimally encoded script number")
+	}
+
+	return DecodeNum(i.instruction), nil
+}
+
+// Encodes a number as a script number, little endian with the sign in the
+// most significant bit.
+func EncodeNum(num int64) []byte {
+	if num == 0 {
+		return []byte{}
+	}
+
+	absolute := new(big.Int).Abs(big.NewInt(num))
+	negative := num < 0
+	result := make([]byte, 0)
+
+	for absolute.Sign() > 0 {
+		result = append(result, byte(absolute.Uint64()&0xff))
+		absolute.Rsh(absolute, 8)
+	}
+
+	// If the top bit is set, an extra byte is needed for the sign.
+	if result[len(result)-1]&0x80 != 0 {
+		if negative {
+			result = append(result, 0x80)
+		} else {
+			result = append(result, 0x00)
+		}
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+
+	return result
+}
+
+// Returns whether the script number has no unnecessary zero bytes.
+func isMinimalNumber(element []byte) bool {
+	length := len(element)
+	if length == 0 {
+		return true
+	}
+
+	// The most significant byte may only be zero, apart from the sign bit,
+	// when the sign bit would otherwise end up in the byte below it.
+	if element[length-1]&0x7f == 0 {
+		if length == 1 || element[length-2]&0x80 == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Decodes a script number, little endian with the sign in the most
+// significant bit.
+func DecodeNum(element []byte) int64 {
+	if len(element) == 0 {
+		return 0
+	}
+
+	bigEndian := make([]byte, len(element))
+	for i, b := range element {
+		bigEndian[len(element)-1-i] = b
+	}
+
+	negative := bigEndian[0]&0x80 != 0
+	bigEndian[0] &= 0x7f
+
+	result := new(big.Int).SetBytes(bigEndian).Int64()
+	if negative {
+		return -result
+	}
+
+	return result
 }
 
 type Stack struct {
 	stack []Instruction
+	// The alt stack used by OP_TOALTSTACK and OP_FROMALTSTACK
+	altStack []Instruction
+	// One entry per open OP_IF/OP_NOTIF, telling if that branch is executed
+	conditions []bool
+	// The rules enforced by the op codes
+	flags VerifyFlags
 }
 
 func NewStack() *Stack {
-	return &Stack{make([]Instruction, 0)}
+	return NewStackWithFlags(VerifyNone)
+}
+
+// NewStackWithFlags returns an empty stack where the op codes enforce the
+// rules selected by flags.
+func NewStackWithFlags(flags VerifyFlags) *Stack {
+	return &Stack{make([]Instruction, 0), make([]Instruction, 0), make([]bool, 0), flags}
+}
+
+func (s *Stack) Flags() VerifyFlags {
+	return s.flags
+}
+
+// Returns a copy of the main stack, with an empty alt stack and no open
+// conditionals.
+func (s *Stack) Copy() *Stack {
+	stack := NewStackWithFlags(s.flags)
+	stack.stack = append(stack.stack, s.stack...)
+
+	return stack
 }
 
 func (s *Stack) Push(element *Instruction) {
@@ -87,7 +289,7 @@ func (s *Stack) Size() int {
 
 func (s *Stack) Pop() (*Instruction, error) {
 	if s.Size() < 1 {
-		return nil, fmt.Errorf("invalid stack")
+		return nil, newError(ErrInvalidStackOperation, "invalid stack")
 	}
 
 	element := s.stack[s.Size()-1]
@@ -98,7 +300,7 @@ func (s *Stack) Pop() (*Instruction, error) {
 
 func (s *Stack) Peek() (*Instruction, error) {
 	if s.Size() < 1 {
-		return nil, fmt.Errorf("stack is empty")
+		return nil, newError(ErrInvalidStackOperation, "stack is empty")
 	}
 
 	return &s.stack[s.Size()-1], nil
@@ -106,8 +308,56 @@ func (s *Stack) Peek() (*Instruction, error) {
 
 func (s *Stack) PeekN(n int) (*Instruction, error) {
 	if s.Size() < n+1 {
-		return nil, fmt.Errorf("invalid stack")
+		return nil, newError(ErrInvalidStackOperation, "invalid stack")
 	}
 
 	return &s.stack[s.Size()-n-1], nil
 }
+
+// Removes the item n back in the stack, where 0 is the top item.
+func (s *Stack) RemoveN(n int) (*Instruction, error) {
+	if n < 0 || s.Size() < n+1 {
+		return nil, newError(ErrInvalidStackOperation, "invalid stack")
+	}
+
+	index := s.Size() - n - 1
+	element := s.stack[index]
+	s.stack = append(s.stack[:index], s.stack[index+1:]...)
+
+	return &element, nil
+}
+
+func (s *Stack) PushAlt(element *Instruction) {
+	s.altStack = append(s.altStack, *element)
+}
+
+func (s *Stack) AltSize() int {
+	return len(s.altStack)
+}
+
+func (s *Stack) PopAlt() (*Instruction, error) {
+	if s.AltSize() < 1 {
+		return nil, newError(ErrInvalidAltStackOperation, "invalid alt stack")
+	}
+
+	element := s.altStack[s.AltSize()-1]
+	s.altStack = s.altStack[:s.AltSize()-1]
+
+	return &element, nil
+}
+
+// Returns whether the current branch of OP_IF/OP_NOTIF/OP_ELSE is executed.
+func (s *Stack) IsExecuting() bool {
+	for _, condition := range s.conditions {
+		if !condition {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns whether every OP_IF/OP_NOTIF has been closed by an OP_ENDIF.
+func (s *Stack) IsBalanced() bool {
+	return len(s.conditions) == 0
+}
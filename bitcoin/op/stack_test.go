@@ -1,6 +1,7 @@
 package op_test
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
@@ -58,6 +59,43 @@ func TestInstruction(t *testing.T) {
 		}
 	})
 
+	t.Run("OpCode", func(t *testing.T) {
+		opCode := op.NewOpCode(0x76)
+		data, _ := op.NewInstruction([]byte{0x76})
+
+		if !opCode.IsOpCode() || opCode.OpCode() != 0x76 {
+			t.Errorf("expected: op code 0x76, got: %v", opCode.OpCode())
+		}
+
+		if data.IsOpCode() || data.OpCode() != -1 {
+			t.Errorf("expected: data element, got: %v", data.OpCode())
+		}
+
+		if opCode.Equals(data) {
+			t.Errorf("expected: false, got true")
+		}
+	})
+
+	t.Run("IsTrue", func(t *testing.T) {
+		testCases := []struct {
+			data     []byte
+			expected bool
+		}{
+			{[]byte{}, false},
+			{[]byte{0x00}, false},
+			{[]byte{0x00, 0x80}, false},
+			{[]byte{0x80, 0x00}, true},
+			{[]byte{0x01}, true},
+		}
+
+		for _, tc := range testCases {
+			element, _ := op.NewInstruction(tc.data)
+			if element.IsTrue() != tc.expected {
+				t.Errorf("%x: expected: %v, got: %v", tc.data, tc.expected, element.IsTrue())
+			}
+		}
+	})
+
 	t.Run("IsZero", func(t *testing.T) {
 		element1, _ := op.NewInstruction([]byte{0x00})
 		element2, _ := op.NewInstruction([]byte{0x01})
@@ -125,6 +163,30 @@ func TestStack(t *testing.T) {
 		}
 	})
 
+	t.Run("RemoveN", func(t *testing.T) {
+		element1, _ := op.NewInstruction([]byte{0x01})
+		element2, _ := op.NewInstruction([]byte{0x02})
+		stack := op.NewStack()
+		stack.Push(element1)
+		stack.Push(element2)
+
+		removedElement, err := stack.RemoveN(1)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !removedElement.Equals(element1) {
+			t.Fatalf("expected: %v, got: %v", element1, removedElement)
+		}
+		if stack.Size() != 1 {
+			t.Errorf("expected %v, got %v", 1, stack.Size())
+		}
+
+		_, err = stack.RemoveN(1)
+		if err == nil {
+			t.Errorf("expected error, got nil")
+		}
+	})
+
 	t.Run("PeekN", func(t *testing.T) {
 		element1, _ := op.NewInstruction([]byte{0x01})
 		element2, _ := op.NewInstruction([]byte{0x02})
@@ -144,3 +206,70 @@ func TestStack(t *testing.T) {
 		}
 	})
 }
+
+func TestNum(t *testing.T) {
+	testCases := []struct {
+		number  int64
+		encoded string
+	}{
+		{0, ""},
+		{1, "01"},
+		{-1, "81"},
+		{127, "7f"},
+		{128, "8000"},
+		{-128, "8080"},
+		{255, "ff00"},
+		{256, "0001"},
+		{-32767, "ffff"},
+		{2147483647, "ffffff7f"},
+		{-2147483648, "0000008080"},
+	}
+
+	for _, tc := range testCases {
+		encoded := hex.EncodeToString(op.EncodeNum(tc.number))
+		if encoded != tc.encoded {
+			t.Errorf("EncodeNum(%d): expected: %v, got: %v", tc.number, tc.encoded, encoded)
+		}
+
+		data, _ := hex.DecodeString(tc.encoded)
+		decoded := op.DecodeNum(data)
+		if decoded != tc.number {
+			t.Errorf("DecodeNum(%s): expected: %v, got: %v", tc.encoded, tc.number, decoded)
+		}
+	}
+}
+
+func TestIsMinimalPush(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pushOpCode byte
+		data       []byte
+		expected   bool
+	}{
+		{"Direct push", 0x02, []byte{0x01, 0x02}, true},
+		{"Small number instead of OP_5", 0x01, []byte{0x05}, false},
+		{"-1 instead of OP_1NEGATE", 0x01, []byte{0x81}, false},
+		{"Empty OP_PUSHDATA1 instead of OP_0", 0x4c, []byte{}, false},
+		{"OP_PUSHDATA1 for 2 bytes", 0x4c, []byte{0x01, 0x02}, false},
+		{"OP_PUSHDATA1 for 76 bytes", 0x4c, make([]byte, 76), true},
+		{"OP_PUSHDATA2 for 255 bytes", 0x4d, make([]byte, 255), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			instruction := op.NewPushData(tc.pushOpCode, tc.data)
+
+			if instruction.IsMinimalPush() != tc.expected {
+				t.Errorf("expected: %v, got: %v", tc.expected, instruction.IsMinimalPush())
+			}
+		})
+	}
+
+	t.Run("Push op code of a new instruction", func(t *testing.T) {
+		instruction, _ := op.NewInstruction(make([]byte, 300))
+
+		if instruction.PushOpCode() != 0x4d {
+			t.Errorf("expected: %x, got: %x", 0x4d, instruction.PushOpCode())
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package op
+
+import (
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+// The validation weight used by every signature check of a tapscript that
+// is not empty, see BIP 342.
+const ValidationWeightPerSigOp = 50
+
+// The validation weight of a tapscript on top of the size of the witness.
+const ValidationWeightOffset = 50
+
+// TaprootSigHashFunc returns the hash that a taproot signature with hashType
+// signs.
+type TaprootSigHashFunc func(hashType byte) ([]byte, error)
+
+// Tapscript holds what the signature op codes of a tapscript need from the
+// script being executed, see BIP 342.
+type Tapscript struct {
+	// Gives the signature hash of the spending transaction
+	Checker TxChecker
+	// What the signatures commit to besides the transaction, where the
+	// interpreter keeps the position of the last OP_CODESEPARATOR up to date
+	ExecutionData TaprootExecutionData
+	// The validation weight left for the signature checks
+	ValidationWeightLeft int64
+}
+
+// Verifies the Schnorr signature, which is popped after the public key.
+// Pushes 1 if the signature is valid and 0 if the signature is empty. A
+// signature that is not empty must be valid.
+func (t *Tapscript) CHECKSIG(stack *Stack) (*Stack, error) {
+	if stack.Size() < 2 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	pubKey, err := stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	success, err := t.checkSignature(signature.instruction, pubKey.instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	pushBool(stack, success)
+
+	return stack, nil
+}
+
+// Same as OP_CHECKSIG, but OP_VERIFY is executed afterward.
+func (t *Tapscript) CHECKSIGVERIFY(stack *Stack) (*Stack, error) {
+	stack, err := t.CHECKSIG(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	return verify(stack, ErrCheckSigVerify)
+}
+
+// Pops a public key, a number and a signature, and pushes the number plus
+// one if the signature is valid or the number if the signature is empty.
+// It replaces OP_CHECKMULTISIG in tapscript, where a k-of-n multisig is
+// written as <pubkey1> OP_CHECKSIG ... <pubkeyn> OP_CHECKSIGADD <k>
+// OP_NUMEQUAL.
+func (t *Tapscript) CHECKSIGADD(stack *Stack) (*Stack, error) {
+	if stack.Size() < 3 {
+		return nil, newError(ErrInvalidStackOperation, "stack too small")
+	}
+
+	pubKey, err := stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := popNumber(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	success, err := t.checkSignature(signature.instruction, pubKey.instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	pushNumber(stack, n+boolToNumber(success))
+
+	return stack, nil
+}
+
+// Returns whether the signature is not empty, after checking it against
+// the public key. Public keys that are not 32 bytes are reserved for soft
+// forks and accept any signature.
+func (t *Tapscript) checkSignature(signature, pubKey []byte) (bool, error) {
+	success := len(signature) > 0
+
+	if success {
+		t.ValidationWeightLeft -= ValidationWeightPerSigOp
+		if t.ValidationWeightLeft < 0 {
+			return false, newError(ErrTapscriptValidationWeight, "too many signature checks for the size of the witness")
+		}
+	}
+
+	if len(pubKey) == 0 {
+		return false, newError(ErrPubKeyType, "public key is empty")
+	}
+
+	if len(pubKey) == 32 && success {
+		sigHash := func(hashType byte) ([]byte, error) {
+			if t.Checker == nil {
+				return nil, newError(ErrUnknown, "no transaction to check the signature against")
+			}
+
+			return t.Checker.TaprootSignatureHash(hashType, &t.ExecutionData)
+		}
+
+		if err := CheckSchnorrSignature(signature, pubKey, sigHash); err != nil {
+			return false, err
+		}
+	}
+
+	return success, nil
+}
+
+// CheckSchnorrSignature verifies a BIP 340 signature against a 32 byte
+// x-only public key. The signature is 64 bytes when it has the default hash
+// type, otherwise the hash type is appended as the 65th byte. An error tells
+// why the signature is not valid.
+func CheckSchnorrSignature(signature, pubKey []byte, sigHash TaprootSigHashFunc) error {
+	hashType := byte(0x00)
+
+	switch len(signature) {
+	case 64:
+	case 65:
+		hashType = signature[64]
+		// The default hash type must not be given explicitly
+		if hashType == 0x00 {
+			return newError(ErrSchnorrSigHashType, "explicit default hash type in signature")
+		}
+		signature = signature[:64]
+	default:
+		return newError(ErrSchnorrSigSize, "invalid Schnorr signature size")
+	}
+
+	if !isDefinedTaprootHashType(hashType) {
+		return newError(ErrSchnorrSigHashType, "undefined hash type in signature")
+	}
+
+	z, err := sigHash(hashType)
+	if err != nil {
+		return err
+	}
+
+	point, err := ecc.ParseXOnly(pubKey)
+	if err != nil {
+		return newError(ErrSchnorrSig, "invalid Schnorr signature")
+	}
+
+	sig, err := ecc.ParseSchnorr(signature)
+	if err != nil {
+		return newError(ErrSchnorrSig, "invalid Schnorr signature")
+	}
+
+	valid, err := point.VerifySchnorr(z, sig)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return newError(ErrSchnorrSig, "invalid Schnorr signature")
+	}
+
+	return nil
+}
+
+// Returns whether the hash type is SIGHASH_DEFAULT, SIGHASH_ALL,
+// SIGHASH_NONE or SIGHASH_SINGLE, where the last three may be combined with
+// SIGHASH_ANYONECANPAY.
+func isDefinedTaprootHashType(hashType byte) bool {
+	return hashType == 0x00 || (hashType&^0x80 >= 0x01 && hashType&^0x80 <= 0x03)
+}
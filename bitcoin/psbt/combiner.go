@@ -0,0 +1,74 @@
+package psbt
+
+import "fmt"
+
+// Combine returns the PSBT with the key-value pairs of all of packets, which
+// must be of the same version and transaction. When packets have different
+// values for a key, the value of the first one is kept. This is the Combiner
+// of BIP 174.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("no PSBTs to combine")
+	}
+
+	first := packets[0]
+	id, err := first.Id()
+	if err != nil {
+		return nil, err
+	}
+
+	global, inputs, outputs, err := first.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range packets[1:] {
+		otherId, err := other.Id()
+		if err != nil {
+			return nil, err
+		}
+		if other.Version != first.Version || otherId != id {
+			return nil, fmt.Errorf("PSBTs are not for the same transaction")
+		}
+
+		otherGlobal, otherInputs, otherOutputs, err := other.encode()
+		if err != nil {
+			return nil, err
+		}
+
+		global = mergeKeyValues(global, otherGlobal)
+		for i := range inputs {
+			inputs[i] = mergeKeyValues(inputs[i], otherInputs[i])
+		}
+		for i := range outputs {
+			outputs[i] = mergeKeyValues(outputs[i], otherOutputs[i])
+		}
+	}
+
+	p, unsignedTx, _, _, err := decodeGlobal(global, first.params)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.decodeMaps(unsignedTx, inputs, outputs); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Returns the key-value pairs of kvs followed by the ones of other whose
+// keys are not in kvs
+func mergeKeyValues(kvs, other []keyValue) []keyValue {
+	seen := make(map[string]bool)
+	for _, kv := range kvs {
+		seen[string(kv.key)] = true
+	}
+
+	for _, kv := range other {
+		if !seen[string(kv.key)] {
+			kvs = append(kvs, kv)
+		}
+	}
+
+	return kvs
+}
@@ -0,0 +1,184 @@
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// Finalize finalizes all inputs, see FinalizeInput.
+func (p *Packet) Finalize() error {
+	for i := range p.Inputs {
+		if err := p.FinalizeInput(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FinalizeInput builds the final scriptSig and witness of the input at
+// inputIndex from its partial signatures, and removes the fields that were
+// only needed to sign it. Inputs of public key hashes, multisig scripts and
+// the key path of taproot outputs can be finalized, and the input must
+// verify. This is the Input Finalizer of BIP 174.
+func (p *Packet) FinalizeInput(inputIndex int) error {
+	in := p.Inputs[inputIndex]
+	if in.isFinal() {
+		return nil
+	}
+
+	spent, err := p.spentOutput(inputIndex)
+	if err != nil {
+		return err
+	}
+
+	scriptSig := make([][]byte, 0)
+	var witness [][]byte
+	switch {
+	case spent.isP2TR():
+		if in.TaprootKeySig == nil {
+			return fmt.Errorf("input %d has no taproot key signature", inputIndex)
+		}
+		witness = [][]byte{in.TaprootKeySig}
+	case spent.isP2WPKH():
+		witness, err = in.publicKeyHashStack(spent.program)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inputIndex, err)
+		}
+	case spent.isP2WSH():
+		witness, err = in.multisigStack(in.WitnessScript)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inputIndex, err)
+		}
+		witness = append(witness, encodeScript(in.WitnessScript))
+	case spent.isWitness:
+		return fmt.Errorf("input %d spends an output of witness version %d", inputIndex, spent.witnessVersion)
+	case isP2PKHScript(spent.script):
+		scriptSig, err = in.publicKeyHashStack(spent.script.Instructions()[2].Bytes())
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inputIndex, err)
+		}
+	default:
+		scriptSig, err = in.multisigStack(spent.script)
+		if err != nil {
+			return fmt.Errorf("input %d: %w", inputIndex, err)
+		}
+	}
+
+	if spent.isP2SH {
+		scriptSig = append(scriptSig, encodeScript(in.RedeemScript))
+	}
+
+	finalScriptSig, err := bitcoin.ToPushOnlyScript(scriptSig)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return err
+	}
+	tx.Inputs[inputIndex].ScriptSig = finalScriptSig
+	tx.Inputs[inputIndex].Witness = witness
+
+	valid, err := tx.VerifyInput(inputIndex, op.StandardVerifyFlags)
+	if err != nil {
+		return fmt.Errorf("input %d does not verify: %w", inputIndex, err)
+	}
+	if !valid {
+		return fmt.Errorf("input %d does not verify", inputIndex)
+	}
+
+	if len(scriptSig) > 0 {
+		in.FinalScriptSig = finalScriptSig
+	}
+	in.FinalScriptWitness = witness
+
+	in.PartialSigs = nil
+	in.SigHashType = nil
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Derivations = nil
+	in.TaprootKeySig = nil
+	in.TaprootDerivations = nil
+	in.TaprootInternalKey = nil
+	in.TaprootMerkleRoot = nil
+
+	return nil
+}
+
+// Extract returns the signed transaction of a PSBT whose inputs are all
+// finalized. This is the Transaction Extractor of BIP 174.
+func (p *Packet) Extract() (*bitcoin.Tx, error) {
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, in := range p.Inputs {
+		if !in.isFinal() {
+			return nil, fmt.Errorf("input %d is not finalized", i)
+		}
+
+		if in.FinalScriptSig != nil {
+			tx.Inputs[i].ScriptSig = in.FinalScriptSig
+		}
+		tx.Inputs[i].Witness = in.FinalScriptWitness
+	}
+
+	return tx, nil
+}
+
+// Returns the stack <signature> <public key> of the key whose hash160 is
+// h160
+func (in *Input) publicKeyHashStack(h160 []byte) ([][]byte, error) {
+	for _, partialSig := range in.PartialSigs {
+		if bytes.Equal(hash.Hash160(partialSig.PublicKey), h160) {
+			return [][]byte{partialSig.Signature, partialSig.PublicKey}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no signature of the public key hash %x", h160)
+}
+
+// Returns the stack OP_0 <signature>... of a multisig script, with the
+// signatures in the order of the keys of the script
+func (in *Input) multisigStack(script *bitcoin.Script) ([][]byte, error) {
+	required, keys, ok := script.Multisig()
+	if !ok {
+		return nil, fmt.Errorf("script is neither a public key hash nor a multisig script")
+	}
+
+	// The dummy element that OP_CHECKMULTISIG pops
+	stack := [][]byte{{}}
+	for _, key := range keys {
+		for _, partialSig := range in.PartialSigs {
+			if len(stack) <= required && bytes.Equal(partialSig.PublicKey, key) {
+				stack = append(stack, partialSig.Signature)
+			}
+		}
+	}
+
+	if len(stack)-1 < required {
+		return nil, fmt.Errorf("%d of %d signatures", len(stack)-1, required)
+	}
+
+	return stack, nil
+}
+
+// Returns whether the script is OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY
+// OP_CHECKSIG
+func isP2PKHScript(script *bitcoin.Script) bool {
+	instructions := script.Instructions()
+
+	return len(instructions) == 5 &&
+		instructions[0].OpCode() == 0x76 &&
+		instructions[1].OpCode() == 0xa9 &&
+		!instructions[2].IsOpCode() && instructions[2].Length() == 20 &&
+		instructions[3].OpCode() == 0x88 &&
+		instructions[4].OpCode() == 0xac
+}
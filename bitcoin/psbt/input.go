@@ -0,0 +1,304 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// The key types of the input maps
+const (
+	inNonWitnessUTXO         = 0x00
+	inWitnessUTXO            = 0x01
+	inPartialSig             = 0x02
+	inSigHashType            = 0x03
+	inRedeemScript           = 0x04
+	inWitnessScript          = 0x05
+	inBIP32Derivation        = 0x06
+	inFinalScriptSig         = 0x07
+	inFinalScriptWitness     = 0x08
+	inPreviousTxID           = 0x0e
+	inOutputIndex            = 0x0f
+	inSequence               = 0x10
+	inRequiredTimeLockTime   = 0x11
+	inRequiredHeightLockTime = 0x12
+	inTaprootKeySig          = 0x13
+	inTaprootBIP32Derivation = 0x16
+	inTaprootInternalKey     = 0x17
+	inTaprootMerkleRoot      = 0x18
+)
+
+// PartialSig is the signature of an input by one of its public keys, which
+// is in SEC format.
+type PartialSig struct {
+	PublicKey []byte
+	Signature []byte
+}
+
+// Input is what the signers of an input need to know, and the signatures
+// that they have made so far.
+type Input struct {
+	// The whole transaction of the spent output, which is needed to sign
+	// inputs that do not spend segwit outputs
+	NonWitnessUTXO *bitcoin.Tx
+	// The spent output, which is enough to sign segwit inputs
+	WitnessUTXO *bitcoin.TxOutput
+	PartialSigs []PartialSig
+	// The hash type that the input must be signed with, or nil for the
+	// default of the input
+	SigHashType   *bitcoin.SigHashType
+	RedeemScript  *bitcoin.Script
+	WitnessScript *bitcoin.Script
+	Derivations   []KeyDerivation
+	// Set by the Finalizer once the input is complete, which removes the
+	// fields that are only needed to sign it
+	FinalScriptSig     *bitcoin.Script
+	FinalScriptWitness [][]byte
+	// The outpoint of the spent output, in the byte order of the
+	// transaction
+	PreviousTxID []byte
+	OutputIndex  uint32
+	// The sequence of the input, where nil is 0xffffffff
+	Sequence *uint32
+	// The lock times that the input requires, which are only in version 2,
+	// see Packet.LockTime
+	RequiredTimeLockTime   *uint32
+	RequiredHeightLockTime *uint32
+	// The signature of the key path of a taproot input
+	TaprootKeySig      []byte
+	TaprootDerivations []TaprootKeyDerivation
+	// The x-only internal key and the merkle root of the script tree of a
+	// taproot output
+	TaprootInternalKey []byte
+	TaprootMerkleRoot  []byte
+	// The key-value pairs that are not known here
+	Unknowns []Unknown
+}
+
+// Returns whether the input is finalized
+func (in *Input) isFinal() bool {
+	return in.FinalScriptSig != nil || in.FinalScriptWitness != nil
+}
+
+// Decodes the key-value pairs of the input map. The fields of the
+// transaction are only in version 2.
+func (in *Input) decode(kvs []keyValue, version uint32, params *chaincfg.Params) error {
+	hasPreviousTxID, hasOutputIndex := false, false
+	for _, kv := range kvs {
+		keyType, keyData := kv.keyType()
+		if version == 0 && keyType >= inPreviousTxID && keyType <= inRequiredHeightLockTime {
+			return fmt.Errorf("PSBT of version 0 has the input field of type %#02x", keyType)
+		}
+
+		var err error
+		hasKeyData := false
+		switch keyType {
+		case inNonWitnessUTXO:
+			in.NonWitnessUTXO, err = decodeTx(kv.value, params, true)
+		case inWitnessUTXO:
+			in.WitnessUTXO, err = decodeTxOutput(kv.value)
+		case inPartialSig:
+			hasKeyData = true
+			if err = checkPublicKey(keyData); err == nil {
+				in.PartialSigs = append(in.PartialSigs, PartialSig{slices.Clone(keyData), kv.value})
+			}
+		case inSigHashType:
+			var hashType uint32
+			hashType, err = decodeUint32(kv.value)
+			sigHashType := bitcoin.SigHashType(hashType)
+			in.SigHashType = &sigHashType
+		case inRedeemScript:
+			in.RedeemScript, err = bitcoin.ParseRawScript(kv.value)
+		case inWitnessScript:
+			in.WitnessScript, err = bitcoin.ParseRawScript(kv.value)
+		case inBIP32Derivation:
+			hasKeyData = true
+			if err = checkPublicKey(keyData); err == nil {
+				var derivation *Derivation
+				if derivation, err = decodeDerivation(kv.value); err == nil {
+					in.Derivations = append(in.Derivations, KeyDerivation{slices.Clone(keyData), *derivation})
+				}
+			}
+		case inFinalScriptSig:
+			in.FinalScriptSig, err = bitcoin.ParseRawScript(kv.value)
+		case inFinalScriptWitness:
+			in.FinalScriptWitness, err = decodeWitness(kv.value)
+		case inPreviousTxID:
+			hasPreviousTxID = true
+			if len(kv.value) != 32 {
+				err = fmt.Errorf("invalid length %d", len(kv.value))
+			}
+			in.PreviousTxID = kv.value
+		case inOutputIndex:
+			hasOutputIndex = true
+			in.OutputIndex, err = decodeUint32(kv.value)
+		case inSequence:
+			var sequence uint32
+			sequence, err = decodeUint32(kv.value)
+			in.Sequence = &sequence
+		case inRequiredTimeLockTime:
+			var lockTime uint32
+			if lockTime, err = decodeUint32(kv.value); err == nil && lockTime < lockTimeThreshold {
+				err = fmt.Errorf("time lock time %d is a height", lockTime)
+			}
+			in.RequiredTimeLockTime = &lockTime
+		case inRequiredHeightLockTime:
+			var lockTime uint32
+			if lockTime, err = decodeUint32(kv.value); err == nil && (lockTime == 0 || lockTime >= lockTimeThreshold) {
+				err = fmt.Errorf("invalid height lock time %d", lockTime)
+			}
+			in.RequiredHeightLockTime = &lockTime
+		case inTaprootKeySig:
+			if len(kv.value) != 64 && len(kv.value) != 65 {
+				err = fmt.Errorf("invalid signature length %d", len(kv.value))
+			}
+			in.TaprootKeySig = kv.value
+		case inTaprootBIP32Derivation:
+			hasKeyData = true
+			if len(keyData) != 32 {
+				err = fmt.Errorf("invalid x-only public key length %d", len(keyData))
+				break
+			}
+			leafHashes, derivation, decodeErr := decodeTaprootDerivation(kv.value)
+			if err = decodeErr; err == nil {
+				in.TaprootDerivations = append(in.TaprootDerivations, TaprootKeyDerivation{slices.Clone(keyData), leafHashes, *derivation})
+			}
+		case inTaprootInternalKey:
+			if len(kv.value) != 32 {
+				err = fmt.Errorf("invalid x-only public key length %d", len(kv.value))
+			}
+			in.TaprootInternalKey = kv.value
+		case inTaprootMerkleRoot:
+			if len(kv.value) != 32 {
+				err = fmt.Errorf("invalid merkle root length %d", len(kv.value))
+			}
+			in.TaprootMerkleRoot = kv.value
+		default:
+			in.Unknowns = append(in.Unknowns, Unknown{kv.key, kv.value})
+			continue
+		}
+
+		if !hasKeyData && len(keyData) != 0 {
+			return fmt.Errorf("invalid key %x", kv.key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value of type %#02x: %w", keyType, err)
+		}
+	}
+
+	if version == 2 && (!hasPreviousTxID || !hasOutputIndex) {
+		return fmt.Errorf("PSBT of version 2 is missing the previous transaction id or output index")
+	}
+
+	return nil
+}
+
+// Returns the key-value pairs of the input map, in the order of their key
+// types
+func (in *Input) encode(version uint32) []keyValue {
+	kvs := make([]keyValue, 0)
+	if in.NonWitnessUTXO != nil {
+		kvs = append(kvs, newKeyValue(inNonWitnessUTXO, in.NonWitnessUTXO.Serialize()))
+	}
+	if in.WitnessUTXO != nil {
+		kvs = append(kvs, newKeyValue(inWitnessUTXO, in.WitnessUTXO.Serialize()))
+	}
+
+	// The signatures are in the order of the hash160 of their keys, like the
+	// ones of Bitcoin Core
+	partialSigs := slices.Clone(in.PartialSigs)
+	slices.SortStableFunc(partialSigs, func(a, b PartialSig) int {
+		return bytes.Compare(hash.Hash160(a.PublicKey), hash.Hash160(b.PublicKey))
+	})
+	for _, partialSig := range partialSigs {
+		kvs = append(kvs, newKeyValueWithData(inPartialSig, partialSig.PublicKey, partialSig.Signature))
+	}
+
+	if in.SigHashType != nil {
+		kvs = append(kvs, newKeyValue(inSigHashType, binary.LittleEndian.AppendUint32(nil, uint32(*in.SigHashType))))
+	}
+	if in.RedeemScript != nil {
+		kvs = append(kvs, newKeyValue(inRedeemScript, encodeScript(in.RedeemScript)))
+	}
+	if in.WitnessScript != nil {
+		kvs = append(kvs, newKeyValue(inWitnessScript, encodeScript(in.WitnessScript)))
+	}
+	kvs = append(kvs, encodeKeyDerivations(inBIP32Derivation, in.Derivations)...)
+	if in.FinalScriptSig != nil {
+		kvs = append(kvs, newKeyValue(inFinalScriptSig, encodeScript(in.FinalScriptSig)))
+	}
+	if in.FinalScriptWitness != nil {
+		kvs = append(kvs, newKeyValue(inFinalScriptWitness, bitcoin.SerializeWitness(in.FinalScriptWitness)))
+	}
+
+	if version == 2 {
+		kvs = append(kvs, newKeyValue(inPreviousTxID, in.PreviousTxID))
+		kvs = append(kvs, newKeyValue(inOutputIndex, binary.LittleEndian.AppendUint32(nil, in.OutputIndex)))
+		if in.Sequence != nil {
+			kvs = append(kvs, newKeyValue(inSequence, binary.LittleEndian.AppendUint32(nil, *in.Sequence)))
+		}
+		if in.RequiredTimeLockTime != nil {
+			kvs = append(kvs, newKeyValue(inRequiredTimeLockTime, binary.LittleEndian.AppendUint32(nil, *in.RequiredTimeLockTime)))
+		}
+		if in.RequiredHeightLockTime != nil {
+			kvs = append(kvs, newKeyValue(inRequiredHeightLockTime, binary.LittleEndian.AppendUint32(nil, *in.RequiredHeightLockTime)))
+		}
+	}
+
+	if in.TaprootKeySig != nil {
+		kvs = append(kvs, newKeyValue(inTaprootKeySig, in.TaprootKeySig))
+	}
+	kvs = append(kvs, encodeTaprootDerivations(inTaprootBIP32Derivation, in.TaprootDerivations)...)
+	if in.TaprootInternalKey != nil {
+		kvs = append(kvs, newKeyValue(inTaprootInternalKey, in.TaprootInternalKey))
+	}
+	if in.TaprootMerkleRoot != nil {
+		kvs = append(kvs, newKeyValue(inTaprootMerkleRoot, in.TaprootMerkleRoot))
+	}
+
+	return append(kvs, unknownKeyValues(in.Unknowns)...)
+}
+
+// Returns an error unless key is a public key in compressed or uncompressed
+// SEC format
+func checkPublicKey(key []byte) error {
+	if len(key) != 33 && len(key) != 65 {
+		return fmt.Errorf("invalid public key length %d", len(key))
+	}
+
+	if _, err := ecc.Parse(key); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the key-value pairs of the origins of public keys sorted by the
+// keys
+func encodeKeyDerivations(keyType byte, derivations []KeyDerivation) []keyValue {
+	kvs := make([]keyValue, 0, len(derivations))
+	for _, derivation := range derivations {
+		kvs = append(kvs, newKeyValueWithData(keyType, derivation.PublicKey, encodeDerivation(derivation.Derivation)))
+	}
+	sortKeyValues(kvs)
+
+	return kvs
+}
+
+// Returns the key-value pairs of the origins of x-only keys sorted by the
+// keys
+func encodeTaprootDerivations(keyType byte, derivations []TaprootKeyDerivation) []keyValue {
+	kvs := make([]keyValue, 0, len(derivations))
+	for _, derivation := range derivations {
+		kvs = append(kvs, newKeyValueWithData(keyType, derivation.XOnlyKey, encodeTaprootDerivation(derivation.LeafHashes, derivation.Derivation)))
+	}
+	sortKeyValues(kvs)
+
+	return kvs
+}
@@ -0,0 +1,130 @@
+package psbt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+)
+
+// The key types of the output maps
+const (
+	outRedeemScript           = 0x00
+	outWitnessScript          = 0x01
+	outBIP32Derivation        = 0x02
+	outAmount                 = 0x03
+	outScript                 = 0x04
+	outTaprootInternalKey     = 0x05
+	outTaprootBIP32Derivation = 0x07
+)
+
+// Output is an output of the transaction together with what tells a signer
+// that it pays back to the signer, such as the derivation of its keys.
+type Output struct {
+	RedeemScript  *bitcoin.Script
+	WitnessScript *bitcoin.Script
+	Derivations   []KeyDerivation
+	// The amount and the scriptPubKey of the output, which are only in the
+	// output map in version 2
+	Amount             uint64
+	Script             *bitcoin.Script
+	TaprootInternalKey []byte
+	TaprootDerivations []TaprootKeyDerivation
+	// The key-value pairs that are not known here
+	Unknowns []Unknown
+}
+
+// Decodes the key-value pairs of the output map. The fields of the
+// transaction are only in version 2.
+func (out *Output) decode(kvs []keyValue, version uint32) error {
+	hasAmount := false
+	for _, kv := range kvs {
+		keyType, keyData := kv.keyType()
+		if version == 0 && (keyType == outAmount || keyType == outScript) {
+			return fmt.Errorf("PSBT of version 0 has the output field of type %#02x", keyType)
+		}
+
+		var err error
+		hasKeyData := false
+		switch keyType {
+		case outRedeemScript:
+			out.RedeemScript, err = bitcoin.ParseRawScript(kv.value)
+		case outWitnessScript:
+			out.WitnessScript, err = bitcoin.ParseRawScript(kv.value)
+		case outBIP32Derivation:
+			hasKeyData = true
+			if err = checkPublicKey(keyData); err == nil {
+				var derivation *Derivation
+				if derivation, err = decodeDerivation(kv.value); err == nil {
+					out.Derivations = append(out.Derivations, KeyDerivation{slices.Clone(keyData), *derivation})
+				}
+			}
+		case outAmount:
+			hasAmount = true
+			if len(kv.value) != 8 {
+				err = fmt.Errorf("invalid length %d", len(kv.value))
+				break
+			}
+			out.Amount = binary.LittleEndian.Uint64(kv.value)
+		case outScript:
+			out.Script, err = bitcoin.ParseRawScript(kv.value)
+		case outTaprootInternalKey:
+			if len(kv.value) != 32 {
+				err = fmt.Errorf("invalid x-only public key length %d", len(kv.value))
+			}
+			out.TaprootInternalKey = kv.value
+		case outTaprootBIP32Derivation:
+			hasKeyData = true
+			if len(keyData) != 32 {
+				err = fmt.Errorf("invalid x-only public key length %d", len(keyData))
+				break
+			}
+			leafHashes, derivation, decodeErr := decodeTaprootDerivation(kv.value)
+			if err = decodeErr; err == nil {
+				out.TaprootDerivations = append(out.TaprootDerivations, TaprootKeyDerivation{slices.Clone(keyData), leafHashes, *derivation})
+			}
+		default:
+			out.Unknowns = append(out.Unknowns, Unknown{kv.key, kv.value})
+			continue
+		}
+
+		if !hasKeyData && len(keyData) != 0 {
+			return fmt.Errorf("invalid key %x", kv.key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value of type %#02x: %w", keyType, err)
+		}
+	}
+
+	if version == 2 && (!hasAmount || out.Script == nil) {
+		return fmt.Errorf("PSBT of version 2 is missing the amount or the script")
+	}
+
+	return nil
+}
+
+// Returns the key-value pairs of the output map, in the order of their key
+// types
+func (out *Output) encode(version uint32) []keyValue {
+	kvs := make([]keyValue, 0)
+	if out.RedeemScript != nil {
+		kvs = append(kvs, newKeyValue(outRedeemScript, encodeScript(out.RedeemScript)))
+	}
+	if out.WitnessScript != nil {
+		kvs = append(kvs, newKeyValue(outWitnessScript, encodeScript(out.WitnessScript)))
+	}
+	kvs = append(kvs, encodeKeyDerivations(outBIP32Derivation, out.Derivations)...)
+
+	if version == 2 {
+		kvs = append(kvs, newKeyValue(outAmount, binary.LittleEndian.AppendUint64(nil, out.Amount)))
+		kvs = append(kvs, newKeyValue(outScript, encodeScript(out.Script)))
+	}
+
+	if out.TaprootInternalKey != nil {
+		kvs = append(kvs, newKeyValue(outTaprootInternalKey, out.TaprootInternalKey))
+	}
+	kvs = append(kvs, encodeTaprootDerivations(outTaprootBIP32Derivation, out.TaprootDerivations)...)
+
+	return append(kvs, unknownKeyValues(out.Unknowns)...)
+}
@@ -0,0 +1,840 @@
+// Package psbt implements the partially signed bitcoin transactions of BIP 174
+// and their version 2 of BIP 370. A PSBT carries an unsigned transaction
+// together with what each party needs to sign it, such as the outputs that
+// are spent and the derivation paths of the keys, so that the transaction can
+// be created, signed and finalized by different parties.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"sort"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
+)
+
+// The magic bytes that a PSBT starts with, "psbt" followed by 0xff
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// The key types of the global map
+const (
+	globalUnsignedTx       = 0x00
+	globalXPub             = 0x01
+	globalTxVersion        = 0x02
+	globalFallbackLockTime = 0x03
+	globalInputCount       = 0x04
+	globalOutputCount      = 0x05
+	globalTxModifiable     = 0x06
+	globalVersion          = 0xfb
+)
+
+// The largest key or value that is read, which is the largest transaction
+const maxFieldSize = 4_000_000
+
+// The lock times from this value are times, and the ones below are heights
+const lockTimeThreshold = 500_000_000
+
+// The sequence of an input that does not set one
+const defaultSequence = 0xffffffff
+
+// Modifiable is the flags of a PSBT of version 2 that tell what may still be
+// changed.
+type Modifiable byte
+
+const (
+	// Inputs may be added or removed
+	InputsModifiable Modifiable = 0x01
+	// Outputs may be added or removed
+	OutputsModifiable Modifiable = 0x02
+	// An input is signed with SIGHASH_SINGLE, so its output must keep its
+	// index
+	HasSigHashSingle Modifiable = 0x04
+)
+
+// Packet is a partially signed bitcoin transaction. In version 0 the
+// transaction is serialized as a whole, while version 2 serializes its fields
+// in the maps of the inputs and outputs. Both versions are held in the same
+// fields here.
+type Packet struct {
+	// 0 or 2
+	Version   uint32
+	TxVersion int32
+	// The lock time of the transaction when no input requires one, see
+	// LockTime. It is nil when version 2 leaves it out.
+	FallbackLockTime *uint32
+	// Only set in version 2
+	TxModifiable *Modifiable
+	XPubs        []XPub
+	Inputs       []*Input
+	Outputs      []*Output
+	// The key-value pairs of the global map that are not known here
+	Unknowns []Unknown
+	params   *chaincfg.Params
+}
+
+// Derivation is the origin of a key, which is the fingerprint of the master
+// key and the path of the key from the master key.
+type Derivation struct {
+	MasterFingerprint []byte
+	Path              []uint32
+}
+
+// XPub is an extended public key of the signers together with its origin.
+type XPub struct {
+	ExtendedKey *hdkey.ExtendedKey
+	Derivation  Derivation
+}
+
+// KeyDerivation is a public key in SEC format together with its origin.
+type KeyDerivation struct {
+	PublicKey []byte
+	Derivation
+}
+
+// TaprootKeyDerivation is an x-only public key together with its origin and
+// the hashes of the leaves of the script tree that it is used in.
+type TaprootKeyDerivation struct {
+	XOnlyKey   []byte
+	LeafHashes [][]byte
+	Derivation
+}
+
+// Unknown is a key-value pair of a type that is not known here, which is kept
+// as it is.
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// A key-value pair of a map, where the key starts with the key type
+type keyValue struct {
+	key   []byte
+	value []byte
+}
+
+// Returns the key type and the key data of the key
+func (kv keyValue) keyType() (uint64, []byte) {
+	reader := bytes.NewReader(kv.key)
+	keyType, err := varint.Decode(reader)
+	if err != nil {
+		return 0, nil
+	}
+
+	return keyType, kv.key[len(kv.key)-reader.Len():]
+}
+
+// Returns the key-value pair of a key type without key data
+func newKeyValue(keyType byte, value []byte) keyValue {
+	return keyValue{[]byte{keyType}, value}
+}
+
+// Returns the key-value pair of a key type with key data
+func newKeyValueWithData(keyType byte, keyData []byte, value []byte) keyValue {
+	return keyValue{append([]byte{keyType}, keyData...), value}
+}
+
+// Returns the key-value pairs of the unknowns sorted by their keys
+func unknownKeyValues(unknowns []Unknown) []keyValue {
+	result := make([]keyValue, 0, len(unknowns))
+	for _, unknown := range unknowns {
+		result = append(result, keyValue{unknown.Key, unknown.Value})
+	}
+	sortKeyValues(result)
+
+	return result
+}
+
+func sortKeyValues(kvs []keyValue) {
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return bytes.Compare(kvs[i].key, kvs[j].key) < 0
+	})
+}
+
+// Reads the bytes of a key or a value, which are prefixed with their length
+func readField(data io.Reader) ([]byte, error) {
+	length, err := varint.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxFieldSize {
+		return nil, fmt.Errorf("field of %d bytes is too large", length)
+	}
+
+	field := make([]byte, length)
+	if _, err := io.ReadFull(data, field); err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// Reads the key-value pairs of a map up to its separator, which is a key of
+// length zero. Keys must be unique within a map.
+func readMap(data io.Reader) ([]keyValue, error) {
+	kvs := make([]keyValue, 0)
+	seen := make(map[string]bool)
+	for {
+		key, err := readField(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			return kvs, nil
+		}
+
+		value, err := readField(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[string(key)] {
+			return nil, fmt.Errorf("duplicate key %x", key)
+		}
+		seen[string(key)] = true
+
+		kvs = append(kvs, keyValue{key, value})
+	}
+}
+
+// Returns the serialization of a map, which is each key and value prefixed
+// with its length followed by the separator
+func serializeMap(kvs []keyValue) []byte {
+	result := make([]byte, 0)
+	for _, kv := range kvs {
+		keyLength, _ := varint.Encode(uint64(len(kv.key)))
+		valueLength, _ := varint.Encode(uint64(len(kv.value)))
+
+		result = append(result, keyLength...)
+		result = append(result, kv.key...)
+		result = append(result, valueLength...)
+		result = append(result, kv.value...)
+	}
+
+	return append(result, 0x00)
+}
+
+// New returns a PSBT of version 0 for tx, whose inputs must not be signed.
+// This is the Creator of BIP 174.
+func New(tx *bitcoin.Tx, params *chaincfg.Params) (*Packet, error) {
+	for i, txIn := range tx.Inputs {
+		if len(txIn.ScriptSig.Instructions()) > 0 || len(txIn.Witness) > 0 {
+			return nil, fmt.Errorf("input %d of the unsigned transaction is signed", i)
+		}
+	}
+
+	p := &Packet{params: params}
+	p.setUnsignedTx(tx)
+
+	return p, nil
+}
+
+// NewV2 returns a PSBT of version 2 for tx, whose inputs must not be signed.
+// Unlike version 0, inputs and outputs can be added to it, see
+// TxModifiable.
+func NewV2(tx *bitcoin.Tx, params *chaincfg.Params) (*Packet, error) {
+	p, err := New(tx, params)
+	if err != nil {
+		return nil, err
+	}
+	p.Version = 2
+
+	return p, nil
+}
+
+// Sets the fields of the unsigned transaction of version 0
+func (p *Packet) setUnsignedTx(tx *bitcoin.Tx) {
+	lockTime := uint32(tx.LockTime)
+	p.TxVersion = tx.Version
+	p.FallbackLockTime = &lockTime
+
+	p.Inputs = make([]*Input, len(tx.Inputs))
+	for i, txIn := range tx.Inputs {
+		sequence := uint32(txIn.Sequence.Uint64())
+		p.Inputs[i] = &Input{
+			PreviousTxID: slices.Clone(txIn.PrevTx),
+			OutputIndex:  uint32(txIn.PrevIndex.Uint64()),
+			Sequence:     &sequence,
+		}
+	}
+
+	p.Outputs = make([]*Output, len(tx.Outputs))
+	for i, txOut := range tx.Outputs {
+		script := txOut.ScriptPubKey
+		p.Outputs[i] = &Output{Amount: txOut.Amount, Script: &script}
+	}
+}
+
+// UnsignedTx returns the transaction of the PSBT without signatures, which
+// looks up the spent outputs in the UTXOs of the inputs.
+func (p *Packet) UnsignedTx() (*bitcoin.Tx, error) {
+	lockTime, err := p.LockTime()
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]*bitcoin.TxInput, len(p.Inputs))
+	for i, in := range p.Inputs {
+		sequence := uint32(defaultSequence)
+		if in.Sequence != nil {
+			sequence = *in.Sequence
+		}
+
+		inputs[i] = bitcoin.NewTxInput(
+			slices.Clone(in.PreviousTxID),
+			big.NewInt(int64(in.OutputIndex)),
+			bitcoin.NewScript([]op.Instruction{}),
+			big.NewInt(int64(sequence)),
+		)
+	}
+
+	outputs := make([]*bitcoin.TxOutput, len(p.Outputs))
+	for i, out := range p.Outputs {
+		outputs[i] = &bitcoin.TxOutput{Amount: out.Amount, ScriptPubKey: *out.Script}
+	}
+
+	tx := bitcoin.NewTx(p.TxVersion, inputs, outputs, int32(lockTime), p.params)
+	tx.PrevOutFetcher = p.prevOuts()
+
+	return tx, nil
+}
+
+// Returns the outputs spent by the inputs that are known
+func (p *Packet) prevOuts() bitcoin.PrevOutMap {
+	prevOuts := make(bitcoin.PrevOutMap)
+	for i, in := range p.Inputs {
+		prevOut, err := p.prevOut(i)
+		if err != nil {
+			continue
+		}
+
+		txIn := bitcoin.NewTxInput(in.PreviousTxID, big.NewInt(int64(in.OutputIndex)), nil, nil)
+		prevOuts[txIn.String()] = prevOut
+	}
+
+	return prevOuts
+}
+
+// LockTime returns the lock time of the transaction. In version 2 it is the
+// largest lock time that the inputs require, where heights are preferred
+// over times when the inputs allow both, see BIP 370. It is an error when
+// some inputs require a height and others a time.
+func (p *Packet) LockTime() (uint32, error) {
+	var height, time uint32
+	hasLockTime, allowsHeight, allowsTime := false, true, true
+	for _, in := range p.Inputs {
+		if in.RequiredHeightLockTime == nil && in.RequiredTimeLockTime == nil {
+			continue
+		}
+		hasLockTime = true
+
+		if in.RequiredHeightLockTime == nil {
+			allowsHeight = false
+		} else {
+			height = max(height, *in.RequiredHeightLockTime)
+		}
+
+		if in.RequiredTimeLockTime == nil {
+			allowsTime = false
+		} else {
+			time = max(time, *in.RequiredTimeLockTime)
+		}
+	}
+
+	switch {
+	case !hasLockTime && p.FallbackLockTime != nil:
+		return *p.FallbackLockTime, nil
+	case !hasLockTime:
+		return 0, nil
+	case allowsHeight:
+		return height, nil
+	case allowsTime:
+		return time, nil
+	}
+
+	return 0, fmt.Errorf("inputs require both a height and a time lock time")
+}
+
+// Id returns the id of the PSBT, which is the id of its unsigned transaction.
+// The sequences are zero in version 2, as they can still be changed.
+func (p *Packet) Id() (string, error) {
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return "", err
+	}
+
+	if p.Version == 2 {
+		for _, txIn := range tx.Inputs {
+			txIn.Sequence = big.NewInt(0)
+		}
+	}
+
+	return tx.Id(), nil
+}
+
+// Returns the hash of a transaction in the byte order of the outpoints
+func txHash(tx *bitcoin.Tx) []byte {
+	hashed, _ := hex.DecodeString(tx.Id())
+	slices.Reverse(hashed)
+
+	return hashed
+}
+
+// ParseBase64 parses the base64 encoding of a PSBT.
+func ParseBase64(s string, params *chaincfg.Params) (*Packet, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 PSBT: %w", err)
+	}
+
+	reader := bytes.NewReader(raw)
+	p, err := Parse(reader, params)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the PSBT", reader.Len())
+	}
+
+	return p, nil
+}
+
+// Parse parses a PSBT of version 0 or 2.
+func Parse(data io.Reader, params *chaincfg.Params) (*Packet, error) {
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(data, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, magic) {
+		return nil, fmt.Errorf("invalid magic bytes %x", header)
+	}
+
+	global, err := readMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p, unsignedTx, inputCount, outputCount, err := decodeGlobal(global, params)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := readMaps(data, inputCount, "input")
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := readMaps(data, outputCount, "output")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.decodeMaps(unsignedTx, inputs, outputs); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Reads count maps of inputs or outputs. Each map is at least its separator,
+// so a count that is larger than the bytes left is rejected when the reader
+// knows its length, and the maps are only allocated as they are read.
+func readMaps(data io.Reader, count uint64, name string) ([][]keyValue, error) {
+	if reader, ok := data.(interface{ Len() int }); ok && count > uint64(reader.Len()) {
+		return nil, fmt.Errorf("%d %s maps do not fit in the %d bytes left", count, name, reader.Len())
+	}
+
+	maps := make([][]keyValue, 0)
+	for i := uint64(0); i < count; i++ {
+		kvs, err := readMap(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s %d: %w", name, i, err)
+		}
+		maps = append(maps, kvs)
+	}
+
+	return maps, nil
+}
+
+// Decodes the global map, and returns the PSBT, the unsigned transaction of
+// version 0 and the number of inputs and outputs
+func decodeGlobal(kvs []keyValue, params *chaincfg.Params) (*Packet, *bitcoin.Tx, uint64, uint64, error) {
+	p := &Packet{params: params}
+
+	var unsignedTx *bitcoin.Tx
+	var txVersion *int32
+	var inputCount, outputCount *uint64
+	for _, kv := range kvs {
+		keyType, keyData := kv.keyType()
+		if keyType == globalXPub {
+			xpub, err := decodeXPub(keyData, kv.value)
+			if err != nil {
+				return nil, nil, 0, 0, err
+			}
+			p.XPubs = append(p.XPubs, *xpub)
+			continue
+		}
+
+		isKnown := true
+		var err error
+		switch keyType {
+		case globalUnsignedTx:
+			unsignedTx, err = decodeTx(kv.value, params, false)
+		case globalTxVersion:
+			var version uint32
+			version, err = decodeUint32(kv.value)
+			v := int32(version)
+			txVersion = &v
+		case globalFallbackLockTime:
+			var lockTime uint32
+			lockTime, err = decodeUint32(kv.value)
+			p.FallbackLockTime = &lockTime
+		case globalInputCount:
+			inputCount, err = decodeCompactSize(kv.value)
+		case globalOutputCount:
+			outputCount, err = decodeCompactSize(kv.value)
+		case globalTxModifiable:
+			if len(kv.value) != 1 {
+				err = fmt.Errorf("invalid length %d", len(kv.value))
+				break
+			}
+			modifiable := Modifiable(kv.value[0])
+			p.TxModifiable = &modifiable
+		case globalVersion:
+			p.Version, err = decodeUint32(kv.value)
+		default:
+			isKnown = false
+			p.Unknowns = append(p.Unknowns, Unknown{kv.key, kv.value})
+		}
+
+		if isKnown && len(keyData) != 0 {
+			return nil, nil, 0, 0, fmt.Errorf("invalid global key %x", kv.key)
+		}
+		if err != nil {
+			return nil, nil, 0, 0, fmt.Errorf("invalid global value of type %#02x: %w", keyType, err)
+		}
+	}
+
+	switch p.Version {
+	case 0:
+		if unsignedTx == nil {
+			return nil, nil, 0, 0, fmt.Errorf("PSBT has no unsigned transaction")
+		}
+		if txVersion != nil || p.FallbackLockTime != nil || inputCount != nil || outputCount != nil || p.TxModifiable != nil {
+			return nil, nil, 0, 0, fmt.Errorf("PSBT of version 0 has fields of version 2")
+		}
+		for i, txIn := range unsignedTx.Inputs {
+			if len(txIn.ScriptSig.Instructions()) > 0 || len(txIn.Witness) > 0 {
+				return nil, nil, 0, 0, fmt.Errorf("input %d of the unsigned transaction is signed", i)
+			}
+		}
+
+		return p, unsignedTx, uint64(len(unsignedTx.Inputs)), uint64(len(unsignedTx.Outputs)), nil
+	case 2:
+		if unsignedTx != nil {
+			return nil, nil, 0, 0, fmt.Errorf("PSBT of version 2 has an unsigned transaction")
+		}
+		if txVersion == nil || inputCount == nil || outputCount == nil {
+			return nil, nil, 0, 0, fmt.Errorf("PSBT of version 2 is missing the transaction version or the number of inputs or outputs")
+		}
+		p.TxVersion = *txVersion
+
+		return p, nil, *inputCount, *outputCount, nil
+	}
+
+	return nil, nil, 0, 0, fmt.Errorf("unsupported PSBT version %d", p.Version)
+}
+
+// Decodes the maps of the inputs and the outputs, whose transaction fields
+// are the ones of the unsigned transaction in version 0
+func (p *Packet) decodeMaps(unsignedTx *bitcoin.Tx, inputs, outputs [][]keyValue) error {
+	if unsignedTx != nil {
+		p.setUnsignedTx(unsignedTx)
+	} else {
+		p.Inputs = make([]*Input, len(inputs))
+		p.Outputs = make([]*Output, len(outputs))
+		for i := range p.Inputs {
+			p.Inputs[i] = &Input{}
+		}
+		for i := range p.Outputs {
+			p.Outputs[i] = &Output{}
+		}
+	}
+
+	for i, kvs := range inputs {
+		if err := p.Inputs[i].decode(kvs, p.Version, p.params); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	for i, kvs := range outputs {
+		if err := p.Outputs[i].decode(kvs, p.Version); err != nil {
+			return fmt.Errorf("output %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the key-value pairs of the global map
+func (p *Packet) encodeGlobal() ([]keyValue, error) {
+	kvs := make([]keyValue, 0)
+	if p.Version == 0 {
+		tx, err := p.UnsignedTx()
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, newKeyValue(globalUnsignedTx, tx.SerializeLegacy()))
+	}
+
+	xpubs := make([]keyValue, 0, len(p.XPubs))
+	for _, xpub := range p.XPubs {
+		xpubs = append(xpubs, newKeyValueWithData(globalXPub, xpub.ExtendedKey.Serialize(), encodeDerivation(xpub.Derivation)))
+	}
+	sortKeyValues(xpubs)
+	kvs = append(kvs, xpubs...)
+
+	if p.Version == 2 {
+		inputCount, _ := varint.Encode(uint64(len(p.Inputs)))
+		outputCount, _ := varint.Encode(uint64(len(p.Outputs)))
+
+		kvs = append(kvs, newKeyValue(globalTxVersion, binary.LittleEndian.AppendUint32(nil, uint32(p.TxVersion))))
+		if p.FallbackLockTime != nil {
+			kvs = append(kvs, newKeyValue(globalFallbackLockTime, binary.LittleEndian.AppendUint32(nil, *p.FallbackLockTime)))
+		}
+		kvs = append(kvs, newKeyValue(globalInputCount, inputCount))
+		kvs = append(kvs, newKeyValue(globalOutputCount, outputCount))
+		if p.TxModifiable != nil {
+			kvs = append(kvs, newKeyValue(globalTxModifiable, []byte{byte(*p.TxModifiable)}))
+		}
+	}
+
+	if p.Version != 0 {
+		kvs = append(kvs, newKeyValue(globalVersion, binary.LittleEndian.AppendUint32(nil, p.Version)))
+	}
+
+	return append(kvs, unknownKeyValues(p.Unknowns)...), nil
+}
+
+// Returns the key-value pairs of the global map, the inputs and the outputs
+func (p *Packet) encode() ([]keyValue, [][]keyValue, [][]keyValue, error) {
+	global, err := p.encodeGlobal()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	inputs := make([][]keyValue, len(p.Inputs))
+	for i, in := range p.Inputs {
+		inputs[i] = in.encode(p.Version)
+	}
+
+	outputs := make([][]keyValue, len(p.Outputs))
+	for i, out := range p.Outputs {
+		outputs[i] = out.encode(p.Version)
+	}
+
+	return global, inputs, outputs, nil
+}
+
+// Serialize returns the bytes of the PSBT, where the fields of each map are
+// ordered by their key type.
+func (p *Packet) Serialize() ([]byte, error) {
+	global, inputs, outputs, err := p.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	result := slices.Clone(magic)
+	result = append(result, serializeMap(global)...)
+	for _, kvs := range inputs {
+		result = append(result, serializeMap(kvs)...)
+	}
+	for _, kvs := range outputs {
+		result = append(result, serializeMap(kvs)...)
+	}
+
+	return result, nil
+}
+
+// Base64 returns the base64 encoding of the PSBT, which is how PSBTs are
+// passed around as text.
+func (p *Packet) Base64() (string, error) {
+	raw, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// Returns the extended public key of a global key and its origin
+func decodeXPub(keyData []byte, value []byte) (*XPub, error) {
+	if len(keyData) != 78 {
+		return nil, fmt.Errorf("invalid extended public key length %d", len(keyData))
+	}
+
+	extendedKey, err := hdkey.Parse(base58.Checksum(keyData))
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended public key: %w", err)
+	}
+	if extendedKey.IsPrivate() {
+		return nil, fmt.Errorf("global extended key is private")
+	}
+
+	derivation, err := decodeDerivation(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &XPub{extendedKey, *derivation}, nil
+}
+
+// Returns the origin of a key, which is the fingerprint of the master key
+// followed by the child indexes as 32-bit little endian integers
+func decodeDerivation(value []byte) (*Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, fmt.Errorf("invalid derivation length %d", len(value))
+	}
+
+	path := make([]uint32, 0, len(value)/4-1)
+	for i := 4; i < len(value); i += 4 {
+		path = append(path, binary.LittleEndian.Uint32(value[i:]))
+	}
+
+	return &Derivation{slices.Clone(value[:4]), path}, nil
+}
+
+func encodeDerivation(derivation Derivation) []byte {
+	result := slices.Clone(derivation.MasterFingerprint)
+	for _, index := range derivation.Path {
+		result = binary.LittleEndian.AppendUint32(result, index)
+	}
+
+	return result
+}
+
+// Returns the origin of an x-only key, which is preceded by the hashes of the
+// leaves that the key is used in
+func decodeTaprootDerivation(value []byte) ([][]byte, *Derivation, error) {
+	reader := bytes.NewReader(value)
+	count, err := varint.Decode(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count > uint64(reader.Len())/32 {
+		return nil, nil, fmt.Errorf("invalid number of leaf hashes %d", count)
+	}
+
+	leafHashes := make([][]byte, count)
+	for i := range leafHashes {
+		leafHashes[i] = make([]byte, 32)
+		if _, err := io.ReadFull(reader, leafHashes[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	derivation, err := decodeDerivation(value[len(value)-reader.Len():])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return leafHashes, derivation, nil
+}
+
+func encodeTaprootDerivation(leafHashes [][]byte, derivation Derivation) []byte {
+	result, _ := varint.Encode(uint64(len(leafHashes)))
+	for _, leafHash := range leafHashes {
+		result = append(result, leafHash...)
+	}
+
+	return append(result, encodeDerivation(derivation)...)
+}
+
+// Returns the transaction of a value, which must be all of the value, and is
+// in the legacy serialization unless allowWitness
+func decodeTx(value []byte, params *chaincfg.Params, allowWitness bool) (*bitcoin.Tx, error) {
+	parse := bitcoin.ParseLegacy
+	if allowWitness {
+		parse = bitcoin.Parse
+	}
+
+	reader := bytes.NewReader(value)
+	tx, err := parse(reader, params)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the transaction", reader.Len())
+	}
+
+	return tx, nil
+}
+
+// Returns the transaction output of a value, which must be all of the value
+func decodeTxOutput(value []byte) (*bitcoin.TxOutput, error) {
+	// The output is parsed as a list of one output
+	reader := bytes.NewReader(append([]byte{0x01}, value...))
+	outputs, err := bitcoin.ParseTxOutputs(reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the output", reader.Len())
+	}
+
+	return outputs[0], nil
+}
+
+// Returns the witness stack of a value, which must be all of the value
+func decodeWitness(value []byte) ([][]byte, error) {
+	reader := bytes.NewReader(value)
+	witness, err := bitcoin.ParseWitness(reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the witness", reader.Len())
+	}
+
+	return witness, nil
+}
+
+func decodeUint32(value []byte) (uint32, error) {
+	if len(value) != 4 {
+		return 0, fmt.Errorf("invalid length %d", len(value))
+	}
+
+	return binary.LittleEndian.Uint32(value), nil
+}
+
+func decodeCompactSize(value []byte) (*uint64, error) {
+	reader := bytes.NewReader(value)
+	n, err := varint.Decode(reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%d bytes after the number", reader.Len())
+	}
+
+	return &n, nil
+}
+
+func encodeScript(script *bitcoin.Script) []byte {
+	raw, err := script.RawSerialize()
+	if err != nil {
+		return nil
+	}
+
+	return raw
+}
@@ -0,0 +1,155 @@
+package psbt_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/psbt"
+)
+
+type psbtVector struct {
+	Description string  `json:"description"`
+	PSBT        string  `json:"psbt"`
+	LockTime    *uint32 `json:"lock_time"`
+}
+
+type psbtVectors struct {
+	Invalid     []psbtVector `json:"invalid"`
+	Valid       []psbtVector `json:"valid"`
+	SignerFails []psbtVector `json:"signer_fails"`
+	LockTime    []psbtVector `json:"lock_time"`
+	Workflow    struct {
+		MasterKey string `json:"master_key"`
+		Creator   struct {
+			Inputs []struct {
+				TxID  string `json:"txid"`
+				Index int64  `json:"index"`
+			} `json:"inputs"`
+			Outputs []struct {
+				Script string `json:"script"`
+				Amount uint64 `json:"amount"`
+			} `json:"outputs"`
+			PSBT string `json:"psbt"`
+		} `json:"creator"`
+		Updater struct {
+			RedeemScripts  []string `json:"redeem_scripts"`
+			WitnessScripts []string `json:"witness_scripts"`
+			PreviousTxs    []string `json:"previous_txs"`
+			PublicKeys     []struct {
+				PublicKey string `json:"public_key"`
+				Path      string `json:"path"`
+			} `json:"public_keys"`
+			PSBT string `json:"psbt"`
+		} `json:"updater"`
+		SigHashUpdater struct {
+			PSBT string `json:"psbt"`
+		} `json:"sighash_updater"`
+		Signers []struct {
+			Keys []struct {
+				WIF  string `json:"wif"`
+				Path string `json:"path"`
+			} `json:"keys"`
+			PSBT string `json:"psbt"`
+		} `json:"signers"`
+		Combiner struct {
+			PSBT string `json:"psbt"`
+		} `json:"combiner"`
+		Finalizer struct {
+			PSBT string `json:"psbt"`
+		} `json:"finalizer"`
+		Extractor struct {
+			Tx string `json:"tx"`
+		} `json:"extractor"`
+	} `json:"workflow"`
+	UnknownCombiner struct {
+		PSBTs []string `json:"psbts"`
+		PSBT  string   `json:"psbt"`
+	} `json:"unknown_combiner"`
+}
+
+func readPSBTVectors(t *testing.T, name string) *psbtVectors {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	var vectors psbtVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	return &vectors
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, name := range []string{"bip174_test_vectors.json", "bip370_test_vectors.json"} {
+		for _, vector := range readPSBTVectors(t, name).Invalid {
+			if _, err := psbt.ParseBase64(vector.PSBT, &chaincfg.TestNet3Params); err == nil {
+				t.Errorf("ParseBase64: expected an error for %s", vector.Description)
+			}
+		}
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	for _, name := range []string{"bip174_test_vectors.json", "bip370_test_vectors.json"} {
+		for _, vector := range readPSBTVectors(t, name).Valid {
+			p, err := psbt.ParseBase64(vector.PSBT, &chaincfg.TestNet3Params)
+			if err != nil {
+				t.Errorf("ParseBase64(%s): got error %v, expected nil", vector.Description, err)
+				continue
+			}
+
+			encoded, err := p.Base64()
+			if err != nil {
+				t.Errorf("Base64(%s): got error %v, expected nil", vector.Description, err)
+				continue
+			}
+			if encoded != vector.PSBT {
+				t.Errorf("Base64(%s): got %s, expected %s", vector.Description, encoded, vector.PSBT)
+			}
+		}
+	}
+}
+
+func TestLockTime(t *testing.T) {
+	for _, vector := range readPSBTVectors(t, "bip370_test_vectors.json").LockTime {
+		p, err := psbt.ParseBase64(vector.PSBT, &chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatalf("ParseBase64(%s): got error %v, expected nil", vector.Description, err)
+		}
+
+		lockTime, err := p.LockTime()
+		switch {
+		case vector.LockTime == nil && err == nil:
+			t.Errorf("LockTime(%s): got %d, expected an error", vector.Description, lockTime)
+		case vector.LockTime != nil && err != nil:
+			t.Errorf("LockTime(%s): got error %v, expected %d", vector.Description, err, *vector.LockTime)
+		case vector.LockTime != nil && lockTime != *vector.LockTime:
+			t.Errorf("LockTime(%s): got %d, expected %d", vector.Description, lockTime, *vector.LockTime)
+		}
+	}
+}
+
+func TestParseOversizedCounts(t *testing.T) {
+	// The unsigned transaction has 0x7fffffff inputs, and the input and
+	// output counts of version 2 are larger than the PSBT
+	tests := map[string]string{
+		"unsigned transaction": "70736274ff" + "0100" + "09" + "01000000feffffff7f" + "00",
+		"input count":          "70736274ff" + "01fb" + "0402000000" + "0102" + "0402000000" + "0104" + "05feffffff7f" + "0105" + "0100" + "00",
+		"output count":         "70736274ff" + "01fb" + "0402000000" + "0102" + "0402000000" + "0104" + "0100" + "0105" + "05feffffff7f" + "00",
+	}
+
+	for name, hexString := range tests {
+		data, _ := hex.DecodeString(hexString)
+		if _, err := psbt.Parse(bytes.NewReader(data), &chaincfg.TestNet3Params); err == nil {
+			t.Errorf("Parse(%s): expected an error", name)
+		}
+	}
+}
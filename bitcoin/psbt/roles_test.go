@@ -0,0 +1,354 @@
+package psbt_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/psbt"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+)
+
+var params = &chaincfg.TestNet3Params
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("hex.DecodeString: %v", err)
+	}
+
+	return data
+}
+
+func mustParseRawScript(t *testing.T, s string) *bitcoin.Script {
+	t.Helper()
+
+	script, err := bitcoin.ParseRawScript(mustDecodeHex(t, s))
+	if err != nil {
+		t.Fatalf("ParseRawScript: %v", err)
+	}
+
+	return script
+}
+
+func mustParseBase64(t *testing.T, s string) *psbt.Packet {
+	t.Helper()
+
+	p, err := psbt.ParseBase64(s, params)
+	if err != nil {
+		t.Fatalf("ParseBase64: %v", err)
+	}
+
+	return p
+}
+
+func assertBase64(t *testing.T, role string, p *psbt.Packet, expected string) {
+	t.Helper()
+
+	encoded, err := p.Base64()
+	if err != nil {
+		t.Fatalf("%s: Base64: %v", role, err)
+	}
+	if encoded != expected {
+		t.Errorf("%s: got %s, expected %s", role, encoded, expected)
+	}
+}
+
+func TestWorkflow(t *testing.T) {
+	workflow := readPSBTVectors(t, "bip174_test_vectors.json").Workflow
+
+	// Creator
+	inputs := make([]*bitcoin.TxInput, 0)
+	for _, in := range workflow.Creator.Inputs {
+		prevTx := mustDecodeHex(t, in.TxID)
+		slices.Reverse(prevTx)
+		inputs = append(inputs, bitcoin.NewTxInput(prevTx, big.NewInt(in.Index), bitcoin.NewScript([]op.Instruction{}), big.NewInt(0xffffffff)))
+	}
+	outputs := make([]*bitcoin.TxOutput, 0)
+	for _, out := range workflow.Creator.Outputs {
+		outputs = append(outputs, &bitcoin.TxOutput{Amount: out.Amount, ScriptPubKey: *mustParseRawScript(t, out.Script)})
+	}
+
+	p, err := psbt.New(bitcoin.NewTx(2, inputs, outputs, 0, params), params)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	assertBase64(t, "creator", p, workflow.Creator.PSBT)
+
+	// Updater
+	prevTxs := make(map[string]*bitcoin.Tx)
+	for _, raw := range workflow.Updater.PreviousTxs {
+		prevTx, err := bitcoin.Parse(bytes.NewReader(mustDecodeHex(t, raw)), params)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		prevTxs[prevTx.Id()] = prevTx
+	}
+
+	for i, in := range workflow.Creator.Inputs {
+		prevTx := prevTxs[in.TxID]
+		prevOut := prevTx.Outputs[in.Index]
+
+		for _, raw := range workflow.Updater.RedeemScripts {
+			if bytes.Equal(hash.Hash160(mustDecodeHex(t, raw)), prevOut.ScriptPubKey.Instructions()[1].Bytes()) {
+				p.Inputs[i].RedeemScript = mustParseRawScript(t, raw)
+			}
+		}
+
+		_, program, isWitness := p.Inputs[i].RedeemScript.WitnessProgram()
+		if !isWitness {
+			if err := p.SetNonWitnessUTXO(i, prevTx); err != nil {
+				t.Fatalf("SetNonWitnessUTXO: %v", err)
+			}
+			continue
+		}
+
+		p.Inputs[i].WitnessUTXO = prevOut
+		for _, raw := range workflow.Updater.WitnessScripts {
+			witnessScriptHash := sha256.Sum256(mustDecodeHex(t, raw))
+			if bytes.Equal(witnessScriptHash[:], program) {
+				p.Inputs[i].WitnessScript = mustParseRawScript(t, raw)
+			}
+		}
+	}
+
+	master, err := hdkey.Parse(workflow.MasterKey)
+	if err != nil {
+		t.Fatalf("hdkey.Parse: %v", err)
+	}
+	for _, publicKey := range workflow.Updater.PublicKeys {
+		path, err := hdkey.ParsePath(publicKey.Path)
+		if err != nil {
+			t.Fatalf("ParsePath: %v", err)
+		}
+
+		derivation := psbt.Derivation{MasterFingerprint: master.Fingerprint(), Path: path}
+		count, err := p.AddDerivation(mustDecodeHex(t, publicKey.PublicKey), derivation)
+		if err != nil {
+			t.Fatalf("AddDerivation: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("AddDerivation(%s): got %d, expected 1", publicKey.PublicKey, count)
+		}
+	}
+	assertBase64(t, "updater", p, workflow.Updater.PSBT)
+
+	// Updater of the sighash types
+	for _, in := range p.Inputs {
+		hashType := bitcoin.SigHashAll
+		in.SigHashType = &hashType
+	}
+	assertBase64(t, "sighash updater", p, workflow.SigHashUpdater.PSBT)
+
+	// Signers, which sign each their copy of the PSBT
+	signed := make([]*psbt.Packet, 0)
+	for _, signer := range workflow.Signers {
+		p := mustParseBase64(t, workflow.SigHashUpdater.PSBT)
+		for _, key := range signer.Keys {
+			privateKey, _, _, err := ecc.ParseWIF(key.WIF)
+			if err != nil {
+				t.Fatalf("ParseWIF: %v", err)
+			}
+
+			count := 0
+			for i := range p.Inputs {
+				ok, err := p.SignInput(i, privateKey)
+				if err != nil {
+					t.Fatalf("SignInput: %v", err)
+				}
+				if ok {
+					count++
+				}
+			}
+			if count != 1 {
+				t.Errorf("SignInput(%s): signed %d inputs, expected 1", key.Path, count)
+			}
+		}
+		assertBase64(t, "signer", p, signer.PSBT)
+		signed = append(signed, p)
+	}
+
+	// Combiner
+	combined, err := psbt.Combine(signed...)
+	if err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	assertBase64(t, "combiner", combined, workflow.Combiner.PSBT)
+
+	// Finalizer
+	if err := combined.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+	assertBase64(t, "finalizer", combined, workflow.Finalizer.PSBT)
+
+	// Extractor
+	tx, err := combined.Extract()
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if raw := hex.EncodeToString(tx.Serialize()); raw != workflow.Extractor.Tx {
+		t.Errorf("Extract: got %s, expected %s", raw, workflow.Extractor.Tx)
+	}
+}
+
+func TestCombineUnknowns(t *testing.T) {
+	vector := readPSBTVectors(t, "bip174_test_vectors.json").UnknownCombiner
+
+	packets := make([]*psbt.Packet, 0)
+	for _, s := range vector.PSBTs {
+		packets = append(packets, mustParseBase64(t, s))
+	}
+
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	assertBase64(t, "combiner", combined, vector.PSBT)
+}
+
+func TestCombineDifferentTransactions(t *testing.T) {
+	vectors := readPSBTVectors(t, "bip174_test_vectors.json")
+
+	first := mustParseBase64(t, vectors.Workflow.Creator.PSBT)
+	second := mustParseBase64(t, vectors.UnknownCombiner.PSBTs[0])
+	if _, err := psbt.Combine(first, second); err == nil {
+		t.Errorf("Combine: expected an error")
+	}
+}
+
+func TestSignerFails(t *testing.T) {
+	vectors := readPSBTVectors(t, "bip174_test_vectors.json")
+
+	// The checks come before the key is matched, so any key fails
+	privateKey, _, _, err := ecc.ParseWIF(vectors.Workflow.Signers[0].Keys[0].WIF)
+	if err != nil {
+		t.Fatalf("ParseWIF: %v", err)
+	}
+
+	for _, vector := range vectors.SignerFails {
+		p := mustParseBase64(t, vector.PSBT)
+
+		var signErr error
+		for i := range p.Inputs {
+			if _, err := p.SignInput(i, privateKey); err != nil {
+				signErr = err
+			}
+		}
+		if signErr == nil {
+			t.Errorf("SignInput(%s): expected an error", vector.Description)
+		}
+	}
+}
+
+func TestSignWithMasterKey(t *testing.T) {
+	master, err := hdkey.NewMaster(bytes.Repeat([]byte{0x01}, 32), params)
+	if err != nil {
+		t.Fatalf("NewMaster: %v", err)
+	}
+
+	paths := []string{"m/84'/1'/0'/0/0", "m/86'/1'/0'/0/0"}
+	prevOuts := make([]*bitcoin.TxOutput, 0)
+	for i, path := range paths {
+		indexes, err := hdkey.ParsePath(path)
+		if err != nil {
+			t.Fatalf("ParsePath: %v", err)
+		}
+
+		key := master
+		for _, index := range indexes {
+			key, err = key.Child(index)
+			if err != nil {
+				t.Fatalf("Child: %v", err)
+			}
+		}
+
+		publicKey := key.PublicKey()
+		var script *bitcoin.Script
+		if i == 0 {
+			script, err = bitcoin.ParseRawScript(append([]byte{0x00, 0x14}, hash.Hash160(publicKey.SECCompressed())...))
+		} else {
+			var outputKey *ecc.S256Point
+			outputKey, err = bitcoin.TaprootOutputKey(publicKey, nil)
+			if err == nil {
+				script, err = bitcoin.ToP2TRScript(outputKey.XOnly())
+			}
+		}
+		if err != nil {
+			t.Fatalf("script: %v", err)
+		}
+		prevOuts = append(prevOuts, &bitcoin.TxOutput{Amount: 50_000, ScriptPubKey: *script})
+	}
+
+	inputs := []*bitcoin.TxInput{
+		bitcoin.NewTxInput(bytes.Repeat([]byte{0xaa}, 32), big.NewInt(0), bitcoin.NewScript([]op.Instruction{}), big.NewInt(0xfffffffd)),
+		bitcoin.NewTxInput(bytes.Repeat([]byte{0xbb}, 32), big.NewInt(1), bitcoin.NewScript([]op.Instruction{}), big.NewInt(0xfffffffd)),
+	}
+	outputs := []*bitcoin.TxOutput{{Amount: 99_000, ScriptPubKey: prevOuts[0].ScriptPubKey}}
+
+	p, err := psbt.NewV2(bitcoin.NewTx(2, inputs, outputs, 0, params), params)
+	if err != nil {
+		t.Fatalf("NewV2: %v", err)
+	}
+
+	// A watch-only wallet adds the UTXOs and the origins of the keys
+	for i, path := range paths {
+		indexes, _ := hdkey.ParsePath(path)
+		key := master
+		for _, index := range indexes {
+			key, _ = key.Child(index)
+		}
+
+		derivation := psbt.Derivation{MasterFingerprint: master.Fingerprint(), Path: indexes}
+		p.Inputs[i].WitnessUTXO = prevOuts[i]
+		if i == 0 {
+			p.Inputs[i].Derivations = []psbt.KeyDerivation{{PublicKey: key.PublicKey().SECCompressed(), Derivation: derivation}}
+		} else {
+			p.Inputs[i].TaprootInternalKey = key.PublicKey().XOnly()
+			p.Inputs[i].TaprootDerivations = []psbt.TaprootKeyDerivation{{XOnlyKey: key.PublicKey().XOnly(), Derivation: derivation}}
+		}
+	}
+
+	// The PSBT is passed to the signer
+	encoded, err := p.Base64()
+	if err != nil {
+		t.Fatalf("Base64: %v", err)
+	}
+	p = mustParseBase64(t, encoded)
+
+	count, err := p.Sign(master)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Sign: got %d signatures, expected 2", count)
+	}
+
+	if err := p.Finalize(); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	tx, err := p.Extract()
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	tx.PrevOutFetcher = bitcoin.PrevOutMap{
+		tx.Inputs[0].String(): prevOuts[0],
+		tx.Inputs[1].String(): prevOuts[1],
+	}
+	for i := range tx.Inputs {
+		valid, err := tx.VerifyInput(i, 0)
+		if err != nil || !valid {
+			t.Errorf("VerifyInput(%d): got %v, %v, expected true, nil", i, valid, err)
+		}
+	}
+}
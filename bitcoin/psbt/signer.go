@@ -0,0 +1,316 @@
+package psbt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+)
+
+// The output spent by an input together with the script that is signed
+type spentOutput struct {
+	prevOut *bitcoin.TxOutput
+	// Whether the output is pay-to-script-hash, where script is the redeem
+	// script
+	isP2SH bool
+	script *bitcoin.Script
+	// The witness program of script, if it is one
+	isWitness      bool
+	witnessVersion int
+	program        []byte
+}
+
+// Returns the output spent by the input at inputIndex, where a non-witness
+// UTXO must be the transaction of the outpoint.
+func (p *Packet) prevOut(inputIndex int) (*bitcoin.TxOutput, error) {
+	in := p.Inputs[inputIndex]
+	if in.NonWitnessUTXO != nil {
+		if !bytes.Equal(txHash(in.NonWitnessUTXO), in.PreviousTxID) {
+			return nil, fmt.Errorf("non-witness UTXO of input %d is not the spent transaction", inputIndex)
+		}
+		if int(in.OutputIndex) >= len(in.NonWitnessUTXO.Outputs) {
+			return nil, fmt.Errorf("non-witness UTXO of input %d has no output %d", inputIndex, in.OutputIndex)
+		}
+
+		return in.NonWitnessUTXO.Outputs[in.OutputIndex], nil
+	}
+
+	if in.WitnessUTXO != nil {
+		return in.WitnessUTXO, nil
+	}
+
+	return nil, fmt.Errorf("input %d has no UTXO", inputIndex)
+}
+
+// Returns the output spent by the input at inputIndex, after checking that
+// the redeem script and the witness script are the ones of the output, and
+// that an input that does not spend a segwit output has its non-witness UTXO,
+// see the checks of the Signer of BIP 174.
+func (p *Packet) spentOutput(inputIndex int) (*spentOutput, error) {
+	in := p.Inputs[inputIndex]
+	prevOut, err := p.prevOut(inputIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	spent := &spentOutput{prevOut: prevOut, script: &prevOut.ScriptPubKey}
+	if prevOut.ScriptPubKey.IsP2SHScriptPubKey() {
+		if in.RedeemScript == nil {
+			return nil, fmt.Errorf("input %d has no redeem script", inputIndex)
+		}
+		if !bytes.Equal(hash.Hash160(encodeScript(in.RedeemScript)), prevOut.ScriptPubKey.Instructions()[1].Bytes()) {
+			return nil, fmt.Errorf("redeem script of input %d does not match the spent output", inputIndex)
+		}
+
+		spent.isP2SH = true
+		spent.script = in.RedeemScript
+	}
+
+	spent.witnessVersion, spent.program, spent.isWitness = spent.script.WitnessProgram()
+	if !spent.isWitness && in.NonWitnessUTXO == nil {
+		return nil, fmt.Errorf("input %d does not spend a segwit output, but has no non-witness UTXO", inputIndex)
+	}
+
+	if spent.isP2WSH() {
+		if in.WitnessScript == nil {
+			return nil, fmt.Errorf("input %d has no witness script", inputIndex)
+		}
+
+		witnessScriptHash := sha256.Sum256(encodeScript(in.WitnessScript))
+		if !bytes.Equal(witnessScriptHash[:], spent.program) {
+			return nil, fmt.Errorf("witness script of input %d does not match the spent output", inputIndex)
+		}
+	}
+
+	return spent, nil
+}
+
+func (s *spentOutput) isP2WPKH() bool {
+	return s.isWitness && s.witnessVersion == 0 && len(s.program) == 20
+}
+
+func (s *spentOutput) isP2WSH() bool {
+	return s.isWitness && s.witnessVersion == 0 && len(s.program) == 32
+}
+
+// Taproot outputs nested in pay-to-script-hash can not be spent
+func (s *spentOutput) isP2TR() bool {
+	return s.isWitness && s.witnessVersion == 1 && len(s.program) == 32 && !s.isP2SH
+}
+
+// SignInput adds the signature of privateKey to the input at inputIndex,
+// which is signed with its sighash type, or SIGHASH_ALL by default. Inputs
+// of public key hashes, multisig scripts and the key path of taproot
+// outputs, where privateKey is the internal key, can be signed. Returns
+// false when the input does not use the key. This is the Signer of BIP 174.
+func (p *Packet) SignInput(inputIndex int, privateKey *ecc.PrivateKey) (bool, error) {
+	in := p.Inputs[inputIndex]
+	if in.isFinal() {
+		return false, nil
+	}
+
+	spent, err := p.spentOutput(inputIndex)
+	if err != nil {
+		return false, err
+	}
+
+	if spent.isP2TR() {
+		return p.signTaprootInput(inputIndex, privateKey, spent.program)
+	}
+
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return false, err
+	}
+
+	hashType := bitcoin.SigHashAll
+	if in.SigHashType != nil {
+		hashType = *in.SigHashType
+	}
+
+	sec := privateKey.SECCompressed()
+	var z []byte
+	switch {
+	case spent.isP2WPKH():
+		if !bytes.Equal(hash.Hash160(sec), spent.program) {
+			return false, nil
+		}
+
+		scriptCode, err := bitcoin.ToP2PKHScript(spent.program)
+		if err != nil {
+			return false, err
+		}
+
+		z, err = tx.SegwitSignatureHash(inputIndex, scriptCode, spent.prevOut.Amount, hashType)
+		if err != nil {
+			return false, err
+		}
+	case spent.isP2WSH():
+		if !usesKey(sec, in.WitnessScript) {
+			return false, nil
+		}
+
+		z, err = tx.SegwitSignatureHash(inputIndex, in.WitnessScript, spent.prevOut.Amount, hashType)
+		if err != nil {
+			return false, err
+		}
+	case spent.isWitness:
+		return false, fmt.Errorf("input %d spends an output of witness version %d", inputIndex, spent.witnessVersion)
+	default:
+		// Legacy scripts may use the uncompressed public key
+		if !usesKey(sec, spent.script) {
+			sec = privateKey.SECUncompressed()
+			if !usesKey(sec, spent.script) {
+				return false, nil
+			}
+		}
+
+		z, err = tx.SignatureHash(inputIndex, spent.script, hashType)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	signature, err := privateKey.Sign(new(big.Int).SetBytes(z))
+	if err != nil {
+		return false, err
+	}
+
+	partialSig := PartialSig{sec, append(signature.DER(), byte(hashType))}
+	for i := range in.PartialSigs {
+		if bytes.Equal(in.PartialSigs[i].PublicKey, sec) {
+			in.PartialSigs[i] = partialSig
+			return true, nil
+		}
+	}
+	in.PartialSigs = append(in.PartialSigs, partialSig)
+
+	return true, nil
+}
+
+// Signs the key path of a taproot input of the output key program, where
+// privateKey is the internal key
+func (p *Packet) signTaprootInput(inputIndex int, privateKey *ecc.PrivateKey, program []byte) (bool, error) {
+	in := p.Inputs[inputIndex]
+	if in.TaprootInternalKey != nil && !bytes.Equal(in.TaprootInternalKey, privateKey.XOnly()) {
+		return false, nil
+	}
+
+	tweakedKey, err := bitcoin.TaprootTweakPrivateKey(privateKey, in.TaprootMerkleRoot)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(tweakedKey.XOnly(), program) {
+		return false, nil
+	}
+
+	hashType := bitcoin.SigHashDefault
+	if in.SigHashType != nil {
+		hashType = *in.SigHashType
+	}
+
+	tx, err := p.UnsignedTx()
+	if err != nil {
+		return false, err
+	}
+
+	z, err := tx.TaprootSignatureHash(inputIndex, hashType, nil)
+	if err != nil {
+		return false, err
+	}
+
+	auxRand := make([]byte, 32)
+	if _, err := rand.Read(auxRand); err != nil {
+		return false, err
+	}
+
+	signature, err := tweakedKey.SignSchnorr(z, auxRand)
+	if err != nil {
+		return false, err
+	}
+
+	sig := signature.Serialize()
+	// The default hash type is left out
+	if hashType != bitcoin.SigHashDefault {
+		sig = append(sig, byte(hashType))
+	}
+	in.TaprootKeySig = sig
+
+	return true, nil
+}
+
+// Sign signs the inputs with the keys of master that the derivations of the
+// inputs point to, which are the ones with the fingerprint of master. It
+// returns the number of signatures that were added, so that a signer that
+// holds only a master key can sign a PSBT made by a watch-only wallet.
+func (p *Packet) Sign(master *hdkey.ExtendedKey) (int, error) {
+	fingerprint := master.Fingerprint()
+
+	count := 0
+	for i, in := range p.Inputs {
+		// The keys of the taproot derivations are x-only, and only the key
+		// path is signed, which has no leaf hashes
+		derivations := make([]Derivation, 0)
+		keys := make([][]byte, 0)
+		for _, derivation := range in.Derivations {
+			derivations = append(derivations, derivation.Derivation)
+			keys = append(keys, derivation.PublicKey)
+		}
+		for _, derivation := range in.TaprootDerivations {
+			if len(derivation.LeafHashes) == 0 {
+				derivations = append(derivations, derivation.Derivation)
+				keys = append(keys, derivation.XOnlyKey)
+			}
+		}
+
+		for j, derivation := range derivations {
+			if !bytes.Equal(derivation.MasterFingerprint, fingerprint) {
+				continue
+			}
+
+			key, err := deriveKey(master, derivation.Path)
+			if err != nil {
+				return count, err
+			}
+
+			publicKey := key.PublicKey()
+			if !bytes.Equal(publicKey.SECCompressed(), keys[j]) && !bytes.Equal(publicKey.XOnly(), keys[j]) {
+				continue
+			}
+
+			privateKey, err := key.PrivateKey()
+			if err != nil {
+				return count, err
+			}
+
+			signed, err := p.SignInput(i, privateKey)
+			if err != nil {
+				return count, err
+			}
+			if signed {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Returns the descendant of key at the child indexes of path
+func deriveKey(key *hdkey.ExtendedKey, path []uint32) (*hdkey.ExtendedKey, error) {
+	for _, index := range path {
+		child, err := key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+
+	return key, nil
+}
@@ -0,0 +1,94 @@
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// SetNonWitnessUTXO sets prevTx as the transaction of the output spent by
+// the input at inputIndex. Inputs that do not spend segwit outputs need it to
+// be signed, while segwit inputs only need the output in WitnessUTXO.
+func (p *Packet) SetNonWitnessUTXO(inputIndex int, prevTx *bitcoin.Tx) error {
+	in := p.Inputs[inputIndex]
+	if !bytes.Equal(txHash(prevTx), in.PreviousTxID) {
+		return fmt.Errorf("transaction %s is not spent by input %d", prevTx.Id(), inputIndex)
+	}
+	if int(in.OutputIndex) >= len(prevTx.Outputs) {
+		return fmt.Errorf("transaction %s has no output %d", prevTx.Id(), in.OutputIndex)
+	}
+
+	in.NonWitnessUTXO = prevTx
+
+	return nil
+}
+
+// AddDerivation adds the origin of publicKey, which is in SEC format, to the
+// inputs and the outputs whose scripts use the key or its hash. Returns the
+// number of inputs and outputs that the key was added to.
+func (p *Packet) AddDerivation(publicKey []byte, derivation Derivation) (int, error) {
+	if err := checkPublicKey(publicKey); err != nil {
+		return 0, err
+	}
+	keyDerivation := KeyDerivation{publicKey, derivation}
+
+	count := 0
+	for i, in := range p.Inputs {
+		scripts := []*bitcoin.Script{in.RedeemScript, in.WitnessScript}
+		if prevOut, err := p.prevOut(i); err == nil {
+			scripts = append(scripts, &prevOut.ScriptPubKey)
+		}
+
+		if usesKey(publicKey, scripts...) {
+			in.Derivations = addKeyDerivation(in.Derivations, keyDerivation)
+			count++
+		}
+	}
+
+	for _, out := range p.Outputs {
+		if usesKey(publicKey, out.Script, out.RedeemScript, out.WitnessScript) {
+			out.Derivations = addKeyDerivation(out.Derivations, keyDerivation)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Returns the derivations with derivation, which replaces the one of the
+// same key
+func addKeyDerivation(derivations []KeyDerivation, derivation KeyDerivation) []KeyDerivation {
+	for i := range derivations {
+		if bytes.Equal(derivations[i].PublicKey, derivation.PublicKey) {
+			derivations[i] = derivation
+			return derivations
+		}
+	}
+
+	return append(derivations, derivation)
+}
+
+// Returns whether any of the scripts pushes the public key or its hash160,
+// where nil scripts are skipped
+func usesKey(publicKey []byte, scripts ...*bitcoin.Script) bool {
+	h160 := hash.Hash160(publicKey)
+	for _, script := range scripts {
+		if script == nil {
+			continue
+		}
+
+		for _, instruction := range script.Instructions() {
+			if instruction.IsOpCode() {
+				continue
+			}
+
+			if bytes.Equal(instruction.Bytes(), publicKey) || bytes.Equal(instruction.Bytes(), h160) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
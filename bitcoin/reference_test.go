@@ -0,0 +1,289 @@
+package bitcoin_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// The verification flags by the names used in the Bitcoin Core test vectors.
+var verifyFlagsByName = map[string]op.VerifyFlags{
+	"NONE":                       op.VerifyNone,
+	"P2SH":                       op.VerifyP2SH,
+	"STRICTENC":                  op.VerifyStrictEnc,
+	"DERSIG":                     op.VerifyDERSig,
+	"LOW_S":                      op.VerifyLowS,
+	"NULLDUMMY":                  op.VerifyNullDummy,
+	"SIGPUSHONLY":                op.VerifySigPushOnly,
+	"MINIMALDATA":                op.VerifyMinimalData,
+	"DISCOURAGE_UPGRADABLE_NOPS": op.VerifyDiscourageUpgradableNops,
+	"CLEANSTACK":                 op.VerifyCleanStack,
+	"CHECKLOCKTIMEVERIFY":        op.VerifyCheckLockTimeVerify,
+	"CHECKSEQUENCEVERIFY":        op.VerifyCheckSequenceVerify,
+	"NULLFAIL":                   op.VerifyNullFail,
+	"WITNESS":                    op.VerifyWitness,
+	"TAPROOT":                    op.VerifyTaproot,
+	"MINIMALIF":                  op.VerifyMinimalIf,
+	"WITNESS_PUBKEYTYPE":         op.VerifyWitnessPubKeyType,
+
+	"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM": op.VerifyDiscourageUpgradableWitnessProgram,
+}
+
+// The flags of the Bitcoin Core test vectors that are not implemented, whose
+// vectors are skipped. CONST_SCRIPTCODE makes OP_CODESEPARATOR and signatures
+// in the signed script of legacy scripts fail.
+var skippedVerifyFlags = []string{"CONST_SCRIPTCODE"}
+
+// Parses the flags of a test vector, skipping the vectors of the flags that
+// are not implemented and failing for unknown flags.
+func vectorFlags(t *testing.T, names string) op.VerifyFlags {
+	t.Helper()
+
+	for _, name := range strings.Split(names, ",") {
+		if slices.Contains(skippedVerifyFlags, name) {
+			t.Skipf("flag %s is not implemented", name)
+		}
+	}
+
+	flags, err := parseVerifyFlags(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return flags
+}
+
+// Parses a comma separated list of flags, failing for flags that are not
+// supported.
+func parseVerifyFlags(names string) (op.VerifyFlags, error) {
+	flags := op.VerifyNone
+	if names == "" {
+		return flags, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		flag, exists := verifyFlagsByName[name]
+		if !exists {
+			return flags, fmt.Errorf("unsupported flag %s", name)
+		}
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+// Loads the test vectors of a Bitcoin Core json file, leaving out the
+// comments, which are the vectors with a single string.
+func loadVectors(t *testing.T, name string) [][]any {
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+
+	var vectors [][]any
+	if err := json.Unmarshal(content, &vectors); err != nil {
+		t.Fatalf("parsing %s: %v", name, err)
+	}
+
+	return slices.DeleteFunc(vectors, func(vector []any) bool {
+		return len(vector) == 1
+	})
+}
+
+// Returns the Bitcoin Core name of the result of a script verification.
+func scriptResult(valid bool, err error) string {
+	var scriptErr *bitcoin.ScriptError
+	switch {
+	case err == nil && valid:
+		return "OK"
+	case err == nil:
+		return "EVAL_FALSE"
+	case errors.As(err, &scriptErr):
+		// Bitcoin Core has no error codes for invalid script numbers
+		if scriptErr.Code == op.ErrNumberOverflow || scriptErr.Code == op.ErrMinimalNumber {
+			return "UNKNOWN_ERROR"
+		}
+		return scriptErr.Code.String()
+	}
+
+	return "UNKNOWN_ERROR"
+}
+
+func TestScriptVectors(t *testing.T) {
+	for i, vector := range loadVectors(t, "script_tests.json") {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			// Witness vectors start with the witness elements followed by
+			// the amount of the spent output in bitcoin.
+			var witness [][]byte
+			var amount uint64
+			if elements, isWitness := vector[0].([]any); isWitness {
+				for _, element := range elements[:len(elements)-1] {
+					data, err := hex.DecodeString(element.(string))
+					if err != nil {
+						t.Fatalf("decoding witness: %v", err)
+					}
+					witness = append(witness, data)
+				}
+				amount = uint64(math.Round(elements[len(elements)-1].(float64) * 100_000_000))
+				vector = vector[1:]
+			}
+
+			scriptSigAsm, scriptPubKeyAsm := vector[0].(string), vector[1].(string)
+			flagNames, expected := vector[2].(string), vector[3].(string)
+
+			flags := vectorFlags(t, flagNames)
+
+			scriptSig, sigErr := bitcoin.ParseScriptAsm(scriptSigAsm)
+			scriptPubKey, pubKeyErr := bitcoin.ParseScriptAsm(scriptPubKeyAsm)
+			if sigErr != nil || pubKeyErr != nil {
+				// Scripts that end in the middle of a push can not be parsed
+				// and fail with a bad op code in Bitcoin Core.
+				if expected != "BAD_OPCODE" {
+					t.Fatalf("parsing %q %q: %v %v", scriptSigAsm, scriptPubKeyAsm, sigErr, pubKeyErr)
+				}
+				return
+			}
+
+			spendingTx := newSpendingTx(t, scriptSig, scriptPubKey, witness, amount)
+			result := scriptResult(spendingTx.VerifyInput(0, flags))
+
+			if result != expected {
+				t.Errorf("%q %q %s: expected %s, got %s", scriptSigAsm, scriptPubKeyAsm, flagNames, expected, result)
+			}
+		})
+	}
+}
+
+// Returns a transaction spending the only output of a coinbase transaction
+// that pays amount to scriptPubKey, as described in script_tests.json.
+func newSpendingTx(t *testing.T, scriptSig, scriptPubKey *bitcoin.Script, witness [][]byte, amount uint64) *bitcoin.Tx {
+	final := big.NewInt(0xffffffff)
+
+	coinbaseScriptSig, _ := bitcoin.ParseScriptAsm("0 0")
+	creditingTx := bitcoin.NewTx(1,
+		[]*bitcoin.TxInput{bitcoin.NewTxInput(make([]byte, 32), final, coinbaseScriptSig, final)},
+		[]*bitcoin.TxOutput{{Amount: amount, ScriptPubKey: *scriptPubKey}},
+		0, &chaincfg.MainNetParams)
+
+	creditingTxHash := hash.Hash256(creditingTx.Serialize())
+	txIn := bitcoin.NewTxInput(creditingTxHash, big.NewInt(0), scriptSig, final)
+	txIn.Witness = witness
+
+	spendingTx := bitcoin.NewTx(1,
+		[]*bitcoin.TxInput{txIn},
+		[]*bitcoin.TxOutput{{Amount: amount, ScriptPubKey: *bitcoin.NewScript([]op.Instruction{})}},
+		0, &chaincfg.MainNetParams)
+	spendingTx.PrevOutFetcher = bitcoin.PrevOutMap{txIn.String(): creditingTx.Outputs[0]}
+
+	return spendingTx
+}
+
+// Parses the transaction of a tx_valid.json or tx_invalid.json vector, with
+// the outputs that it spends.
+func parseTxVector(t *testing.T, vector []any) (*bitcoin.Tx, op.VerifyFlags) {
+	flags := vectorFlags(t, vector[2].(string))
+
+	raw, err := hex.DecodeString(vector[1].(string))
+	if err != nil {
+		t.Fatalf("decoding transaction: %v", err)
+	}
+
+	tx, err := bitcoin.Parse(bytes.NewReader(raw), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("parsing transaction: %v", err)
+	}
+
+	prevOuts := bitcoin.PrevOutMap{}
+	for _, element := range vector[0].([]any) {
+		prevOut := element.([]any)
+
+		prevTx, _ := hex.DecodeString(prevOut[0].(string))
+		slices.Reverse(prevTx)
+		prevIndex := big.NewInt(int64(uint32(int64(prevOut[1].(float64)))))
+
+		scriptPubKey, err := bitcoin.ParseScriptAsm(prevOut[2].(string))
+		if err != nil {
+			t.Fatalf("parsing %q: %v", prevOut[2], err)
+		}
+
+		var amount uint64
+		if len(prevOut) > 3 {
+			amount = uint64(prevOut[3].(float64))
+		}
+
+		outPoint := bitcoin.NewTxInput(prevTx, prevIndex, nil, nil).String()
+		prevOuts[outPoint] = &bitcoin.TxOutput{Amount: amount, ScriptPubKey: *scriptPubKey}
+	}
+	tx.PrevOutFetcher = prevOuts
+
+	return tx, flags
+}
+
+func TestTxValidVectors(t *testing.T) {
+	for i, vector := range loadVectors(t, "tx_valid.json") {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			tx, flags := parseTxVector(t, vector)
+
+			for index := range tx.Inputs {
+				valid, err := tx.VerifyInput(index, flags)
+				if err != nil || !valid {
+					t.Errorf("%s: input %d: expected OK, got %s (%v)", tx.Id(), index, scriptResult(valid, err), err)
+				}
+			}
+		})
+	}
+}
+
+// The tx_invalid.json vectors that are invalid because of the checks of a
+// transaction on its own, which Bitcoin Core does before its scripts are
+// verified. They are not script rules, so the interpreter does not do them.
+var skippedInvalidTxs = map[string]string{
+	"0ea8dd5d0a5d36350fc1ed1ade25df63c6dc98f966b7b0546335bd966bfe3399": "negative output amount",
+	"6a6b568d403db6c0ff04d018035d406280f283f023726718e63411abe4d31363": "duplicate inputs",
+	"bf93c6fe89592b2508f2876c6200d5ffadbd741e18c57b5e9fe5eff3101137b3": "coinbase scriptSig of 1 byte",
+	"d12fb29f9b00aaa9e89f5c34a27f43dd73f729aa796b36da0738b97f00587d0b": "coinbase scriptSig of 101 bytes",
+	"a87439547de75492b9fa7299b0ba1cf729c2ae53e84979bb713175171e93fe74": "null prevout of an input that is not a coinbase",
+	"2d3f5c65b0ae97f5f52bef7f3b9c8ffeaeed0957289b5750a1c75bf30dd4493a": "null prevout of an input that is not a coinbase",
+}
+
+func TestTxInvalidVectors(t *testing.T) {
+	for i, vector := range loadVectors(t, "tx_invalid.json") {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			tx, flags := parseTxVector(t, vector)
+			if reason, skipped := skippedInvalidTxs[tx.Id()]; skipped {
+				t.Skipf("%s: the interpreter does not check for a %s", tx.Id(), reason)
+			}
+
+			// An input must fail its scripts, and not for another reason
+			// such as a missing spent output
+			var scriptErr *bitcoin.ScriptError
+			for index := range tx.Inputs {
+				valid, err := tx.VerifyInput(index, flags)
+				switch {
+				case err == nil && !valid:
+					return
+				case errors.As(err, &scriptErr):
+					return
+				case err != nil:
+					t.Fatalf("%s: input %d: expected a script error, got %v", tx.Id(), index, err)
+				}
+			}
+
+			t.Errorf("%s: expected an invalid input", tx.Id())
+		})
+	}
+}
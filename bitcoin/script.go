@@ -3,12 +3,13 @@ package bitcoin
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
-	"slices"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
 )
@@ -21,6 +22,26 @@ func NewScript(instructions []op.Instruction) *Script {
 	return &Script{instructions}
 }
 
+// Returns the script that pushes each of the elements, where an empty
+// element is OP_0, which is how scriptSigs are made from their stack.
+func ToPushOnlyScript(elements [][]byte) (*Script, error) {
+	instructions := make([]op.Instruction, 0, len(elements))
+	for _, element := range elements {
+		if len(element) == 0 {
+			instructions = append(instructions, *op.NewOpCode(0x00)) // OP_0
+			continue
+		}
+
+		instruction, err := op.NewInstruction(element)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, *instruction)
+	}
+
+	return NewScript(instructions), nil
+}
+
 func (script *Script) String() string {
 	return "notImplementedYet"
 }
@@ -29,20 +50,85 @@ func (script *Script) Instructions() []op.Instruction {
 	return script.instructions
 }
 
+// Returns whether this follows the
+// OP_DUP OP_HASH160 <20 byte hash> OP_EQUALVERIFY OP_CHECKSIG pattern.
+func (script *Script) IsP2PKHScriptPubKey() bool {
+	return len(script.instructions) == 5 &&
+		script.instructions[0].Equals(op.NewOpCode(0x76)) && // OP_DUP
+		script.instructions[1].Equals(&op.OP_CODE.HASH160) &&
+		script.instructions[2].Length() == 20 &&
+		script.instructions[2].PushOpCode() == 20 &&
+		script.instructions[3].Equals(op.NewOpCode(0x88)) && // OP_EQUALVERIFY
+		script.instructions[4].Equals(op.NewOpCode(0xac)) // OP_CHECKSIG
+}
+
 // Returns whether this follows the
 // OP_HASH160 <20 byte hash> OP_EQUAL pattern.
 func (script *Script) IsP2SHScriptPubKey() bool {
 	return len(script.instructions) == 3 &&
 		script.instructions[0].Equals(&op.OP_CODE.HASH160) &&
 		script.instructions[1].Length() == 20 &&
+		script.instructions[1].PushOpCode() == 20 &&
 		script.instructions[2].Equals(&op.OP_CODE.EQUAL)
 }
 
+// Returns the version and program of a witness program, which is a version
+// op code, OP_0 or OP_1 to OP_16, followed by a direct push of 2 to 40 bytes,
+// see BIP 141.
+func (script *Script) WitnessProgram() (int, []byte, bool) {
+	if len(script.instructions) != 2 || !script.instructions[0].IsOpCode() {
+		return 0, nil, false
+	}
+
+	version := script.instructions[0].OpCode()
+	if version != 0 && (version < 81 || version > 96) {
+		return 0, nil, false
+	}
+	if version != 0 {
+		version -= 80
+	}
+
+	program := script.instructions[1]
+	if program.IsOpCode() || program.Length() < 2 || program.Length() > 40 {
+		return 0, nil, false
+	}
+
+	if int(program.PushOpCode()) != program.Length() {
+		return 0, nil, false
+	}
+
+	return version, program.Bytes(), true
+}
+
+// Returns whether this follows the OP_0 <20 byte hash> pattern.
+func (script *Script) IsP2WPKHScriptPubKey() bool {
+	version, program, ok := script.WitnessProgram()
+
+	return ok && version == 0 && len(program) == 20
+}
+
+// Returns whether this follows the OP_0 <32 byte hash> pattern.
+func (script *Script) IsP2WSHScriptPubKey() bool {
+	version, program, ok := script.WitnessProgram()
+
+	return ok && version == 0 && len(program) == 32
+}
+
+// Returns whether this follows the OP_1 <32 byte x-only key> pattern.
+func (script *Script) IsP2TRScriptPubKey() bool {
+	version, program, ok := script.WitnessProgram()
+
+	return ok && version == 1 && len(program) == 32
+}
+
 func ParseScript(data io.Reader) (*Script, error) {
 	length, err := varint.Decode(data)
 	if err != nil {
 		return nil, err
 	}
+	if length > maxTxSize {
+		return nil, fmt.Errorf("script of %d bytes is too large", length)
+	}
 
 	instructions := make([]op.Instruction, 0)
 	scriptLength := length
@@ -50,7 +136,7 @@ func ParseScript(data io.Reader) (*Script, error) {
 	var count uint64 = 0
 	for count < scriptLength {
 		current := make([]byte, 1)
-		_, err := data.Read(current)
+		_, err := io.ReadFull(data, current)
 		if err != nil {
 			return nil, err
 		}
@@ -58,64 +144,42 @@ func ParseScript(data io.Reader) (*Script, error) {
 		count += 1
 
 		currentByte := current[0]
-		if currentByte >= 1 && currentByte <= 75 {
-			tmpData := make([]byte, currentByte)
-			_, err := data.Read(tmpData)
-			if err != nil {
-				return nil, err
-			}
-			instruction, err := op.NewInstruction(tmpData)
-			if err != nil {
-				return nil, err
+		if currentByte >= 1 && currentByte <= 78 {
+			// The op code is the length of the data, or OP_PUSHDATA1, OP_PUSHDATA2
+			// and OP_PUSHDATA4 followed by the length as a 1, 2 or 4 byte integer.
+			lengthSize := 0
+			switch currentByte {
+			case 76:
+				lengthSize = 1
+			case 77:
+				lengthSize = 2
+			case 78:
+				lengthSize = 4
 			}
-			instructions = append(instructions, *instruction)
-			count += uint64(currentByte)
-		} else if currentByte == 76 {
-			lengthContainer := make([]byte, 1)
-			_, err := data.Read(lengthContainer)
-			if err != nil {
-				return nil, err
-			}
-			dataLength := int32(binary.LittleEndian.Uint32(lengthContainer))
 
-			tmpData := make([]byte, dataLength)
-			_, err = data.Read(tmpData)
-			if err != nil {
-				return nil, err
-			}
-			instruction, err := op.NewInstruction(tmpData)
-			if err != nil {
-				return nil, err
+			dataLength := uint64(currentByte)
+			if lengthSize > 0 {
+				lengthContainer := make([]byte, 4)
+				_, err := io.ReadFull(data, lengthContainer[:lengthSize])
+				if err != nil {
+					return nil, err
+				}
+				dataLength = uint64(binary.LittleEndian.Uint32(lengthContainer))
 			}
-			instructions = append(instructions, *instruction)
 
-			count += uint64(dataLength) + 1
-		} else if currentByte == 77 {
-			lengthContainer := make([]byte, 2)
-			_, err := data.Read(lengthContainer)
-			if err != nil {
-				return nil, err
+			if count+uint64(lengthSize)+dataLength > scriptLength {
+				return nil, fmt.Errorf("parsing script failed")
 			}
-			dataLength := int32(binary.LittleEndian.Uint32(lengthContainer))
 
-			tmpData := make([]byte, dataLength)
-			_, err = data.Read(tmpData)
+			tmpData, err := readBytes(data, dataLength)
 			if err != nil {
 				return nil, err
 			}
-			instruction, err := op.NewInstruction(tmpData)
-			if err != nil {
-				return nil, err
-			}
-			instructions = append(instructions, *instruction)
+			instructions = append(instructions, *op.NewPushData(currentByte, tmpData))
 
-			count += uint64(dataLength) + 2
+			count += uint64(lengthSize) + dataLength
 		} else {
-			instruction, err := op.NewInstruction([]byte{currentByte})
-			if err != nil {
-				return nil, err
-			}
-			instructions = append(instructions, *instruction)
+			instructions = append(instructions, *op.NewOpCode(currentByte))
 		}
 	}
 
@@ -135,24 +199,25 @@ func (script *Script) RawSerialize() ([]byte, error) {
 			scriptAsBytes = append(scriptAsBytes, instruction.Bytes()...)
 		} else {
 			length := instruction.Length()
+			pushOpCode := instruction.PushOpCode()
 
-			if length < 76 {
+			scriptAsBytes = append(scriptAsBytes, pushOpCode)
+			switch pushOpCode {
+			case 76:
+				if length > 0xff {
+					return nil, fmt.Errorf("too long of an instruction")
+				}
 				value := endian.BigIntToLittleEndian(big.NewInt(int64(length)), 1)
 				scriptAsBytes = append(scriptAsBytes, value...)
-			} else if length < 256 {
-				value := endian.BigIntToLittleEndian(big.NewInt(int64(76)), 2)
-				scriptAsBytes = append(scriptAsBytes, value...)
-
-				value = endian.BigIntToLittleEndian(big.NewInt(int64(length)), 1)
+			case 77:
+				if length > 0xffff {
+					return nil, fmt.Errorf("too long of an instruction")
+				}
+				value := endian.BigIntToLittleEndian(big.NewInt(int64(length)), 2)
 				scriptAsBytes = append(scriptAsBytes, value...)
-			} else if length <= 520 {
-				value := endian.BigIntToLittleEndian(big.NewInt(int64(77)), 2)
+			case 78:
+				value := endian.BigIntToLittleEndian(big.NewInt(int64(length)), 4)
 				scriptAsBytes = append(scriptAsBytes, value...)
-
-				value = endian.BigIntToLittleEndian(big.NewInt(int64(length)), 2)
-				scriptAsBytes = append(scriptAsBytes, value...)
-			} else {
-				return nil, fmt.Errorf("too long of an instruction")
 			}
 			scriptAsBytes = append(scriptAsBytes, instruction.Bytes()...)
 		}
@@ -176,145 +241,751 @@ func (script *Script) Serialize() ([]byte, error) {
 	return append(length, scriptAsBytes...), nil
 }
 
+// Returns a copy of the script where all OP_CODESEPARATOR are removed, as
+// done when the script is signed.
+func (script *Script) withoutCodeSeparators() *Script {
+	instructions := make([]op.Instruction, 0, len(script.instructions))
+	for _, instruction := range script.instructions {
+		if instruction.OpCode() != 171 {
+			instructions = append(instructions, instruction)
+		}
+	}
+
+	return NewScript(instructions)
+}
+
+// Returns a copy of the script where the pushes of the signatures are
+// removed, as a signature cannot sign itself. Only pushes with the default
+// push op code for the length of a signature are removed.
+func (script *Script) withoutSignatures(signatures [][]byte) *Script {
+	instructions := make([]op.Instruction, 0, len(script.instructions))
+	for _, instruction := range script.instructions {
+		if !isSignaturePush(instruction, signatures) {
+			instructions = append(instructions, instruction)
+		}
+	}
+
+	return NewScript(instructions)
+}
+
+func isSignaturePush(instruction op.Instruction, signatures [][]byte) bool {
+	for _, signature := range signatures {
+		// An empty signature is pushed by OP_0
+		if len(signature) == 0 {
+			if instruction.IsOpCode() && instruction.OpCode() == 0 {
+				return true
+			}
+			continue
+		}
+
+		if instruction.IsOpCode() || !bytes.Equal(instruction.Bytes(), signature) {
+			continue
+		}
+
+		if instruction.PushOpCode() == op.NewPushData(0, signature).PushOpCode() {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (script *Script) Add(other *Script) *Script {
 	instructions := append(script.instructions, other.instructions...)
 	return NewScript(instructions)
 }
 
-func (script *Script) Evaluate(z []byte) (bool, error) {
+const (
+	// The maximum size of a script in bytes
+	MaxScriptSize = 10_000
+	// The maximum number of non-push op codes in a script
+	MaxOpsPerScript = 201
+	// The maximum number of elements on the stack and alt stack together
+	MaxStackSize = 1_000
+)
+
+// ScriptError is returned when the evaluation of a script fails, telling
+// which rule failed at which instruction.
+type ScriptError struct {
+	// The rule that failed
+	Code op.ErrorCode
+	// The op code that failed, or -1 when it is not caused by an op code
+	OpCode int
+	// The index of the failing instruction in the script
+	Index int
+	Err   error
+}
+
+// Returns a ScriptError for err, taking the code from err when the op code
+// returned an *op.Error.
+func newScriptError(opCode int, index int, err error) *ScriptError {
+	code := op.ErrUnknown
+
+	var opErr *op.Error
+	if errors.As(err, &opErr) {
+		code = opErr.Code
+	}
+
+	return &ScriptError{code, opCode, index, err}
+}
+
+func (e *ScriptError) Error() string {
+	name, exists := op.OP_CODE_NAMES[e.OpCode]
+	if !exists {
+		if e.OpCode < 0 {
+			return fmt.Sprintf("script failed at instruction %d: %s", e.Index, e.Err)
+		}
+		name = fmt.Sprintf("0x%02x", e.OpCode)
+	}
+
+	return fmt.Sprintf("script failed at instruction %d (%s): %s", e.Index, name, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// Evaluates the script, where checker gives the signature and locktime op
+// codes access to the spending transaction. The rules selected by flags are
+// enforced on top of the basic script rules. Returns whether the element
+// left on top of the stack is true.
+func (script *Script) Evaluate(checker op.TxChecker, flags op.VerifyFlags) (bool, error) {
+	stack, err := script.execute(op.NewStackWithFlags(baseFlags(flags)), checker, op.SigVersionBase, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return isTrue(stack), nil
+}
+
+// Verifies that scriptSig and witness unlock scriptPubKey, enforcing the
+// rules selected by flags. The scriptSig is evaluated first and the
+// resulting stack is used to evaluate the scriptPubKey. If the scriptPubKey
+// is a pay-to-script-hash and VerifyP2SH is set, the redeem script, the last
+// element of the scriptSig, is evaluated on what is left of the scriptSig
+// stack. If VerifyWitness is set and the scriptPubKey or the redeem script is
+// a witness program, the witness is evaluated against it.
+func VerifyScript(scriptSig, scriptPubKey *Script, witness [][]byte, checker op.TxChecker, flags op.VerifyFlags) (bool, error) {
+	if flags.Has(op.VerifySigPushOnly) && !scriptSig.IsPushOnly() {
+		return false, &ScriptError{op.ErrSigPushOnly, -1, 0, fmt.Errorf("scriptSig is not push only")}
+	}
+
+	stack, err := scriptSig.execute(op.NewStackWithFlags(baseFlags(flags)), checker, op.SigVersionBase, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// Every script starts with an empty alt stack, so the stack is copied
+	// instead of passed on.
+	p2shStack := stack.Copy()
+
+	stack, err = scriptPubKey.execute(stack.Copy(), checker, op.SigVersionBase, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if !isTrue(stack) {
+		return false, nil
+	}
+
+	hasWitnessProgram := false
+	if version, program, ok := scriptPubKey.WitnessProgram(); ok && flags.Has(op.VerifyWitness) {
+		hasWitnessProgram = true
+
+		if len(scriptSig.instructions) != 0 {
+			return false, &ScriptError{op.ErrWitnessMalleated, -1, 0, fmt.Errorf("scriptSig of a witness program is not empty")}
+		}
+
+		if valid, err := verifyWitnessProgram(witness, version, program, false, checker, flags); err != nil || !valid {
+			return valid, err
+		}
+
+		// The witness is evaluated on its own stack
+		stack = op.NewStackWithFlags(flags)
+		stack.Push(op.NewPushData(1, []byte{1}))
+	}
+
+	if flags.Has(op.VerifyP2SH) && scriptPubKey.IsP2SHScriptPubKey() {
+		if !scriptSig.IsPushOnly() {
+			return false, &ScriptError{op.ErrSigPushOnly, -1, 0, fmt.Errorf("scriptSig is not push only")}
+		}
+
+		element, err := p2shStack.Pop()
+		if err != nil {
+			return false, newScriptError(-1, 0, err)
+		}
+
+		// A redeem script that ends in the middle of a push fails like a
+		// bad op code
+		redeemScript, err := ParseRawScript(element.Bytes())
+		if err != nil {
+			return false, &ScriptError{op.ErrBadOpCode, -1, 0, err}
+		}
+
+		stack, err = redeemScript.execute(p2shStack, checker, op.SigVersionBase, nil)
+		if err != nil {
+			return false, err
+		}
+
+		if !isTrue(stack) {
+			return false, nil
+		}
+
+		if version, program, ok := redeemScript.WitnessProgram(); ok && flags.Has(op.VerifyWitness) {
+			hasWitnessProgram = true
+
+			if len(scriptSig.instructions) != 1 {
+				return false, &ScriptError{op.ErrWitnessMalleatedP2SH, -1, 0, fmt.Errorf("scriptSig of a nested witness program is not a single push")}
+			}
+
+			if valid, err := verifyWitnessProgram(witness, version, program, true, checker, flags); err != nil || !valid {
+				return valid, err
+			}
+
+			stack = op.NewStackWithFlags(flags)
+			stack.Push(op.NewPushData(1, []byte{1}))
+		}
+	}
+
+	if flags.Has(op.VerifyCleanStack) && stack.Size() != 1 {
+		return false, &ScriptError{op.ErrCleanStack, -1, len(scriptPubKey.instructions), fmt.Errorf("stack size is not 1")}
+	}
+
+	if flags.Has(op.VerifyWitness) && !hasWitnessProgram && len(witness) != 0 {
+		return false, &ScriptError{op.ErrWitnessUnexpected, -1, 0, fmt.Errorf("witness for an output that is not a witness program")}
+	}
+
+	return true, nil
+}
+
+// Returns the flags that are enforced when executing scripts that are not
+// witness scripts, leaving out the rules of version 0 witness scripts, which
+// are enforced by the op codes
+func baseFlags(flags op.VerifyFlags) op.VerifyFlags {
+	return flags &^ op.VerifyWitnessPubKeyType
+}
+
+// Verifies the witness against a witness program. Version 0 programs are
+// either the hash of a public key or the SHA-256 of a witness script, and
+// version 1 programs of 32 bytes that are not nested in a
+// pay-to-script-hash are taproot outputs, which succeed unless VerifyTaproot
+// is set. Other programs are reserved for soft forks and succeed unless
+// VerifyDiscourageUpgradableWitnessProgram is set.
+func verifyWitnessProgram(witness [][]byte, version int, program []byte, isP2SH bool, checker op.TxChecker, flags op.VerifyFlags) (bool, error) {
+	if version == 1 && len(program) == 32 && !isP2SH {
+		if !flags.Has(op.VerifyTaproot) {
+			return true, nil
+		}
+		return verifyTaproot(witness, program, checker, flags)
+	}
+
+	if version != 0 {
+		if flags.Has(op.VerifyDiscourageUpgradableWitnessProgram) {
+			return false, &ScriptError{op.ErrDiscourageUpgradableWitnessProgram, -1, 0, fmt.Errorf("witness program of version %d", version)}
+		}
+		return true, nil
+	}
+
+	var script *Script
+	var elements [][]byte
+	switch len(program) {
+	case 32:
+		if len(witness) == 0 {
+			return false, &ScriptError{op.ErrWitnessProgramWitnessEmpty, -1, 0, fmt.Errorf("witness is empty")}
+		}
+
+		witnessScript := witness[len(witness)-1]
+		if !bytes.Equal(hash.HashSHA256(witnessScript), program) {
+			return false, &ScriptError{op.ErrWitnessProgramMismatch, -1, 0, fmt.Errorf("witness script does not match the program")}
+		}
+
+		var err error
+		script, err = ParseRawScript(witnessScript)
+		if err != nil {
+			return false, &ScriptError{op.ErrBadOpCode, -1, 0, err}
+		}
+		elements = witness[:len(witness)-1]
+	case 20:
+		if len(witness) != 2 {
+			return false, &ScriptError{op.ErrWitnessProgramMismatch, -1, 0, fmt.Errorf("witness does not have 2 elements")}
+		}
+
+		var err error
+		script, err = ToP2PKHScript(program)
+		if err != nil {
+			return false, err
+		}
+		elements = witness
+	default:
+		return false, &ScriptError{op.ErrWitnessProgramWrongLength, -1, 0, fmt.Errorf("witness program of %d bytes", len(program))}
+	}
+
+	stack := op.NewStackWithFlags(flags)
+	for _, data := range elements {
+		element, err := op.NewInstruction(data)
+		if err != nil {
+			return false, newScriptError(-1, 0, err)
+		}
+
+		stack.Push(element)
+	}
+
+	stack, err := script.execute(stack, checker, op.SigVersionWitnessV0, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// The witness script must leave exactly one element on the stack, as if
+	// VerifyCleanStack was set
+	if stack.Size() != 1 {
+		return false, &ScriptError{op.ErrCleanStack, -1, len(script.instructions), fmt.Errorf("stack size is not 1")}
+	}
+
+	return isTrue(stack), nil
+}
+
+// Verifies the witness of a taproot output, where program is the x-only
+// output key, see BIP 341. A witness of one element is a signature of the
+// output key. Otherwise the last element is a control block proving that the
+// element before it is a script of the output key, which is executed on the
+// rest of the witness when it is a tapscript. An annex, a last element
+// starting with 0x50, is only signed.
+func verifyTaproot(witness [][]byte, program []byte, checker op.TxChecker, flags op.VerifyFlags) (bool, error) {
+	if len(witness) == 0 {
+		return false, &ScriptError{op.ErrWitnessProgramWitnessEmpty, -1, 0, fmt.Errorf("witness is empty")}
+	}
+
+	execData := op.TaprootExecutionData{CodeSeparatorPosition: 0xffffffff}
+
+	elements := witness
+	if len(elements) >= 2 && len(elements[len(elements)-1]) > 0 && elements[len(elements)-1][0] == 0x50 {
+		execData.Annex = elements[len(elements)-1]
+		elements = elements[:len(elements)-1]
+	}
+
+	if len(elements) == 1 {
+		sigHash := func(hashType byte) ([]byte, error) {
+			if checker == nil {
+				return nil, fmt.Errorf("no transaction to check the signature against")
+			}
+
+			return checker.TaprootSignatureHash(hashType, &execData)
+		}
+
+		if err := op.CheckSchnorrSignature(elements[0], program, sigHash); err != nil {
+			return false, newScriptError(-1, 0, err)
+		}
 
-	stack := op.NewStack()
-	// altStack := NewStack()
+		return true, nil
+	}
+
+	control := elements[len(elements)-1]
+	rawScript := elements[len(elements)-2]
+	elements = elements[:len(elements)-2]
+
+	if !isValidControlBlockSize(len(control)) {
+		return false, &ScriptError{op.ErrTaprootWrongControlSize, -1, 0, fmt.Errorf("invalid control block size: %d", len(control))}
+	}
+
+	controlBlock, err := ParseControlBlock(control)
+	if err != nil {
+		return false, &ScriptError{op.ErrWitnessProgramMismatch, -1, 0, err}
+	}
+
+	if valid, err := controlBlock.Verify(program, rawScript); err != nil || !valid {
+		return false, &ScriptError{op.ErrWitnessProgramMismatch, -1, 0, fmt.Errorf("script is not committed to by the output key")}
+	}
+
+	// Other leaf versions are reserved for soft forks
+	if controlBlock.LeafVersion != TapscriptLeafVersion {
+		return true, nil
+	}
+
+	// A tapscript with an OP_SUCCESSx op code succeeds without being executed
+	success, err := hasSuccessOpCode(rawScript)
+	if err != nil {
+		return false, &ScriptError{op.ErrBadOpCode, -1, 0, err}
+	}
+	if success {
+		return true, nil
+	}
+
+	script, err := ParseRawScript(rawScript)
+	if err != nil {
+		return false, &ScriptError{op.ErrBadOpCode, -1, 0, err}
+	}
+
+	if len(elements) > MaxStackSize {
+		return false, &ScriptError{op.ErrStackSize, -1, 0, fmt.Errorf("more than %d stack elements", MaxStackSize)}
+	}
+
+	stack := op.NewStackWithFlags(flags)
+	for _, data := range elements {
+		element, err := op.NewInstruction(data)
+		if err != nil {
+			return false, newScriptError(-1, 0, err)
+		}
+
+		stack.Push(element)
+	}
+
+	execData.TapLeafHash = TapLeafHash(controlBlock.LeafVersion, rawScript)
+	tapscript := &op.Tapscript{
+		Checker:              checker,
+		ExecutionData:        execData,
+		ValidationWeightLeft: int64(len(SerializeWitness(witness))) + op.ValidationWeightOffset,
+	}
+
+	stack, err = script.execute(stack, checker, op.SigVersionTapscript, tapscript)
+	if err != nil {
+		return false, err
+	}
+
+	// The tapscript must leave exactly one element on the stack, as if
+	// VerifyCleanStack was set
+	if stack.Size() != 1 {
+		return false, &ScriptError{op.ErrCleanStack, -1, len(script.instructions), fmt.Errorf("stack size is not 1")}
+	}
+
+	return isTrue(stack), nil
+}
+
+// Returns whether the raw script has an OP_SUCCESSx op code, looking no
+// further than the first push that runs past the end of the script, which
+// gives an error.
+func hasSuccessOpCode(raw []byte) (bool, error) {
+	for i := 0; i < len(raw); {
+		opCode := raw[i]
+		i++
+
+		if op.IsSuccess(int(opCode)) {
+			return true, nil
+		}
+
+		length := 0
+		switch {
+		case opCode >= 1 && opCode <= 75:
+			length = int(opCode)
+		case opCode >= 76 && opCode <= 78:
+			lengthSize := 1 << (opCode - 76)
+			if i+lengthSize > len(raw) {
+				return false, fmt.Errorf("push past the end of the script")
+			}
+
+			lengthContainer := make([]byte, 4)
+			copy(lengthContainer, raw[i:i+lengthSize])
+			length = int(binary.LittleEndian.Uint32(lengthContainer))
+			i += lengthSize
+		}
+
+		if length > len(raw)-i {
+			return false, fmt.Errorf("push past the end of the script")
+		}
+		i += length
+	}
+
+	return false, nil
+}
+
+// Returns whether the script only contains data pushes.
+func (script *Script) IsPushOnly() bool {
+	for _, instruction := range script.instructions {
+		// OP_1NEGATE, OP_RESERVED and OP_1 to OP_16 are counted as pushes
+		if instruction.OpCode() > 96 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Executes the instructions of the script on the stack, enforcing the rules
+// selected by the flags of the stack. Signatures are checked against the
+// signature hash selected by sigVersion. A tapscript is executed with the
+// rules of BIP 342, where tapscript holds the state of its signature checks.
+func (script *Script) execute(stack *op.Stack, checker op.TxChecker, sigVersion op.SigVersion, tapscript *op.Tapscript) (*op.Stack, error) {
+	flags := stack.Flags()
+	isTapscript := sigVersion == op.SigVersionTapscript
+
+	raw, err := script.RawSerialize()
+	if err != nil {
+		return nil, err
+	}
+
+	// The size of a tapscript is only limited by the block size
+	if len(raw) > MaxScriptSize && !isTapscript {
+		return nil, &ScriptError{op.ErrScriptSize, -1, 0, fmt.Errorf("script is larger than %d bytes", MaxScriptSize)}
+	}
+
+	// The signed script starts after the last executed OP_CODESEPARATOR
+	codeSeparatorIndex := 0
+	sigHash := func(hashType byte, signatures [][]byte) ([]byte, error) {
+		if checker == nil {
+			return nil, fmt.Errorf("no transaction to check the signature against")
+		}
+
+		scriptCode := NewScript(script.instructions[codeSeparatorIndex:])
+		if sigVersion == op.SigVersionBase {
+			scriptCode = scriptCode.withoutSignatures(signatures)
+		}
+
+		raw, err := scriptCode.RawSerialize()
+		if err != nil {
+			return nil, err
+		}
 
-	for len(script.instructions) > 0 {
-		instruction := script.instructions[0]
-		script.instructions = script.instructions[1:]
+		return checker.SignatureHash(raw, hashType, sigVersion)
+	}
 
+	opCount := 0
+	for index, instruction := range script.instructions {
 		if instruction.IsOpCode() {
-			opCode := int(instruction.Bytes()[0])
-			operation, exists := op.OP_CODE_FUNCTIONS[opCode]
-			if exists {
-				s, err := operation(stack)
-				if err != nil {
-					return false, err
-				}
-				stack = s
-			} else {
-				if opCode == 99 {
-					s, instructions, err := op.IF(stack, script.instructions)
-					if err != nil {
-						return false, err
-					}
-					stack = s
-					script.instructions = *instructions
-				} else if opCode == 100 {
-					// OP_IF, OP_NOTIF
-
-				} else if slices.Contains([]int{107, 108}, opCode) {
-					// OP_TOALTSTACK, OP_FROMALTSTACK
-				} else if slices.Contains([]int{172, 173, 174, 175}, opCode) {
-					// OP_CHECKSIG, OP_CHECKSIGVERIFY, OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY
-					s, err := op.CHECKSIG(stack, new(big.Int).SetBytes(z))
-					if err != nil {
-						return false, err
-					}
-					stack = s
+			opCode := instruction.OpCode()
+
+			// Pushes of OP_0 to OP_16 are not counted, and a tapscript has no
+			// limit on the number of op codes
+			if opCode > 96 && !isTapscript {
+				opCount++
+				if opCount > MaxOpsPerScript {
+					return nil, &ScriptError{op.ErrOpCount, opCode, index, fmt.Errorf("more than %d op codes", MaxOpsPerScript)}
 				}
 			}
-		} else {
-			element, err := op.NewInstruction(instruction.Bytes())
-			if err != nil {
-				return false, err
+
+			if op.IsDisabled(opCode) {
+				return nil, &ScriptError{op.ErrDisabledOpCode, opCode, index, fmt.Errorf("disabled opcode")}
 			}
-			stack.Push(element)
-			if len(script.instructions) == 3 &&
-				script.instructions[0].Equals(&op.OP_CODE.HASH160) &&
-				script.instructions[1].Length() == 20 &&
-				script.instructions[2].Equals(&op.OP_CODE.EQUAL) {
 
-				hash160 := script.instructions[1]
+			if !stack.IsExecuting() && !op.IsConditional(opCode) {
+				continue
+			}
 
-				script.instructions = script.instructions[3:]
-				stack, err := op.HASH160(stack)
-				if err != nil {
-					return false, err
+			// The public keys of OP_CHECKMULTISIG count towards the op codes
+			if (opCode == 174 || opCode == 175) && !isTapscript {
+				if top, err := stack.Peek(); err == nil && top.Length() <= 4 {
+					if n := top.Int64(); n >= 0 && n <= op.MaxPubKeysPerMultisig {
+						opCount += int(n)
+					}
 				}
-				stack.Push(&hash160)
 
-				stack, err = op.EQUAL(stack)
-				if err != nil {
-					return false, err
+				if opCount > MaxOpsPerScript {
+					return nil, &ScriptError{op.ErrOpCount, opCode, index, fmt.Errorf("more than %d op codes", MaxOpsPerScript)}
 				}
+			}
 
-				_, err = op.VERIFY(stack)
-				if err != nil {
-					return false, err
+			if isUpgradableNop(opCode, flags) && flags.Has(op.VerifyDiscourageUpgradableNops) {
+				return nil, &ScriptError{op.ErrDiscourageUpgradableNops, opCode, index, fmt.Errorf("upgradable NOP executed")}
+			}
+
+			if opCode == 171 {
+				codeSeparatorIndex = index + 1
+				if isTapscript {
+					tapscript.ExecutionData.CodeSeparatorPosition = uint32(index)
 				}
+			}
 
-				length, err := varint.Encode(uint64(instruction.Length()))
-				if err != nil {
-					return false, err
+			// The argument of OP_IF and OP_NOTIF in a tapscript must be empty
+			// or exactly 0x01, and so must it in a version 0 witness script
+			// when VerifyMinimalIf is set
+			if (opCode == 99 || opCode == 100) && stack.IsExecuting() {
+				top, err := stack.Peek()
+				switch {
+				case err != nil || isMinimalIf(top):
+				case isTapscript:
+					return nil, &ScriptError{op.ErrTapscriptMinimalIf, opCode, index, fmt.Errorf("OP_IF argument is not minimal")}
+				case sigVersion == op.SigVersionWitnessV0 && flags.Has(op.VerifyMinimalIf):
+					return nil, &ScriptError{op.ErrMinimalIf, opCode, index, fmt.Errorf("OP_IF argument is not minimal")}
 				}
+			}
 
-				redeemScript, err := ParseScript(bytes.NewReader(append(length, instruction.Bytes()...)))
-				if err != nil {
-					return false, err
+			var err error
+			operation, exists := op.OP_CODE_FUNCTIONS[opCode]
+			if exists {
+				stack, err = operation(stack)
+			} else {
+				switch {
+				case opCode == 172 && isTapscript:
+					stack, err = tapscript.CHECKSIG(stack)
+				case opCode == 173 && isTapscript:
+					stack, err = tapscript.CHECKSIGVERIFY(stack)
+				case (opCode == 174 || opCode == 175) && isTapscript:
+					err = &op.Error{Code: op.ErrTapscriptCheckMultiSig, Description: "OP_CHECKMULTISIG is disabled in tapscript"}
+				case opCode == 186 && isTapscript:
+					stack, err = tapscript.CHECKSIGADD(stack)
+				case opCode == 172:
+					stack, err = op.CHECKSIG(stack, sigHash)
+				case opCode == 173:
+					stack, err = op.CHECKSIGVERIFY(stack, sigHash)
+				case opCode == 174:
+					stack, err = op.CHECKMULTISIG(stack, sigHash)
+				case opCode == 175:
+					stack, err = op.CHECKMULTISIGVERIFY(stack, sigHash)
+				case opCode == 177:
+					if flags.Has(op.VerifyCheckLockTimeVerify) {
+						stack, err = op.CHECKLOCKTIMEVERIFY(stack, checker)
+					}
+				case opCode == 178:
+					if flags.Has(op.VerifyCheckSequenceVerify) {
+						stack, err = op.CHECKSEQUENCEVERIFY(stack, checker)
+					}
+				default:
+					err = &op.Error{Code: op.ErrBadOpCode, Description: "bad opcode"}
 				}
+			}
+
+			if err != nil {
+				return nil, newScriptError(opCode, index, err)
+			}
+		} else {
+			if instruction.Length() > op.MaxScriptElementSize {
+				return nil, &ScriptError{op.ErrPushSize, -1, index, fmt.Errorf("push larger than %d bytes", op.MaxScriptElementSize)}
+			}
+
+			if !stack.IsExecuting() {
+				continue
+			}
 
-				script.instructions = append(redeemScript.instructions, script.instructions...)
+			if flags.Has(op.VerifyMinimalData) && !instruction.IsMinimalPush() {
+				return nil, &ScriptError{op.ErrMinimalData, -1, index, fmt.Errorf("data push larger than necessary")}
 			}
+
+			element, err := op.NewInstruction(instruction.Bytes())
+			if err != nil {
+				return nil, newScriptError(-1, index, err)
+			}
+			stack.Push(element)
 		}
-	}
 
-	if stack.Size() == 0 {
-		return false, fmt.Errorf("stack size is not 1")
+		if stack.Size()+stack.AltSize() > MaxStackSize {
+			return nil, &ScriptError{op.ErrStackSize, instruction.OpCode(), index, fmt.Errorf("more than %d stack elements", MaxStackSize)}
+		}
 	}
 
-	element, err := stack.Pop()
-	if err != nil {
-		return false, err
+	if !stack.IsBalanced() {
+		return nil, &ScriptError{op.ErrUnbalancedConditional, -1, len(script.instructions), fmt.Errorf("missing OP_ENDIF")}
 	}
 
-	if element == nil {
-		return false, fmt.Errorf("element is nil")
+	return stack, nil
+}
+
+// Returns whether the op code is a NOP reserved for soft forks. OP_NOP2 and
+// OP_NOP3 are only upgradable when OP_CHECKLOCKTIMEVERIFY and
+// OP_CHECKSEQUENCEVERIFY are not enforced.
+func isUpgradableNop(opCode int, flags op.VerifyFlags) bool {
+	switch {
+	case opCode == 176 || (opCode >= 179 && opCode <= 185):
+		return true
+	case opCode == 177:
+		return !flags.Has(op.VerifyCheckLockTimeVerify)
+	case opCode == 178:
+		return !flags.Has(op.VerifyCheckSequenceVerify)
 	}
-	return true, nil
+
+	return false
 }
 
-func ToP2PKHScript(h160 []byte) (*Script, error) {
+// Returns whether the argument of OP_IF or OP_NOTIF is empty or 0x01.
+func isMinimalIf(element *op.Instruction) bool {
+	switch element.Length() {
+	case 0:
+		return true
+	case 1:
+		return element.Bytes()[0] == 0x01
+	}
+
+	return false
+}
 
-	dup, err := op.NewInstruction([]byte{0x76})
+// Returns whether the top element of the stack is true.
+func isTrue(stack *op.Stack) bool {
+	element, err := stack.Peek()
 	if err != nil {
-		return nil, err
+		return false
 	}
 
-	hash160, err := op.NewInstruction([]byte{0xa9})
+	return element.IsTrue()
+}
+
+// ParseRawScript parses a script that is not prefixed by its length.
+func ParseRawScript(raw []byte) (*Script, error) {
+	length, err := varint.Encode(uint64(len(raw)))
 	if err != nil {
 		return nil, err
 	}
 
+	return ParseScript(bytes.NewReader(append(length, raw...)))
+}
+
+func ToP2PKHScript(h160 []byte) (*Script, error) {
 	data, err := op.NewInstruction(h160)
 	if err != nil {
 		return nil, err
 	}
 
-	equalVerify, err := op.NewInstruction([]byte{0x88})
-	if err != nil {
-		return nil, err
+	p2pkh := NewScript([]op.Instruction{
+		*op.NewOpCode(0x76), // OP_DUP
+		*op.NewOpCode(0xa9), // OP_HASH160
+		*data,
+		*op.NewOpCode(0x88), // OP_EQUALVERIFY
+		*op.NewOpCode(0xac), // OP_CHECKSIG
+	})
+
+	return p2pkh, nil
+}
+
+// Returns the pay-to-script-hash script OP_HASH160 <20 byte hash> OP_EQUAL,
+// where h160 is the hash of the redeem script.
+func ToP2SHScript(h160 []byte) (*Script, error) {
+	if len(h160) != 20 {
+		return nil, fmt.Errorf("invalid script hash length: %d", len(h160))
 	}
 
-	checkSignature, err := op.NewInstruction([]byte{0xac})
+	data, err := op.NewInstruction(h160)
 	if err != nil {
 		return nil, err
 	}
 
-	p2pkh := NewScript([]op.Instruction{
-		*dup,
-		*hash160,
+	p2sh := NewScript([]op.Instruction{
+		*op.NewOpCode(0xa9), // OP_HASH160
 		*data,
-		*equalVerify,
-		*checkSignature,
+		*op.NewOpCode(0x87), // OP_EQUAL
 	})
 
-	return p2pkh, nil
+	return p2sh, nil
+}
+
+// Returns the pay-to-witness-public-key-hash script OP_0 <20 byte hash>.
+func ToP2WPKHScript(h160 []byte) (*Script, error) {
+	return toWitnessProgram(0, h160)
+}
+
+// Returns the pay-to-witness-script-hash script OP_0 <32 byte hash>, where
+// h256 is the SHA-256 of the witness script.
+func ToP2WSHScript(h256 []byte) (*Script, error) {
+	return toWitnessProgram(0, h256)
+}
+
+// Returns the taproot script OP_1 <32 byte x-only key>, where outputKey is
+// the x-only output key.
+func ToP2TRScript(outputKey []byte) (*Script, error) {
+	if len(outputKey) != 32 {
+		return nil, fmt.Errorf("invalid taproot output key length: %d", len(outputKey))
+	}
+
+	return toWitnessProgram(1, outputKey)
+}
+
+func toWitnessProgram(version int, program []byte) (*Script, error) {
+	if len(program) < 2 || len(program) > 40 {
+		return nil, fmt.Errorf("invalid witness program length: %d", len(program))
+	}
+
+	versionOpCode := op.NewOpCode(0x00) // OP_0
+	if version > 0 {
+		versionOpCode = op.NewOpCode(byte(0x50 + version)) // OP_1 to OP_16
+	}
+
+	return NewScript([]op.Instruction{
+		*versionOpCode,
+		*op.NewPushData(byte(len(program)), program),
+	}), nil
 }
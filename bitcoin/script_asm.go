@@ -0,0 +1,118 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+)
+
+// The op codes by name, both with and without the OP_ prefix. The pushes of
+// the numbers are left out, as they are written as plain numbers.
+var opCodesByName = func() map[string]byte {
+	names := make(map[string]byte)
+	for opCode, name := range op.OP_CODE_NAMES {
+		if opCode <= 96 && opCode != 80 {
+			continue
+		}
+
+		names[name] = byte(opCode)
+		names[strings.TrimPrefix(name, "OP_")] = byte(opCode)
+	}
+
+	return names
+}()
+
+// Parses a script written in the assembly format used by the Bitcoin Core
+// tests, where the tokens are separated by whitespace:
+//
+//   - a decimal number is pushed as a script number, using OP_0, OP_1NEGATE
+//     and OP_1 to OP_16 when possible
+//   - hex prefixed by 0x is inserted into the script as raw bytes
+//   - text in single quotes is pushed as data
+//   - anything else is the name of an op code, with or without the OP_ prefix
+func ParseScriptAsm(asm string) (*Script, error) {
+	raw := make([]byte, 0)
+
+	for _, token := range strings.Fields(asm) {
+		switch {
+		case isDecimal(token):
+			number, err := strconv.ParseInt(token, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("number out of range: %s", token)
+			}
+
+			raw = append(raw, asmNumber(number)...)
+		case strings.HasPrefix(token, "0x") && len(token) > 2:
+			data, err := hex.DecodeString(token[2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex: %s", token)
+			}
+
+			raw = append(raw, data...)
+		case len(token) >= 2 && strings.HasPrefix(token, "'") && strings.HasSuffix(token, "'"):
+			raw = append(raw, asmPush([]byte(token[1:len(token)-1]))...)
+		default:
+			opCode, exists := opCodesByName[token]
+			if !exists {
+				return nil, fmt.Errorf("unknown op code: %s", token)
+			}
+
+			raw = append(raw, opCode)
+		}
+	}
+
+	return ParseRawScript(raw)
+}
+
+// Returns whether the token is a decimal number, with an optional minus sign.
+func isDecimal(token string) bool {
+	digits := strings.TrimPrefix(token, "-")
+	if len(digits) == 0 {
+		return false
+	}
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the raw script that pushes the number.
+func asmNumber(number int64) []byte {
+	switch {
+	case number == 0:
+		return []byte{0x00} // OP_0
+	case number == -1:
+		return []byte{0x4f} // OP_1NEGATE
+	case number >= 1 && number <= 16:
+		return []byte{byte(0x50 + number)} // OP_1 to OP_16
+	}
+
+	return asmPush(op.EncodeNum(number))
+}
+
+// Returns the raw script that pushes the data with the smallest push op code.
+func asmPush(data []byte) []byte {
+	length := len(data)
+
+	var prefix []byte
+	switch {
+	case length < 76:
+		prefix = []byte{byte(length)}
+	case length <= 0xff:
+		prefix = []byte{76, byte(length)}
+	case length <= 0xffff:
+		prefix = binary.LittleEndian.AppendUint16([]byte{77}, uint16(length))
+	default:
+		prefix = binary.LittleEndian.AppendUint32([]byte{78}, uint32(length))
+	}
+
+	return append(prefix, data...)
+}
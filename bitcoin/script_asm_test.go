@@ -0,0 +1,54 @@
+package bitcoin_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+)
+
+func TestParseScriptAsm(t *testing.T) {
+	tests := []struct {
+		asm      string
+		expected string
+	}{
+		{"", ""},
+		{"0 -1 1 16 17", "004f51600111"},
+		{"1000 -1000", "02e80302e883"},
+		{"DUP HASH160 0x14 0x89abcdefabbaabbaabbaabbaabbaabbaabbaabba EQUALVERIFY CHECKSIG", "76a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba88ac"},
+		{"OP_DUP CHECKLOCKTIMEVERIFY NOP", "76b161"},
+		{"'Az'", "02417a"},
+		{"''", "00"},
+		{"0x4c 0x01 0x07", "4c0107"},
+	}
+
+	for _, test := range tests {
+		script, err := bitcoin.ParseScriptAsm(test.asm)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", test.asm, err)
+		}
+
+		raw, err := script.RawSerialize()
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", test.asm, err)
+		}
+
+		if hex.EncodeToString(raw) != test.expected {
+			t.Errorf("%q: expected %s, got %x", test.asm, test.expected, raw)
+		}
+	}
+}
+
+func TestParseScriptAsmInvalid(t *testing.T) {
+	tests := []string{
+		"FOO",
+		"0xzz",
+		"0x4c 0x02 0x07",
+	}
+
+	for _, asm := range tests {
+		if _, err := bitcoin.ParseScriptAsm(asm); err == nil {
+			t.Errorf("%q: expected an error", asm)
+		}
+	}
+}
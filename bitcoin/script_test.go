@@ -3,9 +3,12 @@ package bitcoin_test
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 )
 
 func TestParseScript(t *testing.T) {
@@ -46,3 +49,283 @@ func TestSerialize(t *testing.T) {
 		t.Errorf("unexpected serialized script: %s", asHex)
 	}
 }
+
+// Parses a hex encoded script without the length prefix.
+func scriptFromHex(t *testing.T, hexString string) *bitcoin.Script {
+	raw, _ := hex.DecodeString(hexString)
+	script, err := bitcoin.ParseScript(bytes.NewReader(append([]byte{byte(len(raw))}, raw...)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return script
+}
+
+func TestParseScriptPushData(t *testing.T) {
+	t.Run("OP_PUSHDATA1, OP_PUSHDATA2 and OP_PUSHDATA4", func(t *testing.T) {
+		script := scriptFromHex(t, "4c01aa4d0200bbbb4e03000000cccccc76")
+
+		expected := []string{"aa", "bbbb", "cccccc", "76"}
+		for i, instruction := range script.Instructions() {
+			if instruction.Hex() != expected[i] {
+				t.Errorf("expected: %s, got: %s", expected[i], instruction.Hex())
+			}
+		}
+
+		if script.Instructions()[2].IsOpCode() || !script.Instructions()[3].IsOpCode() {
+			t.Errorf("expected data followed by OP_DUP")
+		}
+	})
+
+	t.Run("Push past the end of the script", func(t *testing.T) {
+		raw, _ := hex.DecodeString("034c05aa")
+		_, err := bitcoin.ParseScript(bytes.NewReader(raw))
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script string
+	}{
+		{"OP_IF executes the true branch", "51630167680151"},
+		{"OP_NOTIF executes the false branch", "0064516851"},
+		{"OP_ELSE executes the false branch", "00636a67516851"},
+		{"Nested OP_IF in a skipped branch", "00630063006a686a67516851"},
+		{"Alt stack", "51526b6c5287"},
+		{"OP_ROT, OP_SUB and OP_NUMEQUAL", "5152537b94529c"},
+		{"OP_SHA256, OP_RIPEMD160 and OP_SIZE", "00a8a682011487"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			script := scriptFromHex(t, tc.script)
+
+			result, err := script.Evaluate(nil, op.VerifyNone)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !result {
+				t.Errorf("expected the script to be valid")
+			}
+		})
+	}
+
+	t.Run("Missing OP_ENDIF", func(t *testing.T) {
+		script := scriptFromHex(t, "5163")
+
+		_, err := script.Evaluate(nil, op.VerifyNone)
+		var scriptErr *bitcoin.ScriptError
+		if !errors.As(err, &scriptErr) || scriptErr.Err.Error() != "missing OP_ENDIF" {
+			t.Errorf("expected 'missing OP_ENDIF', got %v", err)
+		}
+	})
+
+	t.Run("Disabled op code in a skipped branch", func(t *testing.T) {
+		script := scriptFromHex(t, "0063957e6851")
+
+		_, err := script.Evaluate(nil, op.VerifyNone)
+		var scriptErr *bitcoin.ScriptError
+		if !errors.As(err, &scriptErr) || scriptErr.Err.Error() != "disabled opcode" {
+			t.Errorf("expected 'disabled opcode', got %v", err)
+		}
+
+		if scriptErr.OpCode != 0x95 || scriptErr.Index != 2 {
+			t.Errorf("expected OP_MUL at index 2, got %x at %d", scriptErr.OpCode, scriptErr.Index)
+		}
+	})
+
+	t.Run("Reserved op code in a skipped branch", func(t *testing.T) {
+		script := scriptFromHex(t, "0063506851")
+
+		_, err := script.Evaluate(nil, op.VerifyNone)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("False on top of the stack", func(t *testing.T) {
+		for _, raw := range []string{"5100", "", "0180"} {
+			script := scriptFromHex(t, raw)
+
+			result, err := script.Evaluate(nil, op.VerifyNone)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result {
+				t.Errorf("expected %s to be invalid", raw)
+			}
+		}
+	})
+
+	t.Run("Failing OP_VERIFY", func(t *testing.T) {
+		script := scriptFromHex(t, "510069")
+
+		_, err := script.Evaluate(nil, op.VerifyNone)
+		var scriptErr *bitcoin.ScriptError
+		if !errors.As(err, &scriptErr) || scriptErr.OpCode != 0x69 {
+			t.Fatalf("expected OP_VERIFY to fail, got %v", err)
+		}
+
+		expected := "script failed at instruction 2 (OP_VERIFY): transaction invalid"
+		if err.Error() != expected {
+			t.Errorf("expected: %s, got: %s", expected, err.Error())
+		}
+	})
+
+	t.Run("Evaluate does not consume the script", func(t *testing.T) {
+		script := scriptFromHex(t, "5151")
+
+		_, _ = script.Evaluate(nil, op.VerifyNone)
+
+		if len(script.Instructions()) != 2 {
+			t.Errorf("expected: 2, got: %d", len(script.Instructions()))
+		}
+	})
+}
+
+func TestVerifyScript(t *testing.T) {
+	p2sh := func(redeemScript []byte) *bitcoin.Script {
+		return scriptFromHex(t, "a914"+hex.EncodeToString(hash.Hash160(redeemScript))+"87")
+	}
+
+	t.Run("Pay-to-script-hash", func(t *testing.T) {
+		// The redeem script OP_2 OP_EQUAL
+		scriptSig := scriptFromHex(t, "52025287")
+
+		valid, err := bitcoin.VerifyScript(scriptSig, p2sh([]byte{0x52, 0x87}), nil, nil, op.VerifyP2SH)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !valid {
+			t.Errorf("expected the script to be valid")
+		}
+	})
+
+	t.Run("Pay-to-script-hash with a failing redeem script", func(t *testing.T) {
+		scriptSig := scriptFromHex(t, "53025287")
+
+		valid, err := bitcoin.VerifyScript(scriptSig, p2sh([]byte{0x52, 0x87}), nil, nil, op.VerifyP2SH)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if valid {
+			t.Errorf("expected the script to be invalid")
+		}
+	})
+
+	t.Run("Pay-to-script-hash requires a push only scriptSig", func(t *testing.T) {
+		scriptSig := scriptFromHex(t, "527c025287")
+
+		_, err := bitcoin.VerifyScript(scriptSig, p2sh([]byte{0x52, 0x87}), nil, nil, op.VerifyP2SH)
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("scriptSig is evaluated before the scriptPubKey", func(t *testing.T) {
+		valid, err := bitcoin.VerifyScript(scriptFromHex(t, "5351"), scriptFromHex(t, "94529c"), nil, nil, op.VerifyP2SH)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !valid {
+			t.Errorf("expected the script to be valid")
+		}
+	})
+}
+
+// Returns a script that repeats the instruction count times.
+func repeatScript(instruction *op.Instruction, count int) []op.Instruction {
+	instructions := make([]op.Instruction, count)
+	for i := range instructions {
+		instructions[i] = *instruction
+	}
+
+	return instructions
+}
+
+func TestEvaluateLimits(t *testing.T) {
+	nop := op.NewOpCode(0x61)
+	one := op.NewOpCode(0x51)
+
+	testCases := []struct {
+		name         string
+		instructions []op.Instruction
+		expected     op.ErrorCode
+	}{
+		{"Script larger than 10,000 bytes", repeatScript(nop, 10_001), op.ErrScriptSize},
+		{"Push larger than 520 bytes in a skipped branch", []op.Instruction{
+			*op.NewOpCode(0x00), *op.NewOpCode(0x63), *op.NewPushData(0x4d, make([]byte, 521)), *op.NewOpCode(0x68), *one,
+		}, op.ErrPushSize},
+		{"More than 201 op codes", append(repeatScript(nop, 202), *one), op.ErrOpCount},
+		{"More than 1,000 stack elements", repeatScript(one, 1_001), op.ErrStackSize},
+		{"Public keys of OP_CHECKMULTISIG count as op codes", append(repeatScript(nop, 190), []op.Instruction{
+			*op.NewOpCode(0x00), *op.NewOpCode(0x00), *op.NewOpCode(0x60), *op.NewOpCode(0xae),
+		}...), op.ErrOpCount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bitcoin.NewScript(tc.instructions).Evaluate(nil, op.VerifyNone)
+
+			var scriptErr *bitcoin.ScriptError
+			if !errors.As(err, &scriptErr) || scriptErr.Code != tc.expected {
+				t.Errorf("expected %s, got %v", tc.expected, err)
+			}
+		})
+	}
+
+	t.Run("201 op codes", func(t *testing.T) {
+		result, err := bitcoin.NewScript(append(repeatScript(nop, 201), *one)).Evaluate(nil, op.VerifyNone)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !result {
+			t.Errorf("expected the script to be valid")
+		}
+	})
+}
+
+func TestVerifyFlags(t *testing.T) {
+	testCases := []struct {
+		name         string
+		scriptSig    string
+		scriptPubKey string
+		flags        op.VerifyFlags
+		expected     op.ErrorCode
+	}{
+		{"Non-minimal push", "0105", "5587", op.VerifyMinimalData, op.ErrMinimalData},
+		{"Upgradable NOP", "51", "b0", op.VerifyDiscourageUpgradableNops, op.ErrDiscourageUpgradableNops},
+		{"OP_CHECKLOCKTIMEVERIFY as OP_NOP2", "51", "b1", op.VerifyDiscourageUpgradableNops, op.ErrDiscourageUpgradableNops},
+		{"More than one element left", "5151", "51", op.VerifyCleanStack, op.ErrCleanStack},
+		{"scriptSig is not push only", "5176", "87", op.VerifySigPushOnly, op.ErrSigPushOnly},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scriptSig := scriptFromHex(t, tc.scriptSig)
+			scriptPubKey := scriptFromHex(t, tc.scriptPubKey)
+
+			valid, err := bitcoin.VerifyScript(scriptSig, scriptPubKey, nil, nil, op.VerifyNone)
+			if err != nil || !valid {
+				t.Fatalf("expected the script to be valid without flags, got %v", err)
+			}
+
+			_, err = bitcoin.VerifyScript(scriptSig, scriptPubKey, nil, nil, tc.flags)
+
+			var scriptErr *bitcoin.ScriptError
+			if !errors.As(err, &scriptErr) || scriptErr.Code != tc.expected {
+				t.Errorf("expected %s, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package bitcoin
+
+// SigHashType selects the parts of a transaction that a signature signs. It
+// is appended to the signature as its last byte.
+type SigHashType uint32
+
+const (
+	// Signs like SigHashAll, but is only defined for taproot signatures,
+	// which leave out the hash type byte when it is the default
+	SigHashDefault SigHashType = 0x00
+	// Signs all inputs and outputs
+	SigHashAll SigHashType = 0x01
+	// Signs all inputs and none of the outputs, so anyone can change them
+	SigHashNone SigHashType = 0x02
+	// Signs all inputs and only the output with the same index as the input
+	SigHashSingle SigHashType = 0x03
+	// Combined with one of the others, only the input being signed is signed,
+	// so anyone can add inputs
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+// Returns the hash type without the SigHashAnyoneCanPay modifier. Undefined
+// hash types sign like SigHashAll.
+func (t SigHashType) base() SigHashType {
+	return t & 0x1f
+}
+
+// Returns whether the SigHashAnyoneCanPay modifier is set.
+func (t SigHashType) anyoneCanPay() bool {
+	return t&SigHashAnyoneCanPay != 0
+}
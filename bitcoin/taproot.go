@@ -0,0 +1,137 @@
+package bitcoin
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
+)
+
+// The leaf version of tapscript, see BIP 342.
+const TapscriptLeafVersion = 0xc0
+
+// The maximum depth of the merkle tree of taproot scripts.
+const TaprootControlMaxNodeCount = 128
+
+const (
+	// The size of a control block without merkle path
+	taprootControlBaseSize = 33
+	// The size of a node of the merkle path of a control block
+	taprootControlNodeSize = 32
+)
+
+// Returns the hash of a leaf of the taproot script tree.
+func TapLeafHash(leafVersion byte, script []byte) []byte {
+	length, err := varint.Encode(uint64(len(script)))
+	if err != nil {
+		return nil
+	}
+
+	return hash.TaggedHash("TapLeaf", []byte{leafVersion}, length, script)
+}
+
+// Returns the hash of a branch of the taproot script tree, which is the same
+// whichever order the two children are given in.
+func TapBranchHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+
+	return hash.TaggedHash("TapBranch", a, b)
+}
+
+// Returns the tweak that commits the internal key to the merkle root of the
+// script tree, where merkleRoot is nil when there are no scripts.
+func taprootTweak(internalKey *ecc.S256Point, merkleRoot []byte) []byte {
+	return hash.TaggedHash("TapTweak", internalKey.XOnly(), merkleRoot)
+}
+
+// Returns the output key of the internal key and the merkle root of the
+// script tree, where merkleRoot is nil when there are no scripts. The x-only
+// output key is the witness program of the taproot output.
+func TaprootOutputKey(internalKey *ecc.S256Point, merkleRoot []byte) (*ecc.S256Point, error) {
+	return internalKey.TweakXOnly(taprootTweak(internalKey, merkleRoot))
+}
+
+// Returns the private key of the output key of TaprootOutputKey, which
+// signs key path spends.
+func TaprootTweakPrivateKey(privateKey *ecc.PrivateKey, merkleRoot []byte) (*ecc.PrivateKey, error) {
+	return privateKey.TweakXOnly(taprootTweak(privateKey.PublicKey(), merkleRoot))
+}
+
+// ControlBlock is the last witness element of a taproot script path spend,
+// which proves that the script is a leaf of the script tree committed to by
+// the output key.
+type ControlBlock struct {
+	LeafVersion byte
+	// Whether the y coordinate of the output key is odd
+	OutputKeyYIsOdd bool
+	InternalKey     *ecc.S256Point
+	// The hashes from the leaf up to the merkle root
+	MerklePath [][]byte
+}
+
+// Parses a control block, which is the leaf version and the parity of the
+// output key in one byte, followed by the x-only internal key and the
+// merkle path.
+func ParseControlBlock(data []byte) (*ControlBlock, error) {
+	if !isValidControlBlockSize(len(data)) {
+		return nil, fmt.Errorf("invalid control block size: %d", len(data))
+	}
+
+	internalKey, err := ecc.ParseXOnly(data[1:taprootControlBaseSize])
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([][]byte, 0, (len(data)-taprootControlBaseSize)/taprootControlNodeSize)
+	for i := taprootControlBaseSize; i < len(data); i += taprootControlNodeSize {
+		path = append(path, data[i:i+taprootControlNodeSize])
+	}
+
+	return &ControlBlock{data[0] & 0xfe, data[0]&0x01 == 1, internalKey, path}, nil
+}
+
+// Returns whether a control block of size bytes has a merkle path of whole
+// nodes that is not deeper than the maximum depth.
+func isValidControlBlockSize(size int) bool {
+	return size >= taprootControlBaseSize &&
+		size <= taprootControlBaseSize+TaprootControlMaxNodeCount*taprootControlNodeSize &&
+		(size-taprootControlBaseSize)%taprootControlNodeSize == 0
+}
+
+func (c *ControlBlock) Serialize() []byte {
+	first := c.LeafVersion
+	if c.OutputKeyYIsOdd {
+		first |= 0x01
+	}
+
+	result := append([]byte{first}, c.InternalKey.XOnly()...)
+	for _, node := range c.MerklePath {
+		result = append(result, node...)
+	}
+
+	return result
+}
+
+// Returns the merkle root of the script tree that the script is a leaf of.
+func (c *ControlBlock) MerkleRoot(script []byte) []byte {
+	root := TapLeafHash(c.LeafVersion, script)
+	for _, node := range c.MerklePath {
+		root = TapBranchHash(root, node)
+	}
+
+	return root
+}
+
+// Returns whether the script is committed to by the x-only output key.
+func (c *ControlBlock) Verify(outputKey []byte, script []byte) (bool, error) {
+	point, err := TaprootOutputKey(c.InternalKey, c.MerkleRoot(script))
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(point.XOnly(), outputKey) && point.HasEvenY() != c.OutputKeyYIsOdd, nil
+}
@@ -0,0 +1,610 @@
+package bitcoin_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+// The wallet test vectors of BIP 341.
+type bip341Vectors struct {
+	ScriptPubKey []struct {
+		Given struct {
+			InternalPubkey string          `json:"internalPubkey"`
+			ScriptTree     json.RawMessage `json:"scriptTree"`
+		} `json:"given"`
+		Intermediary struct {
+			LeafHashes    []string `json:"leafHashes"`
+			MerkleRoot    *string  `json:"merkleRoot"`
+			TweakedPubkey string   `json:"tweakedPubkey"`
+		} `json:"intermediary"`
+		Expected struct {
+			ScriptPubKey            string   `json:"scriptPubKey"`
+			Bip350Address           string   `json:"bip350Address"`
+			ScriptPathControlBlocks []string `json:"scriptPathControlBlocks"`
+		} `json:"expected"`
+	} `json:"scriptPubKey"`
+	KeyPathSpending []struct {
+		Given struct {
+			RawUnsignedTx string `json:"rawUnsignedTx"`
+			UtxosSpent    []struct {
+				ScriptPubKey string `json:"scriptPubKey"`
+				AmountSats   uint64 `json:"amountSats"`
+			} `json:"utxosSpent"`
+		} `json:"given"`
+		InputSpending []struct {
+			Given struct {
+				TxinIndex       int     `json:"txinIndex"`
+				InternalPrivkey string  `json:"internalPrivkey"`
+				MerkleRoot      *string `json:"merkleRoot"`
+				HashType        byte    `json:"hashType"`
+			} `json:"given"`
+			Intermediary struct {
+				TweakedPrivkey string `json:"tweakedPrivkey"`
+				SigHash        string `json:"sigHash"`
+			} `json:"intermediary"`
+			Expected struct {
+				Witness []string `json:"witness"`
+			} `json:"expected"`
+		} `json:"inputSpending"`
+		Auxiliary struct {
+			FullySignedTx string `json:"fullySignedTx"`
+		} `json:"auxiliary"`
+	} `json:"keyPathSpending"`
+}
+
+func readBIP341Vectors(t *testing.T) *bip341Vectors {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/bip341_wallet_test_vectors.json")
+	if err != nil {
+		t.Fatalf("os.ReadFile: %v", err)
+	}
+
+	var vectors bip341Vectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	return &vectors
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("hex.DecodeString(%q): %v", s, err)
+	}
+
+	return b
+}
+
+// A leaf of a script tree with the merkle path from the leaf to the root.
+type tapLeaf struct {
+	id          int
+	script      []byte
+	leafVersion byte
+	path        [][]byte
+}
+
+// Returns the hash and the leaves of a script tree in the JSON format of the
+// test vectors, where a branch is a list of two trees.
+func buildScriptTree(t *testing.T, tree json.RawMessage) ([]byte, []*tapLeaf) {
+	var branch []json.RawMessage
+	if err := json.Unmarshal(tree, &branch); err == nil {
+		leftHash, left := buildScriptTree(t, branch[0])
+		rightHash, right := buildScriptTree(t, branch[1])
+
+		for _, leaf := range left {
+			leaf.path = append(leaf.path, rightHash)
+		}
+		for _, leaf := range right {
+			leaf.path = append(leaf.path, leftHash)
+		}
+
+		return bitcoin.TapBranchHash(leftHash, rightHash), append(left, right...)
+	}
+
+	var leaf struct {
+		Id          int    `json:"id"`
+		Script      string `json:"script"`
+		LeafVersion byte   `json:"leafVersion"`
+	}
+	if err := json.Unmarshal(tree, &leaf); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+
+	script := decodeHex(t, leaf.Script)
+
+	return bitcoin.TapLeafHash(leaf.LeafVersion, script), []*tapLeaf{{leaf.Id, script, leaf.LeafVersion, nil}}
+}
+
+func TestTaprootScriptPubKey(t *testing.T) {
+	for _, vector := range readBIP341Vectors(t).ScriptPubKey {
+		t.Run(vector.Expected.ScriptPubKey, func(t *testing.T) {
+			internalKey, err := ecc.ParseXOnly(decodeHex(t, vector.Given.InternalPubkey))
+			if err != nil {
+				t.Fatalf("ParseXOnly: %v", err)
+			}
+
+			var merkleRoot []byte
+			var leaves []*tapLeaf
+			if string(vector.Given.ScriptTree) != "null" {
+				merkleRoot, leaves = buildScriptTree(t, vector.Given.ScriptTree)
+
+				if hex.EncodeToString(merkleRoot) != *vector.Intermediary.MerkleRoot {
+					t.Errorf("expected merkle root %s, got %x", *vector.Intermediary.MerkleRoot, merkleRoot)
+				}
+			}
+
+			outputKey, err := bitcoin.TaprootOutputKey(internalKey, merkleRoot)
+			if err != nil {
+				t.Fatalf("TaprootOutputKey: %v", err)
+			}
+
+			if hex.EncodeToString(outputKey.XOnly()) != vector.Intermediary.TweakedPubkey {
+				t.Errorf("expected output key %s, got %x", vector.Intermediary.TweakedPubkey, outputKey.XOnly())
+			}
+
+			script, err := bitcoin.ToP2TRScript(outputKey.XOnly())
+			if err != nil {
+				t.Fatalf("ToP2TRScript: %v", err)
+			}
+
+			raw, _ := script.RawSerialize()
+			if hex.EncodeToString(raw) != vector.Expected.ScriptPubKey {
+				t.Errorf("expected scriptPubKey %s, got %x", vector.Expected.ScriptPubKey, raw)
+			}
+
+			if !script.IsP2TRScriptPubKey() {
+				t.Errorf("expected a pay-to-taproot scriptPubKey")
+			}
+
+			address, err := bitcoin.OutputKeyToP2TRAddress(outputKey.XOnly(), &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("OutputKeyToP2TRAddress: %v", err)
+			}
+
+			if address != vector.Expected.Bip350Address {
+				t.Errorf("expected address %s, got %s", vector.Expected.Bip350Address, address)
+			}
+
+			for _, leaf := range leaves {
+				leafHash := bitcoin.TapLeafHash(leaf.leafVersion, leaf.script)
+				if hex.EncodeToString(leafHash) != vector.Intermediary.LeafHashes[leaf.id] {
+					t.Errorf("expected leaf hash %s, got %x", vector.Intermediary.LeafHashes[leaf.id], leafHash)
+				}
+
+				controlBlock := &bitcoin.ControlBlock{
+					LeafVersion:     leaf.leafVersion,
+					OutputKeyYIsOdd: !outputKey.HasEvenY(),
+					InternalKey:     internalKey,
+					MerklePath:      leaf.path,
+				}
+
+				expected := vector.Expected.ScriptPathControlBlocks[leaf.id]
+				if hex.EncodeToString(controlBlock.Serialize()) != expected {
+					t.Errorf("expected control block %s, got %x", expected, controlBlock.Serialize())
+				}
+
+				parsed, err := bitcoin.ParseControlBlock(decodeHex(t, expected))
+				if err != nil {
+					t.Fatalf("ParseControlBlock: %v", err)
+				}
+
+				valid, err := parsed.Verify(outputKey.XOnly(), leaf.script)
+				if err != nil || !valid {
+					t.Errorf("expected the control block to verify, got %v, %v", valid, err)
+				}
+
+				valid, _ = parsed.Verify(outputKey.XOnly(), append(leaf.script, 0x51))
+				if valid {
+					t.Errorf("expected the control block of another script to fail")
+				}
+			}
+		})
+	}
+}
+
+// The second output of the key path vectors has a script that ends with a
+// truncated push, which ParseScript rejects. It is replaced by an OP_RETURN
+// output of the same size, so only the signature hashes that do not commit
+// to that output can be compared with the vectors.
+const (
+	truncatedOutputScript   = "20ac9a87f5594be208f8532db38cff670c450ed2fea8fcdefcc9a663f78bab962b"
+	replacementOutputScript = "206a1e000000000000000000000000000000000000000000000000000000000000"
+)
+
+// Returns whether a signature of the input with hashType commits to the
+// second output.
+func commitsToSecondOutput(inputIndex int, hashType byte) bool {
+	switch hashType & 0x03 {
+	case byte(bitcoin.SigHashNone):
+		return false
+	case byte(bitcoin.SigHashSingle):
+		return inputIndex == 1
+	default:
+		return true
+	}
+}
+
+// Returns the transaction of the key path vectors, with the spent outputs.
+func newKeyPathTx(t *testing.T, rawTx string, utxos []struct {
+	ScriptPubKey string `json:"scriptPubKey"`
+	AmountSats   uint64 `json:"amountSats"`
+}) *bitcoin.Tx {
+	tx := parseHexTx(t, strings.Replace(rawTx, truncatedOutputScript, replacementOutputScript, 1))
+
+	prevOuts := bitcoin.PrevOutMap{}
+	for i, utxo := range utxos {
+		scriptPubKey, err := bitcoin.ParseScriptAsm("0x" + utxo.ScriptPubKey)
+		if err != nil {
+			t.Fatalf("ParseScriptAsm: %v", err)
+		}
+
+		prevOuts[tx.Inputs[i].String()] = &bitcoin.TxOutput{Amount: utxo.AmountSats, ScriptPubKey: *scriptPubKey}
+	}
+	tx.PrevOutFetcher = prevOuts
+
+	return tx
+}
+
+func TestTaprootSignatureHash(t *testing.T) {
+	for _, vector := range readBIP341Vectors(t).KeyPathSpending {
+		tx := newKeyPathTx(t, vector.Given.RawUnsignedTx, vector.Given.UtxosSpent)
+
+		for _, input := range vector.InputSpending {
+			t.Run(input.Intermediary.SigHash, func(t *testing.T) {
+				index := input.Given.TxinIndex
+				hashType := bitcoin.SigHashType(input.Given.HashType)
+
+				sigHash, err := tx.TaprootSignatureHash(index, hashType, nil)
+				if err != nil {
+					t.Fatalf("TaprootSignatureHash: %v", err)
+				}
+
+				commits := commitsToSecondOutput(index, input.Given.HashType)
+				if !commits && hex.EncodeToString(sigHash) != input.Intermediary.SigHash {
+					t.Errorf("expected signature hash %s, got %x", input.Intermediary.SigHash, sigHash)
+				}
+
+				var merkleRoot []byte
+				if input.Given.MerkleRoot != nil {
+					merkleRoot = decodeHex(t, *input.Given.MerkleRoot)
+				}
+
+				privateKey, _ := ecc.NewPrivateKey(new(big.Int).SetBytes(decodeHex(t, input.Given.InternalPrivkey)))
+				tweakedKey, err := bitcoin.TaprootTweakPrivateKey(privateKey, merkleRoot)
+				if err != nil {
+					t.Fatalf("TaprootTweakPrivateKey: %v", err)
+				}
+
+				if tweakedKey.Hex() != input.Intermediary.TweakedPrivkey {
+					t.Errorf("expected tweaked private key %s, got %s", input.Intermediary.TweakedPrivkey, tweakedKey.Hex())
+				}
+
+				// The vectors are signed without auxiliary randomness
+				signature, err := tweakedKey.SignSchnorr(sigHash, make([]byte, 32))
+				if err != nil {
+					t.Fatalf("SignSchnorr: %v", err)
+				}
+
+				witness := signature.Serialize()
+				if hashType != bitcoin.SigHashDefault {
+					witness = append(witness, byte(hashType))
+				}
+
+				if !commits && hex.EncodeToString(witness) != input.Expected.Witness[0] {
+					t.Errorf("expected witness %s, got %x", input.Expected.Witness[0], witness)
+				}
+
+				valid, err := tx.SignTaprootInput(index, privateKey, merkleRoot, hashType)
+				if err != nil || !valid {
+					t.Errorf("expected the signed input to verify, got %v, %v", valid, err)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyTaprootKeyPath(t *testing.T) {
+	for _, vector := range readBIP341Vectors(t).KeyPathSpending {
+		tx := newKeyPathTx(t, vector.Auxiliary.FullySignedTx, vector.Given.UtxosSpent)
+
+		for _, input := range vector.InputSpending {
+			index := input.Given.TxinIndex
+
+			valid, err := tx.VerifyInput(index, op.StandardVerifyFlags)
+			if commitsToSecondOutput(index, input.Given.HashType) {
+				// The replaced output invalidates the signature
+				assertScriptError(t, err, op.ErrSchnorrSig)
+			} else if err != nil || !valid {
+				t.Errorf("input %d: expected it to verify, got %v, %v", index, valid, err)
+			}
+		}
+
+		// Changing the first output invalidates the signature of the first
+		// input, which signs it with SIGHASH_SINGLE
+		tx.Outputs[0].Amount--
+
+		_, err := tx.VerifyInput(0, op.StandardVerifyFlags)
+		assertScriptError(t, err, op.ErrSchnorrSig)
+	}
+}
+
+func TestVerifyTaprootKeyPathAfterChange(t *testing.T) {
+	vector := readBIP341Vectors(t).KeyPathSpending[0]
+	tx := newKeyPathTx(t, vector.Given.RawUnsignedTx, vector.Given.UtxosSpent)
+
+	input := vector.InputSpending[0]
+	index := input.Given.TxinIndex
+	privateKey, _ := ecc.NewPrivateKey(new(big.Int).SetBytes(decodeHex(t, input.Given.InternalPrivkey)))
+	var merkleRoot []byte
+	if input.Given.MerkleRoot != nil {
+		merkleRoot = decodeHex(t, *input.Given.MerkleRoot)
+	}
+
+	valid, err := tx.SignTaprootInput(index, privateKey, merkleRoot, bitcoin.SigHashDefault)
+	if err != nil || !valid {
+		t.Fatalf("expected the signed input to verify, got %v, %v", valid, err)
+	}
+
+	// The signature hashes are those of the transaction as it is, not as it
+	// was when it was signed
+	tx.Outputs[0].Amount--
+	_, err = tx.VerifyInput(index, op.StandardVerifyFlags)
+	assertScriptError(t, err, op.ErrSchnorrSig)
+
+	tx.Outputs[0].Amount++
+	if valid, err := tx.VerifyInput(index, op.StandardVerifyFlags); err != nil || !valid {
+		t.Errorf("expected the input to verify again, got %v, %v", valid, err)
+	}
+}
+
+func TestSignTaprootInputAnyoneCanPay(t *testing.T) {
+	vector := readBIP341Vectors(t).KeyPathSpending[0]
+	tx := newKeyPathTx(t, vector.Given.RawUnsignedTx, vector.Given.UtxosSpent)
+
+	input := vector.InputSpending[0]
+	index := input.Given.TxinIndex
+	privateKey, _ := ecc.NewPrivateKey(new(big.Int).SetBytes(decodeHex(t, input.Given.InternalPrivkey)))
+	var merkleRoot []byte
+	if input.Given.MerkleRoot != nil {
+		merkleRoot = decodeHex(t, *input.Given.MerkleRoot)
+	}
+
+	// Only the output spent by the input is known
+	outPoint := tx.Inputs[index].String()
+	tx.PrevOutFetcher = bitcoin.PrevOutMap{outPoint: tx.PrevOutFetcher.(bitcoin.PrevOutMap)[outPoint]}
+
+	if _, err := tx.SignTaprootInput(index, privateKey, merkleRoot, bitcoin.SigHashAll); err == nil {
+		t.Errorf("expected an error for SIGHASH_ALL without the other spent outputs")
+	}
+
+	for _, hashType := range []bitcoin.SigHashType{bitcoin.SigHashAll, bitcoin.SigHashNone, bitcoin.SigHashSingle} {
+		valid, err := tx.SignTaprootInput(index, privateKey, merkleRoot, hashType|bitcoin.SigHashAnyoneCanPay)
+		if err != nil || !valid {
+			t.Errorf("hash type %#x: expected the signed input to verify, got %v, %v", hashType|bitcoin.SigHashAnyoneCanPay, valid, err)
+		}
+	}
+}
+
+func assertScriptError(t *testing.T, err error, code op.ErrorCode) {
+	t.Helper()
+
+	scriptErr, ok := err.(*bitcoin.ScriptError)
+	if !ok || scriptErr.Code != code {
+		t.Errorf("expected %s, got %v", code, err)
+	}
+}
+
+func TestSignTaprootInputWrongKey(t *testing.T) {
+	vector := readBIP341Vectors(t).KeyPathSpending[0]
+	tx := newKeyPathTx(t, vector.Given.RawUnsignedTx, vector.Given.UtxosSpent)
+
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(1))
+	if _, err := tx.SignTaprootInput(0, privateKey, nil, bitcoin.SigHashDefault); err == nil {
+		t.Errorf("expected an error for a key that does not match the output")
+	}
+
+	// The third input spends a pay-to-public-key-hash output
+	if _, err := tx.SignTaprootInput(2, privateKey, nil, bitcoin.SigHashDefault); err == nil {
+		t.Errorf("expected an error for an output that is not taproot")
+	}
+
+	if len(tx.Inputs[0].Witness) != 0 {
+		t.Errorf("expected the witness to be untouched")
+	}
+}
+
+// A script tree of tapscripts paying to an output key.
+type tapscriptTree struct {
+	internalKey *ecc.S256Point
+	outputKey   *ecc.S256Point
+	leaves      []*tapLeaf
+}
+
+// Returns the tree of the tapscripts in asm, where the leaves are paired
+// from the left.
+func newTapscriptTree(t *testing.T, internalKey *ecc.S256Point, asms ...string) *tapscriptTree {
+	trees := make([]json.RawMessage, len(asms))
+	for i, asm := range asms {
+		script, err := bitcoin.ParseScriptAsm(asm)
+		if err != nil {
+			t.Fatalf("ParseScriptAsm(%q): %v", asm, err)
+		}
+
+		raw, _ := script.RawSerialize()
+		trees[i], _ = json.Marshal(map[string]any{"id": i, "script": hex.EncodeToString(raw), "leafVersion": bitcoin.TapscriptLeafVersion})
+	}
+
+	for len(trees) > 1 {
+		trees[1], _ = json.Marshal([]json.RawMessage{trees[0], trees[1]})
+		trees = trees[1:]
+	}
+
+	merkleRoot, leaves := buildScriptTree(t, trees[0])
+
+	outputKey, err := bitcoin.TaprootOutputKey(internalKey, merkleRoot)
+	if err != nil {
+		t.Fatalf("TaprootOutputKey: %v", err)
+	}
+
+	return &tapscriptTree{internalKey, outputKey, leaves}
+}
+
+// Returns the transaction that spends the output of the tree with the
+// leaf, where stack gives the witness elements that the leaf is executed on.
+func (tree *tapscriptTree) spend(t *testing.T, leaf int, stack func(tx *bitcoin.Tx) [][]byte) *bitcoin.Tx {
+	scriptPubKey, err := bitcoin.ToP2TRScript(tree.outputKey.XOnly())
+	if err != nil {
+		t.Fatalf("ToP2TRScript: %v", err)
+	}
+
+	tx := newSpendingTx(t, bitcoin.NewScript([]op.Instruction{}), scriptPubKey, nil, 100_000)
+
+	controlBlock := &bitcoin.ControlBlock{
+		LeafVersion:     bitcoin.TapscriptLeafVersion,
+		OutputKeyYIsOdd: !tree.outputKey.HasEvenY(),
+		InternalKey:     tree.internalKey,
+		MerklePath:      tree.leaves[leaf].path,
+	}
+
+	tx.Inputs[0].Witness = append(stack(tx), tree.leaves[leaf].script, controlBlock.Serialize())
+
+	return tx
+}
+
+// Returns the signature of the leaf with the default hash type.
+func (tree *tapscriptTree) sign(t *testing.T, tx *bitcoin.Tx, leaf int, privateKey *ecc.PrivateKey) []byte {
+	sigHash, err := tx.TaprootSignatureHash(0, bitcoin.SigHashDefault, &op.TaprootExecutionData{
+		TapLeafHash:           bitcoin.TapLeafHash(bitcoin.TapscriptLeafVersion, tree.leaves[leaf].script),
+		CodeSeparatorPosition: 0xffffffff,
+	})
+	if err != nil {
+		t.Fatalf("TaprootSignatureHash: %v", err)
+	}
+
+	signature, err := privateKey.SignSchnorr(sigHash, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("SignSchnorr: %v", err)
+	}
+
+	return signature.Serialize()
+}
+
+func TestVerifyTaprootScriptPath(t *testing.T) {
+	key1, _ := ecc.NewPrivateKey(big.NewInt(1001))
+	key2, _ := ecc.NewPrivateKey(big.NewInt(1002))
+	internalKey, _ := ecc.NewPrivateKey(big.NewInt(1003))
+
+	x1, x2 := hex.EncodeToString(key1.XOnly()), hex.EncodeToString(key2.XOnly())
+	tree := newTapscriptTree(t, internalKey.PublicKey(),
+		"0x20 0x"+x1+" CHECKSIG",
+		"0x20 0x"+x1+" CHECKSIG 0x20 0x"+x2+" 0xba 2 NUMEQUAL",
+		"0x50",
+		"1 0x20 0x"+x1+" 1 CHECKMULTISIG",
+		"IF 1 ELSE 1 ENDIF",
+		"0x20 0x"+x1+strings.Repeat(" 2DUP CHECKSIGVERIFY", 10)+" CHECKSIG",
+	)
+
+	tests := []struct {
+		name     string
+		leaf     int
+		stack    func(tx *bitcoin.Tx) [][]byte
+		expected string
+	}{
+		{"signature", 0, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 0, key1)}
+		}, "OK"},
+		{"invalid signature", 0, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 1, key1)}
+		}, "SCHNORR_SIG"},
+		{"signature of another key", 0, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 0, key2)}
+		}, "SCHNORR_SIG"},
+		{"explicit default hash type", 0, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{append(tree.sign(t, tx, 0, key1), 0x00)}
+		}, "SCHNORR_SIG_HASHTYPE"},
+		{"invalid signature size", 0, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 0, key1)[:63]}
+		}, "SCHNORR_SIG_SIZE"},
+		{"2-of-2 with OP_CHECKSIGADD", 1, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 1, key2), tree.sign(t, tx, 1, key1)}
+		}, "OK"},
+		{"1-of-2 with OP_CHECKSIGADD", 1, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{{}, tree.sign(t, tx, 1, key1)}
+		}, "EVAL_FALSE"},
+		{"OP_SUCCESS", 2, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{}
+		}, "OK"},
+		{"OP_CHECKMULTISIG", 3, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{{}, tree.sign(t, tx, 3, key1)}
+		}, "TAPSCRIPT_CHECKMULTISIG"},
+		{"minimal OP_IF", 4, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{{0x01}}
+		}, "OK"},
+		{"non-minimal OP_IF", 4, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{{0x02}}
+		}, "TAPSCRIPT_MINIMALIF"},
+		{"validation weight", 5, func(tx *bitcoin.Tx) [][]byte {
+			return [][]byte{tree.sign(t, tx, 5, key1)}
+		}, "TAPSCRIPT_VALIDATION_WEIGHT"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := tree.spend(t, test.leaf, test.stack)
+
+			result := scriptResult(tx.VerifyInput(0, op.StandardVerifyFlags))
+			if result != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestVerifyTaprootControlBlock(t *testing.T) {
+	key1, _ := ecc.NewPrivateKey(big.NewInt(1001))
+	internalKey, _ := ecc.NewPrivateKey(big.NewInt(1003))
+
+	tree := newTapscriptTree(t, internalKey.PublicKey(), "1", "2 DROP 1")
+
+	tx := tree.spend(t, 0, func(tx *bitcoin.Tx) [][]byte { return [][]byte{} })
+	if valid, err := tx.VerifyInput(0, op.StandardVerifyFlags); err != nil || !valid {
+		t.Errorf("expected it to verify, got %v, %v", valid, err)
+	}
+
+	witness := tx.Inputs[0].Witness
+	controlBlock := witness[len(witness)-1]
+
+	witness[len(witness)-1] = controlBlock[:len(controlBlock)-1]
+	_, err := tx.VerifyInput(0, op.StandardVerifyFlags)
+	assertScriptError(t, err, op.ErrTaprootWrongControlSize)
+
+	// The script of the other leaf with this merkle path
+	witness[len(witness)-1] = controlBlock
+	witness[len(witness)-2] = tree.leaves[1].script
+	_, err = tx.VerifyInput(0, op.StandardVerifyFlags)
+	assertScriptError(t, err, op.ErrWitnessProgramMismatch)
+
+	// The wrong parity of the output key
+	witness[len(witness)-2] = tree.leaves[0].script
+	witness[len(witness)-1] = append([]byte{controlBlock[0] ^ 0x01}, controlBlock[1:]...)
+	_, err = tx.VerifyInput(0, op.StandardVerifyFlags)
+	assertScriptError(t, err, op.ErrWitnessProgramMismatch)
+
+	// A key path spend of the same output
+	tx.Inputs[0].Witness = [][]byte{tree.sign(t, tx, 0, key1)}
+	_, err = tx.VerifyInput(0, op.StandardVerifyFlags)
+	assertScriptError(t, err, op.ErrSchnorrSig)
+}
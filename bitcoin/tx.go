@@ -2,11 +2,15 @@ package bitcoin
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"slices"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
@@ -15,40 +19,114 @@ import (
 )
 
 type Tx struct {
-	Version   int32
-	Inputs    []*TxInput
-	Outputs   []*TxOutput
-	LockTime  int32
-	isTestnet bool
+	Version  int32
+	Inputs   []*TxInput
+	Outputs  []*TxOutput
+	LockTime int32
+	// Looks up the outputs spent by the inputs, which are fetched from
+	// mempool.space when it is nil.
+	PrevOutFetcher PrevOutFetcher
+	params         *chaincfg.Params
 }
 
-func NewTx(version int32, inputs []*TxInput, outputs []*TxOutput, lockTime int32, isTestnet bool) *Tx {
-	return &Tx{version, inputs, outputs, lockTime, isTestnet}
+func NewTx(version int32, inputs []*TxInput, outputs []*TxOutput, lockTime int32, params *chaincfg.Params) *Tx {
+	return &Tx{version, inputs, outputs, lockTime, nil, params}
 }
 
 func (tx *Tx) String() string {
 	return fmt.Sprintf("Tx: %s, version: %d", tx.Id(), tx.Version)
 }
 
-// Human-readable hexadecimal of the transaction hash.
+// Human-readable hexadecimal of the transaction hash, in reversed order.
+// The witness data is not part of the hash, see WitnessId.
 func (tx *Tx) Id() string {
-	return fmt.Sprintf("%x", tx.hash())
+	hashed := tx.hash()
+	slices.Reverse(hashed)
+
+	return fmt.Sprintf("%x", hashed)
+}
+
+// Human-readable hexadecimal of the hash of the witness serialization, in
+// reversed order. It is the same as Id for transactions without witness data.
+func (tx *Tx) WitnessId() string {
+	hashed := hash.Hash256(tx.Serialize())
+	slices.Reverse(hashed)
+
+	return fmt.Sprintf("%x", hashed)
 }
 
 // Binary hash of the legacy serialization.
 func (tx *Tx) hash() []byte {
-	txSerialized := tx.Serialize()
+	txSerialized := tx.SerializeLegacy()
 
 	return hash.Hash256(txSerialized)
 }
 
-func Parse(data io.Reader, isTestnet bool) (*Tx, error) {
+// The largest transaction, whose weight is that of a whole block, which
+// bounds the counts and the lengths that are read
+const maxTxSize = 4_000_000
+
+// Reads length bytes, which are only allocated as they are read, so that a
+// length that is larger than the data does not allocate it.
+func readBytes(data io.Reader, length uint64) ([]byte, error) {
+	if length > maxTxSize {
+		return nil, fmt.Errorf("element of %d bytes is too large", length)
+	}
+
+	result, err := io.ReadAll(io.LimitReader(data, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(result)) != length {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return result, nil
+}
+
+// Parses a transaction in either the legacy or the witness serialization,
+// see BIP 144.
+func Parse(data io.Reader, params *chaincfg.Params) (*Tx, error) {
+	return parse(data, params, true)
+}
+
+// ParseLegacy parses a transaction in the legacy serialization, where an
+// input count of zero is not the marker of the witness serialization.
+func ParseLegacy(data io.Reader, params *chaincfg.Params) (*Tx, error) {
+	return parse(data, params, false)
+}
+
+func parse(data io.Reader, params *chaincfg.Params, allowWitness bool) (*Tx, error) {
 	version, err := parseVersion(data)
 	if err != nil {
 		return nil, err
 	}
 
-	inputs, err := ParseTxInputs(data)
+	numberOfInputs, err := varint.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// An input count of zero is the marker of the witness serialization,
+	// which is followed by the flag and then the real input count.
+	isWitnessSerialization := allowWitness && numberOfInputs == 0
+	if isWitnessSerialization {
+		flag := make([]byte, 1)
+		if _, err := io.ReadFull(data, flag); err != nil {
+			return nil, err
+		}
+
+		if flag[0] != 0x01 {
+			return nil, fmt.Errorf("unknown witness flag: %d", flag[0])
+		}
+
+		numberOfInputs, err = varint.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inputs, err := parseTxInputs(data, numberOfInputs)
 	if err != nil {
 		return nil, err
 	}
@@ -58,18 +136,32 @@ func Parse(data io.Reader, isTestnet bool) (*Tx, error) {
 		return nil, err
 	}
 
+	if isWitnessSerialization {
+		for _, txIn := range inputs {
+			txIn.Witness, err = ParseWitness(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The witness serialization is only allowed when there is witness data
+		if !hasWitness(inputs) {
+			return nil, fmt.Errorf("superfluous witness record")
+		}
+	}
+
 	lockTime, err := parseLockTime(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewTx(version, inputs, outputs, lockTime, isTestnet), nil
+	return NewTx(version, inputs, outputs, lockTime, params), nil
 }
 
 func parseVersion(data io.Reader) (int32, error) {
 	version := make([]byte, 4)
 
-	_, err := data.Read(version)
+	_, err := io.ReadFull(data, version)
 	if err != nil {
 		return 0, err
 	}
@@ -80,7 +172,7 @@ func parseVersion(data io.Reader) (int32, error) {
 func parseLockTime(data io.Reader) (int32, error) {
 	lockTime := make([]byte, 4)
 
-	_, err := data.Read(lockTime)
+	_, err := io.ReadFull(data, lockTime)
 	if err != nil {
 		return 0, err
 	}
@@ -88,8 +180,29 @@ func parseLockTime(data io.Reader) (int32, error) {
 	return int32(binary.LittleEndian.Uint32(lockTime)), nil
 }
 
-// Returns the byte serialization of the transaction.
+// Returns the byte serialization of the transaction, which is the witness
+// serialization of BIP 144 when any input has witness data.
 func (tx *Tx) Serialize() []byte {
+	if !tx.HasWitness() {
+		return tx.SerializeLegacy()
+	}
+
+	result := endian.BigIntToLittleEndian(big.NewInt(int64(tx.Version)), 4)
+	// The marker and the flag
+	result = append(result, 0x00, 0x01)
+	result = append(result, tx.serializeInputs()...)
+	result = append(result, tx.serializeOutputs()...)
+	for _, txIn := range tx.Inputs {
+		result = append(result, SerializeWitness(txIn.Witness)...)
+	}
+	result = append(result, endian.BigIntToLittleEndian(big.NewInt(int64(tx.LockTime)), 4)...)
+
+	return result
+}
+
+// Returns the byte serialization of the transaction without the witness
+// data, which is the serialization that the transaction id is the hash of.
+func (tx *Tx) SerializeLegacy() []byte {
 	result := endian.BigIntToLittleEndian(big.NewInt(int64(tx.Version)), 4)
 	result = append(result, tx.serializeInputs()...)
 	result = append(result, tx.serializeOutputs()...)
@@ -98,6 +211,34 @@ func (tx *Tx) Serialize() []byte {
 	return result
 }
 
+// Returns whether any of the inputs has witness data.
+func (tx *Tx) HasWitness() bool {
+	return hasWitness(tx.Inputs)
+}
+
+func hasWitness(inputs []*TxInput) bool {
+	for _, txIn := range inputs {
+		if len(txIn.Witness) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the weight of the transaction, where each byte of the legacy
+// serialization weighs 4 and each byte of the witness data weighs 1, see
+// BIP 141.
+func (tx *Tx) Weight() int {
+	return 3*len(tx.SerializeLegacy()) + len(tx.Serialize())
+}
+
+// Returns the virtual size of the transaction, which is its weight divided
+// by 4 and rounded up.
+func (tx *Tx) VSize() int {
+	return (tx.Weight() + 3) / 4
+}
+
 // Returns the byte serialization of the transaction inputs.
 func (tx *Tx) serializeInputs() []byte {
 	result, err := varint.Encode(uint64(len(tx.Inputs)))
@@ -131,11 +272,11 @@ func (tx *Tx) Fee() (int64, error) {
 	var inputSum int64 = 0
 	var outputSum int64 = 0
 	for _, txIn := range tx.Inputs {
-		value, err := txIn.Value(tx.isTestnet)
+		prevOut, err := tx.prevOut(txIn)
 		if err != nil {
 			return 0, err
 		}
-		inputSum += int64(value)
+		inputSum += int64(prevOut.Amount)
 	}
 	for _, txOut := range tx.Outputs {
 		outputSum += int64(txOut.Amount)
@@ -144,10 +285,27 @@ func (tx *Tx) Fee() (int64, error) {
 	return inputSum - outputSum, nil
 }
 
-func (tx *Tx) SignatureHash(inputIndex int, redeemScript *Script) ([]byte, error) {
+// Returns the legacy signature hash of the input at inputIndex for hashType.
+// The redeem script is signed in place of the scriptSig, or the scriptPubKey
+// of the spent output when redeemScript is nil.
+func (tx *Tx) SignatureHash(inputIndex int, redeemScript *Script, hashType SigHashType) ([]byte, error) {
+	// A bug in the original client makes SIGHASH_SINGLE without a matching
+	// output sign the number one instead of the transaction.
+	if hashType.base() == SigHashSingle && inputIndex >= len(tx.Outputs) {
+		one := make([]byte, 32)
+		one[0] = 0x01
+
+		return one, nil
+	}
+
 	signature := endian.BigIntToLittleEndian(big.NewInt(int64(tx.Version)), 4)
 
-	length, err := varint.Encode(uint64(len(tx.Inputs)))
+	numberOfInputs := len(tx.Inputs)
+	if hashType.anyoneCanPay() {
+		numberOfInputs = 1
+	}
+
+	length, err := varint.Encode(uint64(numberOfInputs))
 	if err != nil {
 		return nil, err
 	}
@@ -156,80 +314,81 @@ func (tx *Tx) SignatureHash(inputIndex int, redeemScript *Script) ([]byte, error
 
 	for i, txIn := range tx.Inputs {
 		if i == inputIndex {
-			if redeemScript != nil {
-				signature, err = redeemScript.Serialize()
-				if err != nil {
-					return nil, err
-				}
-			} else {
-				scriptSignature, err := txIn.ScriptPubKey(tx.isTestnet)
+			scriptCode := redeemScript
+			if scriptCode == nil {
+				prevOut, err := tx.prevOut(txIn)
 				if err != nil {
 					return nil, err
 				}
-				tmpTxIn := NewTxInput(txIn.PrevTx, txIn.PrevIndex, scriptSignature, txIn.Sequence)
+				scriptCode = &prevOut.ScriptPubKey
+			}
+			tmpTxIn := NewTxInput(txIn.PrevTx, txIn.PrevIndex, scriptCode.withoutCodeSeparators(), txIn.Sequence)
 
-				signature = append(signature, tmpTxIn.Serialize()...)
+			signature = append(signature, tmpTxIn.Serialize()...)
+		} else if !hashType.anyoneCanPay() {
+			// The sequence of the other inputs is not signed when the
+			// outputs are not all signed, so they can be updated.
+			sequence := txIn.Sequence
+			if hashType.base() == SigHashNone || hashType.base() == SigHashSingle {
+				sequence = big.NewInt(0)
 			}
-		} else {
-			tmpTxIn := NewTxInput(txIn.PrevTx, txIn.PrevIndex, nil, txIn.Sequence)
+			tmpTxIn := NewTxInput(txIn.PrevTx, txIn.PrevIndex, nil, sequence)
 
 			signature = append(signature, tmpTxIn.Serialize()...)
 		}
 	}
 
-	outputLength, err := varint.Encode(uint64(len(tx.Outputs)))
+	outputs := tx.Outputs
+	switch hashType.base() {
+	case SigHashNone:
+		outputs = nil
+	case SigHashSingle:
+		// The outputs before the signed one are replaced by empty outputs
+		// with an amount of -1.
+		outputs = make([]*TxOutput, inputIndex+1)
+		for i := 0; i < inputIndex; i++ {
+			outputs[i] = &TxOutput{Amount: math.MaxUint64, ScriptPubKey: *NewScript([]op.Instruction{})}
+		}
+		outputs[inputIndex] = tx.Outputs[inputIndex]
+	}
+
+	outputLength, err := varint.Encode(uint64(len(outputs)))
 	if err != nil {
 		return nil, err
 	}
 
 	signature = append(signature, outputLength...)
-	for _, txOut := range tx.Outputs {
+	for _, txOut := range outputs {
 		signature = append(signature, txOut.Serialize()...)
 	}
 
 	lockTime := endian.BigIntToLittleEndian(big.NewInt(int64(tx.LockTime)), 4)
 	signature = append(signature, lockTime...)
-
-	SIGHASH_ALL := 1
-	signature = append(signature, endian.BigIntToLittleEndian(big.NewInt(int64(SIGHASH_ALL)), 4)...)
+	signature = binary.LittleEndian.AppendUint32(signature, uint32(hashType))
 
 	hashed := hash.Hash256(signature)
 
 	return hashed, nil
 }
 
-func (tx *Tx) VerifyInput(inputIndex int) (bool, error) {
-	txInput := tx.Inputs[inputIndex]
-	scriptPubKey, err := txInput.ScriptPubKey(tx.isTestnet)
-	if err != nil {
-		return false, err
-	}
-
-	var redeemScript *Script
-	if scriptPubKey.IsP2SHScriptPubKey() {
-		instruction := scriptPubKey.instructions[len(scriptPubKey.instructions)-1]
-		lenBytes, err := varint.Encode(uint64(instruction.Length()))
-		if err != nil {
-			return false, err
-		}
-		rawRedeemScript := append(lenBytes, instruction.Bytes()...)
-		redeemScript, err = ParseScript(bytes.NewReader(rawRedeemScript))
-		if err != nil {
-			return false, err
-		}
-	} else {
-		redeemScript = nil
-	}
+// Verifies the input at inputIndex, enforcing the script rules selected by
+// flags.
+func (tx *Tx) VerifyInput(inputIndex int, flags op.VerifyFlags) (bool, error) {
+	return tx.verifyInput(inputIndex, flags, &sigHashCache{})
+}
 
-	z, err := tx.SignatureHash(inputIndex, redeemScript)
+// Verifies the input, sharing the hashes of the signature hashes in cache
+// with the other inputs that are verified together
+func (tx *Tx) verifyInput(inputIndex int, flags op.VerifyFlags, cache *sigHashCache) (bool, error) {
+	txInput := tx.Inputs[inputIndex]
+	prevOut, err := tx.prevOut(txInput)
 	if err != nil {
 		return false, err
 	}
 
-	script := scriptPubKey.Add(txInput.ScriptSig)
-	result, err := script.Evaluate(z)
+	checker := newTxChecker(tx, inputIndex, prevOut.Amount, cache)
 
-	return result, err
+	return VerifyScript(txInput.ScriptSig, &prevOut.ScriptPubKey, txInput.Witness, checker, flags)
 }
 
 // Verify this transaction
@@ -243,46 +402,199 @@ func (tx *Tx) Verify() (bool, error) {
 		return false, fmt.Errorf("Fee must be positive")
 	}
 
+	// The transaction does not change while its inputs are verified
+	cache := &sigHashCache{}
 	for index := 0; index < len(tx.Inputs); index++ {
-		_, err := tx.VerifyInput(index)
+		valid, err := tx.verifyInput(index, op.MandatoryVerifyFlags, cache)
 		if err != nil {
 			return false, err
 		}
+
+		if !valid {
+			return false, nil
+		}
 	}
 
 	return true, nil
 }
 
-func (tx *Tx) SignInput(inputIndex int, privateKey *ecc.PrivateKey) (bool, error) {
-	z, err := tx.SignatureHash(inputIndex, nil)
+// Signs the input with hashType, which must spend a pay-to-public-key-hash,
+// pay-to-witness-public-key-hash or pay-to-script-hash nested
+// pay-to-witness-public-key-hash output of the compressed public key of
+// privateKey, or a taproot output without scripts of the internal key
+// privateKey. Returns whether the signed input verifies.
+func (tx *Tx) SignInput(inputIndex int, privateKey *ecc.PrivateKey, hashType SigHashType) (bool, error) {
+	txIn := tx.Inputs[inputIndex]
+	prevOut, err := tx.prevOut(txIn)
 	if err != nil {
 		return false, err
 	}
+	scriptPubKey := &prevOut.ScriptPubKey
 
-	signature, err := privateKey.Sign(big.NewInt(0).SetBytes(z))
+	sec := privateKey.SECCompressed()
+	h160 := hash.Hash160(sec)
+
+	switch {
+	case scriptPubKey.IsP2TRScriptPubKey():
+		return tx.SignTaprootInput(inputIndex, privateKey, nil, hashType)
+	case scriptPubKey.IsP2WPKHScriptPubKey():
+		sig, err := tx.signSegwit(inputIndex, privateKey, h160, prevOut.Amount, hashType)
+		if err != nil {
+			return false, err
+		}
+
+		txIn.ScriptSig = NewScript([]op.Instruction{})
+		txIn.Witness = [][]byte{sig, sec}
+	case scriptPubKey.IsP2SHScriptPubKey():
+		redeemScript, err := ToP2WPKHScript(h160)
+		if err != nil {
+			return false, err
+		}
+
+		rawRedeemScript, err := redeemScript.RawSerialize()
+		if err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(hash.Hash160(rawRedeemScript), scriptPubKey.instructions[1].Bytes()) {
+			return false, fmt.Errorf("only pay-to-witness-public-key-hash redeem scripts can be signed")
+		}
+
+		sig, err := tx.signSegwit(inputIndex, privateKey, h160, prevOut.Amount, hashType)
+		if err != nil {
+			return false, err
+		}
+
+		redeemInstruction, err := op.NewInstruction(rawRedeemScript)
+		if err != nil {
+			return false, err
+		}
+
+		txIn.ScriptSig = NewScript([]op.Instruction{*redeemInstruction})
+		txIn.Witness = [][]byte{sig, sec}
+	default:
+		z, err := tx.SignatureHash(inputIndex, nil, hashType)
+		if err != nil {
+			return false, err
+		}
+
+		sig, err := sign(privateKey, z, hashType)
+		if err != nil {
+			return false, err
+		}
+
+		sigInstruction, err := op.NewInstruction(sig)
+		if err != nil {
+			return false, err
+		}
+
+		secInstruction, err := op.NewInstruction(sec)
+		if err != nil {
+			return false, err
+		}
+
+		txIn.ScriptSig = NewScript([]op.Instruction{*sigInstruction, *secInstruction})
+	}
+
+	return tx.VerifyInput(inputIndex, op.StandardVerifyFlags)
+}
+
+// Signs the key path of a taproot input with hashType, where privateKey is
+// the internal key and merkleRoot is the merkle root of the script tree of
+// the output, or nil when it has no scripts. Returns whether the signed
+// input verifies.
+func (tx *Tx) SignTaprootInput(inputIndex int, privateKey *ecc.PrivateKey, merkleRoot []byte, hashType SigHashType) (bool, error) {
+	txIn := tx.Inputs[inputIndex]
+	prevOut, err := tx.prevOut(txIn)
 	if err != nil {
 		return false, err
 	}
 
-	der := signature.DER()
-	sig := append(der, byte(1))
+	version, program, ok := prevOut.ScriptPubKey.WitnessProgram()
+	if !ok || version != 1 || len(program) != 32 {
+		return false, fmt.Errorf("input %d does not spend a taproot output", inputIndex)
+	}
 
-	sec := privateKey.SECCompressed()
+	tweakedKey, err := TaprootTweakPrivateKey(privateKey, merkleRoot)
+	if err != nil {
+		return false, err
+	}
 
-	sigInstruction, err := op.NewInstruction(sig)
+	if !bytes.Equal(tweakedKey.XOnly(), program) {
+		return false, fmt.Errorf("private key does not match the output key")
+	}
+
+	z, err := tx.TaprootSignatureHash(inputIndex, hashType, nil)
 	if err != nil {
 		return false, err
 	}
 
-	secInstruction, err := op.NewInstruction(sec)
+	auxRand := make([]byte, 32)
+	if _, err := rand.Read(auxRand); err != nil {
+		return false, err
+	}
+
+	signature, err := tweakedKey.SignSchnorr(z, auxRand)
 	if err != nil {
 		return false, err
 	}
 
-	scriptSig := NewScript([]op.Instruction{*sigInstruction, *secInstruction})
-	tx.Inputs[inputIndex].ScriptSig = scriptSig
+	sig := signature.Serialize()
+	// The default hash type is left out
+	if hashType != SigHashDefault {
+		sig = append(sig, byte(hashType))
+	}
+
+	txIn.ScriptSig = NewScript([]op.Instruction{})
+	txIn.Witness = [][]byte{sig}
+
+	return tx.VerifyInput(inputIndex, op.StandardVerifyFlags)
+}
+
+// Returns the signature of a pay-to-witness-public-key-hash input of h160.
+func (tx *Tx) signSegwit(inputIndex int, privateKey *ecc.PrivateKey, h160 []byte, amount uint64, hashType SigHashType) ([]byte, error) {
+	scriptCode, err := ToP2PKHScript(h160)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := tx.SegwitSignatureHash(inputIndex, scriptCode, amount, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	return sign(privateKey, z, hashType)
+}
+
+// Returns the DER signature of z followed by the hash type.
+func sign(privateKey *ecc.PrivateKey, z []byte, hashType SigHashType) ([]byte, error) {
+	signature, err := privateKey.Sign(big.NewInt(0).SetBytes(z))
+	if err != nil {
+		return nil, err
+	}
+
+	der := signature.DER()
+
+	return append(der, byte(hashType)), nil
+}
+
+// Returns the output spent by txIn.
+func (tx *Tx) prevOut(txIn *TxInput) (*TxOutput, error) {
+	if tx.PrevOutFetcher != nil {
+		return tx.PrevOutFetcher.FetchPrevOut(txIn)
+	}
+
+	prevTx, err := txIn.fetchTransaction(tx.params)
+	if err != nil {
+		return nil, err
+	}
+
+	index := txIn.PrevIndex.Int64()
+	if index >= int64(len(prevTx.Outputs)) {
+		return nil, fmt.Errorf("output %s does not exist", txIn)
+	}
 
-	return tx.VerifyInput(inputIndex)
+	return prevTx.Outputs[index], nil
 }
 
 func (tx *Tx) IsCoinbase() bool {
@@ -0,0 +1,105 @@
+package bitcoin
+
+import "github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+
+// Lock times below this value are block heights, above it they are UNIX timestamps.
+const lockTimeThreshold = 500_000_000
+
+const (
+	// Disables the relative lock time of an input, see BIP 68.
+	sequenceDisableFlag = 1 << 31
+	// Set when the relative lock time is in units of 512 seconds.
+	sequenceTypeFlag = 1 << 22
+	// The bits of the sequence that hold the relative lock time.
+	sequenceLockTimeMask = 0x0000ffff
+)
+
+// The hashes that the signature hashes of all inputs share, which are
+// computed when they are first needed while the inputs are verified. The
+// transaction must not change while they are in use.
+type sigHashCache struct {
+	segwit  *segwitSigHashes
+	taproot *taprootSigHashes
+}
+
+// txChecker checks the op codes that inspect the spending transaction
+// against one of its inputs.
+type txChecker struct {
+	tx         *Tx
+	inputIndex int
+	// The value of the spent output, which segwit signatures sign
+	amount uint64
+	cache  *sigHashCache
+}
+
+func newTxChecker(tx *Tx, inputIndex int, amount uint64, cache *sigHashCache) *txChecker {
+	return &txChecker{tx, inputIndex, amount, cache}
+}
+
+// Returns the hash signed by a signature of the input with hashType.
+func (c *txChecker) SignatureHash(scriptCode []byte, hashType byte, sigVersion op.SigVersion) ([]byte, error) {
+	script, err := ParseRawScript(scriptCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if sigVersion == op.SigVersionWitnessV0 {
+		if c.cache.segwit == nil {
+			c.cache.segwit = c.tx.segwitSigHashes()
+		}
+		return c.tx.segwitSignatureHash(c.inputIndex, script, c.amount, SigHashType(hashType), c.cache.segwit)
+	}
+
+	return c.tx.SignatureHash(c.inputIndex, script, SigHashType(hashType))
+}
+
+// Returns the hash signed by a taproot signature of the input with hashType.
+func (c *txChecker) TaprootSignatureHash(hashType byte, execData *op.TaprootExecutionData) ([]byte, error) {
+	if SigHashType(hashType).base() == SigHashSingle && c.inputIndex >= len(c.tx.Outputs) {
+		return nil, &op.Error{Code: op.ErrSchnorrSigHashType, Description: "no output for SIGHASH_SINGLE"}
+	}
+
+	return c.tx.taprootSignatureHash(c.inputIndex, SigHashType(hashType), execData, c.cache)
+}
+
+// Returns whether the transaction's nLockTime satisfies lockTime, see BIP 65.
+func (c *txChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := int64(uint32(c.tx.LockTime))
+
+	// Both must be block heights or both must be timestamps.
+	if (txLockTime < lockTimeThreshold) != (lockTime < lockTimeThreshold) {
+		return false
+	}
+
+	if lockTime > txLockTime {
+		return false
+	}
+
+	// The lock time is ignored when the input is final.
+	return c.tx.Inputs[c.inputIndex].Sequence.Uint64() != 0xffffffff
+}
+
+// Returns whether the input's nSequence satisfies sequence, see BIP 112.
+func (c *txChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.tx.Inputs[c.inputIndex].Sequence.Uint64())
+
+	// Relative lock times are only enforced from version 2 transactions.
+	if uint32(c.tx.Version) < 2 {
+		return false
+	}
+
+	if txSequence&sequenceDisableFlag != 0 {
+		return false
+	}
+
+	mask := int64(sequenceTypeFlag | sequenceLockTimeMask)
+	txMasked := txSequence & mask
+	masked := sequence & mask
+
+	// Both must be in blocks or both must be in time.
+	if (txMasked < sequenceTypeFlag) != (masked < sequenceTypeFlag) {
+		return false
+	}
+
+	return masked <= txMasked
+}
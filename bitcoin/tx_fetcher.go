@@ -2,38 +2,71 @@ package bitcoin
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 )
 
-type Fetcher func(txId string, isTestnet bool) ([]byte, error)
+// PrevOutFetcher looks up the output that is spent by a transaction input.
+type PrevOutFetcher interface {
+	FetchPrevOut(txIn *TxInput) (*TxOutput, error)
+}
+
+// PrevOutMap is a PrevOutFetcher for outputs that are already known, keyed
+// by the outpoint as returned by TxInput.String.
+type PrevOutMap map[string]*TxOutput
+
+func (m PrevOutMap) FetchPrevOut(txIn *TxInput) (*TxOutput, error) {
+	prevOut, exists := m[txIn.String()]
+	if !exists {
+		return nil, fmt.Errorf("unknown output %s", txIn)
+	}
+
+	return prevOut, nil
+}
+
+type Fetcher func(txId string, params *chaincfg.Params) ([]byte, error)
 
 type TxFetcher struct {
-	cache     map[string]*Tx
-	fetcher   Fetcher
-	isTestnet bool
+	cache   map[string]*Tx
+	fetcher Fetcher
+	params  *chaincfg.Params
 }
 
-func NewTxFetcher(fetcher Fetcher, isTestnet bool) *TxFetcher {
+func NewTxFetcher(fetcher Fetcher, params *chaincfg.Params) *TxFetcher {
 	return &TxFetcher{
-		cache:     make(map[string]*Tx),
-		fetcher:   fetcher,
-		isTestnet: isTestnet,
+		cache:   make(map[string]*Tx),
+		fetcher: fetcher,
+		params:  params,
 	}
 }
 
-func getUrl(isTestnet bool) string {
-	if isTestnet {
-		return "https://mempool.space/testnet4"
+// Returns the mempool.space url of the network, which has no url for regtest
+func getUrl(params *chaincfg.Params) (string, error) {
+	switch params.Name {
+	case chaincfg.MainNetParams.Name:
+		return "https://mempool.space", nil
+	case chaincfg.TestNet3Params.Name:
+		return "https://mempool.space/testnet", nil
+	case chaincfg.TestNet4Params.Name:
+		return "https://mempool.space/testnet4", nil
+	case chaincfg.SigNetParams.Name:
+		return "https://mempool.space/signet", nil
 	}
-	return "https://mempool.space"
+
+	return "", fmt.Errorf("mempool.space does not serve the %s network", params.Name)
 }
 
-func MemPoolFetcher(txId string, isTestnet bool) ([]byte, error) {
-	url := fmt.Sprintf("%s/api/tx/%s/raw", getUrl(isTestnet), txId)
+func MemPoolFetcher(txId string, params *chaincfg.Params) ([]byte, error) {
+	baseUrl, err := getUrl(params)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/api/tx/%s/raw", baseUrl, txId)
 	response, err := http.Get(url)
 	if err != nil {
 		return nil, err
@@ -59,25 +92,14 @@ func MemPoolFetcher(txId string, isTestnet bool) ([]byte, error) {
 
 func (txf *TxFetcher) Fetch(txId string, isFresh bool) (*Tx, error) {
 	if isFresh || txf.cache[txId] == nil {
-		raw, err := txf.fetcher(txId, txf.isTestnet)
+		raw, err := txf.fetcher(txId, txf.params)
 		if err != nil {
 			return nil, err
 		}
 
-		var tx *Tx
-		if raw[4] == 0 {
-			raw = append(raw[:4], raw[6:]...)
-			tx, err = Parse(bytes.NewReader(raw), txf.isTestnet)
-			tx.LockTime = int32(binary.LittleEndian.Uint32(raw[len(raw)-4:]))
-			// tx.LockTime = endian.LittleEndianToInt32(raw[len(raw)-4:])
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			tx, err = Parse(bytes.NewReader(raw), txf.isTestnet)
-			if err != nil {
-				return nil, err
-			}
+		tx, err := Parse(bytes.NewReader(raw), txf.params)
+		if err != nil {
+			return nil, err
 		}
 
 		if tx.Id() != txId {
@@ -87,7 +109,6 @@ func (txf *TxFetcher) Fetch(txId string, isFresh bool) (*Tx, error) {
 		txf.cache[txId] = tx
 
 	}
-	// txf.cache[txId].isTestnet = isTestnet
 
 	return txf.cache[txId], nil
 }
@@ -7,9 +7,10 @@ import (
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 )
 
-func MockFetcher(txId string, isTestnet bool) ([]byte, error) {
+func MockFetcher(txId string, params *chaincfg.Params) ([]byte, error) {
 	path := filepath.Join("testdata", "15e10745f15593a899cef391191bdd3d7c12412cc4696b7bcb669d0feadc8521.raw")
 
 	content, err := os.ReadFile(path)
@@ -22,9 +23,8 @@ func MockFetcher(txId string, isTestnet bool) ([]byte, error) {
 
 func TestTxFetcher(t *testing.T) {
 	t.Skip("Implementation is not done yet.")
-	isTestnet := false
 	isFresh := false
-	txFetcher := bitcoin.NewTxFetcher(MockFetcher, isTestnet)
+	txFetcher := bitcoin.NewTxFetcher(MockFetcher, &chaincfg.MainNetParams)
 	txId := "15e10745f15593a899cef391191bdd3d7c12412cc4696b7bcb669d0feadc8521"
 
 	tx, err := txFetcher.Fetch(txId, isFresh)
@@ -35,3 +35,12 @@ func TestTxFetcher(t *testing.T) {
 
 	fmt.Printf("tx: %v\n", tx)
 }
+
+func TestMemPoolFetcherRegtest(t *testing.T) {
+	txId := "15e10745f15593a899cef391191bdd3d7c12412cc4696b7bcb669d0feadc8521"
+
+	_, err := bitcoin.MemPoolFetcher(txId, &chaincfg.RegressionNetParams)
+	if err == nil {
+		t.Errorf("expected an error for regtest")
+	}
+}
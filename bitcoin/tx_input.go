@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"slices"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
 )
@@ -14,6 +17,9 @@ type TxInput struct {
 	PrevIndex *big.Int
 	ScriptSig *Script
 	Sequence  *big.Int
+	// The witness stack of the input, see BIP 141. It is empty for inputs
+	// that do not spend a segwit output.
+	Witness [][]byte
 }
 
 func NewTxInput(prevTx []byte, prevIndex *big.Int, scriptSig *Script, sequence *big.Int) *TxInput {
@@ -22,6 +28,7 @@ func NewTxInput(prevTx []byte, prevIndex *big.Int, scriptSig *Script, sequence *
 		prevIndex,
 		scriptSig,
 		sequence,
+		nil,
 	}
 }
 
@@ -35,14 +42,28 @@ func ParseTxInputs(data io.Reader) ([]*TxInput, error) {
 		return nil, err
 	}
 
-	inputs := make([]*TxInput, numberOfInputs)
+	return parseTxInputs(data, numberOfInputs)
+}
+
+// The smallest input, which is the outpoint, an empty scriptSig and the
+// sequence
+const minTxInputSize = 32 + 4 + 1 + 4
+
+func parseTxInputs(data io.Reader, numberOfInputs uint64) ([]*TxInput, error) {
+	if numberOfInputs > maxTxSize/minTxInputSize {
+		return nil, fmt.Errorf("transaction has too many inputs: %d", numberOfInputs)
+	}
+
+	// The inputs are appended as they are read, so that a count that is
+	// larger than the data does not allocate them
+	inputs := make([]*TxInput, 0)
 	for i := uint64(0); i < numberOfInputs; i++ {
 		txInput, err := parseTxInput(data)
 		if err != nil {
 			return nil, err
 		}
 
-		inputs[i] = txInput
+		inputs = append(inputs, txInput)
 	}
 
 	return inputs, nil
@@ -50,13 +71,13 @@ func ParseTxInputs(data io.Reader) ([]*TxInput, error) {
 
 func parseTxInput(data io.Reader) (*TxInput, error) {
 	previousTx := make([]byte, 32)
-	_, err := data.Read(previousTx)
+	_, err := io.ReadFull(data, previousTx)
 	if err != nil {
 		return nil, err
 	}
 
 	previousTransactionIndex := make([]byte, 4)
-	_, err = data.Read(previousTransactionIndex)
+	_, err = io.ReadFull(data, previousTransactionIndex)
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +88,7 @@ func parseTxInput(data io.Reader) (*TxInput, error) {
 	}
 
 	sequence := make([]byte, 4)
-	_, err = data.Read(sequence)
+	_, err = io.ReadFull(data, sequence)
 	if err != nil {
 		return nil, err
 	}
@@ -77,26 +98,91 @@ func parseTxInput(data io.Reader) (*TxInput, error) {
 		endian.LittleEndianToBigInt(previousTransactionIndex),
 		scriptSignature,
 		endian.LittleEndianToBigInt(sequence),
+		nil,
 	}, nil
 }
 
+// ParseWitness parses the witness stack of an input, which is the number of
+// elements followed by each element prefixed with its length.
+func ParseWitness(data io.Reader) ([][]byte, error) {
+	numberOfElements, err := varint.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	// Each element is at least its length
+	if numberOfElements > maxTxSize {
+		return nil, fmt.Errorf("witness has too many elements: %d", numberOfElements)
+	}
+
+	witness := make([][]byte, 0)
+	for i := uint64(0); i < numberOfElements; i++ {
+		length, err := varint.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		element, err := readBytes(data, length)
+		if err != nil {
+			return nil, err
+		}
+
+		witness = append(witness, element)
+	}
+
+	return witness, nil
+}
+
+// SerializeWitness returns the byte serialization of a witness stack.
+func SerializeWitness(witness [][]byte) []byte {
+	result, err := varint.Encode(uint64(len(witness)))
+	if err != nil {
+		return nil
+	}
+
+	for _, element := range witness {
+		length, err := varint.Encode(uint64(len(element)))
+		if err != nil {
+			return nil
+		}
+
+		result = append(result, length...)
+		result = append(result, element...)
+	}
+
+	return result
+}
+
 // Returns the byte serialization of the transaction input.
 func (txIn *TxInput) Serialize() []byte {
 	result := make([]byte, 0)
 
 	result = append(result, txIn.PrevTx...)
 	result = append(result, endian.BigIntToLittleEndian(txIn.PrevIndex, 4)...)
-	// TODO: Implement the serialization of the ScriptSig.
-	// result = append(result, txIn.ScriptSig.Serialize()...)
+
+	scriptSig := txIn.ScriptSig
+	if scriptSig == nil {
+		scriptSig = NewScript([]op.Instruction{})
+	}
+
+	serializedScriptSig, err := scriptSig.Serialize()
+	if err != nil {
+		return nil
+	}
+
+	result = append(result, serializedScriptSig...)
 	result = append(result, endian.BigIntToLittleEndian(txIn.Sequence, 4)...)
 
 	return result
 }
 
-func (txIn *TxInput) fetchTransaction(isTestnet bool) (*Tx, error) {
-	txFetcher := NewTxFetcher(MemPoolFetcher, isTestnet)
+func (txIn *TxInput) fetchTransaction(params *chaincfg.Params) (*Tx, error) {
+	txFetcher := NewTxFetcher(MemPoolFetcher, params)
+
+	// The transaction id is the hash in reversed order
+	previousTx := slices.Clone(txIn.PrevTx)
+	slices.Reverse(previousTx)
 
-	previousTxHex := fmt.Sprintf("%x", txIn.PrevTx)
+	previousTxHex := fmt.Sprintf("%x", previousTx)
 	tx, err := txFetcher.Fetch(previousTxHex, false)
 	if err != nil {
 		return nil, err
@@ -107,8 +193,8 @@ func (txIn *TxInput) fetchTransaction(isTestnet bool) (*Tx, error) {
 }
 
 // Get the output value by looking up tx hash. Returns the amount in satoshi.
-func (txIn *TxInput) Value(isTestnet bool) (uint64, error) {
-	tx, err := txIn.fetchTransaction(isTestnet)
+func (txIn *TxInput) Value(params *chaincfg.Params) (uint64, error) {
+	tx, err := txIn.fetchTransaction(params)
 	if err != nil {
 		return 0, err
 	}
@@ -117,8 +203,8 @@ func (txIn *TxInput) Value(isTestnet bool) (uint64, error) {
 }
 
 // Get the ScriptPubKey by looking up the tx hash. Returns a Script object.
-func (txIn *TxInput) ScriptPubKey(isTestnet bool) (*Script, error) {
-	tx, err := txIn.fetchTransaction(isTestnet)
+func (txIn *TxInput) ScriptPubKey(params *chaincfg.Params) (*Script, error) {
+	tx, err := txIn.fetchTransaction(params)
 	if err != nil {
 		return nil, err
 	}
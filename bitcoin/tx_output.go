@@ -17,20 +17,29 @@ func (txOut *TxOutput) String() string {
 	return fmt.Sprintf("%d:%s", txOut.Amount, txOut.ScriptPubKey.String())
 }
 
+// The smallest output, which is the amount and an empty script pubkey
+const minTxOutputSize = 8 + 1
+
 func ParseTxOutputs(data io.Reader) ([]*TxOutput, error) {
 	numberOfOutputs, err := varint.Decode(data)
 	if err != nil {
 		return nil, err
 	}
 
-	outputs := make([]*TxOutput, numberOfOutputs)
-	for i := 0; i < int(numberOfOutputs); i++ {
+	if numberOfOutputs > maxTxSize/minTxOutputSize {
+		return nil, fmt.Errorf("transaction has too many outputs: %d", numberOfOutputs)
+	}
+
+	// The outputs are appended as they are read, so that a count that is
+	// larger than the data does not allocate them
+	outputs := make([]*TxOutput, 0)
+	for i := uint64(0); i < numberOfOutputs; i++ {
 		txOutput, err := parseTxOutput(data)
 		if err != nil {
 			return nil, err
 		}
 
-		outputs[i] = txOutput
+		outputs = append(outputs, txOutput)
 	}
 
 	return outputs, nil
@@ -38,7 +47,7 @@ func ParseTxOutputs(data io.Reader) ([]*TxOutput, error) {
 
 func parseTxOutput(data io.Reader) (*TxOutput, error) {
 	amount := make([]byte, 8)
-	_, err := data.Read(amount)
+	_, err := io.ReadFull(data, amount)
 	if err != nil {
 		return nil, err
 	}
@@ -56,13 +65,12 @@ func parseTxOutput(data io.Reader) (*TxOutput, error) {
 
 // Returns the byte serialization of the transaction output.
 func (txOut *TxOutput) Serialize() []byte {
-	result, err := varint.Encode(txOut.Amount)
+	result := binary.LittleEndian.AppendUint64(make([]byte, 0), txOut.Amount)
+
+	scriptPubKey, err := txOut.ScriptPubKey.Serialize()
 	if err != nil {
 		return nil
 	}
 
-	// TODO: Implement the serialization of the ScriptPubKey.
-	// result = append(result, txOut.ScriptPubKey.Serialize()...)
-
-	return result
+	return append(result, scriptPubKey...)
 }
@@ -0,0 +1,94 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
+)
+
+// The hashes of the parts of a transaction that are the same for the
+// signature hash of every input, see BIP 143.
+type segwitSigHashes struct {
+	hashPrevouts []byte
+	hashSequence []byte
+	hashOutputs  []byte
+}
+
+// Returns the hashes shared by the signature hashes of all inputs.
+func (tx *Tx) segwitSigHashes() *segwitSigHashes {
+	prevouts := make([]byte, 0, 36*len(tx.Inputs))
+	sequences := make([]byte, 0, 4*len(tx.Inputs))
+	for _, txIn := range tx.Inputs {
+		prevouts = append(prevouts, txIn.PrevTx...)
+		prevouts = append(prevouts, endian.BigIntToLittleEndian(txIn.PrevIndex, 4)...)
+		sequences = append(sequences, endian.BigIntToLittleEndian(txIn.Sequence, 4)...)
+	}
+
+	outputs := make([]byte, 0)
+	for _, txOut := range tx.Outputs {
+		outputs = append(outputs, txOut.Serialize()...)
+	}
+
+	return &segwitSigHashes{
+		hashPrevouts: hash.Hash256(prevouts),
+		hashSequence: hash.Hash256(sequences),
+		hashOutputs:  hash.Hash256(outputs),
+	}
+}
+
+// Returns the signature hash of a segwit version 0 input for hashType as
+// described in BIP 143, where amount is the value of the spent output. The
+// scriptCode is the witness script for pay-to-witness-script-hash inputs and
+// the pay-to-public-key-hash script of the key hash for
+// pay-to-witness-public-key-hash inputs.
+func (tx *Tx) SegwitSignatureHash(inputIndex int, scriptCode *Script, amount uint64, hashType SigHashType) ([]byte, error) {
+	return tx.segwitSignatureHash(inputIndex, scriptCode, amount, hashType, tx.segwitSigHashes())
+}
+
+// Returns the signature hash of a segwit version 0 input with the hashes
+// shared by all inputs, which must be those of the transaction as it is
+func (tx *Tx) segwitSignatureHash(inputIndex int, scriptCode *Script, amount uint64, hashType SigHashType, sigHashes *segwitSigHashes) ([]byte, error) {
+	txIn := tx.Inputs[inputIndex]
+
+	serializedScriptCode, err := scriptCode.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	// The hashes of what is not signed are zero
+	zero := make([]byte, 32)
+
+	hashPrevouts := sigHashes.hashPrevouts
+	if hashType.anyoneCanPay() {
+		hashPrevouts = zero
+	}
+
+	hashSequence := sigHashes.hashSequence
+	if hashType.anyoneCanPay() || hashType.base() == SigHashNone || hashType.base() == SigHashSingle {
+		hashSequence = zero
+	}
+
+	hashOutputs := sigHashes.hashOutputs
+	switch {
+	case hashType.base() == SigHashSingle && inputIndex < len(tx.Outputs):
+		hashOutputs = hash.Hash256(tx.Outputs[inputIndex].Serialize())
+	case hashType.base() == SigHashSingle || hashType.base() == SigHashNone:
+		hashOutputs = zero
+	}
+
+	signature := endian.BigIntToLittleEndian(big.NewInt(int64(tx.Version)), 4)
+	signature = append(signature, hashPrevouts...)
+	signature = append(signature, hashSequence...)
+	signature = append(signature, txIn.PrevTx...)
+	signature = append(signature, endian.BigIntToLittleEndian(txIn.PrevIndex, 4)...)
+	signature = append(signature, serializedScriptCode...)
+	signature = append(signature, endian.BigIntToLittleEndian(new(big.Int).SetUint64(amount), 8)...)
+	signature = append(signature, endian.BigIntToLittleEndian(txIn.Sequence, 4)...)
+	signature = append(signature, hashOutputs...)
+	signature = append(signature, endian.BigIntToLittleEndian(big.NewInt(int64(tx.LockTime)), 4)...)
+	signature = binary.LittleEndian.AppendUint32(signature, uint32(hashType))
+
+	return hash.Hash256(signature), nil
+}
@@ -0,0 +1,199 @@
+package bitcoin_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+// The examples of BIP 143, with the output spent by the signed input.
+var bip143Examples = []struct {
+	name         string
+	unsignedTx   string
+	inputIndex   int
+	scriptPubKey string
+	scriptCode   string
+	amount       uint64
+	privateKey   string
+	sigHash      string
+}{
+	{
+		name:         "Native P2WPKH",
+		unsignedTx:   "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000",
+		inputIndex:   1,
+		scriptPubKey: "00141d0f172a0ecb48aee1be1f2687d2963ae33f71a1",
+		scriptCode:   "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac",
+		amount:       600_000_000,
+		privateKey:   "619c335025c7f4012e556c2a58b2506e30b8511b53ade95ea316fd8c3286feb9",
+		sigHash:      "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670",
+	},
+	{
+		name:         "P2SH-P2WPKH",
+		unsignedTx:   "0100000001db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a54770100000000feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac92040000",
+		inputIndex:   0,
+		scriptPubKey: "a9144733f37cf4db86fbc2efed2500b4f4e49f31202387",
+		scriptCode:   "76a91479091972186c449eb1ded22b78e40d009bdf008988ac",
+		amount:       1_000_000_000,
+		privateKey:   "eb696a065ef48a2192da5b28b694f87544b30fae8327c4510137a922f32c6dcf",
+		sigHash:      "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6",
+	},
+}
+
+func parseHexTx(t *testing.T, hexString string) *bitcoin.Tx {
+	raw, _ := hex.DecodeString(hexString)
+
+	tx, err := bitcoin.Parse(bytes.NewReader(raw), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return tx
+}
+
+func TestSegwitSignatureHash(t *testing.T) {
+	for _, example := range bip143Examples {
+		t.Run(example.name, func(t *testing.T) {
+			tx := parseHexTx(t, example.unsignedTx)
+
+			z, err := tx.SegwitSignatureHash(example.inputIndex, scriptFromHex(t, example.scriptCode), example.amount, bitcoin.SigHashAll)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if hex.EncodeToString(z) != example.sigHash {
+				t.Errorf("expected: %s, got: %x", example.sigHash, z)
+			}
+		})
+	}
+}
+
+func TestSignSegwitInput(t *testing.T) {
+	for _, example := range bip143Examples {
+		t.Run(example.name, func(t *testing.T) {
+			tx := parseHexTx(t, example.unsignedTx)
+			txIn := tx.Inputs[example.inputIndex]
+			tx.PrevOutFetcher = bitcoin.PrevOutMap{
+				txIn.String(): {Amount: example.amount, ScriptPubKey: *scriptFromHex(t, example.scriptPubKey)},
+			}
+
+			secret, _ := new(big.Int).SetString(example.privateKey, 16)
+			privateKey, err := ecc.NewPrivateKey(secret)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			valid, err := tx.SignInput(example.inputIndex, privateKey, bitcoin.SigHashAll)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !valid {
+				t.Errorf("expected the signed input to be valid")
+			}
+
+			if len(txIn.Witness) != 2 || !tx.HasWitness() {
+				t.Errorf("expected a witness with a signature and a public key")
+			}
+
+			if tx.Id() == tx.WitnessId() {
+				t.Errorf("expected the witness to change the witness id only")
+			}
+
+			// The witness commits to the amount of the spent output
+			tx.PrevOutFetcher = bitcoin.PrevOutMap{
+				txIn.String(): {Amount: example.amount + 1, ScriptPubKey: *scriptFromHex(t, example.scriptPubKey)},
+			}
+
+			valid, err = tx.VerifyInput(example.inputIndex, op.MandatoryVerifyFlags)
+			if err != nil || valid {
+				t.Errorf("expected an invalid input for another amount, got %v (%v)", valid, err)
+			}
+		})
+	}
+}
+
+func TestSegwitSignatureHashAfterChange(t *testing.T) {
+	example := bip143Examples[0]
+	tx := parseHexTx(t, example.unsignedTx)
+	txIn := tx.Inputs[example.inputIndex]
+	tx.PrevOutFetcher = bitcoin.PrevOutMap{
+		txIn.String(): {Amount: example.amount, ScriptPubKey: *scriptFromHex(t, example.scriptPubKey)},
+	}
+
+	secret, _ := new(big.Int).SetString(example.privateKey, 16)
+	privateKey, err := ecc.NewPrivateKey(secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if valid, err := tx.SignInput(example.inputIndex, privateKey, bitcoin.SigHashAll); err != nil || !valid {
+		t.Fatalf("expected the signed input to be valid, got %v (%v)", valid, err)
+	}
+
+	// The signature hashes are those of the transaction as it is, not as it
+	// was when it was signed
+	tx.Outputs[0].Amount++
+	valid, err := tx.VerifyInput(example.inputIndex, op.MandatoryVerifyFlags)
+	if err != nil || valid {
+		t.Errorf("expected an invalid input for another output, got %v (%v)", valid, err)
+	}
+
+	tx.Outputs[0].Amount--
+	z, err := tx.SegwitSignatureHash(example.inputIndex, scriptFromHex(t, example.scriptCode), example.amount, bitcoin.SigHashAll)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hex.EncodeToString(z) != example.sigHash {
+		t.Errorf("expected: %s, got: %x", example.sigHash, z)
+	}
+}
+
+func TestVerifyWitnessProgram(t *testing.T) {
+	// OP_0 <sha256(OP_1)>
+	p2wsh := scriptFromHex(t, "00204ae81572f06e1b88fd5ced7a1a000945432e83e1551e6f721ee9c00b8cc33260")
+
+	testCases := []struct {
+		name      string
+		scriptSig *bitcoin.Script
+		witness   [][]byte
+		code      op.ErrorCode
+	}{
+		{"witness script", bitcoin.NewScript([]op.Instruction{}), [][]byte{{0x51}}, -1},
+		{"empty witness", bitcoin.NewScript([]op.Instruction{}), nil, op.ErrWitnessProgramWitnessEmpty},
+		{"wrong witness script", bitcoin.NewScript([]op.Instruction{}), [][]byte{{0x52}}, op.ErrWitnessProgramMismatch},
+		{"scriptSig not empty", scriptFromHex(t, "51"), [][]byte{{0x51}}, op.ErrWitnessMalleated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			valid, err := bitcoin.VerifyScript(tc.scriptSig, p2wsh, tc.witness, nil, op.VerifyP2SH|op.VerifyWitness)
+			if tc.code < 0 {
+				if err != nil || !valid {
+					t.Errorf("expected a valid script, got %v (%v)", valid, err)
+				}
+				return
+			}
+
+			var scriptErr *bitcoin.ScriptError
+			if !errors.As(err, &scriptErr) || scriptErr.Code != tc.code {
+				t.Errorf("expected %s, got %v", tc.code, err)
+			}
+		})
+	}
+
+	t.Run("unexpected witness", func(t *testing.T) {
+		_, err := bitcoin.VerifyScript(bitcoin.NewScript([]op.Instruction{}), scriptFromHex(t, "51"), [][]byte{{0x51}}, nil, op.VerifyP2SH|op.VerifyWitness)
+
+		var scriptErr *bitcoin.ScriptError
+		if !errors.As(err, &scriptErr) || scriptErr.Code != op.ErrWitnessUnexpected {
+			t.Errorf("expected %s, got %v", op.ErrWitnessUnexpected, err)
+		}
+	})
+}
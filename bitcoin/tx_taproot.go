@@ -0,0 +1,182 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
+)
+
+// The hashes of the parts of a transaction and the spent outputs that are
+// the same for the signature message of every taproot input, see BIP 341.
+// They are computed when a hash type first needs them, so that signing with
+// SIGHASH_ANYONECANPAY needs no other spent output than that of the input.
+type taprootSigHashes struct {
+	hashPrevouts      []byte
+	hashAmounts       []byte
+	hashScriptPubKeys []byte
+	hashSequences     []byte
+	hashOutputs       []byte
+}
+
+// Computes the hashes of the inputs, which commit to all of the spent
+// outputs, unless they are computed already.
+func (h *taprootSigHashes) computeInputs(tx *Tx) error {
+	if h.hashPrevouts != nil {
+		return nil
+	}
+
+	prevouts := make([]byte, 0, 36*len(tx.Inputs))
+	amounts := make([]byte, 0, 8*len(tx.Inputs))
+	scriptPubKeys := make([]byte, 0)
+	sequences := make([]byte, 0, 4*len(tx.Inputs))
+	for _, txIn := range tx.Inputs {
+		prevOut, err := tx.prevOut(txIn)
+		if err != nil {
+			return err
+		}
+
+		scriptPubKey, err := prevOut.ScriptPubKey.Serialize()
+		if err != nil {
+			return err
+		}
+
+		prevouts = append(prevouts, txIn.PrevTx...)
+		prevouts = append(prevouts, endian.BigIntToLittleEndian(txIn.PrevIndex, 4)...)
+		amounts = binary.LittleEndian.AppendUint64(amounts, prevOut.Amount)
+		scriptPubKeys = append(scriptPubKeys, scriptPubKey...)
+		sequences = append(sequences, endian.BigIntToLittleEndian(txIn.Sequence, 4)...)
+	}
+
+	h.hashPrevouts = hash.HashSHA256(prevouts)
+	h.hashAmounts = hash.HashSHA256(amounts)
+	h.hashScriptPubKeys = hash.HashSHA256(scriptPubKeys)
+	h.hashSequences = hash.HashSHA256(sequences)
+
+	return nil
+}
+
+// Computes the hash of the outputs unless it is computed already.
+func (h *taprootSigHashes) computeOutputs(tx *Tx) {
+	if h.hashOutputs != nil {
+		return
+	}
+
+	outputs := make([]byte, 0)
+	for _, txOut := range tx.Outputs {
+		outputs = append(outputs, txOut.Serialize()...)
+	}
+
+	h.hashOutputs = hash.HashSHA256(outputs)
+}
+
+// Returns the signature hash of a segwit version 1 input for hashType as
+// described in BIP 341. The execData holds the annex and, for script path
+// spends, the leaf and the position of the last OP_CODESEPARATOR. Key path
+// spends without an annex may pass nil. All of the spent outputs are
+// signed, so they must be available through the PrevOutFetcher, unless
+// hashType has SigHashAnyoneCanPay, which only needs the spent output of the
+// input.
+func (tx *Tx) TaprootSignatureHash(inputIndex int, hashType SigHashType, execData *op.TaprootExecutionData) ([]byte, error) {
+	return tx.taprootSignatureHash(inputIndex, hashType, execData, &sigHashCache{})
+}
+
+// Returns the signature hash of a segwit version 1 input, with the hashes
+// shared by all inputs from cache, where they are computed when missing
+func (tx *Tx) taprootSignatureHash(inputIndex int, hashType SigHashType, execData *op.TaprootExecutionData, cache *sigHashCache) ([]byte, error) {
+	if hashType > 0x03 && (hashType < 0x81 || hashType > 0x83) {
+		return nil, fmt.Errorf("undefined hash type: %d", hashType)
+	}
+
+	if hashType.base() == SigHashSingle && inputIndex >= len(tx.Outputs) {
+		return nil, fmt.Errorf("no output for SIGHASH_SINGLE of input %d", inputIndex)
+	}
+
+	if execData == nil {
+		execData = &op.TaprootExecutionData{CodeSeparatorPosition: 0xffffffff}
+	}
+
+	if cache.taproot == nil {
+		cache.taproot = &taprootSigHashes{}
+	}
+	sigHashes := cache.taproot
+
+	txIn := tx.Inputs[inputIndex]
+
+	// The signature hash epoch
+	message := []byte{0x00}
+	message = append(message, byte(hashType))
+	message = binary.LittleEndian.AppendUint32(message, uint32(tx.Version))
+	message = binary.LittleEndian.AppendUint32(message, uint32(tx.LockTime))
+
+	if !hashType.anyoneCanPay() {
+		if err := sigHashes.computeInputs(tx); err != nil {
+			return nil, err
+		}
+		message = append(message, sigHashes.hashPrevouts...)
+		message = append(message, sigHashes.hashAmounts...)
+		message = append(message, sigHashes.hashScriptPubKeys...)
+		message = append(message, sigHashes.hashSequences...)
+	}
+
+	if hashType.base() != SigHashNone && hashType.base() != SigHashSingle {
+		sigHashes.computeOutputs(tx)
+		message = append(message, sigHashes.hashOutputs...)
+	}
+
+	// The spend type tells whether it is a script path spend and whether
+	// there is an annex
+	spendType := byte(0)
+	if execData.TapLeafHash != nil {
+		spendType |= 0x02
+	}
+	if execData.Annex != nil {
+		spendType |= 0x01
+	}
+	message = append(message, spendType)
+
+	if hashType.anyoneCanPay() {
+		prevOut, err := tx.prevOut(txIn)
+		if err != nil {
+			return nil, err
+		}
+
+		scriptPubKey, err := prevOut.ScriptPubKey.Serialize()
+		if err != nil {
+			return nil, err
+		}
+
+		message = append(message, txIn.PrevTx...)
+		message = append(message, endian.BigIntToLittleEndian(txIn.PrevIndex, 4)...)
+		message = binary.LittleEndian.AppendUint64(message, prevOut.Amount)
+		message = append(message, scriptPubKey...)
+		message = append(message, endian.BigIntToLittleEndian(txIn.Sequence, 4)...)
+	} else {
+		message = binary.LittleEndian.AppendUint32(message, uint32(inputIndex))
+	}
+
+	if execData.Annex != nil {
+		annex, err := varint.Encode(uint64(len(execData.Annex)))
+		if err != nil {
+			return nil, err
+		}
+		annex = append(annex, execData.Annex...)
+		message = append(message, hash.HashSHA256(annex)...)
+	}
+
+	if hashType.base() == SigHashSingle {
+		message = append(message, hash.HashSHA256(tx.Outputs[inputIndex].Serialize())...)
+	}
+
+	if execData.TapLeafHash != nil {
+		message = append(message, execData.TapLeafHash...)
+		// The key version
+		message = append(message, 0x00)
+		message = binary.LittleEndian.AppendUint32(message, execData.CodeSeparatorPosition)
+	}
+
+	return hash.TaggedHash("TapSighash", message), nil
+}
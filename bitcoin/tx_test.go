@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/hex"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 )
 
 func TestTx(t *testing.T) {
@@ -22,7 +25,7 @@ func TestTx(t *testing.T) {
 
 	t.Run("Parse version", func(t *testing.T) {
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -34,7 +37,7 @@ func TestTx(t *testing.T) {
 
 	t.Run("Parse LockTime", func(t *testing.T) {
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -47,7 +50,7 @@ func TestTx(t *testing.T) {
 	t.Run("Fee", func(t *testing.T) {
 		t.Skip("WIP")
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -66,7 +69,7 @@ func TestTx(t *testing.T) {
 	t.Run("Parse inputs", func(t *testing.T) {
 		t.Skip("WIP")
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -92,12 +95,12 @@ func TestTx(t *testing.T) {
 		hexString := "0100000001813f79011acb80925dfe69b3def355fe914bd1d96a3f5f71bf8303c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac194306003c6a989c7d1000000006b483045022100ed81ff192e75a3fd2304004dcadb746fa5e24c5031ccfcf21320b0277457c98f02207a986d955c6e0cb35d446a89d3f56100f4d7f67801c31967743a9c8e10615bed01210349fc4e631e3624a545de3f89f5d8684c7b8138bd94bdd531d2e213bf016b278afeffffff02a135ef01000000001976a914bc3b654dca7e56b04dca18f2566cdaf02e8d9ada88ac99c39800000000001976a9141c4bc762dd5423e332166702cb75f40df79fea1288ac19430600"
 		dataBytes, _ := hex.DecodeString(hexString)
 		stream := bytes.NewReader(dataBytes)
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
 
-		z, err := tx.SignatureHash(0, nil)
+		z, err := tx.SignatureHash(0, nil, bitcoin.SigHashAll)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -130,7 +133,7 @@ func TestTx(t *testing.T) {
 
 	t.Run("IsCoinbase return false when tx is not a coinbase transaction", func(t *testing.T) {
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -145,7 +148,7 @@ func TestTx(t *testing.T) {
 
 		dataBytes, _ := hex.DecodeString(hexString)
 		stream := bytes.NewReader(dataBytes)
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -157,7 +160,7 @@ func TestTx(t *testing.T) {
 
 	t.Run("CoinbaseHeight on a non coinbase transaction", func(t *testing.T) {
 		stream := setup()
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -174,7 +177,7 @@ func TestTx(t *testing.T) {
 
 		dataBytes, _ := hex.DecodeString(hexString)
 		stream := bytes.NewReader(dataBytes)
-		tx, err := bitcoin.Parse(stream, false)
+		tx, err := bitcoin.Parse(stream, &chaincfg.MainNetParams)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -189,3 +192,234 @@ func TestTx(t *testing.T) {
 		}
 	})
 }
+
+func TestSegwitTx(t *testing.T) {
+	hexString := "0100000000010100010000000000000000000000000000000000000000000000000000000000000000000000ffffffff01e8030000000000001976a9144c9c3dfac4207d5d8cb89df5722cb3d712385e3f88ac02483045022100cfb07164b36ba64c1b1e8c7720a56ad64d96f6ef332d3d37f9cb3c96477dc44502200a464cd7a9cf94cd70f66ce4f4f0625ef650052c7afcfe29d7d7e01830ff91ed012103596d3451025c19dbbdeb932d6bf8bfb4ad499b95b6f88db8899efac102e5fc7100000000"
+	legacyHexString := "010000000100010000000000000000000000000000000000000000000000000000000000000000000000ffffffff01e8030000000000001976a9144c9c3dfac4207d5d8cb89df5722cb3d712385e3f88ac00000000"
+
+	setup := func(t *testing.T) *bitcoin.Tx {
+		dataBytes, _ := hex.DecodeString(hexString)
+
+		tx, err := bitcoin.Parse(bytes.NewReader(dataBytes), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return tx
+	}
+
+	t.Run("Parse witness", func(t *testing.T) {
+		tx := setup(t)
+
+		if !tx.HasWitness() {
+			t.Fatalf("expected witness data")
+		}
+
+		witness := tx.Inputs[0].Witness
+		if len(witness) != 2 {
+			t.Fatalf("unexpected number of witness elements: %d", len(witness))
+		}
+
+		expected := "03596d3451025c19dbbdeb932d6bf8bfb4ad499b95b6f88db8899efac102e5fc71"
+		if hex.EncodeToString(witness[1]) != expected {
+			t.Errorf("expected: %s, got: %x", expected, witness[1])
+		}
+
+		if tx.LockTime != 0 {
+			t.Errorf("unexpected LockTime: %d", tx.LockTime)
+		}
+	})
+
+	t.Run("Serialize", func(t *testing.T) {
+		tx := setup(t)
+
+		if value := hex.EncodeToString(tx.Serialize()); value != hexString {
+			t.Errorf("expected: %s, got: %s", hexString, value)
+		}
+
+		if value := hex.EncodeToString(tx.SerializeLegacy()); value != legacyHexString {
+			t.Errorf("expected: %s, got: %s", legacyHexString, value)
+		}
+	})
+
+	t.Run("Id and WitnessId", func(t *testing.T) {
+		tx := setup(t)
+
+		expected := "b2ce556154e5ab22bec0a2f990b2b843f4f4085486c0d2cd82873685c0012004"
+		if tx.Id() != expected {
+			t.Errorf("expected: %s, got: %s", expected, tx.Id())
+		}
+
+		expected = "7944c8f36d682addda15124399bf954ec5d4b3a426e9d505a5f74a08644f0ebb"
+		if tx.WitnessId() != expected {
+			t.Errorf("expected: %s, got: %s", expected, tx.WitnessId())
+		}
+	})
+
+	t.Run("Weight and VSize", func(t *testing.T) {
+		tx := setup(t)
+
+		// The 85 bytes of the legacy serialization weigh 4 each, and the 110
+		// bytes of the marker, flag and witness weigh 1 each
+		if tx.Weight() != 450 || tx.VSize() != 113 {
+			t.Errorf("expected: 450 and 113, got: %d and %d", tx.Weight(), tx.VSize())
+		}
+	})
+
+	t.Run("WitnessId of a legacy transaction", func(t *testing.T) {
+		dataBytes, _ := hex.DecodeString(legacyHexString)
+		tx, err := bitcoin.Parse(bytes.NewReader(dataBytes), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tx.HasWitness() || tx.WitnessId() != tx.Id() {
+			t.Errorf("expected the same ids, got: %s and %s", tx.Id(), tx.WitnessId())
+		}
+	})
+
+	t.Run("Superfluous witness record", func(t *testing.T) {
+		dataBytes, _ := hex.DecodeString("01000000000101" + legacyHexString[10:len(legacyHexString)-8] + "00" + "00000000")
+		_, err := bitcoin.Parse(bytes.NewReader(dataBytes), &chaincfg.MainNetParams)
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+}
+
+func TestSigHashTypes(t *testing.T) {
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(8675309))
+	h160 := hash.Hash160(privateKey.SECCompressed())
+	p2pkh, _ := bitcoin.ToP2PKHScript(h160)
+	p2wpkh, _ := bitcoin.ToP2WPKHScript(h160)
+
+	// Returns a transaction with two inputs spending scriptPubKey and two
+	// outputs.
+	newTx := func(scriptPubKey *bitcoin.Script) *bitcoin.Tx {
+		final := big.NewInt(0xffffffff)
+		inputs := []*bitcoin.TxInput{
+			bitcoin.NewTxInput(bytes.Repeat([]byte{0x01}, 32), big.NewInt(0), nil, final),
+			bitcoin.NewTxInput(bytes.Repeat([]byte{0x02}, 32), big.NewInt(1), nil, final),
+		}
+		outputs := []*bitcoin.TxOutput{
+			{Amount: 1000, ScriptPubKey: *p2pkh},
+			{Amount: 2000, ScriptPubKey: *p2pkh},
+		}
+
+		tx := bitcoin.NewTx(1, inputs, outputs, 0, &chaincfg.MainNetParams)
+		tx.PrevOutFetcher = bitcoin.PrevOutMap{
+			inputs[0].String(): {Amount: 5000, ScriptPubKey: *scriptPubKey},
+			inputs[1].String(): {Amount: 5000, ScriptPubKey: *scriptPubKey},
+		}
+
+		return tx
+	}
+
+	testCases := []struct {
+		name     string
+		hashType bitcoin.SigHashType
+		// Changes the transaction after the first input is signed
+		change   func(tx *bitcoin.Tx)
+		expected bool
+	}{
+		{"ALL with a changed output", bitcoin.SigHashAll, func(tx *bitcoin.Tx) { tx.Outputs[1].Amount++ }, false},
+		{"NONE with a changed output", bitcoin.SigHashNone, func(tx *bitcoin.Tx) { tx.Outputs[0].Amount++ }, true},
+		{"NONE with a changed sequence", bitcoin.SigHashNone, func(tx *bitcoin.Tx) { tx.Inputs[1].Sequence = big.NewInt(1) }, true},
+		{"SINGLE with another output changed", bitcoin.SigHashSingle, func(tx *bitcoin.Tx) { tx.Outputs[1].Amount++ }, true},
+		{"SINGLE with its output changed", bitcoin.SigHashSingle, func(tx *bitcoin.Tx) { tx.Outputs[0].Amount++ }, false},
+		{"ALL with a removed input", bitcoin.SigHashAll, func(tx *bitcoin.Tx) { tx.Inputs = tx.Inputs[:1] }, false},
+		{"ALL|ANYONECANPAY with a removed input", bitcoin.SigHashAll | bitcoin.SigHashAnyoneCanPay, func(tx *bitcoin.Tx) { tx.Inputs = tx.Inputs[:1] }, true},
+	}
+
+	for _, scriptPubKey := range []struct {
+		name   string
+		script *bitcoin.Script
+	}{{"P2PKH", p2pkh}, {"P2WPKH", p2wpkh}} {
+		for _, tc := range testCases {
+			t.Run(scriptPubKey.name+" "+tc.name, func(t *testing.T) {
+				tx := newTx(scriptPubKey.script)
+
+				valid, err := tx.SignInput(0, privateKey, tc.hashType)
+				if err != nil || !valid {
+					t.Fatalf("expected a valid signature, got %v (%v)", valid, err)
+				}
+
+				tc.change(tx)
+
+				// The hashes shared by the segwit inputs are computed again
+				// for the changed transaction.
+				tx = bitcoin.NewTx(tx.Version, tx.Inputs, tx.Outputs, tx.LockTime, &chaincfg.MainNetParams)
+				tx.PrevOutFetcher = newTx(scriptPubKey.script).PrevOutFetcher
+
+				valid, err = tx.VerifyInput(0, op.StandardVerifyFlags)
+				if err != nil && tc.expected {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if valid != tc.expected {
+					t.Errorf("expected %v, got %v", tc.expected, valid)
+				}
+			})
+		}
+	}
+}
+
+func TestSigHashSingleBug(t *testing.T) {
+	tx := bitcoin.NewTx(1,
+		[]*bitcoin.TxInput{
+			bitcoin.NewTxInput(make([]byte, 32), big.NewInt(0), nil, big.NewInt(0xffffffff)),
+			bitcoin.NewTxInput(make([]byte, 32), big.NewInt(1), nil, big.NewInt(0xffffffff)),
+		},
+		[]*bitcoin.TxOutput{{Amount: 1000, ScriptPubKey: *bitcoin.NewScript([]op.Instruction{})}},
+		0, &chaincfg.MainNetParams)
+
+	z, err := tx.SignatureHash(1, bitcoin.NewScript([]op.Instruction{}), bitcoin.SigHashSingle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "0100000000000000000000000000000000000000000000000000000000000000"
+	if hex.EncodeToString(z) != expected {
+		t.Errorf("expected: %s, got: %x", expected, z)
+	}
+}
+
+func TestParseOversizedCounts(t *testing.T) {
+	// An input with an empty scriptSig, and an output with an empty script
+	input := strings.Repeat("00", 36) + "00" + "ffffffff"
+	output := strings.Repeat("00", 8) + "00"
+
+	tests := map[string]string{
+		"inputs":           "01000000" + "feffffff7f",
+		"outputs":          "01000000" + "01" + input + "ffffffffffffffff7f",
+		"witness elements": "01000000" + "0001" + "01" + input + "01" + output + "feffffff7f",
+		"witness element":  "01000000" + "0001" + "01" + input + "01" + output + "01" + "feffffff7f",
+		"script":           "01000000" + "01" + input + "01" + strings.Repeat("00", 8) + "feffffff7f",
+		"push":             "01000000" + "01" + input + "01" + strings.Repeat("00", 8) + "06" + "4effffff7f00",
+	}
+
+	for name, hexString := range tests {
+		dataBytes, _ := hex.DecodeString(hexString)
+		if _, err := bitcoin.Parse(bytes.NewReader(dataBytes), &chaincfg.MainNetParams); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	tests := []string{
+		"0100000000010100010000000000000000000000000000000000000000000000000000000000000000000000ffffffff01e8030000000000001976a9144c9c3dfac4207d5d8cb89df5722cb3d712385e3f88ac02483045022100cfb07164b36ba64c1b1e8c7720a56ad64d96f6ef332d3d37f9cb3c96477dc44502200a464cd7a9cf94cd70f66ce4f4f0625ef650052c7afcfe29d7d7e01830ff91ed012103596d3451025c19dbbdeb932d6bf8bfb4ad499b95b6f88db8899efac102e5fc7100000000",
+		"010000000100010000000000000000000000000000000000000000000000000000000000000000000000ffffffff01e8030000000000001976a9144c9c3dfac4207d5d8cb89df5722cb3d712385e3f88ac00000000",
+	}
+
+	// Every field is cut short by one of the truncations, which must not be
+	// filled with zeros
+	for _, hexString := range tests {
+		dataBytes, _ := hex.DecodeString(hexString)
+		for length := 0; length < len(dataBytes); length++ {
+			if _, err := bitcoin.Parse(bytes.NewReader(dataBytes[:length]), &chaincfg.MainNetParams); err == nil {
+				t.Errorf("Parse(%x): expected an error for a transaction truncated to %d bytes", dataBytes[:length], length)
+			}
+		}
+	}
+}
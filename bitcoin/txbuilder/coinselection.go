@@ -0,0 +1,267 @@
+package txbuilder
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+)
+
+// Strategy is the coin selection algorithm that chooses the UTXOs to spend.
+type Strategy int
+
+const (
+	// Searches for the UTXOs that pay the payments and the fee without
+	// change, wasting as little as possible, and falls back to Knapsack when
+	// there are none
+	BranchAndBound Strategy = iota
+	// Approximates the UTXOs that are closest to paying the payments, the
+	// fee and the smallest change, by trying random subsets
+	Knapsack
+	// Spends the largest UTXOs until the payments and the fee are paid
+	LargestFirst
+	// Spends UTXOs in random order until the payments, the fee and the
+	// smallest change are paid
+	SingleRandomDraw
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case BranchAndBound:
+		return "branch and bound"
+	case Knapsack:
+		return "knapsack"
+	case LargestFirst:
+		return "largest first"
+	case SingleRandomDraw:
+		return "single random draw"
+	}
+
+	return "unknown"
+}
+
+// The number of branches that Branch-and-Bound searches at most
+const maxBranchAndBoundTries = 100_000
+
+// The number of random subsets that Knapsack tries
+const knapsackIterations = 1000
+
+// A UTXO that may be spent, with its effective value, which is its amount
+// less the fee of spending it
+type candidate struct {
+	utxo  *UTXO
+	value uint64
+}
+
+// Returns the sum of the effective values
+func effectiveValue(candidates []candidate) uint64 {
+	sum := uint64(0)
+	for _, c := range candidates {
+		sum += c.value
+	}
+
+	return sum
+}
+
+// Returns the candidates sorted by their effective values, the largest first
+func sortDescending(candidates []candidate) []candidate {
+	sorted := slices.Clone(candidates)
+	slices.SortStableFunc(sorted, func(a, b candidate) int {
+		return cmp.Compare(b.value, a.value)
+	})
+
+	return sorted
+}
+
+// Returns the candidates whose effective value is between target and
+// target + costOfChange with the least excess, so that leaving out the
+// change is cheaper than adding it, or nil when there are none. The search
+// is depth first, including the largest candidates first.
+func selectBranchAndBound(candidates []candidate, target, costOfChange uint64) []candidate {
+	sorted := sortDescending(candidates)
+
+	available := effectiveValue(sorted)
+	if available < target {
+		return nil
+	}
+
+	selected := make([]bool, len(sorted))
+	var best []bool
+	bestExcess := costOfChange + 1
+	value := uint64(0)
+	tries := 0
+
+	var search func(i int)
+	search = func(i int) {
+		if tries >= maxBranchAndBoundTries || bestExcess == 0 {
+			return
+		}
+		tries++
+
+		if value >= target {
+			// Adding more candidates only adds to the excess
+			if excess := value - target; excess < bestExcess {
+				best, bestExcess = slices.Clone(selected), excess
+			}
+			return
+		}
+		if i == len(sorted) || value+available < target {
+			return
+		}
+
+		v := sorted[i].value
+		available -= v
+		// Including a candidate after leaving out one of the same value is
+		// the same as the branch that included the other one
+		if i == 0 || selected[i-1] || sorted[i-1].value != v {
+			selected[i] = true
+			value += v
+			search(i + 1)
+			value -= v
+			selected[i] = false
+		}
+		search(i + 1)
+		available += v
+	}
+	search(0)
+
+	if best == nil {
+		return nil
+	}
+
+	result := make([]candidate, 0)
+	for i, ok := range best {
+		if ok {
+			result = append(result, sorted[i])
+		}
+	}
+
+	return result
+}
+
+// Returns the candidates closest to target + minChange, or to target when
+// an exact match is found, or nil when they do not add up to target. A
+// single candidate that is larger is used when it is closer than any of the
+// subsets of the smaller candidates.
+func selectKnapsack(candidates []candidate, target, minChange uint64, random *rand.Rand) []candidate {
+	shuffled := slices.Clone(candidates)
+	random.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	var lowestLarger *candidate
+	smaller := make([]candidate, 0)
+	for i, c := range shuffled {
+		switch {
+		case c.value == target:
+			return []candidate{c}
+		case c.value < target+minChange:
+			smaller = append(smaller, c)
+		case lowestLarger == nil || c.value < lowestLarger.value:
+			lowestLarger = &shuffled[i]
+		}
+	}
+
+	totalSmaller := effectiveValue(smaller)
+	if totalSmaller == target {
+		return smaller
+	}
+	if totalSmaller < target {
+		if lowestLarger == nil {
+			return nil
+		}
+		return []candidate{*lowestLarger}
+	}
+
+	smaller = sortDescending(smaller)
+	best, bestValue := approximateBestSubset(smaller, target, random)
+	if bestValue != target && totalSmaller >= target+minChange {
+		best, bestValue = approximateBestSubset(smaller, target+minChange, random)
+	}
+
+	// The larger candidate is better when the subset has no change, or when
+	// it is smaller than the subset
+	if lowestLarger != nil && ((bestValue != target && bestValue < target+minChange) || lowestLarger.value <= bestValue) {
+		return []candidate{*lowestLarger}
+	}
+
+	return best
+}
+
+// Returns the subset of the candidates with the smallest effective value
+// from target found among random subsets, together with the value. Each
+// try includes the candidates at random and then adds the rest until target
+// is reached.
+func approximateBestSubset(candidates []candidate, target uint64, random *rand.Rand) ([]candidate, uint64) {
+	best := slices.Clone(candidates)
+	bestValue := effectiveValue(candidates)
+
+	included := make([]bool, len(candidates))
+	for i := 0; i < knapsackIterations && bestValue != target; i++ {
+		clear(included)
+		total := uint64(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for j, c := range candidates {
+				if (pass == 0 && random.IntN(2) == 0) || (pass == 1 && included[j]) {
+					continue
+				}
+
+				total += c.value
+				included[j] = true
+				if total >= target {
+					reachedTarget = true
+					if total < bestValue {
+						bestValue = total
+						best = make([]candidate, 0)
+						for k, ok := range included {
+							if ok {
+								best = append(best, candidates[k])
+							}
+						}
+					}
+					// Try to reach target without this candidate
+					total -= c.value
+					included[j] = false
+				}
+			}
+		}
+	}
+
+	return best, bestValue
+}
+
+// Returns the largest candidates that add up to target, or nil when all of
+// them do not
+func selectLargestFirst(candidates []candidate, target uint64) []candidate {
+	selected := make([]candidate, 0)
+	value := uint64(0)
+	for _, c := range sortDescending(candidates) {
+		if value >= target {
+			break
+		}
+		selected = append(selected, c)
+		value += c.value
+	}
+
+	if value < target {
+		return nil
+	}
+
+	return selected
+}
+
+// Returns the candidates drawn at random until they add up to target +
+// minChange, so that there always is change, or nil when all of them do not
+func selectSingleRandomDraw(candidates []candidate, target, minChange uint64, random *rand.Rand) []candidate {
+	selected := make([]candidate, 0)
+	value := uint64(0)
+	for _, i := range random.Perm(len(candidates)) {
+		selected = append(selected, candidates[i])
+		value += candidates[i].value
+		if value >= target+minChange {
+			return selected
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package txbuilder_test
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/txbuilder"
+)
+
+// Returns the amounts of the spent UTXOs, sorted
+func spentAmounts(result *txbuilder.Result) []uint64 {
+	amounts := make([]uint64, len(result.Inputs))
+	for i, utxo := range result.Inputs {
+		amounts[i] = utxo.Output.Amount
+	}
+	slices.Sort(amounts)
+
+	return amounts
+}
+
+func TestCoinSelection(t *testing.T) {
+	key := newWalletKey(t, 1)
+	recipient := newWalletKey(t, 2)
+
+	// At 1 sat/vB each input costs 68 sat, and the fixed part of the
+	// transaction costs 42 sat on top of the payment of 100000 sat, so the
+	// effective values of 60068 and 40110 sat pay exactly 100042 sat
+	utxos := []*txbuilder.UTXO{
+		newUTXO(1, 30_000, key.p2wpkh),
+		newUTXO(2, 60_068, key.p2wpkh),
+		newUTXO(3, 75_000, key.p2wpkh),
+		newUTXO(4, 40_110, key.p2wpkh),
+		newUTXO(5, 150_000, key.p2wpkh),
+	}
+	payments := []*bitcoin.TxOutput{{Amount: 100_000, ScriptPubKey: *recipient.p2wpkh}}
+
+	tests := []struct {
+		strategy txbuilder.Strategy
+		utxos    []*txbuilder.UTXO
+		spent    []uint64
+		change   bool
+	}{
+		// The exact match without change
+		{txbuilder.BranchAndBound, utxos, []uint64{40_110, 60_068}, false},
+		{txbuilder.LargestFirst, utxos, []uint64{150_000}, true},
+		// The exact match of the smaller UTXOs
+		{txbuilder.Knapsack, utxos, []uint64{40_110, 60_068}, false},
+		// The smallest UTXO that pays with change is closer than the subsets
+		// of the smaller ones
+		{txbuilder.Knapsack, []*txbuilder.UTXO{utxos[1], utxos[2], newUTXO(6, 110_000, key.p2wpkh), utxos[4]}, []uint64{110_000}, true},
+		// A single UTXO that pays exactly
+		{txbuilder.Knapsack, []*txbuilder.UTXO{utxos[0], newUTXO(6, 100_110, key.p2wpkh), utxos[4]}, []uint64{100_110}, false},
+		// Without an exact match, Knapsack is used
+		{txbuilder.BranchAndBound, []*txbuilder.UTXO{utxos[0], utxos[4]}, []uint64{150_000}, true},
+	}
+
+	for _, test := range tests {
+		builder := txbuilder.NewBuilder(test.utxos, payments, 1, key.p2wpkh, &chaincfg.MainNetParams)
+		builder.Strategy = test.strategy
+		builder.Rand = rand.New(rand.NewPCG(1, 2))
+
+		result, err := builder.Build()
+		if err != nil {
+			t.Fatalf("Build(%s): %v", test.strategy, err)
+		}
+
+		if spent := spentAmounts(result); !slices.Equal(spent, test.spent) {
+			t.Errorf("Build(%s): got the UTXOs of %v sat, expected %v sat", test.strategy, spent, test.spent)
+		}
+		if (result.ChangeIndex >= 0) != test.change {
+			t.Errorf("Build(%s): got the change index %d, expected change: %v", test.strategy, result.ChangeIndex, test.change)
+		}
+		if !test.change && result.Fee != builder.FeeRate.Fee(result.VSize) {
+			t.Errorf("Build(%s): got a fee of %d sat, expected %d sat", test.strategy, result.Fee, builder.FeeRate.Fee(result.VSize))
+		}
+	}
+}
+
+func TestSingleRandomDraw(t *testing.T) {
+	key := newWalletKey(t, 1)
+	recipient := newWalletKey(t, 2)
+
+	utxos := make([]*txbuilder.UTXO, 20)
+	for i := range utxos {
+		utxos[i] = newUTXO(byte(i), uint64(10_000+1_000*i), key.p2wpkh)
+	}
+	payments := []*bitcoin.TxOutput{{Amount: 100_000, ScriptPubKey: *recipient.p2wpkh}}
+
+	// The random draws differ, but all of them have change
+	draws := make(map[string]bool)
+	for seed := uint64(0); seed < 10; seed++ {
+		builder := txbuilder.NewBuilder(utxos, payments, 5, key.p2wpkh, &chaincfg.MainNetParams)
+		builder.Strategy = txbuilder.SingleRandomDraw
+		builder.Rand = rand.New(rand.NewPCG(seed, seed))
+
+		result, err := builder.Build()
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		if result.ChangeIndex < 0 {
+			t.Errorf("Build: expected change with the seed %d", seed)
+		}
+
+		draws[result.Tx.Id()] = true
+	}
+
+	if len(draws) < 2 {
+		t.Errorf("Build: got %d different draws, expected them to differ", len(draws))
+	}
+}
@@ -0,0 +1,206 @@
+package txbuilder
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/varint"
+)
+
+// How an input is signed, which is the weight of the signed input and
+// whether it has witness data. These are the outputs that
+// bitcoin.Tx.SignInput signs, and the pay-to-script-hash multisig outputs
+// that bitcoin.Tx.FinalizeMultisigInput finalizes.
+type inputType struct {
+	weight  int
+	witness bool
+}
+
+// The weight of the outpoint and the sequence of an input
+const outpointWeight = 4 * (32 + 4 + 4)
+
+// The size of a DER signature with a low s followed by the hash type, which
+// is at most 71 bytes for an r of 33 bytes and an s of 32 bytes
+const maxSignatureSize = 72
+
+// The size of a Schnorr signature followed by a hash type that is not
+// SIGHASH_DEFAULT
+const maxSchnorrSignatureSize = 65
+
+// The signed inputs of the outputs with a single key, with the largest
+// signatures
+var (
+	// <signature> <public key> in the scriptSig, with its length
+	p2pkhInput = inputType{outpointWeight + 4*(1+1+maxSignatureSize+1+33), false}
+	// The length of the scriptSig and the push of the 22 bytes
+	// pay-to-witness-public-key-hash redeem script, and <signature>
+	// <public key> in the witness of 2 elements
+	p2shP2WPKHInput = inputType{outpointWeight + 4*(1+1+22) + 1 + 1 + maxSignatureSize + 1 + 33, true}
+	// The empty scriptSig, and <signature> <public key> in the witness of 2
+	// elements
+	p2wpkhInput = inputType{outpointWeight + 4 + 1 + 1 + maxSignatureSize + 1 + 33, true}
+	// The empty scriptSig, and the Schnorr signature of the key path in the
+	// witness of 1 element
+	p2trInput = inputType{outpointWeight + 4 + 1 + 1 + maxSchnorrSignatureSize, true}
+)
+
+// Returns how an output with the script pubkey is signed. Pay-to-script-hash
+// outputs are only signed with their redeem script, which must be a
+// pay-to-witness-public-key-hash or a multisig script.
+func inputTypeOf(scriptPubKey, redeemScript *bitcoin.Script) (inputType, error) {
+	switch {
+	case scriptPubKey.IsP2PKHScriptPubKey():
+		return p2pkhInput, nil
+	case scriptPubKey.IsP2SHScriptPubKey():
+		return p2shInputType(scriptPubKey, redeemScript)
+	case scriptPubKey.IsP2WPKHScriptPubKey():
+		return p2wpkhInput, nil
+	case scriptPubKey.IsP2TRScriptPubKey():
+		return p2trInput, nil
+	}
+
+	raw, _ := scriptPubKey.RawSerialize()
+
+	return inputType{}, fmt.Errorf("can not estimate the size of spending the script pubkey %x", raw)
+}
+
+// Returns how the pay-to-script-hash output of the redeem script is signed
+func p2shInputType(scriptPubKey, redeemScript *bitcoin.Script) (inputType, error) {
+	if redeemScript == nil {
+		return inputType{}, fmt.Errorf("can not estimate the size of spending a pay-to-script-hash output without its redeem script")
+	}
+
+	raw, err := redeemScript.RawSerialize()
+	if err != nil {
+		return inputType{}, err
+	}
+	p2sh, err := bitcoin.ToP2SHScript(hash.Hash160(raw))
+	if err != nil {
+		return inputType{}, err
+	}
+	rawP2SH, _ := p2sh.RawSerialize()
+	rawScriptPubKey, _ := scriptPubKey.RawSerialize()
+	if !bytes.Equal(rawP2SH, rawScriptPubKey) {
+		return inputType{}, fmt.Errorf("redeem script %x does not hash to the script pubkey %x", raw, rawScriptPubKey)
+	}
+
+	if redeemScript.IsP2WPKHScriptPubKey() {
+		return p2shP2WPKHInput, nil
+	}
+	if required, _, ok := redeemScript.Multisig(); ok {
+		if _, err := bitcoin.ToP2SHMultisigScript(redeemScript); err != nil {
+			return inputType{}, err
+		}
+
+		// OP_0, the pushes of the required signatures and the push of the
+		// redeem script
+		size := 1 + required*(1+maxSignatureSize) + pushSize(len(raw)) + len(raw)
+		length, _ := varint.Encode(uint64(size))
+
+		return inputType{outpointWeight + 4*(len(length)+size), false}, nil
+	}
+
+	return inputType{}, fmt.Errorf("can not estimate the size of spending the redeem script %x", raw)
+}
+
+// Returns the size of the opcode that pushes length bytes
+func pushSize(length int) int {
+	switch {
+	case length <= 75:
+		return 1
+	case length <= 0xff:
+		return 2 // OP_PUSHDATA1
+	}
+
+	return 3 // OP_PUSHDATA2
+}
+
+// Returns the weight of the output, whose bytes all weigh 4
+func outputWeight(output *bitcoin.TxOutput) int {
+	return 4 * len(output.Serialize())
+}
+
+// Returns the virtual size of the weight, rounded up
+func vsize(weight int) int {
+	return (weight + 3) / 4
+}
+
+// Returns the weight of the transaction that spends inputs of the types to
+// the outputs, once all inputs are signed
+func estimateWeight(types []inputType, outputs []*bitcoin.TxOutput) int {
+	inputCount, _ := varint.Encode(uint64(len(types)))
+	outputCount, _ := varint.Encode(uint64(len(outputs)))
+	// The version and the lock time
+	weight := 4 * (4 + len(inputCount) + len(outputCount) + 4)
+
+	segwit := false
+	legacyInputs := 0
+	for _, t := range types {
+		weight += t.weight
+		if t.witness {
+			segwit = true
+		} else {
+			legacyInputs++
+		}
+	}
+	for _, output := range outputs {
+		weight += outputWeight(output)
+	}
+
+	// The marker and the flag, and the empty witness of each input that has
+	// none
+	if segwit {
+		weight += 2 + legacyInputs
+	}
+
+	return weight
+}
+
+// EstimateVSize returns the virtual size that the transaction which spends
+// the UTXOs to the outputs will have once its inputs are signed by
+// bitcoin.Tx.SignInput, or by bitcoin.Tx.FinalizeMultisigInput for
+// pay-to-script-hash multisig UTXOs. The estimate assumes the largest
+// signatures, so the signed transaction is at most this size.
+func EstimateVSize(utxos []*UTXO, outputs []*bitcoin.TxOutput) (int, error) {
+	types := make([]inputType, len(utxos))
+	for i, utxo := range utxos {
+		t, err := inputTypeOf(&utxo.Output.ScriptPubKey, utxo.RedeemScript)
+		if err != nil {
+			return 0, err
+		}
+		types[i] = t
+	}
+
+	return vsize(estimateWeight(types, outputs)), nil
+}
+
+// The fee rate that the dust threshold is based on, in sat/kvB
+const dustRelayFeeRate = 3000
+
+// The largest script that can be spent
+const maxScriptSize = 10_000
+
+// DustThreshold returns the smallest amount of the output that is not dust,
+// which is when spending the output costs more than a third of its amount
+// at the dust relay fee rate of 3 sat/vB. Outputs that can not be spent are
+// never dust.
+func DustThreshold(output *bitcoin.TxOutput) uint64 {
+	raw, err := output.ScriptPubKey.RawSerialize()
+	if err != nil || (len(raw) > 0 && raw[0] == 0x6a) || len(raw) > maxScriptSize { // OP_RETURN
+		return 0
+	}
+
+	size := len(output.Serialize())
+	if _, _, ok := output.ScriptPubKey.WitnessProgram(); ok {
+		// The input of a pay-to-witness-public-key-hash output, with the
+		// witness discounted
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		// The input of a pay-to-public-key-hash output
+		size += 32 + 4 + 1 + 107 + 4
+	}
+
+	return uint64(size) * dustRelayFeeRate / 1000
+}
@@ -0,0 +1,319 @@
+// Package txbuilder builds the unsigned transactions that pay outputs from a
+// set of UTXOs at a fee rate. A coin selection strategy chooses the UTXOs to
+// spend, and change above the dust threshold is paid back to the wallet. The
+// fee is based on the size that the transaction will have once it is signed.
+package txbuilder
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/op"
+)
+
+// The sequence of the inputs, which signals that the transaction may be
+// replaced, see BIP 125, and enables its lock time
+const defaultSequence = 0xfffffffd
+
+// The amount of all the bitcoins there will ever be, in satoshis, which no
+// amount or sum of amounts may be above
+const maxMoney = 21_000_000 * 100_000_000
+
+// The highest fee rate that is built with, of one bitcoin per virtual byte
+const maxFeeRate = 100_000_000
+
+// FeeRate is a fee rate in satoshis per virtual byte.
+type FeeRate float64
+
+// Returns the fee of vsize virtual bytes at the fee rate, rounded up to
+// whole satoshis. Fees that do not fit in an uint64 are math.MaxUint64.
+func (rate FeeRate) Fee(vsize int) uint64 {
+	perKvB := math.Round(float64(rate) * 1000)
+	// NaN and infinite rates are not less than the maximum either
+	if !(perKvB < math.MaxUint64) {
+		return math.MaxUint64
+	}
+	if perKvB <= 0 || vsize <= 0 {
+		return 0
+	}
+
+	hi, lo := bits.Mul64(uint64(perKvB), uint64(vsize))
+	if hi != 0 || lo > math.MaxUint64-999 {
+		return math.MaxUint64
+	}
+
+	return (lo + 999) / 1000
+}
+
+// Returns the sum of the amounts, or an error when it is more than all the
+// bitcoins
+func addAmount(sum, amount uint64) (uint64, error) {
+	if amount > maxMoney || sum > maxMoney-amount {
+		return 0, fmt.Errorf("amounts add up to more than %d sat", uint64(maxMoney))
+	}
+
+	return sum + amount, nil
+}
+
+// UTXO is an unspent transaction output that the builder may spend.
+type UTXO struct {
+	// The hash of the transaction of the output, in the byte order of
+	// bitcoin.TxInput.PrevTx
+	PrevTx    []byte
+	PrevIndex uint32
+	Output    *bitcoin.TxOutput
+	// The redeem script of a pay-to-script-hash output, which is a
+	// pay-to-witness-public-key-hash or a multisig script
+	RedeemScript *bitcoin.Script
+}
+
+// Returns the outpoint of the UTXO, as bitcoin.TxInput.String does
+func (utxo *UTXO) String() string {
+	return fmt.Sprintf("%x:%d", utxo.PrevTx, utxo.PrevIndex)
+}
+
+// Builder builds a transaction that pays the payments from the UTXOs. It
+// must be made with NewBuilder, which sets the network parameters, and the
+// zero value fails to build.
+type Builder struct {
+	// The outputs that may be spent, which must be pay-to-public-key-hash,
+	// nested or native pay-to-witness-public-key-hash, pay-to-script-hash
+	// multisig or taproot outputs, each of another outpoint
+	UTXOs []*UTXO
+	// The outputs that are paid
+	Payments []*bitcoin.TxOutput
+	FeeRate  FeeRate
+	// The script pubkey of the change output, which must be one of the
+	// outputs that can be spent
+	ChangeScript *bitcoin.Script
+	// The redeem script of a pay-to-script-hash change script
+	ChangeRedeemScript *bitcoin.Script
+	Strategy           Strategy
+	// The randomness of the strategies, the order of the inputs and the
+	// position of the change, which is seeded at random when it is nil
+	Rand     *rand.Rand
+	Version  int32
+	LockTime int32
+	params   *chaincfg.Params
+}
+
+// Returns a builder of a version 2 transaction that uses Branch-and-Bound.
+func NewBuilder(utxos []*UTXO, payments []*bitcoin.TxOutput, feeRate FeeRate, changeScript *bitcoin.Script, params *chaincfg.Params) *Builder {
+	return &Builder{
+		UTXOs:        utxos,
+		Payments:     payments,
+		FeeRate:      feeRate,
+		ChangeScript: changeScript,
+		Strategy:     BranchAndBound,
+		Version:      2,
+		params:       params,
+	}
+}
+
+// Result is a transaction built by a Builder, which is ready to be signed.
+type Result struct {
+	// The unsigned transaction, whose PrevOutFetcher has the spent outputs
+	Tx *bitcoin.Tx
+	// The UTXOs that are spent, in the order of the inputs
+	Inputs []*UTXO
+	Fee    uint64
+	// The estimated virtual size of the signed transaction, see EstimateVSize
+	VSize int
+	// The index of the change output, or -1 when there is no change
+	ChangeIndex int
+}
+
+// Returns the randomness of the builder
+func (b *Builder) random() *rand.Rand {
+	if b.Rand != nil {
+		return b.Rand
+	}
+
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// Build selects the UTXOs that pay the payments and the fee at the fee rate
+// of the signed transaction, and returns the unsigned transaction. The
+// excess is paid as change when it is above the dust threshold and worth
+// more than spending it later, and is left as fee otherwise.
+func (b *Builder) Build() (*Result, error) {
+	if b.params == nil {
+		return nil, fmt.Errorf("builder has no network parameters, it must be made with NewBuilder")
+	}
+	if len(b.Payments) == 0 {
+		return nil, fmt.Errorf("transaction has no payments")
+	}
+	rate := float64(b.FeeRate)
+	if math.IsNaN(rate) || math.IsInf(rate, 0) || rate < 0 || rate > maxFeeRate {
+		return nil, fmt.Errorf("invalid fee rate %v sat/vB, which must be between 0 and %d", b.FeeRate, maxFeeRate)
+	}
+	if b.ChangeScript == nil {
+		return nil, fmt.Errorf("transaction has no change script")
+	}
+
+	paid := uint64(0)
+	for i, payment := range b.Payments {
+		if dust := DustThreshold(payment); payment.Amount < dust {
+			return nil, fmt.Errorf("payment %d of %d sat is below the dust threshold of %d sat", i, payment.Amount, dust)
+		}
+
+		var err error
+		if paid, err = addAmount(paid, payment.Amount); err != nil {
+			return nil, fmt.Errorf("payments: %w", err)
+		}
+	}
+
+	change := &bitcoin.TxOutput{ScriptPubKey: *b.ChangeScript}
+	changeType, err := inputTypeOf(b.ChangeScript, b.ChangeRedeemScript)
+	if err != nil {
+		return nil, fmt.Errorf("invalid change script: %w", err)
+	}
+
+	// The sums of the amounts of any of the UTXOs are at most this total
+	types := make(map[*UTXO]inputType, len(b.UTXOs))
+	outPoints := make(map[string]bool, len(b.UTXOs))
+	segwit := false
+	total := uint64(0)
+	for _, utxo := range b.UTXOs {
+		// An outpoint can only be spent once
+		if outPoints[utxo.String()] {
+			return nil, fmt.Errorf("UTXO %s is listed more than once", utxo)
+		}
+		outPoints[utxo.String()] = true
+
+		t, err := inputTypeOf(&utxo.Output.ScriptPubKey, utxo.RedeemScript)
+		if err != nil {
+			return nil, fmt.Errorf("UTXO %s: %w", utxo, err)
+		}
+		types[utxo] = t
+		segwit = segwit || t.witness
+
+		if total, err = addAmount(total, utxo.Output.Amount); err != nil {
+			return nil, fmt.Errorf("UTXOs: %w", err)
+		}
+	}
+
+	// The fees of the parts of the transaction are rounded up to whole
+	// virtual bytes, so that together they pay at least the fee of the
+	// transaction. The marker and the flag are paid for whenever any UTXO
+	// may be spent with witness data.
+	target := paid + b.FeeRate.Fee(vsize(estimateWeight(nil, b.Payments)+segwitWeight(segwit)))
+	candidates := make([]candidate, 0, len(b.UTXOs))
+	available := uint64(0)
+	for _, utxo := range b.UTXOs {
+		weight := types[utxo].weight
+		if segwit && !types[utxo].witness {
+			weight++
+		}
+
+		// UTXOs that cost more to spend than they are worth are left out
+		if fee := b.FeeRate.Fee(vsize(weight)); utxo.Output.Amount > fee {
+			candidates = append(candidates, candidate{utxo, utxo.Output.Amount - fee})
+			available += utxo.Output.Amount - fee
+		}
+	}
+	if available < target {
+		return nil, fmt.Errorf("insufficient funds: %d sat are available after the fees of the inputs, which must be at least %d sat", available, target)
+	}
+
+	// The change must be worth more than spending it, and it costs the fee of
+	// its output now and the fee of its input later
+	changeFee := b.FeeRate.Fee(vsize(outputWeight(change)))
+	changeSpendFee := b.FeeRate.Fee(vsize(changeType.weight))
+	minChange := max(DustThreshold(change), changeSpendFee+1)
+
+	random := b.random()
+	var selected []candidate
+	switch b.Strategy {
+	case BranchAndBound:
+		selected = selectBranchAndBound(candidates, target, changeFee+changeSpendFee)
+		if selected == nil {
+			selected = selectKnapsack(candidates, target, changeFee+minChange, random)
+		}
+	case Knapsack:
+		selected = selectKnapsack(candidates, target, changeFee+minChange, random)
+	case LargestFirst:
+		selected = selectLargestFirst(candidates, target)
+	case SingleRandomDraw:
+		selected = selectSingleRandomDraw(candidates, target, changeFee+minChange, random)
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy %d", b.Strategy)
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("%s coin selection found no UTXOs that pay %d sat", b.Strategy, target)
+	}
+
+	return b.build(selected, types, paid, change, minChange, random)
+}
+
+// Returns the weight of the marker and the flag of a transaction with
+// witness data
+func segwitWeight(segwit bool) int {
+	if segwit {
+		return 2
+	}
+
+	return 0
+}
+
+// Returns the transaction that spends the selected UTXOs, with the change
+// when it is at least minChange
+func (b *Builder) build(selected []candidate, types map[*UTXO]inputType, paid uint64, change *bitcoin.TxOutput, minChange uint64, random *rand.Rand) (*Result, error) {
+	random.Shuffle(len(selected), func(i, j int) {
+		selected[i], selected[j] = selected[j], selected[i]
+	})
+
+	utxos := make([]*UTXO, len(selected))
+	inputTypes := make([]inputType, len(selected))
+	total := uint64(0)
+	for i, c := range selected {
+		utxos[i] = c.utxo
+		inputTypes[i] = types[c.utxo]
+		total += c.utxo.Output.Amount
+	}
+
+	outputs := make([]*bitcoin.TxOutput, len(b.Payments))
+	for i, payment := range b.Payments {
+		outputs[i] = &bitcoin.TxOutput{Amount: payment.Amount, ScriptPubKey: payment.ScriptPubKey}
+	}
+
+	// The amounts are compared by subtracting them, so that the sums of the
+	// fees can not wrap around
+	size := vsize(estimateWeight(inputTypes, outputs))
+	if total < paid || total-paid < b.FeeRate.Fee(size) {
+		return nil, fmt.Errorf("insufficient funds: %d sat do not pay %d sat and the fee of %d sat", total, paid, b.FeeRate.Fee(size))
+	}
+
+	changeIndex := -1
+	sizeWithChange := vsize(estimateWeight(inputTypes, append(outputs, change)))
+	if fee := b.FeeRate.Fee(sizeWithChange); total-paid >= fee && total-paid-fee >= minChange {
+		change.Amount = total - paid - fee
+		changeIndex = random.IntN(len(outputs) + 1)
+		outputs = slices.Insert(outputs, changeIndex, change)
+		size = sizeWithChange
+	}
+
+	inputs := make([]*bitcoin.TxInput, len(utxos))
+	prevOuts := make(bitcoin.PrevOutMap, len(utxos))
+	for i, utxo := range utxos {
+		inputs[i] = bitcoin.NewTxInput(utxo.PrevTx, big.NewInt(int64(utxo.PrevIndex)), bitcoin.NewScript([]op.Instruction{}), big.NewInt(defaultSequence))
+		prevOuts[inputs[i].String()] = utxo.Output
+	}
+
+	tx := bitcoin.NewTx(b.Version, inputs, outputs, b.LockTime, b.params)
+	tx.PrevOutFetcher = prevOuts
+
+	return &Result{
+		Tx:          tx,
+		Inputs:      utxos,
+		Fee:         total - paid - change.Amount,
+		VSize:       size,
+		ChangeIndex: changeIndex,
+	}, nil
+}
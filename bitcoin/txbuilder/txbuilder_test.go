@@ -0,0 +1,372 @@
+package txbuilder_test
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/txbuilder"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// A key of the wallet together with its script pubkeys
+type walletKey struct {
+	privateKey *ecc.PrivateKey
+	p2pkh      *bitcoin.Script
+	p2shP2WPKH *bitcoin.Script
+	p2wpkh     *bitcoin.Script
+	p2tr       *bitcoin.Script
+}
+
+func newWalletKey(t *testing.T, secret int64) *walletKey {
+	t.Helper()
+
+	privateKey, err := ecc.NewPrivateKey(big.NewInt(secret))
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+
+	h160 := hash.Hash160(privateKey.SECCompressed())
+	p2pkh, _ := bitcoin.ToP2PKHScript(h160)
+	p2wpkh, _ := bitcoin.ToP2WPKHScript(h160)
+	rawP2WPKH, _ := p2wpkh.RawSerialize()
+	p2shP2WPKH, _ := bitcoin.ToP2SHScript(hash.Hash160(rawP2WPKH))
+
+	outputKey, err := bitcoin.TaprootOutputKey(privateKey.PublicKey(), nil)
+	if err != nil {
+		t.Fatalf("TaprootOutputKey: %v", err)
+	}
+	p2tr, _ := bitcoin.ToP2TRScript(outputKey.XOnly())
+
+	return &walletKey{privateKey, p2pkh, p2shP2WPKH, p2wpkh, p2tr}
+}
+
+// Returns the UTXO of the amount, whose outpoint is made from the index
+func newUTXO(index byte, amount uint64, scriptPubKey *bitcoin.Script) *txbuilder.UTXO {
+	prevTx := make([]byte, 32)
+	prevTx[0] = index
+
+	return &txbuilder.UTXO{
+		PrevTx:    prevTx,
+		PrevIndex: uint32(index),
+		Output:    &bitcoin.TxOutput{Amount: amount, ScriptPubKey: *scriptPubKey},
+	}
+}
+
+func TestBuildAndSign(t *testing.T) {
+	key := newWalletKey(t, 1)
+	recipient := newWalletKey(t, 2)
+
+	utxos := []*txbuilder.UTXO{
+		newUTXO(1, 40_000, key.p2pkh),
+		newUTXO(2, 55_000, key.p2shP2WPKH),
+		newUTXO(3, 70_000, key.p2wpkh),
+		newUTXO(4, 85_000, key.p2tr),
+		newUTXO(5, 25_000, key.p2wpkh),
+	}
+	utxos[1].RedeemScript = key.p2wpkh
+	payments := []*bitcoin.TxOutput{
+		{Amount: 120_000, ScriptPubKey: *recipient.p2tr},
+		{Amount: 30_000, ScriptPubKey: *recipient.p2pkh},
+	}
+
+	strategies := []txbuilder.Strategy{txbuilder.BranchAndBound, txbuilder.Knapsack, txbuilder.LargestFirst, txbuilder.SingleRandomDraw}
+	for _, strategy := range strategies {
+		for seed := uint64(0); seed < 5; seed++ {
+			builder := txbuilder.NewBuilder(utxos, payments, 12.5, key.p2wpkh, &chaincfg.MainNetParams)
+			builder.Strategy = strategy
+			builder.Rand = rand.New(rand.NewPCG(seed, seed))
+
+			result, err := builder.Build()
+			if err != nil {
+				t.Fatalf("Build(%s): %v", strategy, err)
+			}
+
+			tx := result.Tx
+			for i := range tx.Inputs {
+				valid, err := tx.SignInput(i, key.privateKey, bitcoin.SigHashAll)
+				if err != nil || !valid {
+					t.Fatalf("SignInput(%s, %d): got %v, %v, expected true, nil", strategy, i, valid, err)
+				}
+			}
+
+			// The estimate is an upper bound, which is off by at most the
+			// byte of each signature that is shorter than the largest one
+			if tx.VSize() > result.VSize || tx.VSize() < result.VSize-len(tx.Inputs) {
+				t.Errorf("Build(%s): got a signed size of %d vB, estimated %d vB", strategy, tx.VSize(), result.VSize)
+			}
+
+			fee, err := tx.Fee()
+			if err != nil {
+				t.Fatalf("Fee: %v", err)
+			}
+			if uint64(fee) != result.Fee || result.Fee < builder.FeeRate.Fee(result.VSize) {
+				t.Errorf("Build(%s): got a fee of %d sat, expected %d sat of at least %d sat", strategy, fee, result.Fee, builder.FeeRate.Fee(result.VSize))
+			}
+
+			outputs := len(payments)
+			if result.ChangeIndex >= 0 {
+				outputs++
+				change := tx.Outputs[result.ChangeIndex]
+				if !change.ScriptPubKey.IsP2WPKHScriptPubKey() || change.Amount < txbuilder.DustThreshold(change) {
+					t.Errorf("Build(%s): got the change %d, expected pay-to-witness-public-key-hash above the dust threshold", strategy, change.Amount)
+				}
+			}
+			if len(tx.Outputs) != outputs {
+				t.Errorf("Build(%s): got %d outputs, expected %d", strategy, len(tx.Outputs), outputs)
+			}
+		}
+	}
+}
+
+func TestBuildP2SHMultisig(t *testing.T) {
+	keys := []*walletKey{newWalletKey(t, 1), newWalletKey(t, 2), newWalletKey(t, 3)}
+	publicKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		publicKeys[i] = key.privateKey.SECCompressed()
+	}
+	multisig, err := bitcoin.ToSortedMultisigScript(2, publicKeys)
+	if err != nil {
+		t.Fatalf("ToSortedMultisigScript: %v", err)
+	}
+	p2sh, err := bitcoin.ToP2SHMultisigScript(multisig)
+	if err != nil {
+		t.Fatalf("ToP2SHMultisigScript: %v", err)
+	}
+
+	utxos := []*txbuilder.UTXO{newUTXO(1, 60_000, p2sh), newUTXO(2, 70_000, p2sh)}
+	for _, utxo := range utxos {
+		utxo.RedeemScript = multisig
+	}
+	payments := []*bitcoin.TxOutput{{Amount: 100_000, ScriptPubKey: *keys[0].p2wpkh}}
+
+	builder := txbuilder.NewBuilder(utxos, payments, 3, p2sh, &chaincfg.MainNetParams)
+	builder.ChangeRedeemScript = multisig
+	builder.Rand = rand.New(rand.NewPCG(1, 2))
+	result, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	tx := result.Tx
+	for i := range tx.Inputs {
+		signatures := make(map[string][]byte)
+		for _, key := range keys[:2] {
+			signature, err := tx.SignMultisigInput(i, key.privateKey, multisig, bitcoin.SigHashAll)
+			if err != nil {
+				t.Fatalf("SignMultisigInput(%d): %v", i, err)
+			}
+			signatures[hex.EncodeToString(key.privateKey.SECCompressed())] = signature
+		}
+
+		valid, err := tx.FinalizeMultisigInput(i, multisig, signatures)
+		if err != nil || !valid {
+			t.Fatalf("FinalizeMultisigInput(%d): got %v, %v, expected true, nil", i, valid, err)
+		}
+	}
+
+	// Off by at most the 2 bytes of each of the 2 signatures of each input
+	// that is shorter than the largest one, and the 2 bytes of each scriptSig
+	// length that fits in a single byte once the signatures are shorter
+	if tx.VSize() > result.VSize || tx.VSize() < result.VSize-6*len(tx.Inputs) {
+		t.Errorf("Build: got a signed size of %d vB, estimated %d vB", tx.VSize(), result.VSize)
+	}
+}
+
+func TestBuildWithoutChange(t *testing.T) {
+	key := newWalletKey(t, 1)
+	recipient := newWalletKey(t, 2)
+
+	// The fixed part of 42 vB and an input of 68 vB at 1 sat/vB, which
+	// leaves 200 sat that are not worth a change output
+	utxos := []*txbuilder.UTXO{newUTXO(1, 100_310, key.p2wpkh)}
+	payments := []*bitcoin.TxOutput{{Amount: 100_000, ScriptPubKey: *recipient.p2wpkh}}
+
+	builder := txbuilder.NewBuilder(utxos, payments, 1, key.p2wpkh, &chaincfg.MainNetParams)
+	builder.Strategy = txbuilder.LargestFirst
+
+	result, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	if result.ChangeIndex != -1 || len(result.Tx.Outputs) != 1 {
+		t.Errorf("Build: got the change index %d, expected no change", result.ChangeIndex)
+	}
+	if result.Fee != 310 || result.VSize != 110 {
+		t.Errorf("Build: got a fee of %d sat for %d vB, expected 310 sat for 110 vB", result.Fee, result.VSize)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	key := newWalletKey(t, 1)
+	p2wsh, _ := bitcoin.ToP2WSHScript(make([]byte, 32))
+
+	payment := &bitcoin.TxOutput{Amount: 50_000, ScriptPubKey: *key.p2wpkh}
+	utxos := []*txbuilder.UTXO{newUTXO(1, 100_000, key.p2wpkh)}
+
+	// The UTXO of a pay-to-script-hash output whose redeem script is not
+	// known, and those of redeem scripts that are not signed or do not match
+	p2shUTXO := newUTXO(1, 100_000, key.p2shP2WPKH)
+	wrongRedeemScript := newUTXO(1, 100_000, key.p2shP2WPKH)
+	wrongRedeemScript.RedeemScript = key.p2pkh
+	rawP2PKH, _ := key.p2pkh.RawSerialize()
+	p2shP2PKH, _ := bitcoin.ToP2SHScript(hash.Hash160(rawP2PKH))
+	unknownRedeemScript := newUTXO(1, 100_000, p2shP2PKH)
+	unknownRedeemScript.RedeemScript = key.p2pkh
+
+	// The pay-to-script-hash of a multisig script of 16 keys, which is too
+	// large to be a redeem script
+	publicKeys := make([][]byte, 16)
+	for i := range publicKeys {
+		publicKeys[i] = newWalletKey(t, int64(i+1)).privateKey.SECCompressed()
+	}
+	largeMultisig, _ := bitcoin.ToMultisigScript(1, publicKeys)
+	rawLargeMultisig, _ := largeMultisig.RawSerialize()
+	p2shLargeMultisig, _ := bitcoin.ToP2SHScript(hash.Hash160(rawLargeMultisig))
+	largeRedeemScript := newUTXO(1, 100_000, p2shLargeMultisig)
+	largeRedeemScript.RedeemScript = largeMultisig
+
+	tests := []struct {
+		name         string
+		utxos        []*txbuilder.UTXO
+		payments     []*bitcoin.TxOutput
+		feeRate      txbuilder.FeeRate
+		changeScript *bitcoin.Script
+	}{
+		{"insufficient funds", []*txbuilder.UTXO{newUTXO(1, 50_000, key.p2wpkh)}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"dust payment", []*txbuilder.UTXO{newUTXO(1, 50_000, key.p2wpkh)}, []*bitcoin.TxOutput{{Amount: 293, ScriptPubKey: *key.p2wpkh}}, 2, key.p2wpkh},
+		{"no payments", []*txbuilder.UTXO{newUTXO(1, 50_000, key.p2wpkh)}, nil, 2, key.p2wpkh},
+		{"unknown UTXO", []*txbuilder.UTXO{newUTXO(1, 100_000, p2wsh)}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"unknown change", utxos, []*bitcoin.TxOutput{payment}, 2, p2wsh},
+		{"P2SH UTXO without redeem script", []*txbuilder.UTXO{p2shUTXO}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"P2SH UTXO with another redeem script", []*txbuilder.UTXO{wrongRedeemScript}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"P2SH UTXO with unknown redeem script", []*txbuilder.UTXO{unknownRedeemScript}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"P2SH UTXO with too large redeem script", []*txbuilder.UTXO{largeRedeemScript}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"P2SH change without redeem script", utxos, []*bitcoin.TxOutput{payment}, 2, key.p2shP2WPKH},
+		{"no change script", utxos, []*bitcoin.TxOutput{payment}, 2, nil},
+		{"infinite fee rate", utxos, []*bitcoin.TxOutput{payment}, txbuilder.FeeRate(math.Inf(1)), key.p2wpkh},
+		{"NaN fee rate", utxos, []*bitcoin.TxOutput{payment}, txbuilder.FeeRate(math.NaN()), key.p2wpkh},
+		{"negative fee rate", utxos, []*bitcoin.TxOutput{payment}, -1, key.p2wpkh},
+		{"too high fee rate", utxos, []*bitcoin.TxOutput{payment}, 1e9, key.p2wpkh},
+		// The payments would wrap around to less than the UTXO
+		{"overflowing payments", utxos, []*bitcoin.TxOutput{{Amount: math.MaxUint64, ScriptPubKey: *key.p2wpkh}, {Amount: 10_000, ScriptPubKey: *key.p2wpkh}}, 2, key.p2wpkh},
+		{"duplicate UTXOs", []*txbuilder.UTXO{newUTXO(1, 30_000, key.p2wpkh), newUTXO(1, 30_000, key.p2wpkh)}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+		{"overflowing UTXOs", []*txbuilder.UTXO{newUTXO(1, math.MaxUint64, key.p2wpkh), newUTXO(2, 10_000, key.p2wpkh)}, []*bitcoin.TxOutput{payment}, 2, key.p2wpkh},
+	}
+
+	for _, test := range tests {
+		builder := txbuilder.NewBuilder(test.utxos, test.payments, test.feeRate, test.changeScript, &chaincfg.MainNetParams)
+		if _, err := builder.Build(); err == nil {
+			t.Errorf("Build(%s): expected an error", test.name)
+		}
+	}
+}
+
+func TestBuildWithoutParams(t *testing.T) {
+	key := newWalletKey(t, 1)
+
+	// A builder that is not made with NewBuilder has no network parameters
+	builder := &txbuilder.Builder{
+		UTXOs:        []*txbuilder.UTXO{newUTXO(1, 100_000, key.p2wpkh)},
+		Payments:     []*bitcoin.TxOutput{{Amount: 50_000, ScriptPubKey: *key.p2wpkh}},
+		FeeRate:      2,
+		ChangeScript: key.p2wpkh,
+		Version:      2,
+	}
+	if _, err := builder.Build(); err == nil {
+		t.Errorf("Build: expected an error for a builder without network parameters")
+	}
+}
+
+func TestEstimateVSize(t *testing.T) {
+	key := newWalletKey(t, 1)
+
+	tests := []struct {
+		inputs   []*bitcoin.Script
+		outputs  []*bitcoin.Script
+		expected int
+	}{
+		{[]*bitcoin.Script{key.p2pkh}, []*bitcoin.Script{key.p2pkh, key.p2pkh}, 226},
+		{[]*bitcoin.Script{key.p2wpkh}, []*bitcoin.Script{key.p2wpkh, key.p2wpkh}, 141},
+		{[]*bitcoin.Script{key.p2shP2WPKH}, []*bitcoin.Script{key.p2shP2WPKH}, 134},
+		{[]*bitcoin.Script{key.p2tr}, []*bitcoin.Script{key.p2tr}, 112},
+		// The input without witness data has an empty witness
+		{[]*bitcoin.Script{key.p2pkh, key.p2wpkh}, []*bitcoin.Script{key.p2wpkh}, 258},
+	}
+
+	for _, test := range tests {
+		utxos := make([]*txbuilder.UTXO, len(test.inputs))
+		for i, script := range test.inputs {
+			utxos[i] = newUTXO(byte(i), 100_000, script)
+			if script == key.p2shP2WPKH {
+				utxos[i].RedeemScript = key.p2wpkh
+			}
+		}
+		outputs := make([]*bitcoin.TxOutput, len(test.outputs))
+		for i, script := range test.outputs {
+			outputs[i] = &bitcoin.TxOutput{Amount: 10_000, ScriptPubKey: *script}
+		}
+
+		vsize, err := txbuilder.EstimateVSize(utxos, outputs)
+		if err != nil {
+			t.Fatalf("EstimateVSize: %v", err)
+		}
+		if vsize != test.expected {
+			t.Errorf("EstimateVSize: got %d, expected %d", vsize, test.expected)
+		}
+	}
+}
+
+func TestDustThreshold(t *testing.T) {
+	key := newWalletKey(t, 1)
+	p2wsh, _ := bitcoin.ToP2WSHScript(make([]byte, 32))
+	opReturn, _ := bitcoin.ParseScriptAsm("RETURN 0x04 0x74657374")
+
+	tests := []struct {
+		scriptPubKey *bitcoin.Script
+		expected     uint64
+	}{
+		{key.p2pkh, 546},
+		{key.p2shP2WPKH, 540},
+		{key.p2wpkh, 294},
+		{p2wsh, 330},
+		{key.p2tr, 330},
+		{opReturn, 0},
+	}
+
+	for _, test := range tests {
+		if dust := txbuilder.DustThreshold(&bitcoin.TxOutput{ScriptPubKey: *test.scriptPubKey}); dust != test.expected {
+			t.Errorf("DustThreshold: got %d, expected %d", dust, test.expected)
+		}
+	}
+}
+
+func TestFeeRate(t *testing.T) {
+	tests := []struct {
+		rate     txbuilder.FeeRate
+		vsize    int
+		expected uint64
+	}{
+		{1, 141, 141},
+		{1.1, 141, 156},
+		{0.5, 141, 71},
+		{12.25, 200, 2450},
+		{0, 141, 0},
+		// Fees that do not fit are the largest fee
+		{txbuilder.FeeRate(math.Inf(1)), 141, math.MaxUint64},
+		{1e16, 1e6, math.MaxUint64},
+		{1e10, 1e6, 1e16},
+	}
+
+	for _, test := range tests {
+		if fee := test.rate.Fee(test.vsize); fee != test.expected {
+			t.Errorf("Fee(%v, %d): got %d, expected %d", test.rate, test.vsize, fee, test.expected)
+		}
+	}
+}
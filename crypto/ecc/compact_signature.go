@@ -0,0 +1,124 @@
+package ecc
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// The length of a compact signature, a header byte and r and s in 32 bytes
+const CompactSignatureLength = 65
+
+// The header byte of a compact signature is this plus the recovery id, plus 4
+// when the public key is compressed
+const compactHeaderOffset = 27
+
+// CompactSignature is an ECDSA signature together with the recovery id, which
+// picks the public key of the signature out of the up to four public keys
+// that it is valid for. This is what lets RecoverPublicKey find the public
+// key from the signature and the message alone.
+type CompactSignature struct {
+	Signature
+	// Bit 0 is whether the y coordinate of kG is odd, and bit 1 whether its x
+	// coordinate is n or more, so that r is the x coordinate minus n
+	RecoveryID byte
+	// Whether the public key is used in compressed SEC format, such as in
+	// its address
+	IsCompressed bool
+}
+
+func (s *CompactSignature) String() string {
+	return fmt.Sprintf("CompactSignature(%x, %x, %d)", s.R, s.S, s.RecoveryID)
+}
+
+// Serialize returns the 65 bytes of the compact signature, which are the
+// header byte 27 + recovery id (+ 4 if compressed) followed by r and s.
+func (s *CompactSignature) Serialize() []byte {
+	header := compactHeaderOffset + s.RecoveryID
+	if s.IsCompressed {
+		header += 4
+	}
+
+	result := []byte{header}
+	result = append(result, s.R.FillBytes(make([]byte, 32))...)
+	result = append(result, s.S.FillBytes(make([]byte, 32))...)
+
+	return result
+}
+
+// ParseCompact parses the 65 bytes of a compact signature.
+func ParseCompact(signature []byte) (*CompactSignature, error) {
+	if len(signature) != CompactSignatureLength {
+		return nil, fmt.Errorf("invalid compact signature length %d", len(signature))
+	}
+
+	header := signature[0]
+	if header < compactHeaderOffset || header >= compactHeaderOffset+8 {
+		return nil, fmt.Errorf("invalid compact signature header %d", header)
+	}
+	header -= compactHeaderOffset
+
+	return &CompactSignature{
+		Signature: Signature{
+			R: new(big.Int).SetBytes(signature[1:33]),
+			S: new(big.Int).SetBytes(signature[33:]),
+		},
+		RecoveryID:   header & 0x03,
+		IsCompressed: header&0x04 != 0,
+	}, nil
+}
+
+// SignCompact signs z like Sign and returns the signature with its recovery
+// id, where isCompressed tells which SEC format of the public key the
+// signature is for.
+func (pk *PrivateKey) SignCompact(z *big.Int, isCompressed bool) (*CompactSignature, error) {
+	signature, recoveryID, err := pk.sign(z)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompactSignature{*signature, recoveryID, isCompressed}, nil
+}
+
+// RecoverPublicKey returns the public key that made the signature of z. A
+// valid signature is always valid for the recovered key, so the signature is
+// checked by comparing the key, or its address, with the expected one.
+func RecoverPublicKey(z *big.Int, sig *CompactSignature) (*S256Point, error) {
+	if sig.RecoveryID > 3 {
+		return nil, fmt.Errorf("invalid recovery id %d", sig.RecoveryID)
+	}
+	if !isScalar(sig.R) || !isScalar(sig.S) {
+		return nil, fmt.Errorf("r and s must be in the range [1, n-1]")
+	}
+
+	// The x coordinate of kG is r, or r + n when it was n or more
+	x := new(big.Int).Set(sig.R)
+	if sig.RecoveryID&0x02 != 0 {
+		x.Add(x, Secp256k1.N)
+		if x.Cmp(Secp256k1.Prime) >= 0 {
+			return nil, fmt.Errorf("signature is not for a point of the curve")
+		}
+	}
+
+	sec := append([]byte{0x02 | sig.RecoveryID&0x01}, x.FillBytes(make([]byte, 32))...)
+	kG, err := Parse(sec)
+	if err != nil {
+		return nil, fmt.Errorf("signature is not for a point of the curve")
+	}
+
+	// P = (s kG - z G) / r
+	rInverse := new(big.Int).ModInverse(sig.R, Secp256k1.N)
+	u := new(big.Int).Mul(new(big.Int).Neg(z), rInverse)
+	u.Mod(u, Secp256k1.N)
+	v := new(big.Int).Mul(sig.S, rInverse)
+	v.Mod(v, Secp256k1.N)
+
+	point, err := doubleScalarMulBase(u, kG, v)
+	if err != nil {
+		return nil, err
+	}
+	if point.IsInfinity {
+		return nil, fmt.Errorf("recovered public key is the point at infinity")
+	}
+
+	return point, nil
+}
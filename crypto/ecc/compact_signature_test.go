@@ -0,0 +1,184 @@
+package ecc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+func TestSignCompact(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		secret := new(big.Int).SetBytes(hash.Hash256([]byte(fmt.Sprintf("secret %d", i))))
+		privateKey, _ := ecc.NewPrivateKey(secret)
+		z := new(big.Int).SetBytes(hash.Hash256([]byte(fmt.Sprintf("message %d", i))))
+		isCompressed := i%2 == 0
+
+		signature, err := privateKey.SignCompact(z, isCompressed)
+		if err != nil {
+			t.Fatalf("SignCompact: got error %v, expected nil", err)
+		}
+
+		// The signature is the same as the one of Sign
+		expected, _ := privateKey.Sign(z)
+		if signature.R.Cmp(expected.R) != 0 || signature.S.Cmp(expected.S) != 0 {
+			t.Errorf("SignCompact: got %v, expected %v", signature, expected)
+		}
+
+		serialized := signature.Serialize()
+		if len(serialized) != ecc.CompactSignatureLength {
+			t.Fatalf("Serialize: got %d bytes, expected %d", len(serialized), ecc.CompactSignatureLength)
+		}
+
+		parsed, err := ecc.ParseCompact(serialized)
+		if err != nil {
+			t.Fatalf("ParseCompact: got error %v, expected nil", err)
+		}
+		if parsed.IsCompressed != isCompressed || parsed.RecoveryID != signature.RecoveryID {
+			t.Errorf("ParseCompact: got %v, expected %v", parsed, signature)
+		}
+
+		publicKey, err := ecc.RecoverPublicKey(z, parsed)
+		if err != nil {
+			t.Fatalf("RecoverPublicKey: got error %v, expected nil", err)
+		}
+		if !publicKey.Equals(&privateKey.PublicKey().Point) {
+			t.Errorf("RecoverPublicKey: got %v, expected %v", publicKey, privateKey.PublicKey())
+		}
+
+		// Another message recovers another public key
+		other, err := ecc.RecoverPublicKey(new(big.Int).Add(z, big.NewInt(1)), parsed)
+		if err == nil && other.Equals(&privateKey.PublicKey().Point) {
+			t.Errorf("RecoverPublicKey: got the public key for another message")
+		}
+	}
+}
+
+func TestSignCompactDeterministic(t *testing.T) {
+	// The signature of RFC 6979 with the private key 1 and the SHA-256 of
+	// "Satoshi Nakamoto", see TestSignDeterministic
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(1))
+	z := new(big.Int).SetBytes(hash.HashSHA256([]byte("Satoshi Nakamoto")))
+
+	signature, err := privateKey.SignCompact(z, true)
+	if err != nil {
+		t.Fatalf("SignCompact: got error %v, expected nil", err)
+	}
+
+	expected := "20934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d82442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"
+	if hex.EncodeToString(signature.Serialize()) != expected {
+		t.Errorf("Serialize: got %x, expected %s", signature.Serialize(), expected)
+	}
+}
+
+func TestRecoverPublicKey(t *testing.T) {
+	// The recovery ids and signatures of btcec, which are from
+	// github.com/fjl/btcec-issue
+	tests := []struct {
+		name      string
+		z         string
+		signature string
+		publicKey string
+	}{
+		{
+			name:      "valid point",
+			z:         "ce0677bb30baa8cf067c88db9811f4333d131bf8bcf12fe7065d211dce971008",
+			signature: "1c90f27b8b488db00b00606796d2987f6a5f59ae62ea05effe84fef5b8b0e549984a691139ad57a3f0b906637673aa2f63d1f55cb1a69199d4009eea23ceaddc93",
+			publicKey: "04e32df42865e97135acfb65f3bae71bdc86f4d49150ad6a440b6f15878109880a0a2b2667f7e725ceea70c673093bf67663e0312623c8e091b13cf2c0f11ef652",
+		},
+		{
+			name:      "low r and s",
+			z:         "ba09edc1275a285fb27bfe82c4eea240a907a0dbaf9e55764b8f318c37d5974f",
+			signature: "1b000000000000000000000000000000000000000000000000000000000000002c0000000000000000000000000000000000000000000000000000000000000004",
+			publicKey: "04a7640409aa2083fdad38b2d8de1263b2251799591d840653fb02dbba503d7745fcb83d80e08a1e02896be691ea6affb8a35939a646f1fc79052a744b1c82edc3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			z, _ := new(big.Int).SetString(test.z, 16)
+			signatureBytes, _ := hex.DecodeString(test.signature)
+			signature, err := ecc.ParseCompact(signatureBytes)
+			if err != nil {
+				t.Fatalf("ParseCompact: got error %v, expected nil", err)
+			}
+
+			publicKey, err := ecc.RecoverPublicKey(z, signature)
+			if err != nil {
+				t.Fatalf("RecoverPublicKey: got error %v, expected nil", err)
+			}
+
+			expected, _ := hex.DecodeString(test.publicKey)
+			if !bytes.Equal(publicKey.SEC(), expected) {
+				t.Errorf("RecoverPublicKey: got %x, expected %s", publicKey.SEC(), test.publicKey)
+			}
+		})
+	}
+}
+
+func TestRecoverPublicKeyInvalid(t *testing.T) {
+	tests := map[string]struct {
+		z         string
+		signature string
+	}{
+		"x is not on the curve": {
+			z:         "00c547e4f7b0f325ad1e56f57e26c745b09a3e503d86e00e5255ff7f715d3d1c",
+			signature: "1c00b1693892219d736caba55bdb67216e485557ea6b6af75f37096c9aa6a5a75f00b940b1d03b21e36b0e47e79769f095fe2ab855bd91e3a38756b7d75a9c4549",
+		},
+		"point at infinity": {
+			z:         "6b8d2c81b11b2d699528dde488dbdf2f94293d0d33c32e347f255fa4a6c1f0a9",
+			signature: "1b79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817986b8d2c81b11b2d699528dde488dbdf2f94293d0d33c32e347f255fa4a6c1f0a9",
+		},
+		"zero r": {
+			z:         "2bcebac60d8a78e520ae81c2ad586792df495ed429bd730dcd897b301932d054",
+			signature: "210000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000007c",
+		},
+		"zero s": {
+			z:         "ce0677bb30baa8cf067c88db9811f4333d131bf8bcf12fe7065d211dce971008",
+			signature: "1c90f27b8b488db00b00606796d2987f6a5f59ae62ea05effe84fef5b8b0e549980000000000000000000000000000000000000000000000000000000000000000",
+		},
+		"s is n": {
+			z:         "ce0677bb30baa8cf067c88db9811f4333d131bf8bcf12fe7065d211dce971008",
+			signature: "1c90f27b8b488db00b00606796d2987f6a5f59ae62ea05effe84fef5b8b0e54998fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141",
+		},
+		"x of r + n is not less than the prime": {
+			z:         "ce0677bb30baa8cf067c88db9811f4333d131bf8bcf12fe7065d211dce971008",
+			signature: "1d90f27b8b488db00b00606796d2987f6a5f59ae62ea05effe84fef5b8b0e549984a691139ad57a3f0b906637673aa2f63d1f55cb1a69199d4009eea23ceaddc93",
+		},
+	}
+
+	for name, test := range tests {
+		z, _ := new(big.Int).SetString(test.z, 16)
+		signatureBytes, _ := hex.DecodeString(test.signature)
+		signature, err := ecc.ParseCompact(signatureBytes)
+		if err != nil {
+			t.Fatalf("ParseCompact(%s): got error %v, expected nil", name, err)
+		}
+
+		if _, err := ecc.RecoverPublicKey(z, signature); err == nil {
+			t.Errorf("RecoverPublicKey: expected an error for %s", name)
+		}
+	}
+}
+
+func TestParseCompactInvalid(t *testing.T) {
+	valid := make([]byte, ecc.CompactSignatureLength)
+	valid[0] = 27
+
+	tests := map[string][]byte{
+		"too short":      valid[:64],
+		"too long":       append(append([]byte{}, valid...), 0x00),
+		"header too low": append([]byte{26}, valid[1:]...),
+		"header too big": append([]byte{35}, valid[1:]...),
+	}
+
+	for name, signature := range tests {
+		if _, err := ecc.ParseCompact(signature); err == nil {
+			t.Errorf("ParseCompact: expected an error for %s", name)
+		}
+	}
+}
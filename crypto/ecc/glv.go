@@ -0,0 +1,176 @@
+package ecc
+
+import (
+	"encoding/hex"
+	"math/big"
+	"math/bits"
+)
+
+// secp256k1 has the endomorphism (x, y) -> (beta x, y), which is the same as
+// the multiplication by lambda, for cube roots of unity beta modulo the prime
+// and lambda modulo n. The method of Gallant, Lambert and Vanstone (GLV)
+// splits a scalar k into k1 + k2 lambda, where k1 and k2 have at most 128
+// bits, so that kP = k1 P + k2 (lambda P) takes half of the doublings.
+
+// Returns the 32 bytes of a constant in hex
+func hexBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 32 {
+		panic("invalid constant " + s)
+	}
+
+	return b
+}
+
+var (
+	// beta in Montgomery form of the field
+	glvBeta = fieldModulus.fromBytes(hexBytes("7ae96a2b657c07106e64479eac3434e99cf0497512f58995c1396c28719501ee"))
+
+	// -lambda and the basis vectors -b1 and -b2 of the lattice of the
+	// decomposition in Montgomery form of the scalars
+	glvMinusLambda = scalarModulus.fromBytes(hexBytes("ac9c52b33fa3cf1f5ad9e3fd77ed9ba4a880b9fc8ec739c2e0cfc810b51283cf"))
+	glvMinusB1     = scalarModulus.fromBytes(hexBytes("00000000000000000000000000000000e4437ed6010e88286f547fa90abfe4c3"))
+	glvMinusB2     = scalarModulus.fromBytes(hexBytes("fffffffffffffffffffffffffffffffe8a280ac50774346dd765cda83db1562c"))
+
+	// round(2^384 b2 / n) and round(2^384 (-b1) / n), which turn the
+	// division of the decomposition into a multiplication and a shift
+	glvG1 = limbsFromBytes(hexBytes("3086d221a7d46bcde86c90e49284eb153daa8a1471e8ca7fe893209a45dbb031"))
+	glvG2 = limbsFromBytes(hexBytes("e4437ed6010e88286f547fa90abfe4c4221208ac9df506c61571b4ae8ac47f71"))
+)
+
+// Returns round(a * b / 2^384) of two plain numbers
+func mulShiftRound(a, b *montgomeryInt) montgomeryInt {
+	var t [8]uint64
+	for i := 0; i < 4; i++ {
+		var c, carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			lo, carry = bits.Add64(lo, t[i+j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[i+j], c = lo, hi
+		}
+		t[i+4] = c
+	}
+
+	// Bit 383 rounds the shift to the nearest number
+	var result montgomeryInt
+	var carry uint64
+	result[0], carry = bits.Add64(t[6], 0, t[5]>>63)
+	result[1], _ = bits.Add64(t[7], 0, carry)
+
+	return result
+}
+
+// Returns the absolute value of a plain number modulo n that is less than
+// 2^128 or greater than n - 2^128, as 16 bytes in big endian, and 1 if it is
+// negative, i.e. greater than n - 2^128
+func splitHalf(a *montgomeryInt) ([]byte, uint64) {
+	high := a[2] | a[3]
+	isNegative := (high | -high) >> 63
+
+	negated := scalarModulus.neg(a)
+	abs := selectInt(a, &negated, isNegative)
+
+	return limbsToBytes(&abs)[16:], isNegative
+}
+
+// Returns k1 and k2 with k = k1 + k2 lambda mod n for a scalar k of 32 bytes
+// in big endian, as their absolute values of 16 bytes and whether they are
+// negative. It takes the same time for every k.
+func splitScalar(k []byte) ([]byte, []byte, uint64, uint64) {
+	n := scalarModulus
+
+	plain := limbsFromBytes(k)
+	plain = n.reduce(&plain, 0)
+
+	// k2 = -(c1 b1 + c2 b2), where c1 and c2 are the rounded coordinates of
+	// (k, 0) in the basis of the lattice
+	c1 := mulShiftRound(&plain, &glvG1)
+	c2 := mulShiftRound(&plain, &glvG2)
+	c1 = n.mul(&c1, &n.rr)
+	c2 = n.mul(&c2, &n.rr)
+	c1 = n.mul(&c1, &glvMinusB1)
+	c2 = n.mul(&c2, &glvMinusB2)
+	k2 := n.add(&c1, &c2)
+
+	// k1 = k - k2 lambda
+	kScalar := n.mul(&plain, &n.rr)
+	k1 := n.mul(&k2, &glvMinusLambda)
+	k1 = n.add(&k1, &kScalar)
+
+	k1 = n.mul(&k1, &montgomeryInt{1})
+	k2 = n.mul(&k2, &montgomeryInt{1})
+	k1Bytes, k1Negative := splitHalf(&k1)
+	k2Bytes, k2Negative := splitHalf(&k2)
+
+	return k1Bytes, k2Bytes, k1Negative, k2Negative
+}
+
+// Returns lambda p
+func (p *projectivePoint) endomorphism() *projectivePoint {
+	return &projectivePoint{fieldModulus.mul(&p.x, &glvBeta), p.y, p.z}
+}
+
+// Returns -p if choice is 1 and p if choice is 0
+func (p *projectivePoint) conditionalNeg(choice uint64) *projectivePoint {
+	negated := fieldModulus.neg(&p.y)
+	return &projectivePoint{p.x, selectInt(&p.y, &negated, choice), p.z}
+}
+
+// Returns kp for a scalar k of 32 bytes in big endian, in the same time for
+// every k, like scalarMul but with the GLV decomposition of k. The two
+// halves share the doublings and the table of p.
+func (p *projectivePoint) scalarMulGLV(k []byte) *projectivePoint {
+	k1, k2, k1Negative, k2Negative := splitScalar(k)
+	table := multiplesTable(p)
+
+	result := newProjectiveInfinity()
+	for i := range k1 {
+		for _, shift := range []uint{4, 0} {
+			result = result.double().double().double().double()
+
+			p1 := selectMultiple(table, (k1[i]>>shift)&0x0f).conditionalNeg(k1Negative)
+			p2 := selectMultiple(table, (k2[i]>>shift)&0x0f).endomorphism().conditionalNeg(k2Negative)
+			result = result.add(p1).add(p2)
+		}
+	}
+
+	return result
+}
+
+// Returns lambda p
+func (p *affinePoint) endomorphism() affinePoint {
+	return affinePoint{fieldModulus.mul(&p.x, &glvBeta), p.y}
+}
+
+// Returns the table of lambda p for every p of a table
+func endomorphismTable(table []affinePoint) []affinePoint {
+	result := make([]affinePoint, len(table))
+	for i := range table {
+		result[i] = table[i].endomorphism()
+	}
+
+	return result
+}
+
+// Returns the wNAF of the GLV halves k1 and k2 of k, where the digits of a
+// negative half are negated
+func splitWNAF(k *big.Int, window uint) ([]int8, []int8) {
+	c := new(big.Int).Mod(k, Secp256k1.N)
+	k1, k2, k1Negative, k2Negative := splitScalar(c.FillBytes(make([]byte, 32)))
+
+	return signedWNAF(k1, k1Negative, window), signedWNAF(k2, k2Negative, window)
+}
+
+func signedWNAF(k []byte, isNegative uint64, window uint) []int8 {
+	digits := wNAF(new(big.Int).SetBytes(k), window)
+	if isNegative == 1 {
+		for i := range digits {
+			digits[i] = -digits[i]
+		}
+	}
+
+	return digits
+}
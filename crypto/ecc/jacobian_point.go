@@ -0,0 +1,325 @@
+package ecc
+
+import "math/big"
+
+// The arithmetic in this file is for verification, where the scalars and the
+// points are public, so it branches on the values and skips the work for
+// zero digits to be as fast as possible. It must not be used with private
+// keys or nonces, see projective_point.go for that.
+
+// jacobianPoint is a point of secp256k1 in Jacobian coordinates, where
+// (X:Y:Z) is the point (X/Z^2, Y/Z^3) and Z = 0 is the point at infinity. The
+// coordinates are in Montgomery form of the field. Unlike Point.Add there is
+// no inverse for each addition, only a single one at the end.
+type jacobianPoint struct {
+	x, y, z montgomeryInt
+}
+
+// affinePoint is a point of secp256k1 that is not the point at infinity, in
+// Montgomery form of the field. The points of the tables are affine, since an
+// addition of an affine point to a Jacobian point is cheaper.
+type affinePoint struct {
+	x, y montgomeryInt
+}
+
+// The window of the wNAF of the scalars of G, which uses a table of the 64
+// odd multiples G, 3G, ..., 127G
+const generatorWindow = 8
+
+// The window of the wNAF of the scalars of other points, whose table of the
+// odd multiples P, 3P, ..., 15P is computed for each multiplication
+const pointWindow = 5
+
+// The odd multiples of G and of lambda G, which are set together with G
+var generatorTable, generatorLambdaTable []affinePoint
+
+func newJacobianInfinity() *jacobianPoint {
+	return &jacobianPoint{}
+}
+
+func newAffinePoint(p *S256Point) *affinePoint {
+	return &affinePoint{
+		x: fieldModulus.fromBig(p.x.number),
+		y: fieldModulus.fromBig(p.y.number),
+	}
+}
+
+func (p *jacobianPoint) isInfinity() bool {
+	return isZeroInt(&p.z) == 1
+}
+
+// Returns -p
+func (p *affinePoint) neg() *affinePoint {
+	return &affinePoint{x: p.x, y: fieldModulus.neg(&p.y)}
+}
+
+// Returns 2p, see dbl-2009-l of the Explicit-Formulas Database
+func (p *jacobianPoint) double() *jacobianPoint {
+	if p.isInfinity() {
+		return p
+	}
+
+	f := fieldModulus
+
+	a := f.mul(&p.x, &p.x)
+	b := f.mul(&p.y, &p.y)
+	c := f.mul(&b, &b)
+	// d = 2((x + b)^2 - a - c)
+	d := f.add(&p.x, &b)
+	d = f.mul(&d, &d)
+	d = f.sub(&d, &a)
+	d = f.sub(&d, &c)
+	d = f.add(&d, &d)
+	// e = 3a
+	e := f.add(&a, &a)
+	e = f.add(&e, &a)
+	g := f.mul(&e, &e)
+
+	// x3 = g - 2d
+	x3 := f.sub(&g, &d)
+	x3 = f.sub(&x3, &d)
+	// y3 = e(d - x3) - 8c
+	y3 := f.sub(&d, &x3)
+	y3 = f.mul(&e, &y3)
+	c = f.add(&c, &c)
+	c = f.add(&c, &c)
+	c = f.add(&c, &c)
+	y3 = f.sub(&y3, &c)
+	// z3 = 2yz
+	z3 := f.mul(&p.y, &p.z)
+	z3 = f.add(&z3, &z3)
+
+	return &jacobianPoint{x3, y3, z3}
+}
+
+// Returns p + q for an affine point q, see madd-2004-hmv of the
+// Explicit-Formulas Database
+func (p *jacobianPoint) addAffine(q *affinePoint) *jacobianPoint {
+	f := fieldModulus
+
+	if p.isInfinity() {
+		return &jacobianPoint{q.x, q.y, f.one}
+	}
+
+	z1z1 := f.mul(&p.z, &p.z)
+	u2 := f.mul(&q.x, &z1z1)
+	s2 := f.mul(&q.y, &p.z)
+	s2 = f.mul(&s2, &z1z1)
+
+	return p.finishAdd(&p.x, &p.y, &u2, &s2, &p.z)
+}
+
+// Returns p + q, see add-1998-cmo-2 of the Explicit-Formulas Database
+func (p *jacobianPoint) add(q *jacobianPoint) *jacobianPoint {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+
+	f := fieldModulus
+
+	z1z1 := f.mul(&p.z, &p.z)
+	z2z2 := f.mul(&q.z, &q.z)
+	u1 := f.mul(&p.x, &z2z2)
+	u2 := f.mul(&q.x, &z1z1)
+	s1 := f.mul(&p.y, &q.z)
+	s1 = f.mul(&s1, &z2z2)
+	s2 := f.mul(&q.y, &p.z)
+	s2 = f.mul(&s2, &z1z1)
+	z1z2 := f.mul(&p.z, &q.z)
+
+	return p.finishAdd(&u1, &s1, &u2, &s2, &z1z2)
+}
+
+// Returns the sum of the points p and q, which are (u1/z^2, s1/z^3) and
+// (u2/z^2, s2/z^3) for z = z1z2, the product of their z coordinates. The
+// equal points and the opposite points are the special cases of the formula.
+func (p *jacobianPoint) finishAdd(u1, s1, u2, s2, z1z2 *montgomeryInt) *jacobianPoint {
+	f := fieldModulus
+
+	h := f.sub(u2, u1)
+	r := f.sub(s2, s1)
+	if isZeroInt(&h) == 1 {
+		if isZeroInt(&r) == 1 {
+			return p.double()
+		}
+		return newJacobianInfinity()
+	}
+
+	hh := f.mul(&h, &h)
+	hhh := f.mul(&h, &hh)
+	v := f.mul(u1, &hh)
+
+	// x3 = r^2 - h^3 - 2v
+	x3 := f.mul(&r, &r)
+	x3 = f.sub(&x3, &hhh)
+	x3 = f.sub(&x3, &v)
+	x3 = f.sub(&x3, &v)
+	// y3 = r(v - x3) - s1 h^3
+	y3 := f.sub(&v, &x3)
+	y3 = f.mul(&r, &y3)
+	s1hhh := f.mul(s1, &hhh)
+	y3 = f.sub(&y3, &s1hhh)
+	// z3 = z1 z2 h
+	z3 := f.mul(z1z2, &h)
+
+	return &jacobianPoint{x3, y3, z3}
+}
+
+// Returns the point in affine coordinates
+func (p *jacobianPoint) toAffine() (*S256Point, error) {
+	if p.isInfinity() {
+		return &S256Point{*NewInfinityPoint()}, nil
+	}
+
+	f := fieldModulus
+	zInverse := f.inverse(&p.z)
+	zInverse2 := f.mul(&zInverse, &zInverse)
+	zInverse3 := f.mul(&zInverse2, &zInverse)
+	x := f.mul(&p.x, &zInverse2)
+	y := f.mul(&p.y, &zInverse3)
+
+	xField, err := NewS256Field(f.toBig(&x))
+	if err != nil {
+		return nil, err
+	}
+	yField, err := NewS256Field(f.toBig(&y))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS256Point(xField, yField)
+}
+
+// Returns the points in affine coordinates, none of which may be the point at
+// infinity. Montgomery's trick shares a single inverse between all of them.
+func toAffineBatch(points []*jacobianPoint) []affinePoint {
+	f := fieldModulus
+
+	// products[i] is the product of the z coordinates of points[:i+1]
+	products := make([]montgomeryInt, len(points))
+	product := f.one
+	for i, p := range points {
+		product = f.mul(&product, &p.z)
+		products[i] = product
+	}
+
+	inverse := f.inverse(&product)
+	result := make([]affinePoint, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		// inverse is the inverse of products[i], which turns into the inverse
+		// of z for points[i] with products[i-1]
+		zInverse := inverse
+		if i > 0 {
+			zInverse = f.mul(&inverse, &products[i-1])
+		}
+		inverse = f.mul(&inverse, &points[i].z)
+
+		zInverse2 := f.mul(&zInverse, &zInverse)
+		zInverse3 := f.mul(&zInverse2, &zInverse)
+		result[i] = affinePoint{
+			x: f.mul(&points[i].x, &zInverse2),
+			y: f.mul(&points[i].y, &zInverse3),
+		}
+	}
+
+	return result
+}
+
+// Returns the odd multiples p, 3p, 5p, ... of p for a wNAF of width window
+func oddMultiples(p *affinePoint, window uint) []affinePoint {
+	count := 1 << (window - 2)
+
+	points := make([]*jacobianPoint, count)
+	points[0] = newJacobianInfinity().addAffine(p)
+	twice := points[0].double()
+	for i := 1; i < count; i++ {
+		points[i] = points[i-1].add(twice)
+	}
+
+	return toAffineBatch(points)
+}
+
+// Returns the width-w non-adjacent form of k, where digit i is the multiple
+// of 2^i. Every digit is zero or odd and between -2^(w-1) and 2^(w-1), and
+// of any w consecutive digits at most one is not zero, so the multiplication
+// only needs the odd multiples of the point and few additions.
+func wNAF(k *big.Int, window uint) []int8 {
+	length := k.BitLen() + 1
+	digits := make([]int8, length)
+
+	carry := uint(0)
+	for bit := 0; bit < length; {
+		if k.Bit(bit) == carry {
+			bit++
+			continue
+		}
+
+		width := min(int(window), length-bit)
+		word := uint(0)
+		for i := width - 1; i >= 0; i-- {
+			word = word<<1 | k.Bit(bit+i)
+		}
+		word += carry
+
+		// A word of 2^(w-1) or more is a negative digit with a carry
+		carry = (word >> (window - 1)) & 1
+		digits[bit] = int8(int(word) - int(carry<<window))
+
+		bit += width
+	}
+
+	return digits
+}
+
+// Returns the point for the digit of a wNAF from the odd multiples
+func lookup(table []affinePoint, digit int8) *affinePoint {
+	if digit > 0 {
+		return &table[(digit-1)/2]
+	}
+
+	return table[(-digit-1)/2].neg()
+}
+
+// wnafTerm is a scalar recoded as wNAF together with the odd multiples of
+// its point
+type wnafTerm struct {
+	digits []int8
+	table  []affinePoint
+}
+
+// Returns uG + vp with Shamir's trick, where all of the multiplications share
+// the doublings. Both scalars are split in halves of 128 bits with the GLV
+// endomorphism and recoded as wNAF, with the precomputed tables of G and
+// lambda G and small tables of p and lambda p. This is only for public values
+// such as in the verification of signatures.
+func doubleScalarMulBase(u *big.Int, p *S256Point, v *big.Int) (*S256Point, error) {
+	u1, u2 := splitWNAF(u, generatorWindow)
+	terms := []wnafTerm{{u1, generatorTable}, {u2, generatorLambdaTable}}
+
+	if !p.IsInfinity {
+		v1, v2 := splitWNAF(v, pointWindow)
+		pointTable := oddMultiples(newAffinePoint(p), pointWindow)
+		terms = append(terms, wnafTerm{v1, pointTable}, wnafTerm{v2, endomorphismTable(pointTable)})
+	}
+
+	length := 0
+	for _, term := range terms {
+		length = max(length, len(term.digits))
+	}
+
+	result := newJacobianInfinity()
+	for i := length - 1; i >= 0; i-- {
+		result = result.double()
+
+		for _, term := range terms {
+			if i < len(term.digits) && term.digits[i] != 0 {
+				result = result.addAffine(lookup(term.table, term.digits[i]))
+			}
+		}
+	}
+
+	return result.toAffine()
+}
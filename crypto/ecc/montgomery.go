@@ -0,0 +1,231 @@
+package ecc
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// The arithmetic in this file takes the same time for every value, so that
+// the time it takes does not leak secrets such as private keys and nonces.
+// A number is four 64 bit limbs with the least significant limb first, and
+// is kept in Montgomery form, a * 2^256 mod m, which turns the reduction of
+// a product into shifts instead of a division. There are no branches on the
+// values; a choice between two values is made with masks instead.
+
+// montgomeryInt is a number modulo a montgomeryModulus in Montgomery form
+type montgomeryInt [4]uint64
+
+// montgomeryModulus is an odd modulus between 2^255 and 2^256, such as the
+// prime of the field and the order n of secp256k1.
+type montgomeryModulus struct {
+	m montgomeryInt
+	// -m^-1 mod 2^64
+	inv uint64
+	// 2^512 mod m, which converts a number into Montgomery form
+	rr montgomeryInt
+	// 2^256 mod m, which is 1 in Montgomery form
+	one montgomeryInt
+	// m - 2, the exponent of the inverse by Fermat's little theorem
+	mMinusTwo []byte
+}
+
+// The moduli of the field and of the scalars of secp256k1
+var (
+	fieldModulus  = newMontgomeryModulus(Secp256k1.Prime)
+	scalarModulus = newMontgomeryModulus(Secp256k1.N)
+)
+
+func newMontgomeryModulus(m *big.Int) *montgomeryModulus {
+	mod := &montgomeryModulus{m: limbsFromBytes(m.FillBytes(make([]byte, 32)))}
+
+	// Newton's iteration doubles the correct bits of the inverse of m[0]
+	inv := uint64(1)
+	for i := 0; i < 6; i++ {
+		inv *= 2 - mod.m[0]*inv
+	}
+	mod.inv = -inv
+
+	r := new(big.Int).Lsh(big.NewInt(1), 256)
+	mod.one = limbsFromBytes(new(big.Int).Mod(r, m).FillBytes(make([]byte, 32)))
+	rr := new(big.Int).Mod(new(big.Int).Mul(r, r), m)
+	mod.rr = limbsFromBytes(rr.FillBytes(make([]byte, 32)))
+	mod.mMinusTwo = new(big.Int).Sub(m, big.NewInt(2)).FillBytes(make([]byte, 32))
+
+	return mod
+}
+
+// Returns the limbs of 32 bytes in big endian
+func limbsFromBytes(b []byte) montgomeryInt {
+	var a montgomeryInt
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			a[3-i] = a[3-i]<<8 | uint64(b[i*8+j])
+		}
+	}
+
+	return a
+}
+
+// Returns the 32 bytes in big endian of the limbs
+func limbsToBytes(a *montgomeryInt) []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 8; j++ {
+			b[31-i*8-j] = byte(a[i] >> (8 * j))
+		}
+	}
+
+	return b
+}
+
+// Returns b if choice is 1 and a if choice is 0
+func selectInt(a, b *montgomeryInt, choice uint64) montgomeryInt {
+	mask := -choice
+	return montgomeryInt{
+		a[0] ^ (mask & (a[0] ^ b[0])),
+		a[1] ^ (mask & (a[1] ^ b[1])),
+		a[2] ^ (mask & (a[2] ^ b[2])),
+		a[3] ^ (mask & (a[3] ^ b[3])),
+	}
+}
+
+// Returns 1 if a is zero and 0 otherwise
+func isZeroInt(a *montgomeryInt) uint64 {
+	z := a[0] | a[1] | a[2] | a[3]
+	return 1 ^ ((z | -z) >> 63)
+}
+
+// Returns a mod m for a + carry*2^256 < 2m
+func (mod *montgomeryModulus) reduce(a *montgomeryInt, carry uint64) montgomeryInt {
+	var d montgomeryInt
+	var borrow uint64
+	d[0], borrow = bits.Sub64(a[0], mod.m[0], 0)
+	d[1], borrow = bits.Sub64(a[1], mod.m[1], borrow)
+	d[2], borrow = bits.Sub64(a[2], mod.m[2], borrow)
+	d[3], borrow = bits.Sub64(a[3], mod.m[3], borrow)
+	// a is less than m when the subtraction borrows more than the carry
+	_, borrow = bits.Sub64(carry, 0, borrow)
+
+	return selectInt(&d, a, borrow)
+}
+
+// Returns a + b mod m
+func (mod *montgomeryModulus) add(a, b *montgomeryInt) montgomeryInt {
+	var sum montgomeryInt
+	var carry uint64
+	sum[0], carry = bits.Add64(a[0], b[0], 0)
+	sum[1], carry = bits.Add64(a[1], b[1], carry)
+	sum[2], carry = bits.Add64(a[2], b[2], carry)
+	sum[3], carry = bits.Add64(a[3], b[3], carry)
+
+	return mod.reduce(&sum, carry)
+}
+
+// Returns a - b mod m
+func (mod *montgomeryModulus) sub(a, b *montgomeryInt) montgomeryInt {
+	var d montgomeryInt
+	var borrow uint64
+	d[0], borrow = bits.Sub64(a[0], b[0], 0)
+	d[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	d[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	d[3], borrow = bits.Sub64(a[3], b[3], borrow)
+
+	// Add m back when the subtraction went below zero
+	mask := -borrow
+	var carry uint64
+	d[0], carry = bits.Add64(d[0], mod.m[0]&mask, 0)
+	d[1], carry = bits.Add64(d[1], mod.m[1]&mask, carry)
+	d[2], carry = bits.Add64(d[2], mod.m[2]&mask, carry)
+	d[3], _ = bits.Add64(d[3], mod.m[3]&mask, carry)
+
+	return d
+}
+
+// Returns -a mod m
+func (mod *montgomeryModulus) neg(a *montgomeryInt) montgomeryInt {
+	return mod.sub(&montgomeryInt{}, a)
+}
+
+// Returns a * b * 2^-256 mod m, which is the product of two numbers in
+// Montgomery form. This is the coarsely integrated operand scanning method.
+func (mod *montgomeryModulus) mul(a, b *montgomeryInt) montgomeryInt {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		// t += a * b[i]
+		var c, carry uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j], c = lo, hi
+		}
+		t[4], carry = bits.Add64(t[4], c, 0)
+		t[5] = carry
+
+		// t = (t + q * m) / 2^64, where q makes the lowest limb zero
+		q := t[0] * mod.inv
+		hi, lo := bits.Mul64(q, mod.m[0])
+		_, carry = bits.Add64(lo, t[0], 0)
+		c = hi + carry
+		for j := 1; j < 4; j++ {
+			hi, lo = bits.Mul64(q, mod.m[j])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j-1], c = lo, hi
+		}
+		t[3], carry = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + carry
+	}
+
+	result := montgomeryInt{t[0], t[1], t[2], t[3]}
+	return mod.reduce(&result, t[4])
+}
+
+// Returns a^e mod m for an exponent of 32 bytes in big endian. The exponent
+// is public, so only the values of a are protected.
+func (mod *montgomeryModulus) exp(a *montgomeryInt, e []byte) montgomeryInt {
+	result := mod.one
+	for _, b := range e {
+		for i := 7; i >= 0; i-- {
+			result = mod.mul(&result, &result)
+			if (b>>i)&1 == 1 {
+				result = mod.mul(&result, a)
+			}
+		}
+	}
+
+	return result
+}
+
+// Returns a^-1 mod m, which is zero for zero
+func (mod *montgomeryModulus) inverse(a *montgomeryInt) montgomeryInt {
+	return mod.exp(a, mod.mMinusTwo)
+}
+
+// Returns the Montgomery form of 32 bytes in big endian, reduced modulo m
+func (mod *montgomeryModulus) fromBytes(b []byte) montgomeryInt {
+	a := limbsFromBytes(b)
+	a = mod.reduce(&a, 0)
+
+	return mod.mul(&a, &mod.rr)
+}
+
+// Returns the Montgomery form of x, which must be in [0, 2^256)
+func (mod *montgomeryModulus) fromBig(x *big.Int) montgomeryInt {
+	return mod.fromBytes(x.FillBytes(make([]byte, 32)))
+}
+
+// Returns the 32 bytes in big endian of a number in Montgomery form
+func (mod *montgomeryModulus) toBytes(a *montgomeryInt) []byte {
+	plain := mod.mul(a, &montgomeryInt{1})
+	return limbsToBytes(&plain)
+}
+
+// Returns the number of a in Montgomery form
+func (mod *montgomeryModulus) toBig(a *montgomeryInt) *big.Int {
+	return new(big.Int).SetBytes(mod.toBytes(a))
+}
@@ -5,9 +5,12 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/big"
+	"strings"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
 )
 
@@ -18,7 +21,11 @@ type PrivateKey struct {
 }
 
 func NewPrivateKey(secret *big.Int) (*PrivateKey, error) {
-	point, err := G.ScalarMul(secret)
+	if !isScalar(secret) {
+		return nil, fmt.Errorf("secret is not in the range [1, n-1]")
+	}
+
+	point, err := scalarBaseMul(secret)
 	if err != nil {
 		return nil, err
 	}
@@ -30,27 +37,74 @@ func (pk *PrivateKey) Hex() string {
 	return fmt.Sprintf("%064x", pk.secret)
 }
 
+// Returns the secret as 32 bytes in big endian
+func (pk *PrivateKey) Bytes() []byte {
+	return pk.secret.FillBytes(make([]byte, 32))
+}
+
+// ParsePrivateKeyHex parses a secret of 32 bytes in hexadecimal, as returned
+// by Hex. A leading 0x is allowed.
+func ParsePrivateKeyHex(s string) (*PrivateKey, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	if len(s) != 64 {
+		return nil, fmt.Errorf("secret must be 64 hexadecimal characters, got %d", len(s))
+	}
+
+	secret, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hexadecimal secret: %w", err)
+	}
+
+	return NewPrivateKey(new(big.Int).SetBytes(secret))
+}
+
 func (pk *PrivateKey) Sign(z *big.Int) (*Signature, error) {
+	signature, _, err := pk.sign(z)
+
+	return signature, err
+}
+
+// Returns the signature of z with a low s and its recovery id, see
+// CompactSignature
+func (pk *PrivateKey) sign(z *big.Int) (*Signature, byte, error) {
 	// k, err := rand.Int(rand.Reader, Secp256k1.N)
 	k := deterministicK(z, pk.secret)
 	// if err != nil {
 	// 	return nil, err
 	// }
 
-	kG, err := G.ScalarMul(k)
+	kG, err := scalarBaseMul(k)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	r := kG.XNum()
+	r := new(big.Int).Mod(kG.XNum(), Secp256k1.N)
 
-	kInverse := new(big.Int).Exp(k, new(big.Int).Sub(Secp256k1.N, big.NewInt(2)), Secp256k1.N)
-	s := new(big.Int).Mod(new(big.Int).Mul(new(big.Int).Add(z, new(big.Int).Mul(pk.secret, r)), kInverse), Secp256k1.N)
+	recoveryID := byte(kG.y.number.Bit(0))
+	if r.Cmp(kG.XNum()) != 0 {
+		recoveryID |= 0x02
+	}
+
+	// s = (z + rd) / k, where the secret d and the nonce k are only used in
+	// the constant-time scalar arithmetic
+	n := scalarModulus
+	zScalar := n.fromBig(new(big.Int).Mod(z, Secp256k1.N))
+	rScalar := n.fromBig(r)
+	dScalar := n.fromBig(pk.secret)
+	kScalar := n.fromBig(k)
+	kInverse := n.inverse(&kScalar)
+	sum := n.mul(&rScalar, &dScalar)
+	sum = n.add(&zScalar, &sum)
+	product := n.mul(&sum, &kInverse)
+	s := n.toBig(&product)
 
+	// The signature with n - s is for -kG, whose y coordinate has the other
+	// parity
 	if s.Cmp(new(big.Int).Div(Secp256k1.N, big.NewInt(2))) > 0 {
 		s = new(big.Int).Sub(Secp256k1.N, s)
+		recoveryID ^= 0x01
 	}
 
-	return NewSignature(r, s), nil
+	return NewSignature(r, s), recoveryID, nil
 }
 
 func deterministicK(z, secret *big.Int) *big.Int {
@@ -106,23 +160,98 @@ func (pk *PrivateKey) SECCompressed() []byte {
 	return pk.point.SECCompressed()
 }
 
-func (pk *PrivateKey) Address(isCompressed, isTestnet bool) string {
-	return pk.point.Address(isCompressed, isTestnet)
+// Returns the point of the public key
+func (pk *PrivateKey) PublicKey() *S256Point {
+	return pk.point
 }
 
-// WIF (Wallet Import Format) is a way to encode the private key to make it easier to copy
-func (pk *PrivateKey) WIF(isCompressed, isTestnet bool) string {
-	s := pk.secret.FillBytes(make([]byte, 32))
+func (pk *PrivateKey) Address(isCompressed bool, params *chaincfg.Params) string {
+	return pk.point.Address(isCompressed, params)
+}
+
+// TweakAdd returns the private key of the tweaked public key of
+// S256Point.TweakAdd.
+func (pk *PrivateKey) TweakAdd(tweak []byte) (*PrivateKey, error) {
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(Secp256k1.N) >= 0 {
+		return nil, fmt.Errorf("invalid tweak")
+	}
 
-	if isTestnet {
-		s = append([]byte{0xef}, s...)
-	} else {
-		s = append([]byte{0x80}, s...)
+	n := scalarModulus
+	d := n.fromBig(pk.secret)
+	tScalar := n.fromBig(t)
+	sum := n.add(&d, &tScalar)
+	if isZeroInt(&sum) == 1 {
+		return nil, fmt.Errorf("tweaked private key is zero")
 	}
 
+	return NewPrivateKey(n.toBig(&sum))
+}
+
+// ECDH returns the shared secret of the private key and the public key of
+// another party, which is the x coordinate of the product of the private key
+// and the public key in 32 bytes. Both parties get the same secret, since
+// a(bG) = b(aG).
+func (pk *PrivateKey) ECDH(publicKey *S256Point) ([]byte, error) {
+	if publicKey.IsInfinity {
+		return nil, fmt.Errorf("public key is the point at infinity")
+	}
+
+	shared, err := newProjectivePoint(publicKey).scalarMulGLV(pk.Bytes()).toAffine()
+	if err != nil {
+		return nil, err
+	}
+
+	return shared.XNum().FillBytes(make([]byte, 32)), nil
+}
+
+// WIF (Wallet Import Format) is a way to encode the private key to make it easier to copy
+func (pk *PrivateKey) WIF(isCompressed bool, params *chaincfg.Params) string {
+	s := append([]byte{params.PrivateKeyID}, pk.Bytes()...)
+
 	if isCompressed {
 		s = append(s, 0x01)
 	}
 
 	return base58.Checksum(s)
 }
+
+// ParseWIF parses a private key in Wallet Import Format, and returns the key,
+// whether its public key is compressed and the network of the key. All of the
+// test networks share a version byte, so their keys are returned with the
+// parameters of testnet3.
+func ParseWIF(wif string) (*PrivateKey, bool, *chaincfg.Params, error) {
+	version, payload, err := base58.DecodeCheck(strings.TrimSpace(wif))
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	var params *chaincfg.Params
+	for _, network := range chaincfg.Networks {
+		if network.PrivateKeyID == version {
+			params = network
+			break
+		}
+	}
+	if params == nil {
+		return nil, false, nil, fmt.Errorf("unknown private key version %#02x", version)
+	}
+
+	isCompressed := false
+	switch {
+	case len(payload) == 33 && payload[32] == 0x01:
+		isCompressed = true
+		payload = payload[:32]
+	case len(payload) == 33:
+		return nil, false, nil, fmt.Errorf("invalid compression flag %#02x", payload[32])
+	case len(payload) != 32:
+		return nil, false, nil, fmt.Errorf("invalid private key length %d", len(payload))
+	}
+
+	privateKey, err := NewPrivateKey(new(big.Int).SetBytes(payload))
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	return privateKey, isCompressed, params, nil
+}
@@ -1,13 +1,20 @@
 package ecc_test
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"math/big"
+	mathrand "math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/endian"
 )
 
@@ -124,25 +131,25 @@ func TestPrivateKey(t *testing.T) {
 		tests := []struct {
 			secret       *big.Int
 			isCompressed bool
-			isTestnet    bool
+			params       *chaincfg.Params
 			address      string
 		}{
 			{
 				secret:       big.NewInt(5002),
 				isCompressed: false,
-				isTestnet:    true,
+				params:       &chaincfg.TestNet3Params,
 				address:      "mmTPbXQFxboEtNRkwfh6K51jvdtHLxGeMA",
 			},
 			{
 				secret:       new(big.Int).Exp(big.NewInt(2020), big.NewInt(5), nil),
 				isCompressed: true,
-				isTestnet:    true,
+				params:       &chaincfg.TestNet3Params,
 				address:      "mopVkxp8UhXqRYbCYJsbeE1h1fiF64jcoH",
 			},
 			{
 				secret:       big.NewInt(0x12345deadbeef),
 				isCompressed: true,
-				isTestnet:    false,
+				params:       &chaincfg.MainNetParams,
 				address:      "1F1Pn2y6pDb68E5nYJJeba4TLg2U7B6KF1",
 			},
 		}
@@ -153,7 +160,7 @@ func TestPrivateKey(t *testing.T) {
 				t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
 			}
 
-			address := privateKey.Address(test.isCompressed, test.isTestnet)
+			address := privateKey.Address(test.isCompressed, test.params)
 			if address != test.address {
 				t.Errorf("Address: got %v, expected %v", address, test.address)
 			}
@@ -164,25 +171,25 @@ func TestPrivateKey(t *testing.T) {
 		tests := []struct {
 			secret       *big.Int
 			isCompressed bool
-			isTestnet    bool
+			params       *chaincfg.Params
 			wif          string
 		}{
 			{
 				secret:       big.NewInt(5003),
 				isCompressed: true,
-				isTestnet:    true,
+				params:       &chaincfg.TestNet3Params,
 				wif:          "cMahea7zqjxrtgAbB7LSGbcQUr1uX1ojuat9jZodMN8rFTv2sfUK",
 			},
 			{
 				secret:       new(big.Int).Exp(big.NewInt(2021), big.NewInt(5), nil),
 				isCompressed: false,
-				isTestnet:    true,
+				params:       &chaincfg.TestNet3Params,
 				wif:          "91avARGdfge8E4tZfYLoxeJ5sGBdNJQH4kvjpWAxgzczjbCwxic",
 			},
 			{
 				secret:       big.NewInt(0x54321deadbeef),
 				isCompressed: true,
-				isTestnet:    false,
+				params:       &chaincfg.MainNetParams,
 				wif:          "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgiuQJv1h8Ytr2S53a",
 			},
 		}
@@ -193,7 +200,7 @@ func TestPrivateKey(t *testing.T) {
 				t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
 			}
 
-			wif := privateKey.WIF(test.isCompressed, test.isTestnet)
+			wif := privateKey.WIF(test.isCompressed, test.params)
 			if wif != test.wif {
 				t.Errorf("WIF: got %v, expected %v", wif, test.wif)
 			}
@@ -209,9 +216,322 @@ func TestCreateTestnetAddress(t *testing.T) {
 		t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
 	}
 
-	address := privateKey.Address(true, true)
+	address := privateKey.Address(true, &chaincfg.TestNet3Params)
 	expected := "mkoxbQEyJWRkUoAZVeF79gdMvwqwpCR7mE"
 	if address != expected {
 		t.Errorf("Address: got %v, expected %v", address, expected)
 	}
 }
+
+func TestNewPrivateKeyOutOfRange(t *testing.T) {
+	secrets := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(-1),
+		ecc.Secp256k1.N,
+		new(big.Int).Add(ecc.Secp256k1.N, big.NewInt(1)),
+	}
+
+	for _, secret := range secrets {
+		if _, err := ecc.NewPrivateKey(secret); err == nil {
+			t.Errorf("NewPrivateKey(%x): expected an error", secret)
+		}
+	}
+}
+
+func TestParsePrivateKeyHex(t *testing.T) {
+	tests := []string{
+		"0000000000000000000000000000000000000000000000000000000000003039",
+		"0x0000000000000000000000000000000000000000000000000000000000003039",
+		" 0000000000000000000000000000000000000000000000000000000000003039\n",
+	}
+
+	for _, test := range tests {
+		privateKey, err := ecc.ParsePrivateKeyHex(test)
+		if err != nil {
+			t.Fatalf("ParsePrivateKeyHex(%q): got error %v, expected nil", test, err)
+		}
+
+		if privateKey.Hex() != "0000000000000000000000000000000000000000000000000000000000003039" {
+			t.Errorf("ParsePrivateKeyHex(%q): got %v", test, privateKey.Hex())
+		}
+	}
+
+	invalid := []string{
+		"3039",
+		"000000000000000000000000000000000000000000000000000000000000303g",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141",
+	}
+
+	for _, test := range invalid {
+		if _, err := ecc.ParsePrivateKeyHex(test); err == nil {
+			t.Errorf("ParsePrivateKeyHex(%q): expected an error", test)
+		}
+	}
+}
+
+func TestParseWIF(t *testing.T) {
+	tests := []struct {
+		wif          string
+		secret       string
+		isCompressed bool
+		params       *chaincfg.Params
+	}{
+		{
+			wif:          "5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ",
+			secret:       "0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d",
+			isCompressed: false,
+			params:       &chaincfg.MainNetParams,
+		},
+		{
+			wif:          "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgiuQJv1h8Ytr2S53a",
+			secret:       "00000000000000000000000000000000000000000000000000054321deadbeef",
+			isCompressed: true,
+			params:       &chaincfg.MainNetParams,
+		},
+		{
+			wif:          "cMahea7zqjxrtgAbB7LSGbcQUr1uX1ojuat9jZodMN8rFTv2sfUK",
+			secret:       "000000000000000000000000000000000000000000000000000000000000138b",
+			isCompressed: true,
+			params:       &chaincfg.TestNet3Params,
+		},
+		{
+			wif:          "91avARGdfge8E4tZfYLoxeJ5sGBdNJQH4kvjpWAxgzczjbCwxic",
+			secret:       fmt.Sprintf("%064x", new(big.Int).Exp(big.NewInt(2021), big.NewInt(5), nil)),
+			isCompressed: false,
+			params:       &chaincfg.TestNet3Params,
+		},
+	}
+
+	for _, test := range tests {
+		privateKey, isCompressed, params, err := ecc.ParseWIF(test.wif)
+		if err != nil {
+			t.Fatalf("ParseWIF(%s): got error %v, expected nil", test.wif, err)
+		}
+
+		if privateKey.Hex() != test.secret {
+			t.Errorf("ParseWIF(%s): got secret %v, expected %v", test.wif, privateKey.Hex(), test.secret)
+		}
+		if isCompressed != test.isCompressed {
+			t.Errorf("ParseWIF(%s): got compressed %v, expected %v", test.wif, isCompressed, test.isCompressed)
+		}
+		if params != test.params {
+			t.Errorf("ParseWIF(%s): got network %s, expected %s", test.wif, params.Name, test.params.Name)
+		}
+
+		wif := privateKey.WIF(isCompressed, params)
+		if wif != test.wif {
+			t.Errorf("WIF: got %v, expected %v", wif, test.wif)
+		}
+	}
+}
+
+func TestParseWIFInvalid(t *testing.T) {
+	secret := make([]byte, 32)
+	secret[31] = 0x01
+	order := ecc.Secp256k1.N.FillBytes(make([]byte, 32))
+
+	tests := map[string]string{
+		"invalid checksum":      "5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTK",
+		"unknown version":       base58.Checksum(append([]byte{0x00}, secret...)),
+		"invalid compression":   base58.Checksum(append(append([]byte{0x80}, secret...), 0x02)),
+		"too short":             base58.Checksum(append([]byte{0x80}, secret[1:]...)),
+		"too long":              base58.Checksum(append(append([]byte{0x80}, secret...), 0x01, 0x01)),
+		"secret is zero":        base58.Checksum(append([]byte{0x80}, make([]byte, 32)...)),
+		"secret is the order n": base58.Checksum(append([]byte{0x80}, order...)),
+	}
+
+	for name, wif := range tests {
+		if _, _, _, err := ecc.ParseWIF(wif); err == nil {
+			t.Errorf("ParseWIF: expected an error for %s", name)
+		}
+	}
+}
+
+func TestPublicKeyMatchesTeachingScalarMul(t *testing.T) {
+	secrets := []*big.Int{
+		big.NewInt(1),
+		big.NewInt(2),
+		big.NewInt(15),
+		big.NewInt(16),
+		new(big.Int).Lsh(big.NewInt(1), 255),
+		new(big.Int).Sub(ecc.Secp256k1.N, big.NewInt(1)),
+	}
+	for i := 0; i < 8; i++ {
+		secret, err := rand.Int(rand.Reader, ecc.Secp256k1.N)
+		if err != nil {
+			t.Fatalf("rand.Int: got error %v, expected nil", err)
+		}
+		if secret.Sign() > 0 {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	for _, secret := range secrets {
+		privateKey, err := ecc.NewPrivateKey(secret)
+		if err != nil {
+			t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
+		}
+
+		expected, err := ecc.G.ScalarMul(secret)
+		if err != nil {
+			t.Fatalf("ScalarMul: got error %v, expected nil", err)
+		}
+
+		if privateKey.PublicKey().String() != expected.String() {
+			t.Errorf("PublicKey(%x): got %v, expected %v", secret, privateKey.PublicKey(), expected)
+		}
+	}
+}
+
+func TestSignDeterministic(t *testing.T) {
+	// The signature of RFC 6979 with the private key 1 and the SHA-256 of
+	// "Satoshi Nakamoto", with a low s
+	privateKey, err := ecc.NewPrivateKey(big.NewInt(1))
+	if err != nil {
+		t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
+	}
+	z := new(big.Int).SetBytes(hash.HashSHA256([]byte("Satoshi Nakamoto")))
+
+	signature, err := privateKey.Sign(z)
+	if err != nil {
+		t.Fatalf("Sign: got error %v, expected nil", err)
+	}
+
+	expectedR := "934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8"
+	expectedS := "2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"
+	if fmt.Sprintf("%064x", signature.R) != expectedR {
+		t.Errorf("Sign: got r %064x, expected %s", signature.R, expectedR)
+	}
+	if fmt.Sprintf("%064x", signature.S) != expectedS {
+		t.Errorf("Sign: got s %064x, expected %s", signature.S, expectedS)
+	}
+}
+
+func TestECDH(t *testing.T) {
+	alice, _ := ecc.NewPrivateKey(big.NewInt(12345))
+	bob, _ := ecc.NewPrivateKey(big.NewInt(67890))
+
+	aliceSecret, err := alice.ECDH(bob.PublicKey())
+	if err != nil {
+		t.Fatalf("ECDH: got error %v, expected nil", err)
+	}
+	bobSecret, err := bob.ECDH(alice.PublicKey())
+	if err != nil {
+		t.Fatalf("ECDH: got error %v, expected nil", err)
+	}
+
+	if !bytes.Equal(aliceSecret, bobSecret) {
+		t.Errorf("ECDH: got %x and %x, expected the same secret", aliceSecret, bobSecret)
+	}
+
+	// The product 12345 * 67890 of the private keys times G
+	expected, _ := ecc.G.ScalarMul(big.NewInt(12345 * 67890))
+	if hex.EncodeToString(aliceSecret) != fmt.Sprintf("%064x", expected.XNum()) {
+		t.Errorf("ECDH: got %x, expected %064x", aliceSecret, expected.XNum())
+	}
+
+	if _, err := alice.ECDH(&ecc.S256Point{Point: *ecc.NewInfinityPoint()}); err == nil {
+		t.Errorf("ECDH: expected an error for the point at infinity")
+	}
+}
+
+func TestGLVProperties(t *testing.T) {
+	// The scalars next to the values of the decomposition, where k1 or k2 is
+	// zero or at the bounds of 128 bits, and random scalars
+	lambda, _ := new(big.Int).SetString("5363ad4cc05c30e0a5261c028812645a122e22ea20816678df02967c1b23bd72", 16)
+	edgeScalars := []*big.Int{
+		big.NewInt(1),
+		lambda,
+		new(big.Int).Sub(lambda, big.NewInt(1)),
+		new(big.Int).Lsh(big.NewInt(1), 128),
+		new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1)),
+		new(big.Int).Rsh(ecc.Secp256k1.N, 1),
+		new(big.Int).Sub(ecc.Secp256k1.N, big.NewInt(1)),
+	}
+
+	generateScalars := func(length int) func(output []reflect.Value, rnd *mathrand.Rand) {
+		return func(output []reflect.Value, rnd *mathrand.Rand) {
+			for i := 0; i < length; i++ {
+				var scalar *big.Int
+				if rnd.Intn(4) == 0 {
+					scalar = edgeScalars[rnd.Intn(len(edgeScalars))]
+				} else {
+					scalar = new(big.Int).Rand(rnd, new(big.Int).Sub(ecc.Secp256k1.N, big.NewInt(1)))
+					scalar.Add(scalar, big.NewInt(1))
+				}
+				output[i] = reflect.ValueOf(scalar)
+			}
+		}
+	}
+
+	t.Run("ECDH matches Point.ScalarMul", func(t *testing.T) {
+		f := func(a *big.Int, b *big.Int) bool {
+			privateKey, _ := ecc.NewPrivateKey(a)
+			publicKey, _ := ecc.G.Point.ScalarMul(b)
+
+			secret, err := privateKey.ECDH(&ecc.S256Point{Point: *publicKey})
+			if err != nil {
+				return false
+			}
+
+			expected, _ := publicKey.ScalarMul(a)
+			return expected.XNum().Cmp(new(big.Int).SetBytes(secret)) == 0
+		}
+		config := quick.Config{MaxCount: 20, Values: generateScalars(2)}
+		if err := quick.Check(f, &config); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Verify matches Point.ScalarMul", func(t *testing.T) {
+		f := func(d *big.Int, k *big.Int, z *big.Int) bool {
+			// The signature is made with the generic arithmetic
+			publicKey, _ := ecc.G.Point.ScalarMul(d)
+			kG, _ := ecc.G.Point.ScalarMul(k)
+			r := new(big.Int).Mod(kG.XNum(), ecc.Secp256k1.N)
+			s := new(big.Int).Mul(r, d)
+			s.Add(s, z)
+			s.Mul(s, new(big.Int).ModInverse(k, ecc.Secp256k1.N))
+			s.Mod(s, ecc.Secp256k1.N)
+			if r.Sign() == 0 || s.Sign() == 0 {
+				return true
+			}
+
+			point := &ecc.S256Point{Point: *publicKey}
+			valid, err := point.Verify(z, ecc.NewSignature(r, s))
+			if err != nil || !valid {
+				return false
+			}
+
+			invalid, err := point.Verify(new(big.Int).Add(z, big.NewInt(1)), ecc.NewSignature(r, s))
+			return err == nil && !invalid
+		}
+		config := quick.Config{MaxCount: 20, Values: generateScalars(3)}
+		if err := quick.Check(f, &config); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func BenchmarkECDH(b *testing.B) {
+	privateKey, _ := ecc.NewPrivateKey(new(big.Int).SetBytes(hash.Hash256([]byte("my secret"))))
+	publicKey, _ := ecc.G.ScalarMul(big.NewInt(67890))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		privateKey.ECDH(publicKey)
+	}
+}
+
+// The shared secret with the affine arithmetic of Point, to compare with
+// BenchmarkECDH
+func BenchmarkECDHAffine(b *testing.B) {
+	secret := new(big.Int).SetBytes(hash.Hash256([]byte("my secret")))
+	publicKey, _ := ecc.G.ScalarMul(big.NewInt(67890))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		publicKey.ScalarMul(secret)
+	}
+}
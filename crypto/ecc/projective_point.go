@@ -0,0 +1,190 @@
+package ecc
+
+import "math/big"
+
+// projectivePoint is a point of secp256k1 in homogeneous projective
+// coordinates, where (X:Y:Z) is the point (X/Z, Y/Z) and (0:1:0) is the point
+// at infinity. The coordinates are in Montgomery form of the field.
+//
+// The addition is the complete formula for curves with a = 0 of Renes,
+// Costello and Batina, "Complete addition formulas for prime order elliptic
+// curves", which has no special cases for doubling or for the point at
+// infinity. Together with the constant-time field arithmetic it takes the
+// same time for every point, unlike Point.Add.
+type projectivePoint struct {
+	x, y, z montgomeryInt
+}
+
+// 3b in Montgomery form, where b = 7 is the constant of the curve
+var curveB3 = fieldModulus.fromBig(big.NewInt(21))
+
+// The generator in projective coordinates, which is set together with G
+var projectiveG *projectivePoint
+
+func newProjectiveInfinity() *projectivePoint {
+	return &projectivePoint{y: fieldModulus.one}
+}
+
+func newProjectivePoint(p *S256Point) *projectivePoint {
+	if p.IsInfinity {
+		return newProjectiveInfinity()
+	}
+
+	return &projectivePoint{
+		x: fieldModulus.fromBig(p.x.number),
+		y: fieldModulus.fromBig(p.y.number),
+		z: fieldModulus.one,
+	}
+}
+
+// Returns p + q, see algorithm 7 of Renes, Costello and Batina
+func (p *projectivePoint) add(q *projectivePoint) *projectivePoint {
+	f := fieldModulus
+
+	t0 := f.mul(&p.x, &q.x)
+	t1 := f.mul(&p.y, &q.y)
+	t2 := f.mul(&p.z, &q.z)
+	t3 := f.add(&p.x, &p.y)
+	t4 := f.add(&q.x, &q.y)
+	t3 = f.mul(&t3, &t4)
+	t4 = f.add(&t0, &t1)
+	t3 = f.sub(&t3, &t4)
+	t4 = f.add(&p.y, &p.z)
+	x3 := f.add(&q.y, &q.z)
+	t4 = f.mul(&t4, &x3)
+	x3 = f.add(&t1, &t2)
+	t4 = f.sub(&t4, &x3)
+	x3 = f.add(&p.x, &p.z)
+	y3 := f.add(&q.x, &q.z)
+	x3 = f.mul(&x3, &y3)
+	y3 = f.add(&t0, &t2)
+	y3 = f.sub(&x3, &y3)
+	x3 = f.add(&t0, &t0)
+	t0 = f.add(&x3, &t0)
+	t2 = f.mul(&curveB3, &t2)
+	z3 := f.add(&t1, &t2)
+	t1 = f.sub(&t1, &t2)
+	y3 = f.mul(&curveB3, &y3)
+	x3 = f.mul(&t4, &y3)
+	t2 = f.mul(&t3, &t1)
+	x3 = f.sub(&t2, &x3)
+	y3 = f.mul(&y3, &t0)
+	t1 = f.mul(&t1, &z3)
+	y3 = f.add(&t1, &y3)
+	t0 = f.mul(&t0, &t3)
+	z3 = f.mul(&z3, &t4)
+	z3 = f.add(&z3, &t0)
+
+	return &projectivePoint{x3, y3, z3}
+}
+
+// Returns 2p, see algorithm 9 of Renes, Costello and Batina
+func (p *projectivePoint) double() *projectivePoint {
+	f := fieldModulus
+
+	t0 := f.mul(&p.y, &p.y)
+	z3 := f.add(&t0, &t0)
+	z3 = f.add(&z3, &z3)
+	z3 = f.add(&z3, &z3)
+	t1 := f.mul(&p.y, &p.z)
+	t2 := f.mul(&p.z, &p.z)
+	t2 = f.mul(&curveB3, &t2)
+	x3 := f.mul(&t2, &z3)
+	y3 := f.add(&t0, &t2)
+	z3 = f.mul(&t1, &z3)
+	t1 = f.add(&t2, &t2)
+	t2 = f.add(&t1, &t2)
+	t0 = f.sub(&t0, &t2)
+	y3 = f.mul(&t0, &y3)
+	y3 = f.add(&x3, &y3)
+	t1 = f.mul(&p.x, &p.y)
+	x3 = f.mul(&t0, &t1)
+	x3 = f.add(&x3, &x3)
+
+	return &projectivePoint{x3, y3, z3}
+}
+
+// Returns q if choice is 1 and p if choice is 0
+func selectPoint(p, q *projectivePoint, choice uint64) *projectivePoint {
+	return &projectivePoint{
+		x: selectInt(&p.x, &q.x, choice),
+		y: selectInt(&p.y, &q.y, choice),
+		z: selectInt(&p.z, &q.z, choice),
+	}
+}
+
+// Returns the point in affine coordinates
+func (p *projectivePoint) toAffine() (*S256Point, error) {
+	if isZeroInt(&p.z) == 1 {
+		return &S256Point{*NewInfinityPoint()}, nil
+	}
+
+	f := fieldModulus
+	zInverse := f.inverse(&p.z)
+	x := f.mul(&p.x, &zInverse)
+	y := f.mul(&p.y, &zInverse)
+
+	xField, err := NewS256Field(f.toBig(&x))
+	if err != nil {
+		return nil, err
+	}
+	yField, err := NewS256Field(f.toBig(&y))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS256Point(xField, yField)
+}
+
+// Returns kp for a scalar k of 32 bytes in big endian, in the same time for
+// every k. The scalar is processed four bits at a time, from a table of the
+// multiples 0p to 15p that is read in full for every window.
+func (p *projectivePoint) scalarMul(k []byte) *projectivePoint {
+	table := multiplesTable(p)
+
+	result := newProjectiveInfinity()
+	for _, b := range k {
+		for _, window := range []byte{b >> 4, b & 0x0f} {
+			result = result.double().double().double().double()
+			result = result.add(selectMultiple(table, window))
+		}
+	}
+
+	return result
+}
+
+// Returns the multiples 0p to 15p of p
+func multiplesTable(p *projectivePoint) *[16]*projectivePoint {
+	var table [16]*projectivePoint
+	table[0] = newProjectiveInfinity()
+	table[1] = p
+	for i := 2; i < 16; i++ {
+		table[i] = table[i-1].add(p)
+	}
+
+	return &table
+}
+
+// Returns the multiple of a window of four bits from the table, which is
+// read in full for every window
+func selectMultiple(table *[16]*projectivePoint, window byte) *projectivePoint {
+	selected := newProjectiveInfinity()
+	for i := 1; i < 16; i++ {
+		selected = selectPoint(selected, table[i], equalByte(byte(i), window))
+	}
+
+	return selected
+}
+
+// Returns 1 if a equals b and 0 otherwise
+func equalByte(a, b byte) uint64 {
+	x := uint64(a ^ b)
+	return 1 ^ ((x | -x) >> 63)
+}
+
+// Returns kG for a secret scalar k, in the same time for every k. This is
+// how the public keys and the nonce points of signatures are computed. The
+// callers only pass scalars in [1, n-1], so k is not reduced.
+func scalarBaseMul(k *big.Int) (*S256Point, error) {
+	return projectiveG.scalarMul(k.FillBytes(make([]byte, 32))).toAffine()
+}
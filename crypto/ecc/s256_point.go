@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
 	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
 	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
 )
@@ -28,6 +29,9 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	projectiveG = newProjectivePoint(G)
+	generatorTable = oddMultiples(newAffinePoint(G), generatorWindow)
+	generatorLambdaTable = endomorphismTable(generatorTable)
 }
 
 func NewS256Point(x, y *S256Field) (*S256Point, error) {
@@ -77,25 +81,32 @@ func (p *S256Point) ScalarMul(coefficient *big.Int) (*S256Point, error) {
 }
 
 func (p *S256Point) Verify(z *big.Int, sig *Signature) (bool, error) {
+	// r and s must be in the range [1, n-1]
+	if !isScalar(sig.R) || !isScalar(sig.S) {
+		return false, nil
+	}
+
 	s_inv := new(big.Int).Exp(sig.S, new(big.Int).Sub(Secp256k1.N, big.NewInt(2)), Secp256k1.N)
 	u := new(big.Int).Mod(new(big.Int).Mul(z, s_inv), Secp256k1.N)
 	v := new(big.Int).Mod(new(big.Int).Mul(sig.R, s_inv), Secp256k1.N)
 
-	ug, err := G.ScalarMul(u)
-	if err != nil {
-		return false, err
-	}
-	v_point, err := p.ScalarMul(v)
+	total, err := doubleScalarMulBase(u, p, v)
 	if err != nil {
 		return false, err
 	}
 
-	total, err := ug.Add(&v_point.Point)
-	if err != nil {
-		return false, err
+	if total.IsInfinity {
+		return false, nil
 	}
 
-	return total.XNum().Cmp(sig.R) == 0, nil
+	x := new(big.Int).Mod(total.XNum(), Secp256k1.N)
+
+	return x.Cmp(sig.R) == 0, nil
+}
+
+// Returns whether k is in the range [1, n-1].
+func isScalar(k *big.Int) bool {
+	return k.Sign() > 0 && k.Cmp(Secp256k1.N) < 0
 }
 
 // Returns the binary representation of the SEC (Standards for Efficient Cryptography) format
@@ -104,7 +115,7 @@ func (p *S256Point) SEC() []byte {
 		return []byte{0x00}
 	}
 
-	return append([]byte{0x04}, append(p.x.number.Bytes(), p.y.number.Bytes()...)...)
+	return append([]byte{0x04}, append(p.x.number.FillBytes(make([]byte, 32)), p.y.number.FillBytes(make([]byte, 32))...)...)
 }
 
 // Returns the binary representation of the compressed SEC (Standards for Efficient Cryptography) format
@@ -114,17 +125,17 @@ func (p *S256Point) SECCompressed() []byte {
 	}
 
 	if p.y.number.Bit(0) == 0 {
-		return append([]byte{0x02}, p.x.number.Bytes()...)
+		return append([]byte{0x02}, p.x.number.FillBytes(make([]byte, 32))...)
 	}
 
-	return append([]byte{0x03}, p.x.number.Bytes()...)
+	return append([]byte{0x03}, p.x.number.FillBytes(make([]byte, 32))...)
 }
 
 // Parse parses a binary representation of the SEC (Standards for Efficient Cryptography) format
 func Parse(sec []byte) (*S256Point, error) {
-	// if len(sec) == 0 {
-	// 	return NewInfinityPoint(), nil
-	// }
+	if !isValidSECLength(sec) {
+		return nil, fmt.Errorf("invalid SEC length")
+	}
 
 	if isUncompressed(sec) {
 		x := new(big.Int).SetBytes(sec[1:33])
@@ -201,6 +212,21 @@ func isUncompressed(sec []byte) bool {
 	return sec[0] == 0x04
 }
 
+func isValidSECLength(sec []byte) bool {
+	if len(sec) == 0 {
+		return false
+	}
+
+	switch sec[0] {
+	case 0x04:
+		return len(sec) == 65
+	case 0x02, 0x03:
+		return len(sec) == 33
+	}
+
+	return false
+}
+
 func (p *S256Point) Hash160(isCompressed bool) []byte {
 	if isCompressed {
 		return hash.Hash160(p.SECCompressed())
@@ -209,13 +235,35 @@ func (p *S256Point) Hash160(isCompressed bool) []byte {
 	return hash.Hash160(p.SEC())
 }
 
-// Address returns the address of the point
-func (p *S256Point) Address(isCompressed bool, isTestnet bool) string {
+// Address returns the pay-to-public-key-hash address of the point on the
+// network of params
+func (p *S256Point) Address(isCompressed bool, params *chaincfg.Params) string {
 	hash := p.Hash160(isCompressed)
 
-	if isTestnet {
-		return base58.Checksum(append([]byte{0x6f}, hash...))
+	return base58.Checksum(append([]byte{params.PubKeyHashAddrID}, hash...))
+}
+
+// TweakAdd returns the point p + tG, where t is the 32 byte tweak. This is
+// how BIP 32 derives the public key of a child.
+func (p *S256Point) TweakAdd(tweak []byte) (*S256Point, error) {
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(Secp256k1.N) >= 0 {
+		return nil, fmt.Errorf("invalid tweak")
+	}
+
+	tG, err := G.ScalarMul(t)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := p.Add(&tG.Point)
+	if err != nil {
+		return nil, err
+	}
+
+	if total.IsInfinity {
+		return nil, fmt.Errorf("tweaked point is infinity")
 	}
 
-	return base58.Checksum(append([]byte{0x00}, hash...))
+	return &S256Point{*total}, nil
 }
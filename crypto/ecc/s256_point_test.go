@@ -2,6 +2,7 @@ package ecc_test
 
 import (
 	"encoding/hex"
+	"fmt"
 	"math/big"
 	"testing"
 
@@ -99,6 +100,22 @@ func TestS256Point(t *testing.T) {
 			t.Errorf("Parse: got %v, expected %v", parsedPoint.String(), point.String())
 		}
 	})
+
+	t.Run("Parse rejects invalid SEC lengths", func(t *testing.T) {
+		testCases := [][]byte{
+			{},
+			{0x02},
+			append([]byte{0x04}, make([]byte, 32)...),
+			append([]byte{0x05}, make([]byte, 32)...),
+		}
+
+		for _, sec := range testCases {
+			_, err := ecc.Parse(sec)
+			if err == nil || err.Error() != "invalid SEC length" {
+				t.Errorf("Parse(%x): got %v, expected 'invalid SEC length'", sec, err)
+			}
+		}
+	})
 }
 
 func TestVerifyingASignature(t *testing.T) {
@@ -151,3 +168,114 @@ func TestCreateASignature(t *testing.T) {
 		t.Errorf("got %s, expected %s", sAsHexString, expectedS)
 	}
 }
+
+func TestTweakAdd(t *testing.T) {
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(12345))
+	tweak := big.NewInt(67890).FillBytes(make([]byte, 32))
+
+	tweakedKey, err := privateKey.TweakAdd(tweak)
+	if err != nil {
+		t.Fatalf("TweakAdd: unexpected error: %v", err)
+	}
+	if tweakedKey.Hex() != fmt.Sprintf("%064x", 12345+67890) {
+		t.Errorf("TweakAdd: got %s, expected %064x", tweakedKey.Hex(), 12345+67890)
+	}
+
+	tweakedPoint, err := privateKey.PublicKey().TweakAdd(tweak)
+	if err != nil {
+		t.Fatalf("TweakAdd: unexpected error: %v", err)
+	}
+	if tweakedPoint.String() != tweakedKey.PublicKey().String() {
+		t.Errorf("TweakAdd: got %s, expected %s", tweakedPoint, tweakedKey.PublicKey())
+	}
+
+	// A tweak of n - 12345 gives the private key zero and the point at
+	// infinity
+	negated := new(big.Int).Sub(ecc.Secp256k1.N, big.NewInt(12345)).FillBytes(make([]byte, 32))
+	if _, err := privateKey.TweakAdd(negated); err == nil {
+		t.Errorf("TweakAdd: expected an error for a zero private key")
+	}
+	if _, err := privateKey.PublicKey().TweakAdd(negated); err == nil {
+		t.Errorf("TweakAdd: expected an error for the point at infinity")
+	}
+
+	if _, err := privateKey.TweakAdd(ecc.Secp256k1.N.FillBytes(make([]byte, 32))); err == nil {
+		t.Errorf("TweakAdd: expected an error for a tweak of n")
+	}
+}
+
+func TestVerifyRandomSignatures(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		secret := new(big.Int).SetBytes(hash.Hash256([]byte(fmt.Sprintf("secret %d", i))))
+		privateKey, _ := ecc.NewPrivateKey(secret)
+		z := new(big.Int).SetBytes(hash.Hash256([]byte(fmt.Sprintf("message %d", i))))
+
+		signature, err := privateKey.Sign(z)
+		if err != nil {
+			t.Fatalf("Sign: got error %v, expected nil", err)
+		}
+
+		if valid, _ := privateKey.PublicKey().Verify(z, signature); !valid {
+			t.Errorf("Verify: got false for signature %d, expected true", i)
+		}
+		if valid, _ := privateKey.PublicKey().Verify(new(big.Int).Add(z, big.NewInt(1)), signature); valid {
+			t.Errorf("Verify: got true for another message %d, expected false", i)
+		}
+	}
+}
+
+func TestVerifyWithGeneratorAsPublicKey(t *testing.T) {
+	// With the private key 1 and z = r both halves of uG + vP are the same
+	// multiple of G, which makes the additions double points
+	k := big.NewInt(1234567890)
+	kG, _ := ecc.G.ScalarMul(k)
+	r := new(big.Int).Mod(kG.XNum(), ecc.Secp256k1.N)
+	kInverse := new(big.Int).ModInverse(k, ecc.Secp256k1.N)
+	s := new(big.Int).Mod(new(big.Int).Mul(new(big.Int).Add(r, r), kInverse), ecc.Secp256k1.N)
+
+	valid, err := ecc.G.Verify(r, ecc.NewSignature(r, s))
+	if err != nil {
+		t.Fatalf("Verify: got error %v, expected nil", err)
+	}
+	if !valid {
+		t.Errorf("Verify: got false, expected true")
+	}
+}
+
+func benchmarkSignature(b *testing.B) (*ecc.S256Point, *big.Int, *ecc.Signature) {
+	privateKey, _ := ecc.NewPrivateKey(new(big.Int).SetBytes(hash.Hash256([]byte("my secret"))))
+	z := new(big.Int).SetBytes(hash.Hash256([]byte("my message")))
+	signature, err := privateKey.Sign(z)
+	if err != nil {
+		b.Fatalf("Sign: got error %v, expected nil", err)
+	}
+
+	return privateKey.PublicKey(), z, signature
+}
+
+func BenchmarkVerify(b *testing.B) {
+	point, z, signature := benchmarkSignature(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		point.Verify(z, signature)
+	}
+}
+
+// The verification with the affine arithmetic of Point, which Verify used
+// before, to compare with BenchmarkVerify
+func BenchmarkVerifyAffine(b *testing.B) {
+	point, z, signature := benchmarkSignature(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sInverse := new(big.Int).ModInverse(signature.S, ecc.Secp256k1.N)
+		u := new(big.Int).Mod(new(big.Int).Mul(z, sInverse), ecc.Secp256k1.N)
+		v := new(big.Int).Mod(new(big.Int).Mul(signature.R, sInverse), ecc.Secp256k1.N)
+
+		ug, _ := ecc.G.ScalarMul(u)
+		vp, _ := point.ScalarMul(v)
+		total, _ := ug.Add(&vp.Point)
+		new(big.Int).Mod(total.XNum(), ecc.Secp256k1.N).Cmp(signature.R)
+	}
+}
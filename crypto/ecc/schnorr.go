@@ -0,0 +1,317 @@
+// Package ecc - Elliptic Curve Cryptography
+package ecc
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hash"
+)
+
+// SchnorrSignature is a BIP 340 signature, where R is the x coordinate of the
+// nonce point.
+type SchnorrSignature struct {
+	R, S *big.Int
+}
+
+func (s *SchnorrSignature) String() string {
+	return fmt.Sprintf("SchnorrSignature(%x, %x)", s.R, s.S)
+}
+
+func NewSchnorrSignature(r, s *big.Int) *SchnorrSignature {
+	return &SchnorrSignature{r, s}
+}
+
+// Serialize returns the 64 byte encoding of the signature
+func (s *SchnorrSignature) Serialize() []byte {
+	result := s.R.FillBytes(make([]byte, 32))
+	return append(result, s.S.FillBytes(make([]byte, 32))...)
+}
+
+// ParseSchnorr parses the 64 byte encoding of a signature. The range of the
+// values is checked when the signature is verified.
+func ParseSchnorr(signature []byte) (*SchnorrSignature, error) {
+	if len(signature) != 64 {
+		return nil, fmt.Errorf("invalid Schnorr signature length")
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	return NewSchnorrSignature(r, s), nil
+}
+
+// XOnly returns the 32 byte x coordinate of the point, which is how BIP 340
+// encodes public keys.
+func (p *S256Point) XOnly() []byte {
+	return p.x.number.FillBytes(make([]byte, 32))
+}
+
+// HasEvenY returns whether the y coordinate of the point is even
+func (p *S256Point) HasEvenY() bool {
+	return p.y.number.Bit(0) == 0
+}
+
+// ParseXOnly parses a 32 byte x-only public key into the point with that x
+// coordinate and an even y coordinate.
+func ParseXOnly(xOnly []byte) (*S256Point, error) {
+	if len(xOnly) != 32 {
+		return nil, fmt.Errorf("invalid x-only public key length")
+	}
+
+	return Parse(append([]byte{0x02}, xOnly...))
+}
+
+// Returns the point with the same x coordinate and an even y coordinate
+func (p *S256Point) withEvenY() (*S256Point, error) {
+	if p.HasEvenY() {
+		return p, nil
+	}
+
+	return p.negate()
+}
+
+func (p *S256Point) negate() (*S256Point, error) {
+	x, err := NewS256Field(p.x.number)
+	if err != nil {
+		return nil, err
+	}
+	y, err := NewS256Field(new(big.Int).Sub(Secp256k1.Prime, p.y.number))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewS256Point(x, y)
+}
+
+// Returns the challenge e = hash(R || P || msg) mod n
+func schnorrChallenge(r *big.Int, p *S256Point, msg []byte) *big.Int {
+	e := hash.TaggedHash("BIP0340/challenge", r.FillBytes(make([]byte, 32)), p.XOnly(), msg)
+	return new(big.Int).Mod(new(big.Int).SetBytes(e), Secp256k1.N)
+}
+
+// VerifySchnorr verifies a BIP 340 signature of msg, where the point is used
+// as the x-only public key, i.e. with an even y coordinate.
+func (p *S256Point) VerifySchnorr(msg []byte, sig *SchnorrSignature) (bool, error) {
+	if sig.R.Cmp(Secp256k1.Prime) >= 0 || sig.S.Cmp(Secp256k1.N) >= 0 {
+		return false, nil
+	}
+
+	point, err := p.withEvenY()
+	if err != nil {
+		return false, err
+	}
+
+	e := schnorrChallenge(sig.R, point, msg)
+
+	// R = sG - eP
+	total, err := doubleScalarMulBase(sig.S, point, new(big.Int).Sub(Secp256k1.N, e))
+	if err != nil {
+		return false, err
+	}
+
+	if total.IsInfinity || total.y.number.Bit(0) != 0 {
+		return false, nil
+	}
+
+	return total.XNum().Cmp(sig.R) == 0, nil
+}
+
+// VerifySchnorrBatch verifies several BIP 340 signatures at once, where
+// msgs[i] is signed by sigs[i] with pubKeys[i]. It is true only if all of the
+// signatures are valid.
+func VerifySchnorrBatch(pubKeys []*S256Point, msgs [][]byte, sigs []*SchnorrSignature) (bool, error) {
+	if len(pubKeys) != len(msgs) || len(pubKeys) != len(sigs) {
+		return false, errors.New("batch verification needs a public key and a message for each signature")
+	}
+
+	// The equation (s1 + a2 s2 + ... + au su)G = R1 + a2 R2 + ... + au Ru +
+	// e1 P1 + (a2 e2)P2 + ... + (au eu)Pu is checked with random a2...au so
+	// the invalid signatures can not cancel each other out.
+	s := big.NewInt(0)
+	total := NewInfinityPoint()
+	for i, sig := range sigs {
+		if sig.R.Cmp(Secp256k1.Prime) >= 0 || sig.S.Cmp(Secp256k1.N) >= 0 {
+			return false, nil
+		}
+
+		a := big.NewInt(1)
+		if i > 0 {
+			var err error
+			a, err = randomScalar()
+			if err != nil {
+				return false, err
+			}
+		}
+
+		point, err := pubKeys[i].withEvenY()
+		if err != nil {
+			return false, err
+		}
+
+		r, err := ParseXOnly(sig.R.FillBytes(make([]byte, 32)))
+		if err != nil {
+			return false, nil
+		}
+
+		e := schnorrChallenge(sig.R, point, msgs[i])
+
+		aR, err := r.ScalarMul(a)
+		if err != nil {
+			return false, err
+		}
+		aeP, err := point.ScalarMul(new(big.Int).Mul(a, e))
+		if err != nil {
+			return false, err
+		}
+
+		total, err = total.Add(&aR.Point)
+		if err != nil {
+			return false, err
+		}
+		total, err = total.Add(&aeP.Point)
+		if err != nil {
+			return false, err
+		}
+
+		s.Add(s, new(big.Int).Mul(a, sig.S))
+	}
+
+	sG, err := G.ScalarMul(s)
+	if err != nil {
+		return false, err
+	}
+
+	return sG.Equals(total), nil
+}
+
+// Returns a random number in the range [1, n-1]
+func randomScalar() (*big.Int, error) {
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(Secp256k1.N, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// SignSchnorr signs msg as described in BIP 340. The auxRand must be 32 bytes
+// and should be fresh randomness, which protects the nonce against side
+// channel attacks. The signature is still secure if it is not random.
+func (pk *PrivateKey) SignSchnorr(msg, auxRand []byte) (*SchnorrSignature, error) {
+	if len(auxRand) != 32 {
+		return nil, fmt.Errorf("auxiliary randomness must be 32 bytes")
+	}
+	if !isScalar(pk.secret) {
+		return nil, fmt.Errorf("secret is not in the range [1, n-1]")
+	}
+
+	// The secret d and the nonce k are only used in the constant-time scalar
+	// arithmetic, and negated when their points have an odd y coordinate
+	n := scalarModulus
+	d := n.fromBig(pk.secret)
+	if !pk.point.HasEvenY() {
+		d = n.neg(&d)
+	}
+
+	t := n.toBytes(&d)
+	for i, b := range hash.TaggedHash("BIP0340/aux", auxRand) {
+		t[i] ^= b
+	}
+
+	nonce := hash.TaggedHash("BIP0340/nonce", t, pk.point.XOnly(), msg)
+	k := n.fromBytes(nonce)
+	if isZeroInt(&k) == 1 {
+		return nil, fmt.Errorf("nonce is zero")
+	}
+
+	kG, err := scalarBaseMul(n.toBig(&k))
+	if err != nil {
+		return nil, err
+	}
+	if !kG.HasEvenY() {
+		k = n.neg(&k)
+	}
+
+	r := kG.XNum()
+	e := schnorrChallenge(r, pk.point, msg)
+	eScalar := n.fromBig(e)
+	product := n.mul(&eScalar, &d)
+	sum := n.add(&k, &product)
+	s := n.toBig(&sum)
+
+	signature := NewSchnorrSignature(r, s)
+
+	valid, err := pk.point.VerifySchnorr(msg, signature)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("created signature does not verify")
+	}
+
+	return signature, nil
+}
+
+// XOnly returns the x-only public key of BIP 340
+func (pk *PrivateKey) XOnly() []byte {
+	return pk.point.XOnly()
+}
+
+// TweakXOnly returns the point P + tG, where P is the point with an even y
+// coordinate for the x-only public key of p, and t is the 32 byte tweak.
+// Taproot commits to a script tree this way, see BIP 341.
+func (p *S256Point) TweakXOnly(tweak []byte) (*S256Point, error) {
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(Secp256k1.N) >= 0 {
+		return nil, fmt.Errorf("invalid tweak")
+	}
+
+	point, err := p.withEvenY()
+	if err != nil {
+		return nil, err
+	}
+
+	tG, err := G.ScalarMul(t)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := point.Add(&tG.Point)
+	if err != nil {
+		return nil, err
+	}
+
+	if total.IsInfinity {
+		return nil, fmt.Errorf("tweaked point is infinity")
+	}
+
+	return &S256Point{*total}, nil
+}
+
+// TweakXOnly returns the private key of the tweaked public key of
+// S256Point.TweakXOnly.
+func (pk *PrivateKey) TweakXOnly(tweak []byte) (*PrivateKey, error) {
+	t := new(big.Int).SetBytes(tweak)
+	if len(tweak) != 32 || t.Cmp(Secp256k1.N) >= 0 {
+		return nil, fmt.Errorf("invalid tweak")
+	}
+
+	// The secret is only used in the constant-time scalar arithmetic, and
+	// negated when its point has an odd y coordinate
+	n := scalarModulus
+	d := n.fromBig(pk.secret)
+	if !pk.point.HasEvenY() {
+		d = n.neg(&d)
+	}
+
+	tScalar := n.fromBig(t)
+	sum := n.add(&d, &tScalar)
+	if isZeroInt(&sum) == 1 {
+		return nil, fmt.Errorf("tweaked private key is zero")
+	}
+
+	return NewPrivateKey(n.toBig(&sum))
+}
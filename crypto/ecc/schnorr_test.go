@@ -0,0 +1,222 @@
+package ecc_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+)
+
+type bip340Vector struct {
+	index     string
+	secretKey []byte
+	publicKey []byte
+	auxRand   []byte
+	message   []byte
+	signature []byte
+	valid     bool
+	comment   string
+}
+
+func readBIP340Vectors(t *testing.T) []bip340Vector {
+	t.Helper()
+
+	file, err := os.Open("testdata/bip340_test_vectors.csv")
+	if err != nil {
+		t.Fatalf("os.Open: %v", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll: %v", err)
+	}
+
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatalf("hex.DecodeString(%q): %v", s, err)
+		}
+		return b
+	}
+
+	vectors := make([]bip340Vector, 0, len(records)-1)
+	for _, record := range records[1:] {
+		vectors = append(vectors, bip340Vector{
+			index:     record[0],
+			secretKey: decode(record[1]),
+			publicKey: decode(record[2]),
+			auxRand:   decode(record[3]),
+			message:   decode(record[4]),
+			signature: decode(record[5]),
+			valid:     record[6] == "TRUE",
+			comment:   record[7],
+		})
+	}
+
+	return vectors
+}
+
+func TestSignSchnorr(t *testing.T) {
+	for _, vector := range readBIP340Vectors(t) {
+		if len(vector.secretKey) == 0 {
+			continue
+		}
+
+		t.Run(vector.index, func(t *testing.T) {
+			privateKey, err := ecc.NewPrivateKey(new(big.Int).SetBytes(vector.secretKey))
+			if err != nil {
+				t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
+			}
+
+			if !bytes.Equal(privateKey.XOnly(), vector.publicKey) {
+				t.Errorf("XOnly: got %x, expected %x", privateKey.XOnly(), vector.publicKey)
+			}
+
+			signature, err := privateKey.SignSchnorr(vector.message, vector.auxRand)
+			if err != nil {
+				t.Fatalf("SignSchnorr: got error %v, expected nil", err)
+			}
+
+			if !bytes.Equal(signature.Serialize(), vector.signature) {
+				t.Errorf("SignSchnorr: got %x, expected %x", signature.Serialize(), vector.signature)
+			}
+		})
+	}
+}
+
+func TestTweakXOnly(t *testing.T) {
+	tweak := bytes.Repeat([]byte{0x42}, 32)
+
+	// The public keys of the secrets 1 and 6 have an even and an odd y
+	for _, secret := range []int64{1, 6} {
+		privateKey, err := ecc.NewPrivateKey(big.NewInt(secret))
+		if err != nil {
+			t.Fatalf("NewPrivateKey: got error %v, expected nil", err)
+		}
+
+		tweakedKey, err := privateKey.TweakXOnly(tweak)
+		if err != nil {
+			t.Fatalf("TweakXOnly: got error %v, expected nil", err)
+		}
+		tweakedPoint, err := privateKey.PublicKey().TweakXOnly(tweak)
+		if err != nil {
+			t.Fatalf("TweakXOnly: got error %v, expected nil", err)
+		}
+
+		if !bytes.Equal(tweakedKey.PublicKey().SEC(), tweakedPoint.SEC()) {
+			t.Errorf("TweakXOnly(%d): got %x, expected %x", secret, tweakedKey.PublicKey().SEC(), tweakedPoint.SEC())
+		}
+	}
+}
+
+func TestVerifySchnorr(t *testing.T) {
+	for _, vector := range readBIP340Vectors(t) {
+		t.Run(vector.index, func(t *testing.T) {
+			publicKey, err := ecc.ParseXOnly(vector.publicKey)
+			if err != nil {
+				if vector.valid {
+					t.Fatalf("ParseXOnly: got error %v, expected nil", err)
+				}
+				return
+			}
+
+			signature, err := ecc.ParseSchnorr(vector.signature)
+			if err != nil {
+				t.Fatalf("ParseSchnorr: got error %v, expected nil", err)
+			}
+
+			valid, err := publicKey.VerifySchnorr(vector.message, signature)
+			if err != nil {
+				t.Fatalf("VerifySchnorr: got error %v, expected nil", err)
+			}
+
+			if valid != vector.valid {
+				t.Errorf("VerifySchnorr: got %v, expected %v (%s)", valid, vector.valid, vector.comment)
+			}
+		})
+	}
+}
+
+func TestVerifySchnorrBatch(t *testing.T) {
+	var publicKeys []*ecc.S256Point
+	var messages [][]byte
+	var signatures []*ecc.SchnorrSignature
+	var invalid *bip340Vector
+
+	for _, vector := range readBIP340Vectors(t) {
+		publicKey, err := ecc.ParseXOnly(vector.publicKey)
+		if err != nil {
+			continue
+		}
+
+		if !vector.valid {
+			if invalid == nil {
+				invalid = &vector
+			}
+			continue
+		}
+
+		signature, err := ecc.ParseSchnorr(vector.signature)
+		if err != nil {
+			t.Fatalf("ParseSchnorr: got error %v, expected nil", err)
+		}
+
+		publicKeys = append(publicKeys, publicKey)
+		messages = append(messages, vector.message)
+		signatures = append(signatures, signature)
+	}
+
+	t.Run("Valid signatures", func(t *testing.T) {
+		valid, err := ecc.VerifySchnorrBatch(publicKeys, messages, signatures)
+		if err != nil {
+			t.Fatalf("VerifySchnorrBatch: got error %v, expected nil", err)
+		}
+		if !valid {
+			t.Errorf("VerifySchnorrBatch: got false, expected true")
+		}
+	})
+
+	t.Run("One invalid signature", func(t *testing.T) {
+		publicKey, _ := ecc.ParseXOnly(invalid.publicKey)
+		signature, _ := ecc.ParseSchnorr(invalid.signature)
+
+		valid, err := ecc.VerifySchnorrBatch(
+			append(publicKeys[:len(publicKeys):len(publicKeys)], publicKey),
+			append(messages[:len(messages):len(messages)], invalid.message),
+			append(signatures[:len(signatures):len(signatures)], signature),
+		)
+		if err != nil {
+			t.Fatalf("VerifySchnorrBatch: got error %v, expected nil", err)
+		}
+		if valid {
+			t.Errorf("VerifySchnorrBatch: got true, expected false")
+		}
+	})
+
+	t.Run("Mismatched lengths", func(t *testing.T) {
+		_, err := ecc.VerifySchnorrBatch(publicKeys[:1], messages, signatures)
+		if err == nil {
+			t.Errorf("VerifySchnorrBatch: got nil, expected an error")
+		}
+	})
+}
+
+func BenchmarkVerifySchnorr(b *testing.B) {
+	privateKey, _ := ecc.NewPrivateKey(big.NewInt(12345))
+	msg := make([]byte, 32)
+	signature, err := privateKey.SignSchnorr(msg, make([]byte, 32))
+	if err != nil {
+		b.Fatalf("SignSchnorr: got error %v, expected nil", err)
+	}
+	publicKey := privateKey.PublicKey()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		publicKey.VerifySchnorr(msg, signature)
+	}
+}
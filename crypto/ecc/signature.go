@@ -42,7 +42,7 @@ func (signature *Signature) DER() []byte {
 }
 
 func ParseDER(der []byte) (*Signature, error) {
-	if der[0] != 0x30 {
+	if len(der) < 8 || der[0] != 0x30 {
 		return nil, fmt.Errorf("bad signature")
 	}
 
@@ -56,6 +56,9 @@ func ParseDER(der []byte) (*Signature, error) {
 	}
 
 	rLength := int(der[3])
+	if 5+rLength >= len(der) {
+		return nil, fmt.Errorf("bad signature length")
+	}
 	r := der[4 : 4+rLength]
 
 	if der[4+rLength] != 0x02 {
@@ -81,4 +81,19 @@ func TestSignature(t *testing.T) {
 			}
 		}
 	})
+	t.Run("ParseDER rejects malformed signatures", func(t *testing.T) {
+		testCases := [][]byte{
+			{},
+			{0x30},
+			{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x01, 0x01},
+			{0x30, 0x06, 0x02, 0x09, 0x01, 0x02, 0x01, 0x01},
+		}
+
+		for _, der := range testCases {
+			_, err := ecc.ParseDER(der)
+			if err == nil {
+				t.Errorf("ParseDER(%x): expected error, got nil", der)
+			}
+		}
+	})
 }
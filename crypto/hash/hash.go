@@ -5,7 +5,11 @@
 // SHA-256 followed by SHA-256 and SHA-256 followed by RIPEMD-160 respectively.
 //
 // The function HashSHA1 is used to hash data with SHA-1 which is used in the
-// bitcoin op code OP_SHA1.
+// bitcoin op code OP_SHA1, and likewise HashSHA256 and HashRIPEMD160 are
+// used in the op codes OP_SHA256 and OP_RIPEMD160.
+//
+// The function TaggedHash is the domain separated SHA-256 from BIP 340, which
+// is used by Schnorr signatures and taproot.
 //
 // See https://en.bitcoin.it/wiki/Protocol_documentation#Hashes
 package hash
@@ -64,3 +68,33 @@ func HashSHA1(data []byte) []byte {
 	}
 	return h.Sum(nil)
 }
+
+// A single round of SHA-256
+func HashSHA256(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// A single round of RIPEMD-160
+func HashRIPEMD160(data []byte) []byte {
+	h := ripemd160.New()
+	_, err := h.Write(data)
+	if err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// SHA-256 of the data prefixed with the SHA-256 of the tag twice, see BIP 340
+func TaggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
@@ -36,3 +36,33 @@ func TestSHA1(t *testing.T) {
 		t.Errorf("Expected %s but got %s", expected, hex.EncodeToString(hash))
 	}
 }
+
+func TestSHA256(t *testing.T) {
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	hash := hash.HashSHA256([]byte("hello"))
+
+	if hex.EncodeToString(hash) != expected {
+		t.Errorf("Expected %s but got %s", expected, hex.EncodeToString(hash))
+	}
+}
+
+func TestRIPEMD160(t *testing.T) {
+	expected := "108f07b8382412612c048d07d13f814118445acd"
+
+	hash := hash.HashRIPEMD160([]byte("hello"))
+
+	if hex.EncodeToString(hash) != expected {
+		t.Errorf("Expected %s but got %s", expected, hex.EncodeToString(hash))
+	}
+}
+
+func TestTaggedHash(t *testing.T) {
+	expected := "a97ff4dc59e2e158c00a7d9cf1e7d60fb090ecf5f728b6d17be7cbbb0fc572dd"
+
+	hash := hash.TaggedHash("BIP0340/challenge", []byte("hel"), []byte("lo"))
+
+	if hex.EncodeToString(hash) != expected {
+		t.Errorf("Expected %s but got %s", expected, hex.EncodeToString(hash))
+	}
+}
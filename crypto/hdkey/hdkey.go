@@ -0,0 +1,353 @@
+// Package hdkey implements the hierarchical deterministic keys of BIP 32,
+// where a tree of keys is derived from a single seed.
+package hdkey
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/ecc"
+	"github.com/stefanalfbo/programmingbitcoin/encoding/base58"
+)
+
+// The index of the first hardened child, which is written as 0' or 0h
+const HardenedKeyStart uint32 = 0x80000000
+
+// The shortest and the longest seed of a master key
+const (
+	MinSeedLength = 16
+	MaxSeedLength = 64
+)
+
+// The length of a serialized extended key
+const serializedLength = 78
+
+// ExtendedKey is a private or a public key together with the chain code that
+// its children are derived with.
+type ExtendedKey struct {
+	params            *chaincfg.Params
+	depth             uint8
+	parentFingerprint []byte
+	childNumber       uint32
+	chainCode         []byte
+	// Nil for an extended public key
+	privateKey *ecc.PrivateKey
+	publicKey  *ecc.S256Point
+}
+
+// Returns the HMAC-SHA512 of data, split into the left and right 32 bytes
+func hmacSHA512(key, data []byte) ([]byte, []byte) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	return sum[:32], sum[32:]
+}
+
+// NewMaster returns the master key of the tree of seed on the network of
+// params. The seed must be between 16 and 64 bytes.
+func NewMaster(seed []byte, params *chaincfg.Params) (*ExtendedKey, error) {
+	if len(seed) < MinSeedLength || len(seed) > MaxSeedLength {
+		return nil, fmt.Errorf("seed must be between %d and %d bytes, got %d", MinSeedLength, MaxSeedLength, len(seed))
+	}
+
+	secret, chainCode := hmacSHA512([]byte("Bitcoin seed"), seed)
+	privateKey, err := ecc.NewPrivateKey(new(big.Int).SetBytes(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	return &ExtendedKey{
+		params:            params,
+		parentFingerprint: make([]byte, 4),
+		chainCode:         chainCode,
+		privateKey:        privateKey,
+		publicKey:         privateKey.PublicKey(),
+	}, nil
+}
+
+// Returns whether the extended key has a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.privateKey != nil
+}
+
+// Returns the network of the extended key
+func (k *ExtendedKey) Params() *chaincfg.Params {
+	return k.params
+}
+
+// Returns the number of derivations from the master key
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+// Returns the index of the key among the children of its parent
+func (k *ExtendedKey) ChildNumber() uint32 {
+	return k.childNumber
+}
+
+// Returns the chain code that the children are derived with
+func (k *ExtendedKey) ChainCode() []byte {
+	return k.chainCode
+}
+
+// Returns the fingerprint of the parent, which is zero for the master key
+func (k *ExtendedKey) ParentFingerprint() []byte {
+	return k.parentFingerprint
+}
+
+// Returns the first 4 bytes of the hash160 of the compressed public key,
+// which identifies the key as a parent
+func (k *ExtendedKey) Fingerprint() []byte {
+	return k.publicKey.Hash160(true)[:4]
+}
+
+// Returns the private key, or an error for an extended public key
+func (k *ExtendedKey) PrivateKey() (*ecc.PrivateKey, error) {
+	if k.privateKey == nil {
+		return nil, fmt.Errorf("extended public key has no private key")
+	}
+
+	return k.privateKey, nil
+}
+
+// Returns the public key
+func (k *ExtendedKey) PublicKey() *ecc.S256Point {
+	return k.publicKey
+}
+
+// Neuter returns the extended public key of k, which derives the public keys
+// of the non-hardened children of k but none of their private keys.
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	return &ExtendedKey{
+		params:            k.params,
+		depth:             k.depth,
+		parentFingerprint: k.parentFingerprint,
+		childNumber:       k.childNumber,
+		chainCode:         k.chainCode,
+		publicKey:         k.publicKey,
+	}
+}
+
+// Child returns the child at index, which is hardened from HardenedKeyStart.
+// A hardened child can only be derived from an extended private key. The
+// child of an index that gives an invalid key is an error, in which case
+// BIP 32 says to continue with the next index.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, fmt.Errorf("cannot derive a key deeper than 255")
+	}
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		if k.privateKey == nil {
+			return nil, fmt.Errorf("cannot derive a hardened child of an extended public key")
+		}
+		data = append([]byte{0x00}, k.privateKey.Bytes()...)
+	} else {
+		data = k.publicKey.SECCompressed()
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	tweak, chainCode := hmacSHA512(k.chainCode, data)
+
+	child := &ExtendedKey{
+		params:            k.params,
+		depth:             k.depth + 1,
+		parentFingerprint: k.Fingerprint(),
+		childNumber:       index,
+		chainCode:         chainCode,
+	}
+
+	if k.privateKey != nil {
+		privateKey, err := k.privateKey.TweakAdd(tweak)
+		if err != nil {
+			return nil, fmt.Errorf("invalid child %d: %w", index, err)
+		}
+		child.privateKey = privateKey
+		child.publicKey = privateKey.PublicKey()
+	} else {
+		publicKey, err := k.publicKey.TweakAdd(tweak)
+		if err != nil {
+			return nil, fmt.Errorf("invalid child %d: %w", index, err)
+		}
+		child.publicKey = publicKey
+	}
+
+	return child, nil
+}
+
+// Derive returns the descendant of k at path, such as m/84'/0'/0'/0/5. A
+// path that starts with m must be derived from a master key.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	if strings.HasPrefix(path, "m") && k.depth != 0 {
+		return nil, fmt.Errorf("path %s starts at the master key, but the key has depth %d", path, k.depth)
+	}
+
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, index := range indexes {
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// ParsePath returns the child indexes of a path such as m/84'/0'/0'/0/5,
+// where a hardened index is marked with ', h or H. The leading m is
+// optional.
+func ParsePath(path string) ([]uint32, error) {
+	if path == "m" || path == "" {
+		return []uint32{}, nil
+	}
+	path = strings.TrimPrefix(path, "m/")
+
+	parts := strings.Split(path, "/")
+	indexes := make([]uint32, 0, len(parts))
+	for _, part := range parts {
+		offset := uint32(0)
+		if trimmed := strings.TrimRight(part, "'hH"); len(trimmed) == len(part)-1 {
+			offset = HardenedKeyStart
+			part = trimmed
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= uint64(HardenedKeyStart) {
+			return nil, fmt.Errorf("invalid path element %q", part)
+		}
+
+		indexes = append(indexes, uint32(index)+offset)
+	}
+
+	return indexes, nil
+}
+
+// FormatPath returns the path of the child indexes, with an apostrophe for
+// hardened indexes.
+func FormatPath(indexes []uint32) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, index := range indexes {
+		if index >= HardenedKeyStart {
+			fmt.Fprintf(&sb, "/%d'", index-HardenedKeyStart)
+		} else {
+			fmt.Fprintf(&sb, "/%d", index)
+		}
+	}
+
+	return sb.String()
+}
+
+// Serialize returns the 78 bytes of the extended key, see BIP 32.
+func (k *ExtendedKey) Serialize() []byte {
+	result := make([]byte, 0, serializedLength)
+	if k.privateKey != nil {
+		result = append(result, k.params.HDPrivateKeyID[:]...)
+	} else {
+		result = append(result, k.params.HDPublicKeyID[:]...)
+	}
+	result = append(result, k.depth)
+	result = append(result, k.parentFingerprint...)
+	result = binary.BigEndian.AppendUint32(result, k.childNumber)
+	result = append(result, k.chainCode...)
+	if k.privateKey != nil {
+		result = append(result, 0x00)
+		result = append(result, k.privateKey.Bytes()...)
+	} else {
+		result = append(result, k.publicKey.SECCompressed()...)
+	}
+
+	return result
+}
+
+// Returns the base58check encoding of the extended key, which starts with
+// xprv or xpub on mainnet and tprv or tpub on the test networks.
+func (k *ExtendedKey) String() string {
+	return base58.Checksum(k.Serialize())
+}
+
+// Parse parses the base58check encoding of an extended key. All of the test
+// networks share the version bytes, so their keys are returned with the
+// parameters of testnet3.
+func Parse(s string) (*ExtendedKey, error) {
+	version, payload, err := base58.DecodeCheck(s)
+	if err != nil {
+		return nil, err
+	}
+
+	data := append([]byte{version}, payload...)
+	if len(data) != serializedLength {
+		return nil, fmt.Errorf("invalid extended key length %d", len(data))
+	}
+
+	params, isPrivate, err := paramsForVersion(data[:4])
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ExtendedKey{
+		params:            params,
+		depth:             data[4],
+		parentFingerprint: data[5:9],
+		childNumber:       binary.BigEndian.Uint32(data[9:13]),
+		chainCode:         data[13:45],
+	}
+
+	if key.depth == 0 && !bytes.Equal(key.parentFingerprint, make([]byte, 4)) {
+		return nil, fmt.Errorf("master key with a parent fingerprint")
+	}
+	if key.depth == 0 && key.childNumber != 0 {
+		return nil, fmt.Errorf("master key with a child number")
+	}
+
+	keyData := data[45:]
+	if isPrivate {
+		if keyData[0] != 0x00 {
+			return nil, fmt.Errorf("invalid private key prefix %#02x", keyData[0])
+		}
+		key.privateKey, err = ecc.NewPrivateKey(new(big.Int).SetBytes(keyData[1:]))
+		if err != nil {
+			return nil, err
+		}
+		key.publicKey = key.privateKey.PublicKey()
+	} else {
+		if keyData[0] != 0x02 && keyData[0] != 0x03 {
+			return nil, fmt.Errorf("invalid public key prefix %#02x", keyData[0])
+		}
+		key.publicKey, err = ecc.Parse(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+// Returns the network of the version bytes and whether they are of an
+// extended private key
+func paramsForVersion(version []byte) (*chaincfg.Params, bool, error) {
+	for _, params := range chaincfg.Networks {
+		if bytes.Equal(version, params.HDPrivateKeyID[:]) {
+			return params, true, nil
+		}
+		if bytes.Equal(version, params.HDPublicKeyID[:]) {
+			return params, false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("unknown extended key version %x", version)
+}
@@ -0,0 +1,245 @@
+package hdkey_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+)
+
+type chain struct {
+	path string
+	xpub string
+	xprv string
+}
+
+// The test vectors 1 to 4 of BIP 32
+var vectors = []struct {
+	seed   string
+	chains []chain
+}{
+	{
+		seed: "000102030405060708090a0b0c0d0e0f",
+		chains: []chain{
+			{"m", "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8", "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+			{"m/0'", "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw", "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+			{"m/0'/1", "xpub6ASuArnXKPbfEwhqN6e3mwBcDTgzisQN1wXN9BJcM47sSikHjJf3UFHKkNAWbWMiGj7Wf5uMash7SyYq527Hqck2AxYysAA7xmALppuCkwQ", "xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+			{"m/0'/1/2'", "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5", "xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+			{"m/0'/1/2'/2", "xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV", "xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334"},
+			{"m/0'/1/2'/2/1000000000", "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy", "xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76"},
+		},
+	},
+	{
+		seed: "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+		chains: []chain{
+			{"m", "xpub661MyMwAqRbcFW31YEwpkMuc5THy2PSt5bDMsktWQcFF8syAmRUapSCGu8ED9W6oDMSgv6Zz8idoc4a6mr8BDzTJY47LJhkJ8UB7WEGuduB", "xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U"},
+			{"m/0", "xpub69H7F5d8KSRgmmdJg2KhpAK8SR3DjMwAdkxj3ZuxV27CprR9LgpeyGmXUbC6wb7ERfvrnKZjXoUmmDznezpbZb7ap6r1D3tgFxHmwMkQTPH", "xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt"},
+			{"m/0/2147483647'", "xpub6ASAVgeehLbnwdqV6UKMHVzgqAG8Gr6riv3Fxxpj8ksbH9ebxaEyBLZ85ySDhKiLDBrQSARLq1uNRts8RuJiHjaDMBU4Zn9h8LZNnBC5y4a", "xprv9wSp6B7kry3Vj9m1zSnLvN3xH8RdsPP1Mh7fAaR7aRLcQMKTR2vidYEeEg2mUCTAwCd6vnxVrcjfy2kRgVsFawNzmjuHc2YmYRmagcEPdU9"},
+			{"m/0/2147483647'/1", "xpub6DF8uhdarytz3FWdA8TvFSvvAh8dP3283MY7p2V4SeE2wyWmG5mg5EwVvmdMVCQcoNJxGoWaU9DCWh89LojfZ537wTfunKau47EL2dhHKon", "xprv9zFnWC6h2cLgpmSA46vutJzBcfJ8yaJGg8cX1e5StJh45BBciYTRXSd25UEPVuesF9yog62tGAQtHjXajPPdbRCHuWS6T8XA2ECKADdw4Ef"},
+			{"m/0/2147483647'/1/2147483646'", "xpub6ERApfZwUNrhLCkDtcHTcxd75RbzS1ed54G1LkBUHQVHQKqhMkhgbmJbZRkrgZw4koxb5JaHWkY4ALHY2grBGRjaDMzQLcgJvLJuZZvRcEL", "xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc"},
+			{"m/0/2147483647'/1/2147483646'/2", "xpub6FnCn6nSzZAw5Tw7cgR9bi15UV96gLZhjDstkXXxvCLsUXBGXPdSnLFbdpq8p9HmGsApME5hQTZ3emM2rnY5agb9rXpVGyy3bdW6EEgAtqt", "xprvA2nrNbFZABcdryreWet9Ea4LvTJcGsqrMzxHx98MMrotbir7yrKCEXw7nadnHM8Dq38EGfSh6dqA9QWTyefMLEcBYJUuekgW4BYPJcr9E7j"},
+		},
+	},
+	{
+		seed: "4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be",
+		chains: []chain{
+			{"m", "xpub661MyMwAqRbcEZVB4dScxMAdx6d4nFc9nvyvH3v4gJL378CSRZiYmhRoP7mBy6gSPSCYk6SzXPTf3ND1cZAceL7SfJ1Z3GC8vBgp2epUt13", "xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6"},
+			{"m/0'", "xpub68NZiKmJWnxxS6aaHmn81bvJeTESw724CRDs6HbuccFQN9Ku14VQrADWgqbhhTHBaohPX4CjNLf9fq9MYo6oDaPPLPxSb7gwQN3ih19Zm4Y", "xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"},
+		},
+	},
+	{
+		seed: "3ddd5602285899a946114506157c7997e5444528f3003f6134712147db19b678",
+		chains: []chain{
+			{"m", "xpub661MyMwAqRbcGczjuMoRm6dXaLDEhW1u34gKenbeYqAix21mdUKJyuyu5F1rzYGVxyL6tmgBUAEPrEz92mBXjByMRiJdba9wpnN37RLLAXa", "xprv9s21ZrQH143K48vGoLGRPxgo2JNkJ3J3fqkirQC2zVdk5Dgd5w14S7fRDyHH4dWNHUgkvsvNDCkvAwcSHNAQwhwgNMgZhLtQC63zxwhQmRv"},
+			{"m/0'", "xpub69AUMk3qDBi3uW1sXgjCmVjJ2G6WQoYSnNHyzkmdCHEhSZ4tBok37xfFEqHd2AddP56Tqp4o56AePAgCjYdvpW2PU2jbUPFKsav5ut6Ch1m", "xprv9vB7xEWwNp9kh1wQRfCCQMnZUEG21LpbR9NPCNN1dwhiZkjjeGRnaALmPXCX7SgjFTiCTT6bXes17boXtjq3xLpcDjzEuGLQBM5ohqkao9G"},
+			{"m/0'/1'", "xpub6BJA1jSqiukeaesWfxe6sNK9CCGaujFFSJLomWHprUL9DePQ4JDkM5d88n49sMGJxrhpjazuXYWdMf17C9T5XnxkopaeS7jGk1GyyVziaMt", "xprv9xJocDuwtYCMNAo3Zw76WENQeAS6WGXQ55RCy7tDJ8oALr4FWkuVoHJeHVAcAqiZLE7Je3vZJHxspZdFHfnBEjHqU5hG1Jaj32dVoS6XLT1"},
+		},
+	},
+}
+
+func TestVectors(t *testing.T) {
+	for _, vector := range vectors {
+		seed, _ := hex.DecodeString(vector.seed)
+		master, err := hdkey.NewMaster(seed, &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("NewMaster(%s): unexpected error: %v", vector.seed, err)
+		}
+
+		for _, chain := range vector.chains {
+			key, err := master.Derive(chain.path)
+			if err != nil {
+				t.Fatalf("Derive(%s): unexpected error: %v", chain.path, err)
+			}
+
+			if key.String() != chain.xprv {
+				t.Errorf("Derive(%s): got %s, expected %s", chain.path, key.String(), chain.xprv)
+			}
+			if key.Neuter().String() != chain.xpub {
+				t.Errorf("Derive(%s).Neuter(): got %s, expected %s", chain.path, key.Neuter().String(), chain.xpub)
+			}
+
+			for _, encoded := range []string{chain.xprv, chain.xpub} {
+				parsed, err := hdkey.Parse(encoded)
+				if err != nil {
+					t.Errorf("Parse(%s): unexpected error: %v", encoded, err)
+					continue
+				}
+				if parsed.String() != encoded {
+					t.Errorf("Parse(%s): got %s", encoded, parsed.String())
+				}
+			}
+		}
+	}
+}
+
+func TestPublicDerivation(t *testing.T) {
+	// The public keys of non-hardened children are the same from the
+	// extended public key of the parent, m/0'/1/2'/2/1000000000 of vector 1
+	parent, err := hdkey.Parse("xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3No2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5")
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+
+	key, err := parent.Derive("2/1000000000")
+	if err != nil {
+		t.Fatalf("Derive: unexpected error: %v", err)
+	}
+
+	expected := "xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy"
+	if key.String() != expected {
+		t.Errorf("Derive: got %s, expected %s", key.String(), expected)
+	}
+
+	if key.IsPrivate() {
+		t.Errorf("IsPrivate: got true, expected false")
+	}
+	if _, err := key.PrivateKey(); err == nil {
+		t.Errorf("PrivateKey: expected an error for an extended public key")
+	}
+	if _, err := parent.Child(hdkey.HardenedKeyStart); err == nil {
+		t.Errorf("Child: expected an error for a hardened child of an extended public key")
+	}
+	if _, err := key.Derive("m/0"); err == nil {
+		t.Errorf("Derive: expected an error for a path from the master key")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := hdkey.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("NewMaster: unexpected error: %v", err)
+	}
+
+	if hex.EncodeToString(master.Fingerprint()) != "3442193e" {
+		t.Errorf("Fingerprint: got %x, expected 3442193e", master.Fingerprint())
+	}
+
+	child, err := master.Child(hdkey.HardenedKeyStart)
+	if err != nil {
+		t.Fatalf("Child: unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(child.ParentFingerprint(), master.Fingerprint()) {
+		t.Errorf("ParentFingerprint: got %x, expected %x", child.ParentFingerprint(), master.Fingerprint())
+	}
+	if child.Depth() != 1 || child.ChildNumber() != hdkey.HardenedKeyStart {
+		t.Errorf("Child: got depth %d and child number %d", child.Depth(), child.ChildNumber())
+	}
+}
+
+func TestTestnet(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := hdkey.NewMaster(seed, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatalf("NewMaster: unexpected error: %v", err)
+	}
+
+	expected := "tprv8ZgxMBicQKsPeDgjzdC36fs6bMjGApWDNLR9erAXMs5skhMv36j9MV5ecvfavji5khqjWaWSFhN3YcCUUdiKH6isR4Pwy3U5y5egddBr16m"
+	if master.String() != expected {
+		t.Errorf("String: got %s, expected %s", master.String(), expected)
+	}
+
+	parsed, err := hdkey.Parse(master.Neuter().String())
+	if err != nil {
+		t.Fatalf("Parse: unexpected error: %v", err)
+	}
+	if parsed.Params() != &chaincfg.TestNet3Params {
+		t.Errorf("Parse: got network %s, expected %s", parsed.Params().Name, chaincfg.TestNet3Params.Name)
+	}
+}
+
+func TestInvalidSeed(t *testing.T) {
+	for _, length := range []int{15, 65} {
+		if _, err := hdkey.NewMaster(make([]byte, length), &chaincfg.MainNetParams); err == nil {
+			t.Errorf("NewMaster: expected an error for a seed of %d bytes", length)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	// The test vector 5 of BIP 32
+	tests := []struct {
+		reason string
+		key    string
+	}{
+		{"pubkey version / prvkey mismatch", "xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6LBpB85b3D2yc8sfvZU521AAwdZafEz7mnzBBsz4wKY5fTtTQBm"},
+		{"prvkey version / pubkey mismatch", "xprv9s21ZrQH143K24Mfq5zL5MhWK9hUhhGbd45hLXo2Pq2oqzMMo63oStZzFGTQQD3dC4H2D5GBj7vWvSQaaBv5cxi9gafk7NF3pnBju6dwKvH"},
+		{"invalid pubkey prefix 04", "xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6Txnt3siSujt9RCVYsx4qHZGc62TG4McvMGcAUjeuwZdduYEvFn"},
+		{"invalid prvkey prefix 04", "xprv9s21ZrQH143K24Mfq5zL5MhWK9hUhhGbd45hLXo2Pq2oqzMMo63oStZzFGpWnsj83BHtEy5Zt8CcDr1UiRXuWCmTQLxEK9vbz5gPstX92JQ"},
+		{"invalid pubkey prefix 01", "xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6N8ZMMXctdiCjxTNq964yKkwrkBJJwpzZS4HS2fxvyYUA4q2Xe4"},
+		{"invalid prvkey prefix 01", "xprv9s21ZrQH143K24Mfq5zL5MhWK9hUhhGbd45hLXo2Pq2oqzMMo63oStZzFAzHGBP2UuGCqWLTAPLcMtD9y5gkZ6Eq3Rjuahrv17fEQ3Qen6J"},
+		{"zero depth with non-zero parent fingerprint", "xprv9s2SPatNQ9Vc6GTbVMFPFo7jsaZySyzk7L8n2uqKXJen3KUmvQNTuLh3fhZMBoG3G4ZW1N2kZuHEPY53qmbZzCHshoQnNf4GvELZfqTUrcv"},
+		{"zero depth with non-zero parent fingerprint", "xpub661no6RGEX3uJkY4bNnPcw4URcQTrSibUZ4NqJEw5eBkv7ovTwgiT91XX27VbEXGENhYRCf7hyEbWrR3FewATdCEebj6znwMfQkhRYHRLpJ"},
+		{"zero depth with non-zero index", "xprv9s21ZrQH4r4TsiLvyLXqM9P7k1K3EYhA1kkD6xuquB5i39AU8KF42acDyL3qsDbU9NmZn6MsGSUYZEsuoePmjzsB3eFKSUEh3Gu1N3cqVUN"},
+		{"zero depth with non-zero index", "xpub661MyMwAuDcm6CRQ5N4qiHKrJ39Xe1R1NyfouMKTTWcguwVcfrZJaNvhpebzGerh7gucBvzEQWRugZDuDXjNDRmXzSZe4c7mnTK97pTvGS8"},
+		{"unknown extended key version", "DMwo58pR1QLEFihHiXPVykYB6fJmsTeHvyTp7hRThAtCX8CvYzgPcn8XnmdfHGMQzT7ayAmfo4z3gY5KfbrZWZ6St24UVf2Qgo6oujFktLHdHY4"},
+		{"unknown extended key version", "DMwo58pR1QLEFihHiXPVykYB6fJmsTeHvyTp7hRThAtCX8CvYzgPcn8XnmdfHPmHJiEDXkTiJTVV9rHEBUem2mwVbbNfvT2MTcAqj3nesx8uBf9"},
+		{"private key 0 not in 1..n-1", "xprv9s21ZrQH143K24Mfq5zL5MhWK9hUhhGbd45hLXo2Pq2oqzMMo63oStZzF93Y5wvzdUayhgkkFoicQZcP3y52uPPxFnfoLZB21Teqt1VvEHx"},
+		{"private key n not in 1..n-1", "xprv9s21ZrQH143K24Mfq5zL5MhWK9hUhhGbd45hLXo2Pq2oqzMMo63oStZzFAzHGBP2UuGCqWLTAPLcMtD5SDKr24z3aiUvKr9bJpdrcLg1y3G"},
+		{"invalid pubkey 020000000000000000000000000000000000000000000000000000000000000007", "xpub661MyMwAqRbcEYS8w7XLSVeEsBXy79zSzH1J8vCdxAZningWLdN3zgtU6Q5JXayek4PRsn35jii4veMimro1xefsM58PgBMrvdYre8QyULY"},
+		{"invalid checksum", "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHL"},
+	}
+
+	for _, test := range tests {
+		if _, err := hdkey.Parse(test.key); err == nil {
+			t.Errorf("Parse: expected an error for %s", test.reason)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []uint32
+	}{
+		{"m", []uint32{}},
+		{"m/84'/0'/0'/0/5", []uint32{84 + hdkey.HardenedKeyStart, hdkey.HardenedKeyStart, hdkey.HardenedKeyStart, 0, 5}},
+		{"m/0h/1H/2", []uint32{hdkey.HardenedKeyStart, 1 + hdkey.HardenedKeyStart, 2}},
+		{"0/2147483647'", []uint32{0, 0xffffffff}},
+	}
+
+	for _, test := range tests {
+		got, err := hdkey.ParsePath(test.path)
+		if err != nil {
+			t.Errorf("ParsePath(%s): unexpected error: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("ParsePath(%s): got %v, expected %v", test.path, got, test.expected)
+		}
+	}
+
+	invalid := []string{"m/", "m/a", "m/-1", "m/2147483648", "m/0''", "m//0", "x/0"}
+	for _, path := range invalid {
+		if _, err := hdkey.ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%s): expected an error", path)
+		}
+	}
+
+	if hdkey.FormatPath([]uint32{84 + hdkey.HardenedKeyStart, 0, 5}) != "m/84'/0/5" {
+		t.Errorf("FormatPath: got %s, expected m/84'/0/5", hdkey.FormatPath([]uint32{84 + hdkey.HardenedKeyStart, 0, 5}))
+	}
+}
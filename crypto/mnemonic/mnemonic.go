@@ -0,0 +1,242 @@
+// Package mnemonic implements the mnemonic sentences of BIP 39, which encode
+// the entropy of a wallet as words that are easy to write down, and turn them
+// into the seed of a BIP 32 master key.
+package mnemonic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"github.com/stefanalfbo/programmingbitcoin/bitcoin/chaincfg"
+	"github.com/stefanalfbo/programmingbitcoin/crypto/hdkey"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+//go:embed english.txt
+var english string
+
+// The English word list of BIP 39, where the index of a word is its value
+var words = strings.Fields(english)
+
+// The values of the words
+var wordIndexes = func() map[string]int {
+	indexes := make(map[string]int, len(words))
+	for i, word := range words {
+		indexes[word] = i
+	}
+
+	return indexes
+}()
+
+// The number of bits that a word encodes
+const bitsPerWord = 11
+
+// The number of rounds of PBKDF2 that derive the seed
+const seedRounds = 2048
+
+// WordError is a word of a mnemonic that is not in the word list, with the
+// words that were most likely meant.
+type WordError struct {
+	Word string
+	// The position of the word in the mnemonic, starting from zero
+	Index       int
+	Suggestions []string
+}
+
+func (e *WordError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("word %d %q is not in the word list", e.Index+1, e.Word)
+	}
+
+	return fmt.Sprintf("word %d %q is not in the word list, did you mean %s?", e.Index+1, e.Word, strings.Join(e.Suggestions, " or "))
+}
+
+// NewEntropy returns bitSize bits of random entropy, which must be a
+// multiple of 32 between 128 and 256.
+func NewEntropy(bitSize int) ([]byte, error) {
+	if err := checkEntropyLength(bitSize); err != nil {
+		return nil, err
+	}
+
+	entropy := make([]byte, bitSize/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+
+	return entropy, nil
+}
+
+func checkEntropyLength(bitSize int) error {
+	if bitSize < 128 || bitSize > 256 || bitSize%32 != 0 {
+		return fmt.Errorf("entropy must be a multiple of 32 bits between 128 and 256, got %d", bitSize)
+	}
+
+	return nil
+}
+
+// New returns the mnemonic of entropy, which is 12 words for 128 bits up to
+// 24 words for 256 bits. The last word includes a checksum of the entropy.
+func New(entropy []byte) (string, error) {
+	if err := checkEntropyLength(len(entropy) * 8); err != nil {
+		return "", err
+	}
+
+	// The checksum is the first bit of the SHA-256 of the entropy for every
+	// 32 bits of entropy
+	checksum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[0])
+	wordCount := (len(entropy)*8 + len(entropy)/4) / bitsPerWord
+
+	result := make([]string, wordCount)
+	for i := range result {
+		result[i] = words[readBits(data, i*bitsPerWord, bitsPerWord)]
+	}
+
+	return strings.Join(result, " "), nil
+}
+
+// Returns the count bits of data from the bit at offset as an integer
+func readBits(data []byte, offset, count int) int {
+	value := 0
+	for i := offset; i < offset+count; i++ {
+		bit := (data[i/8] >> (7 - i%8)) & 1
+		value = value<<1 | int(bit)
+	}
+
+	return value
+}
+
+// Entropy returns the entropy of a mnemonic after checking its words and its
+// checksum. A word that is not in the word list gives a *WordError.
+func Entropy(mnemonic string) ([]byte, error) {
+	fields := strings.Fields(mnemonic)
+	if len(fields) < 12 || len(fields) > 24 || len(fields)%3 != 0 {
+		return nil, fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, got %d", len(fields))
+	}
+
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		value, exists := wordIndexes[strings.ToLower(field)]
+		if !exists {
+			return nil, &WordError{field, i, suggest(strings.ToLower(field))}
+		}
+		values[i] = value
+	}
+
+	// 11 bits per word is 33 bits for every 32 bits of entropy
+	checksumBits := len(fields) / 3
+	entropyBits := len(fields)*bitsPerWord - checksumBits
+	data := make([]byte, (len(fields)*bitsPerWord+7)/8)
+	for i, value := range values {
+		for bit := 0; bit < bitsPerWord; bit++ {
+			if value>>(bitsPerWord-1-bit)&1 == 1 {
+				position := i*bitsPerWord + bit
+				data[position/8] |= 1 << (7 - position%8)
+			}
+		}
+	}
+
+	entropy := data[:entropyBits/8]
+	checksum := sha256.Sum256(entropy)
+	if readBits(data, entropyBits, checksumBits) != readBits(checksum[:], 0, checksumBits) {
+		return nil, fmt.Errorf("invalid mnemonic checksum")
+	}
+
+	return entropy, nil
+}
+
+// IsValid returns whether the mnemonic has known words and a valid checksum.
+func IsValid(mnemonic string) bool {
+	_, err := Entropy(mnemonic)
+
+	return err == nil
+}
+
+// Seed returns the 64 byte seed of a valid mnemonic and a passphrase, which
+// may be empty. Both are normalized to Unicode NFKD, as BIP 39 requires, and
+// the mnemonic is otherwise hashed as it is given.
+func Seed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := Entropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	password := norm.NFKD.String(mnemonic)
+	salt := norm.NFKD.String("mnemonic" + passphrase)
+
+	return pbkdf2.Key([]byte(password), []byte(salt), seedRounds, 64, sha512.New), nil
+}
+
+// NewMasterKey returns the BIP 32 master key of the seed of a mnemonic and a
+// passphrase on the network of params.
+func NewMasterKey(mnemonic, passphrase string, params *chaincfg.Params) (*hdkey.ExtendedKey, error) {
+	seed, err := Seed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return hdkey.NewMaster(seed, params)
+}
+
+// Returns the words that a mistyped word most likely was. The first four
+// letters identify a word of the list, otherwise the words with the fewest
+// edits are returned.
+func suggest(word string) []string {
+	if len(word) >= 4 {
+		for _, candidate := range words {
+			if strings.HasPrefix(candidate, word[:4]) {
+				return []string{candidate}
+			}
+		}
+	}
+
+	// More edits than this are not a typo of the word
+	const maxDistance = 2
+
+	var result []string
+	best := maxDistance
+	for _, candidate := range words {
+		distance := editDistance(word, candidate)
+		if distance < best {
+			best = distance
+			result = nil
+		}
+		if distance == best {
+			result = append(result, candidate)
+		}
+	}
+
+	return result
+}
+
+// Returns the number of insertions, deletions, substitutions and swaps of
+// adjacent letters that turn a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
@@ -45,29 +45,56 @@ func Encode(s []byte) string {
 	return string(append(prefix, encoded...))
 }
 
+// Decode returns the payload of a base58check encoded address, without the
+// version byte and the checksum.
 func Decode(s string) ([]byte, error) {
-	num := big.NewInt(0)
-	for _, char := range s {
-		num = num.Mul(num, big.NewInt(58))
-		index := strings.Index(string(base58Alphabet), string(char))
-		if index == -1 {
-			return nil, fmt.Errorf("invalid character in BASE58")
-		}
-		num = num.Add(num, big.NewInt(int64(index)))
+	_, payload, err := DecodeCheck(s)
+
+	return payload, err
+}
+
+// DecodeCheck returns the version byte and the payload of base58check
+// encoded data, after verifying the checksum.
+func DecodeCheck(s string) (byte, []byte, error) {
+	combined, err := decode(s)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(combined) < 5 {
+		return 0, nil, fmt.Errorf("too short for BASE58 with checksum")
 	}
 
-	combined := make([]byte, 25)
-	num.FillBytes(combined)
 	checksum := combined[len(combined)-4:]
 	withoutChecksum := combined[:len(combined)-4]
 
 	calcChecksum := hash.Hash256(withoutChecksum)
 
 	if !bytes.Equal(checksum, calcChecksum[:4]) {
-		return nil, fmt.Errorf("checksum does not match")
+		return 0, nil, fmt.Errorf("checksum does not match")
+	}
+
+	return withoutChecksum[0], withoutChecksum[1:], nil
+}
+
+// Returns the bytes of s, where each leading '1' is a zero byte.
+func decode(s string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+
+	num := big.NewInt(0)
+	for _, char := range s {
+		num = num.Mul(num, big.NewInt(58))
+		index := strings.Index(string(base58Alphabet), string(char))
+		if index == -1 {
+			return nil, fmt.Errorf("invalid character in BASE58")
+		}
+		num = num.Add(num, big.NewInt(int64(index)))
 	}
 
-	return combined[1 : len(combined)-4], nil
+	return append(make([]byte, zeros), num.Bytes()...), nil
 }
 
 func Checksum(data []byte) string {
@@ -40,3 +40,45 @@ func TestDecode(t *testing.T) {
 		t.Errorf("Decode(%s): got %s, expected %s", address, decodedAsHex, expected)
 	}
 }
+
+func TestDecodeCheck(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedVersion byte
+		expectedPayload string
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", 0x00, "77bff20c60e522dfaa3350c39b030a5d004e839a"},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", 0x05, "b472a266d0bd89c13706a4132ccfb16f7c3b9fcb"},
+		{"mnrVtF8DWjMu839VW3rBfgYaAfKk8983Xf", 0x6f, "507b27411ccf7f16f10297de6cef3f291623eddf"},
+		{"5HueCGU8rMjxEXxiPuD5BDku4MkFqeZyd4dZ1jvhTVqvbTLvyTJ", 0x80, "0c28fca386c7a227600b2fe50b7cae11ec86d3bf1fbe471be89827e19d72aa1d"},
+	}
+
+	for _, test := range tests {
+		version, payload, err := base58.DecodeCheck(test.input)
+		if err != nil {
+			t.Errorf("DecodeCheck(%s): unexpected error: %v", test.input, err)
+			continue
+		}
+
+		if version != test.expectedVersion {
+			t.Errorf("DecodeCheck(%s): got version %x, expected %x", test.input, version, test.expectedVersion)
+		}
+
+		if hex.EncodeToString(payload) != test.expectedPayload {
+			t.Errorf("DecodeCheck(%s): got %x, expected %s", test.input, payload, test.expectedPayload)
+		}
+	}
+
+	invalid := []string{
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3",
+		"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN0",
+		"1111",
+		"",
+	}
+
+	for _, input := range invalid {
+		if _, _, err := base58.DecodeCheck(input); err == nil {
+			t.Errorf("DecodeCheck(%s): expected an error", input)
+		}
+	}
+}
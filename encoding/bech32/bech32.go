@@ -0,0 +1,307 @@
+// Package bech32 implements the bech32 and bech32m encodings of BIP 173 and
+// BIP 350, which are used for segwit addresses.
+package bech32
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Encoding is the checksum variant of a bech32 string.
+type Encoding int
+
+const (
+	// The checksum of BIP 173, used by segwit version 0 addresses
+	Bech32 Encoding = iota + 1
+	// The checksum of BIP 350, used by segwit version 1 and later addresses
+	Bech32m
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case Bech32:
+		return "bech32"
+	case Bech32m:
+		return "bech32m"
+	}
+
+	return "unknown"
+}
+
+// The value that the checksum of each encoding makes the polymod equal to
+func (e Encoding) constant() uint32 {
+	if e == Bech32m {
+		return 0x2bc830a3
+	}
+
+	return 1
+}
+
+// The longest string allowed by BIP 173
+const MaxLength = 90
+
+// The number of characters of the checksum
+const checksumLength = 6
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Error is a string that could not be decoded. For an invalid checksum the
+// Positions are the indexes of the characters that are most likely wrong,
+// which are found when there are no more than two wrong characters.
+type Error struct {
+	Description string
+	Positions   []int
+}
+
+func (e *Error) Error() string {
+	if len(e.Positions) == 0 {
+		return e.Description
+	}
+
+	return fmt.Sprintf("%s at positions %v", e.Description, e.Positions)
+}
+
+func newError(description string, positions ...int) *Error {
+	return &Error{description, positions}
+}
+
+// Returns the BCH checksum polynomial of the 5 bit values modulo the
+// generator, see BIP 173.
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+// Returns the human readable part expanded into 5 bit values, which makes
+// the checksum commit to it.
+func hrpExpand(hrp string) []byte {
+	result := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]>>5)
+	}
+	result = append(result, 0)
+	for i := 0; i < len(hrp); i++ {
+		result = append(result, hrp[i]&31)
+	}
+
+	return result
+}
+
+func createChecksum(hrp string, values []byte, encoding Encoding) []byte {
+	enc := append(hrpExpand(hrp), values...)
+	enc = append(enc, make([]byte, checksumLength)...)
+	mod := polymod(enc) ^ encoding.constant()
+
+	checksum := make([]byte, checksumLength)
+	for i := range checksum {
+		checksum[i] = byte(mod>>(5*(5-i))) & 31
+	}
+
+	return checksum
+}
+
+// Encode returns the string of the human readable part and the 5 bit values
+// with the checksum of encoding. The human readable part is made lowercase.
+func Encode(hrp string, values []byte, encoding Encoding) (string, error) {
+	hrp = strings.ToLower(hrp)
+	if len(hrp) == 0 {
+		return "", newError("empty human readable part")
+	}
+	if len(hrp)+1+len(values)+checksumLength > MaxLength {
+		return "", newError("string is longer than 90 characters")
+	}
+
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", newError("invalid character in human readable part", i)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		if v > 31 {
+			return "", newError("value does not fit in 5 bits")
+		}
+		sb.WriteByte(charset[v])
+	}
+	for _, v := range createChecksum(hrp, values, encoding) {
+		sb.WriteByte(charset[v])
+	}
+
+	return sb.String(), nil
+}
+
+// Decode returns the human readable part, the 5 bit values without the
+// checksum and the encoding of the checksum of s. The human readable part is
+// returned in lowercase. An *Error tells why s is not valid.
+func Decode(s string) (string, []byte, Encoding, error) {
+	hrp, values, err := split(s)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	enc := append(hrpExpand(hrp), values...)
+	switch polymod(enc) {
+	case Bech32.constant():
+		return hrp, values[:len(values)-checksumLength], Bech32, nil
+	case Bech32m.constant():
+		return hrp, values[:len(values)-checksumLength], Bech32m, nil
+	}
+
+	return "", nil, 0, newError("invalid checksum", LocateErrors(s)...)
+}
+
+// Checks everything but the checksum of s, and returns the lowercase human
+// readable part and the 5 bit values of the data part including the
+// checksum.
+func split(s string) (string, []byte, error) {
+	if len(s) > MaxLength {
+		return "", nil, newError("string is longer than 90 characters")
+	}
+
+	hasLower, hasUpper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, newError("invalid character", i)
+		}
+		hasLower = hasLower || (c >= 'a' && c <= 'z')
+		hasUpper = hasUpper || (c >= 'A' && c <= 'Z')
+	}
+	if hasLower && hasUpper {
+		return "", nil, newError("mixed case")
+	}
+
+	s = strings.ToLower(s)
+	separator := strings.LastIndexByte(s, '1')
+	if separator == -1 {
+		return "", nil, newError("missing separator")
+	}
+	if separator == 0 {
+		return "", nil, newError("empty human readable part")
+	}
+	if len(s)-separator-1 < checksumLength {
+		return "", nil, newError("checksum is too short")
+	}
+
+	values := make([]byte, 0, len(s)-separator-1)
+	for i := separator + 1; i < len(s); i++ {
+		v := strings.IndexByte(charset, s[i])
+		if v == -1 {
+			return "", nil, newError("invalid data character", i)
+		}
+		values = append(values, byte(v))
+	}
+
+	return s[:separator], values, nil
+}
+
+// LocateErrors returns the positions of the characters of s that are wrong,
+// when s has a checksum error of one or two characters in the data part for
+// either encoding. Both encodings detect up to four errors, so one or two
+// wrong characters are always located at the right positions. More errors
+// give no positions or wrong positions. The positions are empty when s is
+// valid or fails for another reason than the checksum.
+func LocateErrors(s string) []int {
+	hrp, values, err := split(s)
+	if err != nil {
+		return nil
+	}
+
+	// The polymod is linear, so the residue of a string with errors is the
+	// residue of the correct string xor the residue of the errors alone.
+	// The residue of an error at position i is the polymod of zeros with
+	// the error at i, xor the polymod of the zeros alone.
+	residue := polymod(append(hrpExpand(hrp), values...))
+	zeros := make([]byte, len(values))
+	base := polymod(zeros)
+
+	// The positions of the errors of a single character by their residue
+	singles := make(map[uint32]int, len(values)*31)
+	for i := range values {
+		for e := byte(1); e < 32; e++ {
+			zeros[i] = e
+			singles[polymod(zeros)^base] = i
+		}
+		zeros[i] = 0
+	}
+
+	separator := len(s) - len(values) - 1
+	var best []int
+	for _, encoding := range []Encoding{Bech32, Bech32m} {
+		syndrome := residue ^ encoding.constant()
+
+		var positions []int
+		if position, exists := singles[syndrome]; exists {
+			positions = []int{position}
+		} else {
+			for difference, first := range singles {
+				second, exists := singles[syndrome^difference]
+				if exists && second > first {
+					positions = []int{first, second}
+					break
+				}
+			}
+		}
+
+		if len(positions) > 0 && (best == nil || len(positions) < len(best)) {
+			best = positions
+		}
+	}
+
+	for i := range best {
+		best[i] += separator + 1
+	}
+	sort.Ints(best)
+
+	return best
+}
+
+// ConvertBits regroups the bits of data from fromBits to toBits per value.
+// With pad the last value is padded with zeros, otherwise the bits left over
+// must be zero and fewer than fromBits.
+func ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxValue := uint32(1)<<toBits - 1
+	result := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+
+	for _, value := range data {
+		if value>>fromBits != 0 {
+			return nil, fmt.Errorf("value does not fit in %d bits", fromBits)
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxValue))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits {
+		return nil, fmt.Errorf("more than %d bits of padding", fromBits-1)
+	} else if acc<<(toBits-bits)&maxValue != 0 {
+		return nil, fmt.Errorf("non-zero padding")
+	}
+
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package bech32_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stefanalfbo/programmingbitcoin/encoding/bech32"
+)
+
+func TestDecodeValid(t *testing.T) {
+	tests := []struct {
+		input    string
+		encoding bech32.Encoding
+	}{
+		{"A12UEL5L", bech32.Bech32},
+		{"a12uel5l", bech32.Bech32},
+		{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", bech32.Bech32},
+		{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", bech32.Bech32},
+		{"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j", bech32.Bech32},
+		{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", bech32.Bech32},
+		{"?1ezyfcl", bech32.Bech32},
+		{"A1LQFN3A", bech32.Bech32m},
+		{"a1lqfn3a", bech32.Bech32m},
+		{"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6", bech32.Bech32m},
+		{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", bech32.Bech32m},
+		{"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8", bech32.Bech32m},
+		{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", bech32.Bech32m},
+		{"?1v759aa", bech32.Bech32m},
+	}
+
+	for _, test := range tests {
+		hrp, values, encoding, err := bech32.Decode(test.input)
+		if err != nil {
+			t.Errorf("Decode(%s): unexpected error: %v", test.input, err)
+			continue
+		}
+
+		if encoding != test.encoding {
+			t.Errorf("Decode(%s): got %s, expected %s", test.input, encoding, test.encoding)
+		}
+
+		encoded, err := bech32.Encode(hrp, values, encoding)
+		if err != nil {
+			t.Errorf("Encode(%s): unexpected error: %v", test.input, err)
+		}
+		if encoded != strings.ToLower(test.input) {
+			t.Errorf("Encode(%s): got %s, expected %s", test.input, encoded, strings.ToLower(test.input))
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []string{
+		"\x201nwldj5",
+		"\x7f1axkwrx",
+		"\x801eym55h",
+		"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"de1lg7wt\xff",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+		"\x201xj0phk",
+		"\x7f1g6xzxy",
+		"\x801vctc34",
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4",
+		"qyrz8wqd2c9m",
+		"1qyrz8wqd2c9m",
+		"y1b0jsk6g",
+		"lt1igcx5c0",
+		"in1muywd",
+		"mm1crxm3i",
+		"au1s5cgom",
+		"M1VUXWEZ",
+		"16plkw9",
+		"1p2gdwpf",
+	}
+
+	for _, test := range tests {
+		_, _, _, err := bech32.Decode(test)
+
+		var bech32Err *bech32.Error
+		if !errors.As(err, &bech32Err) {
+			t.Errorf("Decode(%q): got %v, expected a *bech32.Error", test, err)
+		}
+	}
+}
+
+func TestLocateErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []int
+	}{
+		// One wrong character of a bech32 string
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", []int{41}},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", nil},
+		{"bc1qwq08d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", []int{5}},
+		// Two wrong characters of a bech32 string
+		{"bc1qw508d6qejxtdg4y5r3zerv0ry0c5xw7kv8f3t4", []int{23, 26}},
+		// One and two wrong characters of a bech32m string
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj9", []int{61}},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", nil},
+		{"bc1p0xlxvlhemja6c4dqv22uapatqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jx0", []int{26, 60}},
+		// A wrong character of the human readable part
+		{"qc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", nil},
+		// Not a checksum error
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3tb", nil},
+	}
+
+	for _, test := range tests {
+		got := bech32.LocateErrors(test.input)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("LocateErrors(%s): got %v, expected %v", test.input, got, test.expected)
+		}
+	}
+
+	_, _, _, err := bech32.Decode("bc1qw508d6qejxtdg4y5r3zerv0ry0c5xw7kv8f3t4")
+
+	var bech32Err *bech32.Error
+	if !errors.As(err, &bech32Err) || !reflect.DeepEqual(bech32Err.Positions, []int{23, 26}) {
+		t.Errorf("Decode: got %v, expected an invalid checksum at positions [23 26]", err)
+	}
+}
+
+func TestConvertBits(t *testing.T) {
+	data, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+
+	values, err := bech32.ConvertBits(data, 8, 5, true)
+	if err != nil {
+		t.Fatalf("ConvertBits: unexpected error: %v", err)
+	}
+	if len(values) != 32 {
+		t.Errorf("ConvertBits: got %d values, expected 32", len(values))
+	}
+
+	got, err := bech32.ConvertBits(values, 5, 8, false)
+	if err != nil {
+		t.Fatalf("ConvertBits: unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, data) {
+		t.Errorf("ConvertBits: got %x, expected %x", got, data)
+	}
+
+	// 4 values are 20 bits, which is 2 bytes and 4 bits of padding that are
+	// not zero
+	if _, err := bech32.ConvertBits([]byte{0x1f, 0x1f, 0x1f, 0x1f}, 5, 8, false); err == nil {
+		t.Errorf("ConvertBits: expected an error for non-zero padding")
+	}
+
+	if _, err := bech32.ConvertBits([]byte{0x20}, 5, 8, true); err == nil {
+		t.Errorf("ConvertBits: expected an error for a value of more than 5 bits")
+	}
+}
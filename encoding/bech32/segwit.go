@@ -0,0 +1,80 @@
+package bech32
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EncodeSegwitAddress returns the address of the witness program of version
+// with the human readable part of a network, such as "bc" for mainnet.
+// Version 0 uses bech32 and later versions use bech32m, see BIP 350.
+func EncodeSegwitAddress(hrp string, version int, program []byte) (string, error) {
+	if err := checkWitnessProgram(version, program); err != nil {
+		return "", err
+	}
+
+	values, err := ConvertBits(program, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := Bech32m
+	if version == 0 {
+		encoding = Bech32
+	}
+
+	return Encode(hrp, append([]byte{byte(version)}, values...), encoding)
+}
+
+// DecodeSegwitAddress returns the version and the witness program of the
+// address, which must have the human readable part hrp.
+func DecodeSegwitAddress(hrp string, address string) (int, []byte, error) {
+	decodedHRP, values, encoding, err := Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if decodedHRP != strings.ToLower(hrp) {
+		return 0, nil, fmt.Errorf("invalid human readable part %q, expected %q", decodedHRP, hrp)
+	}
+
+	if len(values) == 0 {
+		return 0, nil, fmt.Errorf("empty data section")
+	}
+
+	version := int(values[0])
+	if version == 0 && encoding != Bech32 {
+		return 0, nil, fmt.Errorf("version 0 address must use bech32")
+	}
+	if version != 0 && encoding != Bech32m {
+		return 0, nil, fmt.Errorf("version %d address must use bech32m", version)
+	}
+
+	program, err := ConvertBits(values[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := checkWitnessProgram(version, program); err != nil {
+		return 0, nil, err
+	}
+
+	return version, program, nil
+}
+
+// Checks the version and the length of a witness program, see BIP 141.
+func checkWitnessProgram(version int, program []byte) error {
+	if version < 0 || version > 16 {
+		return fmt.Errorf("invalid witness version %d", version)
+	}
+
+	if len(program) < 2 || len(program) > 40 {
+		return fmt.Errorf("invalid witness program length %d", len(program))
+	}
+
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return fmt.Errorf("invalid version 0 witness program length %d", len(program))
+	}
+
+	return nil
+}
@@ -9,7 +9,7 @@ import (
 func Decode(stream io.Reader) (uint64, error) {
 	i := make([]byte, 1)
 
-	_, err := stream.Read(i)
+	_, err := io.ReadFull(stream, i)
 	if err != nil {
 		return 0, err
 	}
@@ -20,7 +20,7 @@ func Decode(stream io.Reader) (uint64, error) {
 
 	if i[0] == 0xfd {
 		n := make([]byte, 2)
-		_, err := stream.Read(n)
+		_, err := io.ReadFull(stream, n)
 		if err != nil {
 			return 0, err
 		}
@@ -30,7 +30,7 @@ func Decode(stream io.Reader) (uint64, error) {
 
 	if i[0] == 0xfe {
 		n := make([]byte, 4)
-		_, err := stream.Read(n)
+		_, err := io.ReadFull(stream, n)
 		if err != nil {
 			return 0, err
 		}
@@ -40,7 +40,7 @@ func Decode(stream io.Reader) (uint64, error) {
 
 	if i[0] == 0xff {
 		n := make([]byte, 8)
-		_, err := stream.Read(n)
+		_, err := io.ReadFull(stream, n)
 		if err != nil {
 			return 0, err
 		}
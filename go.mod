@@ -3,3 +3,5 @@ module github.com/stefanalfbo/programmingbitcoin
 go 1.22.0
 
 require golang.org/x/crypto v0.31.0
+
+require golang.org/x/text v0.21.0